@@ -3,22 +3,38 @@ package main
 import (
 	"api/cryptography"
 	"api/uid"
+	"bytes"
+	"compress/gzip"
 	"context"
-	"crypto/aes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"io"
 	"log"
+	"log/slog"
 	"math"
+	"math/rand/v2"
 	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
 )
 
 // DONE: test encrypt/decrypt
@@ -35,221 +51,988 @@ import (
 // DONE: either use users provided file size, or have limitations of 5tb
 // DONE: test uid with timeout
 
-func uploadHandler(minioClient *minio.Client, cipher *cryptography.StreamCipher) http.HandlerFunc {
+func uploadHandler(store uploadStore, cipher cryptography.Cipher, cfg config) http.HandlerFunc {
+	// The chunk buffers are recycled across uploads, to not allocate a new one for every file.
+	chunks := newChunkPool(cfg.chunkSize)
 	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
 		defer r.Body.Close()
-		// Get the file size provided by the user, necessary to be able to provide this length to the MinIO uploader.
-		// If we were to remove this element in the header, we would need to call PutObject with the -1 size, which allocates
-		// 700MB for this purpose. Since we aren't aware of daemon memory, we make this design choice.
-		fileSize, err := strconv.ParseInt(r.Header["File-Size"][0], 10, 64)
+		// Get the file sizes provided by the user, to be able to provide these lengths to the MinIO uploader.
+		// Clients which do not know the size of a file can omit it or declare it as unknown, in which case the file is
+		// uploaded to MinIO by parts of the configured size, each of them held in memory.
+		// Several files can be uploaded at once by listing their sizes in the order of their parts, separated by commas.
+		fileSizes, err := parseFileSizes(r.Header.Get("File-Size"))
 		if err != nil {
-			http.Error(w, "File-Size in header should be the file size in bytes", http.StatusPreconditionFailed)
+			http.Error(w, "File-Size in header should be the file size in bytes or unknown, or a comma-separated list of the sizes of the uploaded files", http.StatusPreconditionFailed)
 			return
 		}
-		// The uploaded length corresponds to the number of bytes in the uploaded file and the IV used in the stream cipher.
-		minioDataSize := fileSize + int64(aes.BlockSize)
-
-		// Get the object name to be uniquely identified on MinIO. This value is returned to users upon upload completion
-		// to tell them what UID to use to fetch this file.
-		objectName, errOccurred := getUniqueObjectName(w, r)
-		if errOccurred {
+		for _, fileSize := range fileSizes {
+			if fileSize > cfg.maxUploadSize {
+				http.Error(w, fmt.Sprintf("File-Size exceeds the maximal upload size of %d bytes", cfg.maxUploadSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+		}
+		if len(fileSizes) > 1 && uidChosen(r) {
+			http.Error(w, "A Uid can only be chosen when uploading a single file", http.StatusBadRequest)
+			return
+		}
+		// The file stored under a Uid can be replaced, which otherwise makes the upload fail with a conflict
+		overwrite, err := parseOverwrite(r.Header.Get("X-Overwrite"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if overwrite && !uidChosen(r) {
+			http.Error(w, "X-Overwrite requires the Uid of the file to replace", http.StatusBadRequest)
+			return
+		}
+		// Files of unknown size can be as large as the maximal upload size, as long as MinIO can store them in its
+		// number of parts, unless they are spilled to disk to be uploaded with a known size.
+		opts := uploadOptions{
+			partSize:          cfg.uploadPartSize,
+			sizeLimit:         min(cfg.maxUploadSize, cfg.uploadPartSize*MAX_UPLOAD_PARTS-int64(cipher.HeaderSize())),
+			spillDir:          cfg.spillDir,
+			uniqueFilenames:   cfg.uniqueFilenames,
+			detectContentType: cfg.detectContentType,
+			verifyETag:        cfg.verifyETag,
+		}
+		if opts.spillDir != "" {
+			opts.sizeLimit = cfg.maxUploadSize
+		}
+		// Files can optionally be gzipped before their encryption, to save storage space.
+		opts.compress, err = parseCompression(r.Header.Get("Compress"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Files can optionally expire, after which they are deleted
+		ttl, err := parseTTL(r.Header.Get("TTL-Seconds"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ttl > 0 {
+			opts.expiresAt = time.Now().Add(ttl)
+		}
+		// Files can be tagged, to be listed by their tags
+		opts.tags, err = parseTags(r.Header.Get("X-Tags"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Files can be given additional checksums, for the systems which need other ones than SHA-256
+		opts.checksums, err = parseChecksums(r.Header.Get("X-Checksums"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Files are only deduplicated if they never expire, and if the user did not choose the UID to store them under
+		// nor a namespace, whose files are not shared with other namespaces, nor tags or additional checksums, which the
+		// stored copy may lack
+		opts.deduplicate = cfg.deduplicate && ttl == 0 && !uidChosen(r) && r.Header.Get("X-Namespace") == "" && len(opts.tags) == 0 && len(opts.checksums) == 0
+		// Users can attach their own metadata to the files
+		opts.metadata, err = parseCustomMetadata(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Files can be stored in a cheaper storage class
+		opts.storageClass, err = parseStorageClass(r.Header.Get("X-Storage-Class"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Clients can check that an upload would be accepted before sending its files
+		validate, err := isValidationRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if validate {
+			validateUpload(w, r, len(fileSizes))
+			return
+		}
+		// Uploads are refused once the storage quotas are reached
+		if checkQuota(w, cfg, cipher, fileSizes) {
 			return
 		}
 
-		// Create a pipe that connects the user uploaded data to the encryption stream
-		uploadedDataReader, uploadedDataWriter := io.Pipe()
-		// Create a pipe that connects the encryption stream to the MinIO upload stream
-		ciphertextReader, ciphertextWriter := io.Pipe()
+		// Get the object names to be uniquely identified on MinIO. These values are returned to users upon upload completion
+		// to tell them what UID to use to fetch each file.
+		objectNames := make([]string, len(fileSizes))
+		storedFiles := make([]storedFile, 0, len(fileSizes))
+		// Users are only told about the files of an upload once they were all stored, so the objects and UIDs of an
+		// upload which fails are discarded. The cleanup outlives a client which disconnected.
+		// A file which is overwritten keeps its UID and object, which may hold the previous file, if the upload fails.
+		completed := false
+		var target overwriteTarget
+		defer func() {
+			if !completed && !target.keep {
+				discardUpload(context.WithoutCancel(r.Context()), store, objectNames, storedFiles)
+			}
+		}()
+		for i := range objectNames {
+			var errOccurred bool
+			// Only a single file can be uploaded with a Uid, and thus overwrite another
+			if overwrite {
+				target, errOccurred = getOverwriteTarget(w, r, store, cfg)
+				objectNames[i] = target.objectName
+				if errOccurred {
+					return
+				}
+				continue
+			}
+			objectNames[i], errOccurred = getUniqueObjectName(w, r)
+			if errOccurred {
+				return
+			}
+			// The object which may already be stored under the name must not be deleted along with the failed upload
+			if checkObjectNameFree(w, r.Context(), store, objectNames[i]) {
+				objectNames[i] = ""
+				return
+			}
+		}
+		setRequestUID(r.Context(), strings.Join(objectNames, ","))
+		// The progress of every file can be followed through /upload/progress until the upload is over
+		progresses := make([]*uploadProgress, len(objectNames))
+		for i, objectName := range objectNames {
+			progresses[i] = uploadProgresses.start(objectName, fileSizes[i])
+		}
+		defer func() {
+			for _, objectName := range objectNames {
+				uploadProgresses.finish(objectName, completed)
+			}
+		}()
 
-		// 3 goroutines are used:
-		// 1) Streams the user's uploaded data by chunk
-		// 2) Encrypts the data stream on-the-fly
-		// 3) Uploads the encrypted data stream to MinIO
-		var wg sync.WaitGroup
-		wg.Add(3)
+		// The body cannot be much larger than the files it declares, so that a client cannot stream unbounded data,
+		// e.g. in the preamble of the multipart body, nor stall for too long while sending it.
+		limitIdleReads(w, r, cfg.uploadIdleTimeout)
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBodySize(fileSizes, opts.sizeLimit))
 
-		// Define a blocking channel used for the MinIO uploading to wait until the uploaded file name has been read in the user data stream.
-		// This allows us to store it in the metadata and to return the named file when a user fetches it later on.
-		filenameChannel := make(chan string)
+		// Process the user's uploaded body as a stream, each part holding a file. A body which cannot be parsed is the
+		// client's fault, and is always rejected with a 400 before anything else is written.
+		fileStream, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, "The request body should be multipart/form-data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// 1) Streams the user's uploaded data by chunk
-		go func() {
-			defer wg.Done()
-			defer uploadedDataWriter.Close()
-			// Process the user's uploaded file body as a stream
-			fileStream, err := r.MultipartReader()
+		// The files may be preceded by a part holding their metadata, which overrides the headers giving it
+		part, err := fileStream.NextPart()
+		var metadata uploadMetadata
+		if err == nil && part.FormName() == METADATA_PART_NAME {
+			metadata, err = parseMetadataPart(part)
+			if isBodyTooLarge(err) {
+				http.Error(w, bodyTooLargeMessage, http.StatusRequestEntityTooLarge)
+				return
+			} else if errors.Is(err, errUploadIdle) {
+				http.Error(w, "The upload stalled: "+err.Error(), http.StatusRequestTimeout)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if metadata.Filename != "" && len(fileSizes) > 1 {
+				http.Error(w, "A filename can only be given in the metadata part when uploading a single file", http.StatusBadRequest)
+				return
+			}
+			metadata.apply(&opts, time.Now())
+			part, err = fileStream.NextPart()
+		}
+
+		// The files are stored one after the other, as their parts come in the request body. Files of the same upload
+		// may not share a name, which would make them indistinguishable in the response.
+		filenames := make(map[string]bool, len(fileSizes))
+		for i, fileSize := range fileSizes {
+			if i > 0 {
+				part, err = fileStream.NextPart()
+			}
+			if err == io.EOF {
+				http.Error(w, fmt.Sprintf("File-Size declares %d files, but only %d were uploaded", len(fileSizes), i), http.StatusBadRequest)
+				return
+			} else if isBodyTooLarge(err) {
+				http.Error(w, bodyTooLargeMessage, http.StatusRequestEntityTooLarge)
+				return
+			} else if errors.Is(err, errUploadIdle) {
+				http.Error(w, "The upload stalled: "+err.Error(), http.StatusRequestTimeout)
+				return
+			} else if err != nil {
+				http.Error(w, "Malformed multipart body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			filename, err := partFilename(part)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
-			// Define a buffer to read chunks from this stream to upload to our encryption stream
-			fileChunk := make([]byte, CHUNK_SIZE)
-			var firstPart = true
-			for {
-				// Read parts of the multi-part upload.
-				nextPart, err := fileStream.NextPart()
-				if err == io.EOF {
-					return
-				} else if err != nil {
-					// If any other error occurs, we return it as an unprocessable stream.
-					http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			if metadata.Filename != "" {
+				filename = metadata.Filename
+			}
+			if filename != "" {
+				if filenames[filename] {
+					http.Error(w, fmt.Sprintf("Several files of the upload are named %q", filename), http.StatusBadRequest)
 					return
-				} else {
-					for {
-						nbrReadBytes, errEOF := nextPart.Read(fileChunk)
-						// When we process the first part (the user uploaded file), we parse the header to get the filename.
-						if firstPart {
-							contentDetails := nextPart.Header.Get("Content-Disposition")
-							_, params, err := mime.ParseMediaType(contentDetails)
-							// If we fail to parse the file name, it should not be a problem, we simply cannot store the name in the metadata
-							if err != nil {
-								filenameChannel <- ""
-							} else {
-								filenameChannel <- params["filename"]
-							}
-							firstPart = false
-						}
-						// We then copy the byte chunk to send it to our encryption stream
-						err = sendToEncryption(fileChunk[:nbrReadBytes], uploadedDataWriter)
-						if err != nil {
-							http.Error(w, err.Error(), http.StatusInternalServerError)
-							return
-						}
-						// If these bytes were the last ones in this request multi-part, we move on to the next one.
-						if errEOF == io.EOF {
-							break
-						}
-					}
 				}
+				filenames[filename] = true
 			}
-		}()
+			// The upload of a file of unknown size is given the time to upload the largest file it can be
+			maxFileSize := fileSize
+			if fileSize == UNKNOWN_FILE_SIZE {
+				maxFileSize = opts.sizeLimit
+			}
+			timeout := getMaxNbrRunSeconds(cipher.EncryptedSize(maxFileSize), cfg.uploadMinRate, cfg.uploadTimeoutMargin)
+			file, uploadError := storeUploadedFile(r.Context(), store, cipher, chunks, part, filename, objectNames[i], fileSize, progresses[i], opts, timeout)
+			if uploadError != nil {
+				if uploadError.status == http.StatusGatewayTimeout {
+					setTimeoutRetryAfter(w, timeout)
+				}
+				writeError(w, r.Context(), uploadError)
+				return
+			}
+			storedFiles = append(storedFiles, file)
+		}
+		// Any part left would be a file which was not declared, and therefore not stored
+		if _, err := fileStream.NextPart(); err == nil {
+			http.Error(w, fmt.Sprintf("More files were uploaded than the %d declared in File-Size", len(fileSizes)), http.StatusBadRequest)
+			return
+		} else if isBodyTooLarge(err) {
+			http.Error(w, bodyTooLargeMessage, http.StatusRequestEntityTooLarge)
+			return
+		} else if err != io.EOF {
+			http.Error(w, "Malformed multipart body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// 2) Encrypts the data stream on-the-fly
-		go func() {
-			defer wg.Done()
-			defer ciphertextWriter.Close()
-			defer fmt.Println("Finished encrypting")
+		completed = true
+		if target.previous != nil {
+			forgetReplacedFile(*target.previous, storedFiles[0], target.objectName)
+		}
 
-			// Encrypt the incoming file stream
-			if err := cipher.EncryptStream(uploadedDataReader, ciphertextWriter); err != nil {
-				ciphertextWriter.CloseWithError(err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		// If everything went well, send a success response. A single file is acknowledged with a message, several files
+		// with a JSON array mapping their filenames to their UIDs. The UIDs are also sent in a header, in the order of the
+		// files, for clients which do not parse the body.
+		uids := make([]string, len(storedFiles))
+		for i, file := range storedFiles {
+			uids[i] = file.Uid
+		}
+		w.Header().Set("X-Upload-UID", strings.Join(uids, ","))
+		if len(storedFiles) == 1 {
+			w.Header().Set("X-Content-SHA256", storedFiles[0].Sha256)
+			fmt.Fprintf(w, "File successfully uploaded and encrypted with UID %s \nSHA-256 checksum: %s \n", storedFiles[0].Uid, storedFiles[0].Sha256)
+			for _, name := range opts.checksums {
+				fmt.Fprintf(w, "%s checksum: %s \n", checksumAlgorithms[name].label, storedFiles[0].Checksums[name])
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(storedFiles); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the upload response", "error", err)
+		}
+	}
+}
+
+// uploadOptions holds the options chosen by the user for all the files of an upload.
+type uploadOptions struct {
+	// compress tells whether the files should be gzipped before their encryption, if their type is worth it
+	compress bool
+	// expiresAt is the time after which the files are deleted, if not zero
+	expiresAt time.Time
+	// metadata is the custom metadata attached to the files
+	metadata map[string]string
+	// partSize is the size in bytes of the parts in which the files of unknown size are uploaded to MinIO
+	partSize int64
+	// sizeLimit is the largest size in bytes of the files uploaded without a File-Size
+	sizeLimit int64
+	// spillDir is the directory in which the files whose size is unknown are written before being uploaded, if any
+	spillDir string
+	// deduplicate tells whether files which are already stored should be given the UID of the object holding them
+	deduplicate bool
+	// uniqueFilenames tells whether the files named like a stored file should be renamed
+	uniqueFilenames bool
+	// detectContentType tells whether the type of the files uploaded without a specific one should be detected
+	detectContentType bool
+	// storageClass is the MinIO storage class of the files, the default one of the deployment if empty
+	storageClass string
+	// tags are the MinIO object tags of the files
+	tags map[string]string
+	// contentType is the type given to the files in the metadata part of the upload, overriding the one of their parts
+	// if not empty
+	contentType string
+	// checksums are the names of the checksums to compute for the files in addition to their SHA-256 checksum
+	checksums []string
+	// verifyETag tells whether the ETag of the stored objects is compared to the MD5 checksum of the sent data
+	verifyETag bool
+}
+
+// storedFile describes a file stored by an upload, as reported to the user.
+type storedFile struct {
+	Filename string `json:"filename"`
+	Uid      string `json:"uid"`
+	Sha256   string `json:"sha256"`
+	// Checksums holds the additional checksums requested through X-Checksums, by name
+	Checksums map[string]string `json:"checksums,omitempty"`
+	// objectSize is the size of the object holding the file in MinIO, 0 for a deduplicated file which has no object of
+	// its own.
+	objectSize int64
+}
+
+// storeUploadedFile encrypts a file of the multipart upload and stores it in MinIO under the given object name, with
+// the filename of the part, as returned by partFilename. The part must hold exactly fileSize bytes, or at most opts.sizeLimit bytes if fileSize is UNKNOWN_FILE_SIZE.
+// The bytes of the file are also written to progress as they are read.
+// The returned error holds the status the upload should fail with.
+func storeUploadedFile(requestCtx context.Context, store uploadStore, cipher cryptography.Cipher, chunks *chunkPool, part *multipart.Part, filename string, objectName string, fileSize int64, progress io.Writer, opts uploadOptions, timeout time.Duration) (file storedFile, uploadError *httpError) {
+	// The part's header holds the file details, which are stored in the metadata to return the named file with its type
+	// when a user fetches it later on.
+	details := uploadedFileDetails{contentType: partContentType(part.Header)}
+	if opts.contentType != "" {
+		details.contentType = opts.contentType
+	}
+	// If the part has no filename, the file is named after its UID so that it can still be fetched.
+	details.filename = objectUid(objectName)
+	if filename != "" {
+		details.filename = filename
+	}
+	// The type of a file uploaded without a specific one is detected, so that it can be displayed once fetched
+	var fileData io.Reader = part
+	if opts.detectContentType && details.contentType == defaultContentType {
+		details.contentType, fileData = detectContentType(filename, part)
+	}
+	// Already compressed formats are stored as they are
+	details.compressed = opts.compress && isCompressible(details.contentType)
+	// A filename which is already used is made unique, and freed again if the file is not stored
+	if opts.uniqueFilenames {
+		details.filename = fileNames.claim(details.filename, objectName)
+		defer func() {
+			if uploadError != nil {
+				fileNames.release(details.filename, objectName)
 			}
 		}()
+	}
 
-		uploadError := make(chan bool)
+	// Create a pipe that connects the user uploaded data to the encryption stream
+	uploadedDataReader, uploadedDataWriter := io.Pipe()
+	// Create a pipe that connects the encryption stream to the MinIO upload stream
+	ciphertextReader, ciphertextWriter := io.Pipe()
 
-		// 3) Uploads the encrypted data stream to MinIO
-		go func() {
-			defer wg.Done()
-			defer fmt.Println("Finished uploading")
-			// Wait until a filename is provided before starting the upload, since metadata must be known at the function call time.
-			filename := <-filenameChannel
-			metadata := make(map[string]string)
-			// If the user's request contained a filename, we add it to the metadata, otherwise we don't provide this service.
-			if filename != "" {
-				metadata["Filename"] = filepath.Base(filename)
+	// The upload is bound to the request's context with a timeout for uploads taking too long, so that a client
+	// disconnecting cancels the MinIO upload and nothing is stored.
+	ctx, cancel := context.WithTimeout(requestCtx, timeout)
+	defer cancel()
+	// Once the context is done, break the pipes so that no goroutine stays blocked on a stage which stopped.
+	stopPipeline := context.AfterFunc(ctx, func() {
+		uploadedDataWriter.CloseWithError(ctx.Err())
+		ciphertextWriter.CloseWithError(ctx.Err())
+	})
+	defer stopPipeline()
+
+	// 3 goroutines are used:
+	// 1) Streams the user's uploaded data by chunk
+	// 2) Encrypts the data stream on-the-fly
+	// 3) Uploads the encrypted data stream to MinIO
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	// The goroutines never write to the response themselves. They record the first failure instead, and the
+	// handler writes a single response once they all returned.
+	var failure firstError
+
+	// The SHA-256 checksum of the plaintext is computed while it is read, to let users confirm the integrity of their file.
+	plaintextHash := sha256.New()
+	// The additional checksums requested by the user are computed along
+	checksums := newChecksumSet(opts.checksums)
+	// The number of bytes of the file, only known once it was entirely read if its size was not declared
+	var nbrPlaintextBytes int64
+	// The number of bytes which were encrypted, fewer than those of the file if it was compressed
+	var nbrEncryptedBytes int64
+
+	// 1) Streams the user's uploaded data by chunk
+	go func() {
+		defer wg.Done()
+		defer uploadedDataWriter.Close()
+		// Get a buffer to copy chunks from this stream to our encryption stream, no larger than the file. It is
+		// given back once the whole file was read, which always happens before the handler returns.
+		chunk := chunks.get(fileSize)
+		defer chunks.put(chunk)
+		// The file is written to the encryption stream, through a gzip compressor if the file gets compressed,
+		// and hashed on the way.
+		var encryptionInput io.Writer = uploadedDataWriter
+		var compressor *gzip.Writer
+		if details.compressed {
+			compressor = gzip.NewWriter(uploadedDataWriter)
+			encryptionInput = compressor
+		}
+		fileReader := clientReader{reader: fileData}
+
+		plaintextInput := io.MultiWriter(encryptionInput, plaintextHash, checksums.writer(), progress)
+
+		var err error
+		if fileSize == UNKNOWN_FILE_SIZE {
+			nbrPlaintextBytes, err = forwardFileOfUnknownSize(plaintextInput, fileReader, opts.sizeLimit, *chunk)
+		} else {
+			nbrPlaintextBytes, err = forwardFile(plaintextInput, fileReader, fileSize, *chunk)
+		}
+		if err != nil {
+			var clientError clientReadError
+			switch {
+			case errors.Is(err, errFileTooLarge) && fileSize == UNKNOWN_FILE_SIZE:
+				failure.set(http.StatusRequestEntityTooLarge, fmt.Sprintf("The uploaded file exceeds the maximal upload size of %d bytes", opts.sizeLimit))
+			case errors.Is(err, errFileTooLarge):
+				failure.set(http.StatusRequestEntityTooLarge, "The uploaded file is larger than the declared File-Size")
+			case errors.Is(err, errFileTooSmall):
+				failure.set(http.StatusBadRequest, fmt.Sprintf("The uploaded file is %d bytes long, but its declared File-Size is %d", nbrPlaintextBytes, fileSize))
+			case isBodyTooLarge(err):
+				failure.set(http.StatusRequestEntityTooLarge, bodyTooLargeMessage)
+			case errors.Is(err, errUploadIdle):
+				failure.set(http.StatusRequestTimeout, "The upload stalled: "+err.Error())
+			case errors.As(err, &clientError):
+				// The body could not be read any further, e.g. because the client disconnected
+				failure.set(http.StatusBadRequest, "Unable to read the uploaded file: "+err.Error())
+			default:
+				failure.set(http.StatusInternalServerError, err.Error())
 			}
-			// Set a timeout for uploads taking too long
-			maxNbrRunNanoseconds := getMaxNbrRunSeconds(minioDataSize)
-			timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), maxNbrRunNanoseconds)
-			defer timeoutCancel()
+			uploadedDataWriter.CloseWithError(err)
+			return
+		}
+		// Flush the end of the compressed stream before the encryption stream gets closed
+		if compressor != nil {
+			if err := compressor.Close(); err != nil {
+				failure.set(http.StatusInternalServerError, err.Error())
+				uploadedDataWriter.CloseWithError(err)
+			}
+		}
+	}()
 
-			_, err := minioClient.PutObject(timeoutCtx, BUCKET_NAME, objectName, ciphertextReader, minioDataSize, minio.PutObjectOptions{
-				ContentType:  "application/octet-stream",
-				UserMetadata: metadata,
-			})
+	// 2) Encrypts the data stream on-the-fly
+	go func() {
+		defer wg.Done()
+		defer ciphertextWriter.Close()
+		defer loggerFrom(ctx).Info("Finished encrypting", "uid", objectName)
 
-			if err != nil {
-				http.Error(w, "Upload to MinIO failed", http.StatusInternalServerError)
-				uploadError <- true
+		// Encrypt the incoming file stream
+		start := time.Now()
+		encryptedData := &countingReader{reader: uploadedDataReader}
+		if err := cipher.EncryptStreamCtx(ctx, encryptedData, ciphertextWriter); err != nil {
+			failure.set(http.StatusInternalServerError, "Encryption failed: "+err.Error())
+			// Interrupt both the upload and the reading of the user's data
+			ciphertextWriter.CloseWithError(err)
+			uploadedDataReader.CloseWithError(err)
+			return
+		}
+		nbrEncryptedBytes = encryptedData.count
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			encryptionThroughput.Observe(float64(encryptedData.count) / elapsed)
+		}
+	}()
+
+	// The metadata is kept once the upload completes, to complete it with the checksum.
+	metadata := map[string]string{"Filename": details.filename}
+	if !opts.expiresAt.IsZero() {
+		metadata[EXPIRY_METADATA] = opts.expiresAt.UTC().Format(time.RFC3339)
+	}
+	for key, value := range opts.metadata {
+		metadata[CUSTOM_METADATA_PREFIX+key] = value
+	}
+	if opts.storageClass != "" {
+		metadata[STORAGE_CLASS_METADATA] = opts.storageClass
+	}
+
+	// 3) Uploads the encrypted data stream to MinIO
+	go func() {
+		defer wg.Done()
+		defer loggerFrom(ctx).Info("Finished uploading", "uid", objectName)
+		putOpts := minio.PutObjectOptions{
+			ContentType:  details.contentType,
+			UserMetadata: metadata,
+			StorageClass: opts.storageClass,
+			UserTags:     opts.tags,
+		}
+		// The uploaded length is the size of the encrypted stream, which holds the uploaded file along with the header
+		// of the stream, holding the key ID and the IV.
+		objectSize := cipher.EncryptedSize(fileSize)
+		if details.compressed {
+			metadata["Compression"] = COMPRESSION_GZIP
+		}
+		// The size of a compressed file is only known once it was entirely compressed, and the size of a file which
+		// was not declared once it was entirely read, so such files are uploaded by parts, or spilled to disk first
+		// The MD5 checksum of the ciphertext is compared to the ETag reported by MinIO once the upload completed
+		ciphertextHash := md5.New()
+		hashedCiphertext := io.TeeReader(ciphertextReader, ciphertextHash)
+		var uploadInfo minio.UploadInfo
+		var err error
+		if details.compressed || fileSize == UNKNOWN_FILE_SIZE {
+			if opts.spillDir != "" {
+				uploadInfo, err = putSpilledObject(ctx, store, opts.spillDir, objectName, hashedCiphertext, putOpts)
 			} else {
-				uploadError <- false
+				putOpts.PartSize = uint64(opts.partSize)
+				uploadInfo, err = store.PutObject(ctx, objectName, hashedCiphertext, -1, putOpts)
 			}
-		}()
+		} else {
+			uploadInfo, err = store.PutObject(ctx, objectName, hashedCiphertext, objectSize, putOpts)
+		}
+
+		if err != nil {
+			failure.set(http.StatusInternalServerError, "Upload to MinIO failed")
+			// Stop the encryption stream which can no longer be uploaded
+			ciphertextReader.CloseWithError(err)
+			return
+		}
+		if !opts.verifyETag {
+			return
+		}
+		if err := checkETag(uploadInfo.ETag, ciphertextHash.Sum(nil)); err != nil {
+			loggerFrom(ctx).Error("Integrity check of the upload failed", "uid", objectName, "error", err)
+			failure.set(http.StatusInternalServerError, "Integrity check failed: the file stored in MinIO differs from the uploaded file")
+		}
+	}()
+
+	wg.Wait()
+	if err := failure.get(); err != nil {
+		// Once the context is done, the stages fail in cascade with errors which hide the reason why. A stalled client
+		// is the exception, as the server cancels the request context once the read of its body timed out.
+		if ctxError := contextError(ctx); ctxError != nil && err.status != http.StatusRequestTimeout {
+			return storedFile{}, ctxError
+		}
+		return storedFile{}, err
+	}
+
+	// The checksum is only known once the whole file went through the pipeline, which is after the upload started.
+	// It is therefore added to the object's metadata in a second step.
+	checksum := hex.EncodeToString(plaintextHash.Sum(nil))
+	// A file which is already stored is given the UID of the object holding it, and its new copy is deleted
+	if opts.deduplicate {
+		if existingUid, ok := fileChecksums.lookupOrAdd(checksum, objectName); ok {
+			if err := removeDuplicate(ctx, store, objectName); err == nil {
+				if opts.uniqueFilenames {
+					fileNames.release(details.filename, objectName)
+				}
+				uploadsTotal.Inc()
+				uploadedBytesTotal.Add(float64(nbrPlaintextBytes))
+				return storedFile{Filename: details.filename, Uid: existingUid, Sha256: checksum}, nil
+			}
+			// If the copy cannot be deleted, it is kept as a file of its own
+			loggerFrom(ctx).Warn("Unable to delete a duplicate file", "uid", objectName, "duplicate_of", existingUid)
+		}
+	}
+	metadata[CHECKSUM_METADATA] = checksum
+	metadata[PLAINTEXT_SIZE_METADATA] = strconv.FormatInt(nbrPlaintextBytes, 10)
+	metadata[UPLOADED_AT_METADATA] = time.Now().UTC().Format(time.RFC3339)
+	extraChecksums := checksums.sums()
+	for name, sum := range extraChecksums {
+		metadata[checksumAlgorithms[name].metadata] = sum
+	}
+	if err := store.ReplaceMetadata(ctx, objectName, details.contentType, metadata); err != nil {
+		if opts.deduplicate {
+			fileChecksums.remove(checksum, objectName)
+		}
+		return storedFile{}, &httpError{status: http.StatusInternalServerError, message: "Failed to store the file checksum in MinIO"}
+	}
+
+	uploadsTotal.Inc()
+	uploadedBytesTotal.Add(float64(nbrPlaintextBytes))
+	objectSize := cipher.EncryptedSize(nbrEncryptedBytes)
+	storedBytes.add(objectSize)
+	return storedFile{Filename: details.filename, Uid: objectUid(objectName), Sha256: checksum, Checksums: extraChecksums, objectSize: objectSize}, nil
+}
+
+// removeDuplicate deletes an object holding a file which was already stored, and frees its UID.
+func removeDuplicate(ctx context.Context, store uploadStore, objectName string) error {
+	if err := store.RemoveObject(ctx, objectName); err != nil {
+		return err
+	}
+	releaseObjectName(objectName)
+	return nil
+}
+
+// DISCARD_TIMEOUT bounds the time spent deleting the objects of a failed upload.
+const DISCARD_TIMEOUT = 30 * time.Second
 
-		errInUpload := <-uploadError
-		if errInUpload {
+// discardUpload deletes the objects of an upload which failed, and frees their UIDs, filenames and checksums. The files
+// which were stored are given in the order of objectNames, the following names being those of the files which were not,
+// or only partially, stored. A file which was deduplicated already had its object deleted and its UID freed, which may
+// now be used by another upload, so it is skipped.
+func discardUpload(ctx context.Context, store uploadStore, objectNames []string, storedFiles []storedFile) {
+	ctx, cancel := context.WithTimeout(ctx, DISCARD_TIMEOUT)
+	defer cancel()
+	for i, objectName := range objectNames {
+		// The UID of this file and the following ones were never reserved
+		if objectName == "" {
 			return
 		}
-		wg.Wait()
-		// If everything went well, send a success response
-		fmt.Fprintf(w, "File successfully uploaded and encrypted with UID %s \n", objectName)
+		if i < len(storedFiles) && storedFiles[i].Uid != objectUid(objectName) {
+			continue
+		}
+		// A failed multipart upload may leave its parts behind, and an upload which failed once MinIO received it
+		// its whole object.
+		if err := store.AbortUpload(ctx, objectName); err != nil {
+			loggerFrom(ctx).Warn("Unable to abort the upload of a failed file", "uid", objectName, "error", err)
+		}
+		if err := store.RemoveObject(ctx, objectName); err != nil {
+			// The UID stays reserved, as it still names an object
+			loggerFrom(ctx).Error("Unable to delete the object of a failed upload", "uid", objectName, "error", err)
+			continue
+		}
+		if i < len(storedFiles) {
+			fileChecksums.remove(storedFiles[i].Sha256, objectName)
+			fileNames.release(storedFiles[i].Filename, objectName)
+			storedBytes.remove(storedFiles[i].objectSize)
+		}
+		releaseObjectName(objectName)
+	}
+}
+
+// forwardFile copies a file of the given size from the reader to the writer. The last byte is held back until the end
+// of the file is reached, so that a file larger than declared is never entirely forwarded and thus never uploaded.
+// It fails with errFileTooLarge or errFileTooSmall if the file is not as large as declared, after which the writer
+// must not be used, and returns the number of bytes read from the file.
+func forwardFile(writer io.Writer, reader io.Reader, fileSize int64, buf []byte) (int64, error) {
+	withheld := min(fileSize, 1)
+	nbrForwardedBytes, err := io.CopyBuffer(writer, io.LimitReader(reader, fileSize-withheld), buf)
+	if err != nil {
+		return nbrForwardedBytes, err
+	}
+	// Read the withheld byte along with one more, which must not exist
+	tail := make([]byte, withheld+1)
+	nbrTailBytes, err := io.ReadFull(reader, tail)
+	nbrReadBytes := nbrForwardedBytes + int64(nbrTailBytes)
+	if err == nil {
+		return nbrReadBytes, errFileTooLarge
+	} else if err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nbrReadBytes, err
 	}
+	// Make sure the file was as large as declared. Otherwise, the MinIO upload would keep waiting for the missing
+	// bytes, so it must be interrupted to not store a truncated object.
+	if nbrReadBytes != fileSize {
+		return nbrReadBytes, errFileTooSmall
+	}
+	_, err = writer.Write(tail[:nbrTailBytes])
+	return nbrReadBytes, err
 }
 
-func fetchAndDecryptHandler(minioClient *minio.Client, cipher *cryptography.StreamCipher) http.HandlerFunc {
+// forwardFileOfUnknownSize copies a file of at most sizeLimit bytes from the reader to the writer, and returns its size.
+// It fails with errFileTooLarge once the file exceeds the limit, in which case the upload must be interrupted since
+// MinIO only completes an upload of unknown size when the end of its stream is reached.
+func forwardFileOfUnknownSize(writer io.Writer, reader io.Reader, sizeLimit int64, buf []byte) (int64, error) {
+	nbrForwardedBytes, err := io.CopyBuffer(writer, io.LimitReader(reader, sizeLimit), buf)
+	if err != nil {
+		return nbrForwardedBytes, err
+	}
+	// Any byte past the limit means the file is too large
+	if _, err := io.ReadFull(reader, make([]byte, 1)); err == nil {
+		return nbrForwardedBytes, errFileTooLarge
+	} else if err != io.EOF {
+		return nbrForwardedBytes, err
+	}
+	return nbrForwardedBytes, nil
+}
+
+// MULTIPART_OVERHEAD is the room left in the body of an upload for the boundary and the headers of each of its parts,
+// and for the preamble and epilogue of the body.
+const MULTIPART_OVERHEAD = 64 * 1024
+
+// maxUploadBodySize returns the largest body an upload of files of the given sizes may have, the files of unknown size
+// being at most sizeLimit bytes long.
+func maxUploadBodySize(fileSizes []int64, sizeLimit int64) int64 {
+	maxSize := int64(MULTIPART_OVERHEAD)
+	for _, fileSize := range fileSizes {
+		if fileSize == UNKNOWN_FILE_SIZE {
+			fileSize = sizeLimit
+		}
+		if fileSize > math.MaxInt64-maxSize-MULTIPART_OVERHEAD {
+			return math.MaxInt64
+		}
+		maxSize += fileSize + MULTIPART_OVERHEAD
+	}
+	return maxSize
+}
+
+// isBodyTooLarge tells whether reading the body of a request failed because it exceeded the size it was limited to.
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+// UNKNOWN_FILE_SIZE is the size of the files which were not given a size in the File-Size header, or were declared with
+// the size "unknown".
+const UNKNOWN_FILE_SIZE = -1
+
+// parseFileSizes parses the File-Size header, holding the comma-separated sizes in bytes of the uploaded files.
+// The sizes which are missing or unknown are UNKNOWN_FILE_SIZE.
+func parseFileSizes(header string) ([]int64, error) {
+	sizeStrs := strings.Split(header, ",")
+	fileSizes := make([]int64, len(sizeStrs))
+	for i, sizeStr := range sizeStrs {
+		sizeStr = strings.TrimSpace(sizeStr)
+		if sizeStr == "" || strings.EqualFold(sizeStr, "unknown") {
+			fileSizes[i] = UNKNOWN_FILE_SIZE
+			continue
+		}
+		fileSize, err := strconv.ParseInt(sizeStr, 10, 64)
+		if err != nil || fileSize < 0 {
+			return nil, fmt.Errorf("invalid file size %q", sizeStr)
+		}
+		fileSizes[i] = fileSize
+	}
+	return fileSizes, nil
+}
+
+func fetchAndDecryptHandler(store ObjectStore, cipher cryptography.Cipher, cfg config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		uidStr := r.URL.Query().Get("uid")
-		if uidStr == "" {
-			http.Error(w, "Missing UID", http.StatusBadRequest)
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
 			return
 		}
-		uid, err := strconv.ParseUint(uidStr, 10, 64)
+		raw, err := isRawFetch(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if !uidTracker.Contains(uid) {
-			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+		disposition, err := getDisposition(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		// Prepare to fetch the encrypted object from MinIO
-		objectName := uidStr
-		ctx := context.Background()
-
-		// Get the object from MinIO as a stream
-		object, err := minioClient.GetObject(ctx, BUCKET_NAME, objectName, minio.GetObjectOptions{})
+		inBase64, err := isBase64Fetch(r)
 		if err != nil {
-			http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer object.Close()
+		if raw && inBase64 {
+			http.Error(w, "A file cannot be fetched both raw and as base64", http.StatusBadRequest)
+			return
+		}
+
+		// Prepare to fetch the encrypted object from MinIO. The request's values, such as its logger, are kept but the
+		// fetch is not cancelled along with the request. Only the decryption stops once the request is cancelled.
+		ctx := context.WithoutCancel(r.Context())
 
-		objectInfo, err := object.Stat()
+		objectInfo, err := store.StatObject(ctx, objectName)
 		if err != nil {
 			http.Error(w, "Failed to get object metadata", 408)
 			return
 		}
+		// Expired objects are treated as deleted, even before they are actually removed
+		if isExpired(objectInfo.UserMetadata, time.Now()) {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
 		filename, ok := objectInfo.UserMetadata["Filename"]
 		if !ok {
 			http.Error(w, "Filename not found in metadata", 408)
 			return
 		}
+		// Clients holding the key can download the object as stored, and decrypt it themselves
+		if raw {
+			serveRawObject(ctx, w, store, objectInfo, filename)
+			return
+		}
+		if inBase64 {
+			serveBase64File(ctx, w, r, store, cipher, objectInfo, filename, cfg.base64FetchMaxSize)
+			return
+		}
 
-		// Decrypt the stream and send it to the response
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		// The offsets in a compressed object do not match those of the file, so such objects are always sent whole
+		compressed := objectInfo.UserMetadata["Compression"] == COMPRESSION_GZIP
+		// The header of the encrypted stream is the only overhead of the cipher. Its size depends on the version of the
+		// stream, so it is fetched first, and kept to decrypt a range. The size of a compressed file is unknown anyway.
+		var header []byte
+		var plaintextSize int64
+		if !compressed {
+			header, err = fetchStreamHeader(ctx, store, cipher, objectName)
+			if err != nil {
+				loggerFrom(ctx).Error("Unable to read the header of the object", "uid", objectName, "error", err)
+				http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+				return
+			}
+			plaintextSize = objectInfo.Size - int64(len(header))
+		}
+
+		w.Header().Set("Content-Type", contentTypeOrDefault(objectInfo.ContentType))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, filename))
+		if compressed {
+			w.Header().Set("Accept-Ranges", "none")
+		} else {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+
+		// If the client asked for a single range of the file, only decrypt and send those bytes.
+		// Requests for multiple ranges fall back to sending the whole file.
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && !compressed {
+			start, end, err := parseRange(rangeHeader, plaintextSize)
+			if err == nil {
+				serveDecryptedRange(ctx, w, store, cipher, objectName, header, start, end, plaintextSize)
+				return
+			} else if !errors.Is(err, errMultipleRanges) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", plaintextSize))
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
 
-		// Decrypt the stream and write directly to the response writer
-		err = cipher.DecryptStream(object, w)
+		// Get the object from MinIO as a stream
+		object, err := store.GetObject(ctx, objectName, minio.GetObjectOptions{})
 		if err != nil {
-			http.Error(w, "Error during decryption", http.StatusInternalServerError)
+			http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+			return
+		}
+		defer object.Close()
+
+		// Clients which read trailers are sent the checksum of the file computed while it was sent, to verify it once
+		// received. Trailers can only follow a chunked body, which has no length.
+		sendTrailer := acceptsTrailers(r)
+		if sendTrailer {
+			w.Header().Set("Trailer", CHECKSUM_TRAILER)
+		}
+		// Announce the plaintext length so that clients can track the download progress, unless it is unknown because
+		// the file was compressed. No other bytes than the decrypted file may be written to the body after this point.
+		if !compressed && !sendTrailer {
+			w.Header().Set("Content-Length", strconv.FormatInt(plaintextSize, 10))
+		}
+
+		// Expose the checksum computed at upload time, if any, so that clients can verify the file they receive
+		storedChecksum, hasChecksum := objectInfo.UserMetadata[CHECKSUM_METADATA]
+		if hasChecksum {
+			w.Header().Set("X-Content-SHA256", storedChecksum)
+		}
+
+		// Small files are entirely decrypted and verified before being sent, so that a failure can still be reported
+		// with an error status instead of a truncated body. Larger files are streamed to not hold them in memory.
+		if !compressed && plaintextSize <= cfg.bufferedFetchMaxSize {
+			plaintext := bytes.NewBuffer(make([]byte, 0, plaintextSize))
+			if err := cipher.DecryptStreamCtx(r.Context(), object, plaintext); err != nil || int64(plaintext.Len()) != plaintextSize {
+				if ctxError := contextError(r.Context()); ctxError != nil {
+					writeError(w, ctx, ctxError)
+					return
+				}
+				loggerFrom(ctx).Error("Error during decryption", "uid", objectName, "error", err)
+				http.Error(w, "Error during decryption", http.StatusInternalServerError)
+				return
+			}
+			checksum := sha256.Sum256(plaintext.Bytes())
+			if hasChecksum && hex.EncodeToString(checksum[:]) != storedChecksum {
+				loggerFrom(ctx).Error("Checksum mismatch", "uid", objectName, "stored", storedChecksum, "computed", hex.EncodeToString(checksum[:]))
+				http.Error(w, "The stored file is corrupted", http.StatusInternalServerError)
+				return
+			}
+			nbrSentBytes, _ := w.Write(plaintext.Bytes())
+			if sendTrailer {
+				w.Header().Set(CHECKSUM_TRAILER, hex.EncodeToString(checksum[:]))
+			}
+			downloadedBytesTotal.Add(float64(nbrSentBytes))
+			downloadsTotal.Inc()
 			return
 		}
 
-		// Success
-		fmt.Fprintf(w, "File %s streamed and decrypted successfully.\n", objectName)
+		// Decrypt the stream and write directly to the response writer, hashing the plaintext on the way
+		plaintextHash := sha256.New()
+		sentData := &countingWriter{writer: w}
+		if compressed {
+			err = decryptAndDecompress(r.Context(), cipher, object, io.MultiWriter(sentData, plaintextHash))
+		} else {
+			err = cipher.DecryptStreamCtx(r.Context(), object, io.MultiWriter(sentData, plaintextHash))
+		}
+		downloadedBytesTotal.Add(float64(sentData.count))
+		if err != nil {
+			ctxError := contextError(r.Context())
+			if ctxError == nil {
+				loggerFrom(ctx).Error("Error during decryption", "uid", objectName, "error", err)
+				ctxError = &httpError{status: http.StatusInternalServerError, message: "Error during decryption"}
+			}
+			// Once part of the file was sent, the status can no longer be changed. The client then notices the failure
+			// through the body being shorter than announced.
+			if sentData.count == 0 {
+				writeError(w, ctx, ctxError)
+			}
+			return
+		}
+		downloadsTotal.Inc()
+		// A file which does not match its checksum was already sent, and is reported to the clients through the trailer
+		checksum := hex.EncodeToString(plaintextHash.Sum(nil))
+		if sendTrailer {
+			w.Header().Set(CHECKSUM_TRAILER, checksum)
+		}
+		if hasChecksum && checksum != storedChecksum {
+			loggerFrom(ctx).Error("Checksum mismatch", "uid", objectName, "stored", storedChecksum, "computed", checksum)
+		}
 	}
 }
 
 var uidTracker = uid.UidTracker{}
 
-// The chunk size was chosen for extreme cases where the daemon has very little RAM. For faster uploads, chunks of 16-64MB can easily be used.
-const CHUNK_SIZE = 1024 * 1024 * 8
-const BUCKET_NAME = "challenge-taurus"
+// fileChecksums indexes the stored files by their checksum, to deduplicate the uploaded files.
+var fileChecksums = checksumIndex{}
+
+// fileNames indexes the stored files by their filename, to give the uploaded files unique names.
+var fileNames = filenameIndex{}
+
+// uidReservations tracks the UIDs reserved through /reserve, until they are used by an upload or expire.
+var uidReservations = reservationIndex{}
+
+// SHUTDOWN_TIMEOUT bounds how long ongoing requests are waited for when the server is stopped.
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
+var errFileTooLarge = errors.New("uploaded file is larger than its declared size")
+var errFileTooSmall = errors.New("uploaded file is smaller than its declared size")
+
+// bodyTooLargeMessage is the response to an upload whose body exceeds the size allowed by the files it declares.
+const bodyTooLargeMessage = "The request body is larger than the files declared in File-Size"
 
 func main() {
+	// The binary can also encrypt or decrypt local files with the same key, e.g. to check a downloaded object offline
+	encrypt := flag.Bool("encrypt", false, "encrypt the file at the first argument into the second one, - standing for stdin/stdout")
+	decrypt := flag.Bool("decrypt", false, "decrypt the file at the first argument into the second one, - standing for stdin/stdout")
+	flag.Parse()
+
+	// The server reports every problem of its configuration before starting, instead of failing on the first request
+	// which needs the faulty setting
+	if !*encrypt && !*decrypt {
+		if problems := validateConfig(); len(problems) > 0 {
+			for _, problem := range problems {
+				log.Println("Invalid configuration:", problem)
+			}
+			os.Exit(1)
+		}
+	}
+
+	// Files are encrypted with SYM_KEY, while the keys it replaced still decrypt the files uploaded before
 	c := cryptography.StreamCipher{}
-	c.Init(os.Getenv("SYM_KEY"))
+	if err := loadKeys(&c, os.Getenv("SYM_KEY"), os.Getenv("SYM_KEY_ID"), os.Getenv("OLD_SYM_KEYS")); err != nil {
+		log.Fatalln(err)
+	}
+	if *encrypt || *decrypt {
+		if *encrypt == *decrypt || flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "usage: api -encrypt|-decrypt <in> <out>")
+			os.Exit(2)
+		}
+		if err := cryptLocalFile(&c, *decrypt, flag.Arg(0), flag.Arg(1), os.Stdin, os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	// Log in JSON, including the messages of the log package
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	c.BufferSize = cfg.encryptionBufferSize
+	// The handlers encrypt and decrypt the files in the configured mode
+	cipher, err := newCipher(cfg.cipherMode, &c)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	endpoint := "minio:9000"
 	accessKeyID := os.Getenv("MINIO_USER")
 	secretAccessKey := os.Getenv("MINIO_PWD")
 
 	// Initialize minio client object, with disabled SSL due to the toy example setting.
-	minioClient, err := minio.New(endpoint, &minio.Options{
+	minioClient, err := minio.New(cfg.minioEndpoint, &minio.Options{
 		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
 		Secure: false,
 	})
@@ -257,82 +1040,625 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	// Fetch all current used object names at runtime to store this in RAM and avoid frequent calls to MinIO for unique ID generation.
-	err = fetchUidsFromMinio(&uidTracker, minioClient)
+	// Create the bucket if needed, for a fresh MinIO deployment to work out of the box
+	err = ensureBucket(context.Background(), minioClient, cfg.bucketName, cfg.region)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	// The ETags of the objects of a bucket encrypted by MinIO are not MD5 checksums, and cannot be checked
+	if cfg.verifyETag {
+		encrypted, err := usesServerSideEncryption(context.Background(), minioClient, cfg.bucketName)
+		if err != nil {
+			log.Printf("Unable to tell whether the bucket uses server-side encryption, the ETags of the uploads are checked: %v", err)
+		} else if encrypted {
+			log.Printf("Bucket %s uses server-side encryption, the ETags of the uploads are not checked", cfg.bucketName)
+			cfg.verifyETag = false
+		}
+	}
+	// The objects are stored under the hash of their names if OBJECT_KEY_SECRET is set, already validated
+	var bucketStore uploadStore = &minioStore{client: minioClient, bucket: cfg.bucketName}
+	var bucketPresigner presigner = &minioStore{client: minioClient, bucket: cfg.bucketName}
+	if objectKeySecret := os.Getenv("OBJECT_KEY_SECRET"); objectKeySecret != "" {
+		secret, _ := hex.DecodeString(objectKeySecret)
+		keys := &hashedKeyStore{store: bucketStore, secret: secret}
+		bucketStore = keys
+		bucketPresigner = &hashedKeyPresigner{presigner: bucketPresigner, keys: keys}
+	}
+	store := &retryStore{store: &instrumentedStore{store: bucketStore}, policy: cfg.retry}
+
+	// Fetch all current used object names at runtime to store this in RAM and avoid frequent calls to MinIO for unique ID generation.
+	// The checksums and filenames of the stored files are fetched along, to deduplicate and name the uploaded files.
+	// The listing is attempted again from the start if it fails midway, which the store cannot retry by itself. The
+	// server does not start if MinIO cannot be listed within the startup timeout.
+	startupCtx, cancelStartup := context.WithTimeout(context.Background(), cfg.startupTimeout)
+	err = withRetry(startupCtx, cfg.retry, func() error {
+		return fetchUidsFromMinio(startupCtx, &uidTracker, &fileChecksums, &fileNames, store)
+	})
+	cancelStartup()
+	if err != nil {
+		log.Fatalf("Unable to list the objects in MinIO within STARTUP_TIMEOUT (%s): %v", cfg.startupTimeout, err)
+	}
+
+	// Delete the expired objects in the background, free the reserved UIDs which were not used in time, and abort the
+	// upload sessions which were abandoned
+	go runReaper(context.Background(), store, &uidTracker, &fileNames, &uidReservations, &uploadSessions, cfg.reaperInterval)
+
+	// Requests must carry one of these API keys, unless none is configured
+	apiKeys := parseAPIKeys(os.Getenv("API_KEYS"))
+	if len(apiKeys) == 0 {
+		log.Println("API_KEYS is not set, authentication is disabled")
+	}
+
+	// Browsers may upload and fetch files from these origins, already validated by loadConfig
+	allowedOrigins, _ := parseAllowedOrigins(cfg.allowedOrigins)
 
 	// Set up the HTTP handler
-	http.HandleFunc("/upload", uploadHandler(minioClient, &c))
-	http.HandleFunc("/fetch", fetchAndDecryptHandler(minioClient, &c))
+	// The uploads of files and of the parts of resumable uploads share the slots bounding the memory they use
+	upload := uploadHandler(store, cipher, cfg)
+	startUpload := startUploadHandler(store, cipher, cfg, &uploadSessions)
+	uploadPart := uploadPartHandler(store, cipher, cfg, &uploadSessions)
+	completeUpload := completeUploadHandler(store, cipher, &uploadSessions)
+	if cfg.maxConcurrentUploads > 0 {
+		slots := newUploadSlots(cfg.maxConcurrentUploads)
+		upload = slots.limit(upload)
+		uploadPart = slots.limit(uploadPart)
+	}
+	// Starting a resumable upload counts as an upload, while its parts and completion are limited apart, so that a file
+	// sent by parts does not use up the uploads of its client
+	if cfg.uploadRateLimit > 0 {
+		uploads := newClientRateLimiter(cfg.uploadRateLimit, cfg.uploadRateBurst)
+		upload = uploads.limit(upload)
+		startUpload = uploads.limit(startUpload)
+		parts := newClientRateLimiter(cfg.uploadRateLimit, cfg.uploadRateBurst)
+		uploadPart = parts.limit(uploadPart)
+		completeUpload = parts.limit(completeUpload)
+	}
+	http.HandleFunc("/upload", instrument("upload", allowCORS(allowedOrigins, http.MethodPost, requireAPIKey(apiKeys, upload))))
+	http.HandleFunc("/upload/progress", instrument("upload_progress", allowCORS(allowedOrigins, http.MethodGet, requireAPIKey(apiKeys, uploadProgressHandler(&uploadProgresses)))))
+	http.HandleFunc("/upload/start", instrument("upload_start", allowCORS(allowedOrigins, http.MethodPost, requireAPIKey(apiKeys, startUpload))))
+	http.HandleFunc("/upload/part", instrument("upload_part", allowCORS(allowedOrigins, http.MethodPut, requireAPIKey(apiKeys, uploadPart))))
+	http.HandleFunc("/upload/complete", instrument("upload_complete", allowCORS(allowedOrigins, http.MethodPost, requireAPIKey(apiKeys, completeUpload))))
+	http.HandleFunc("/fetch", instrument("fetch", allowCORS(allowedOrigins, http.MethodGet, requireAPIKey(apiKeys, fetchAndDecryptHandler(store, cipher, cfg)))))
+	http.HandleFunc("/fetch-zip", instrument("fetch_zip", allowCORS(allowedOrigins, http.MethodGet, requireAPIKey(apiKeys, fetchZipHandler(store, cipher)))))
+	http.HandleFunc("/verify", instrument("verify", requireAPIKey(apiKeys, verifyHandler(store, cipher))))
+	http.HandleFunc("/list", instrument("list", requireAPIKey(apiKeys, listHandler(store))))
+	http.HandleFunc("/info", instrument("info", requireAPIKey(apiKeys, infoHandler(store))))
+	http.HandleFunc("/metadata", instrument("metadata", requireAPIKey(apiKeys, updateMetadataHandler(store, cfg))))
+	http.HandleFunc("/reserve", instrument("reserve", requireAPIKey(apiKeys, reserveHandler(UID_RESERVATION_DURATION))))
+	http.HandleFunc("/stats", instrument("stats", requireAPIKey(apiKeys, statsHandler(store, STATS_CACHE_DURATION))))
+	http.HandleFunc("/selftest", instrument("selftest", requireAPIKey(apiKeys, selfTestHandler(cipher))))
+	http.HandleFunc("/rekey", instrument("rekey", requireAPIKey(apiKeys, rekeyHandler(store, cipher, cfg))))
+	http.HandleFunc("/remap", instrument("remap", requireAPIKey(apiKeys, remapHandler(store, cfg))))
+	http.HandleFunc("/presign", instrument("presign", requireAPIKey(apiKeys, presignHandler(bucketPresigner, cfg.presignExpiry))))
+	http.Handle("/metrics", promhttp.Handler())
 
-	// Start the server
-	log.Println("Server started at :8080")
-	log.Println(http.ListenAndServe(":8080", nil))
+	// Start the server, and stop it gracefully when the process is asked to terminate
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// Verify the stored files in the background to detect bit rot, until the server stops
+	if cfg.scrubInterval > 0 {
+		go runScrubber(ctx, store, cipher, cfg.scrubInterval, SCRUB_PAUSE)
+	}
+	server := &http.Server{Addr: cfg.listenAddr, Handler: logRequests(slog.Default(), http.DefaultServeMux)}
+	log.Printf("Server started at %s", cfg.listenAddr)
+	if err := runServer(ctx, server, SHUTDOWN_TIMEOUT); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Server stopped")
+}
+
+// runServer serves requests until the context is done, at which point the server stops accepting new connections and
+// waits up to shutdownTimeout for the ongoing requests to complete. This lets in-flight uploads finish instead of leaving
+// partial objects in MinIO.
+func runServer(ctx context.Context, server *http.Server, shutdownTimeout time.Duration) error {
+	serverError := make(chan error, 1)
+	go func() {
+		serverError <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverError:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for ongoing requests to complete")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %v", err)
+	}
+	return nil
 }
 
 // fetchUidsFromMinio fetches the list of objects in the bucket to extract their uids and store them into the UID tracker in RAM.
-func fetchUidsFromMinio(tracker *uid.UidTracker, client *minio.Client) error {
+// The filenames of the objects are stored into the filename index, the checksums of the objects which never expire
+// into the checksum index, and the total size of the objects into storedBytes. If the listing fails or the context is done before it ends, an error is returned and the
+// indexes are left untouched.
+func fetchUidsFromMinio(ctx context.Context, tracker *uid.UidTracker, checksums *checksumIndex, filenames *filenameIndex, store uploadStore) error {
 	currentObjectIds := make([]uint64, 0, 100)
-	for obj := range client.ListObjects(context.Background(), BUCKET_NAME, minio.ListObjectsOptions{}) {
-		newUid, err := strconv.ParseUint(obj.Key, 10, 64)
-		if err == nil {
+	currentChecksums := make(map[string]string)
+	currentFilenames := make(map[filenameKey]string)
+	namespacedObjectIds := make(map[string][]uint64)
+	var totalSize int64
+	// Stop the listing if it is abandoned on an error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objects := store.ListObjects(ctx, "")
+	for {
+		var obj minio.ObjectInfo
+		var ok bool
+		// The listing is given up once the context is done, even if the store does not stop it
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case obj, ok = <-objects:
+		}
+		if !ok {
+			break
+		}
+		// A partial listing would leave the UIDs of the objects which were not listed untracked, and thus unfetchable,
+		// so the indexes are only initialized once every object was listed
+		if obj.Err != nil {
+			return obj.Err
+		}
+		totalSize += obj.Size
+		namespace, newUid, err := parseObjectKey(obj.Key)
+		if err == nil && namespace == "" {
 			currentObjectIds = append(currentObjectIds, newUid)
+		} else if err == nil {
+			namespacedObjectIds[namespace] = append(namespacedObjectIds[namespace], newUid)
+		}
+		if filename, ok := objectFilename(obj.UserMetadata); ok {
+			currentFilenames[newFilenameKey(filename, obj.Key)] = obj.Key
+		}
+		// The files of the namespaces are never deduplicated
+		if _, expires := objectExpiry(obj.UserMetadata); expires || namespace != "" {
+			continue
+		}
+		if checksum, ok := objectChecksum(obj.UserMetadata); ok {
+			if _, ok := currentChecksums[checksum]; !ok {
+				currentChecksums[checksum] = obj.Key
+			}
 		}
 	}
 	tracker.Init(currentObjectIds)
+	namespaceTrackers.reset(namespacedObjectIds)
+	checksums.reset(currentChecksums)
+	filenames.reset(currentFilenames)
+	storedBytes.reset(totalSize)
 	return nil
 }
 
 // getMaxNbrRunSeconds returns the maximal expected time it should take for the system to upload to MinIO.
-// This time is determined in a very conservative manner, and should therefore be a reasonable upper-bound for a timeout.
-func getMaxNbrRunSeconds(nbrUploadedBytes int64) time.Duration {
-	// We assume that on such a system, the slowest rate we should be observing is 1MB/s.
-	const minRateBytes float64 = 1024 * 1024 * 1
-	// Also account for the fact starting the upload may have a little overhead, so add 10s for safety.
-	safetySeconds := int64(10)
+// It is the time the upload takes at the slowest rate expected on the deployment, minRateBytes per second, plus a safety
+// margin accounting for the overhead of starting the upload, which matters most for small files. Both depend on the
+// infrastructure: a fast network allows a higher rate, which avoids huge files being given absurdly long timeouts.
+// The returned duration is capped to the largest time.Duration instead of overflowing.
+func getMaxNbrRunSeconds(nbrUploadedBytes int64, minRateBytes float64, safetyMargin time.Duration) time.Duration {
 	// Calculate how many seconds it should take using the slowest assumed byte rate upload
-	// Convert these seconds to nanoseconds for successful type change to time.Duration
-	return time.Duration((safetySeconds + int64(math.Ceil(float64(nbrUploadedBytes)/minRateBytes))) * int64(math.Pow10(9)))
+	uploadSeconds := math.Ceil(float64(nbrUploadedBytes) / minRateBytes)
+	if uploadSeconds >= float64(math.MaxInt64-safetyMargin)/float64(time.Second) {
+		return time.Duration(math.MaxInt64)
+	}
+	return safetyMargin + time.Duration(uploadSeconds)*time.Second
+}
+
+// MAX_TIMEOUT_RETRY_AFTER caps the delay suggested to the clients whose upload timed out, which would otherwise grow
+// with the size of their file well beyond the time a struggling MinIO needs to recover.
+const MAX_TIMEOUT_RETRY_AFTER = 15 * time.Minute
+
+// setTimeoutRetryAfter tells the client of an upload which timed out how many seconds to wait before retrying, through
+// the Retry-After header. The delay is drawn between half and all of the time the upload was given, as returned by
+// getMaxNbrRunSeconds, so that larger files back off longer, and the clients whose uploads timed out together do not
+// hit MinIO again all at once.
+func setTimeoutRetryAfter(w http.ResponseWriter, timeout time.Duration) {
+	timeout = min(timeout, MAX_TIMEOUT_RETRY_AFTER)
+	delay := timeout/2 + rand.N(timeout/2+1)
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(math.Ceil(delay.Seconds()), 1))))
 }
 
 // getUniqueObjectName returns true if an error occurred, meaning the program should return.
 // On the other hand, if it returns false, the returned string contains a unique identifier for the uploaded file.
 // The appropriate error and error code will be sent to the user in the function directly.
+// The UID is unique within the namespace of the request, which prefixes the returned object name.
 func getUniqueObjectName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace, err := requestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", true
+	}
+	tracker := namespaceTrackers.tracker(namespace)
 	var objectName string
+	suggestedUid, chosen, err := requestedUid(r)
+	if errors.Is(err, errRepeatedUid) {
+		http.Error(w, "A single Uid header should be given", http.StatusBadRequest)
+		return "", true
+	} else if err != nil {
+		http.Error(w, "The UID provided in the header cannot be parsed as a uint64.", http.StatusPreconditionFailed)
+		return "", true
+	}
 	// If the request header contains a UID field, try using it
-	if uidStr, ok := r.Header["Uid"]; ok {
-		suggestedUid, err := strconv.ParseUint(uidStr[0], 10, 64)
-		if err != nil {
-			http.Error(w, "The UID provided in the header cannot be parsed as a uint64.", http.StatusPreconditionFailed)
-			return "", true
+	if chosen {
+		// A UID which was reserved is already tracked, and can be used once. UIDs are only reserved in the default
+		// namespace.
+		if namespace == "" && uidReservations.claim(suggestedUid) {
+			return objectKey(namespace, suggestedUid), false
 		}
-		added, err := uidTracker.AddUid(suggestedUid)
+		added, err := tracker.AddUid(suggestedUid)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusConflict)
 			return "", true
 		}
-		objectName = strconv.FormatUint(added, 10)
+		objectName = objectKey(namespace, added)
 
 	} else {
 		// If it does not contain a UID field, generate one for them
 		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
 		defer cancel()
-		added, err := uidTracker.GenerateAndAdd(ctx)
+		added, attempts, err := tracker.GenerateAndAddWithAttempts(ctx)
+		uidGenerationAttempts.Observe(float64(attempts))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return "", true
 		}
-		objectName = strconv.FormatUint(added, 10)
+		objectName = objectKey(namespace, added)
 	}
 	return objectName, false
 }
 
-// sendToEncryption reads the data in the buffer and copies it to a stream.
-func sendToEncryption(data []byte, writer io.Writer) error {
-	// Write the plaintext data to the writer
-	_, err := writer.Write(data)
-	return err
+var errRepeatedUid = errors.New("several UIDs were chosen")
+
+// uidChosen tells whether a request chooses the UID of its file with its Uid header, which chooses none when it is
+// empty, as sent by the clients which always set it.
+func uidChosen(r *http.Request) bool {
+	return slices.ContainsFunc(r.Header.Values("Uid"), func(value string) bool { return value != "" })
+}
+
+// requestedUid returns the UID chosen with the Uid header of a request, and false if none was chosen, the header being
+// absent or empty. errRepeatedUid is returned if the header holds several UIDs, and any other error means the UID
+// cannot be parsed as a uint64.
+func requestedUid(r *http.Request) (uint64, bool, error) {
+	var uidStr string
+	for _, value := range r.Header.Values("Uid") {
+		if value == "" {
+			continue
+		}
+		if uidStr != "" {
+			return 0, false, errRepeatedUid
+		}
+		uidStr = value
+	}
+	if uidStr == "" {
+		return 0, false, nil
+	}
+	chosenUid, err := strconv.ParseUint(uidStr, 10, 64)
+	return chosenUid, err == nil, err
+}
+
+// checkObjectNameFree returns true if no file can be stored under the given object name, after rejecting the request.
+// A UID which is free in the tracker may still name an object in MinIO, e.g. if the objects were not all listed at
+// startup, and uploading to it would overwrite another file. The upload is then rejected with a 409 and the UID stays
+// in use, as it names an object. If MinIO cannot tell whether the object exists, the UID is freed.
+func checkObjectNameFree(w http.ResponseWriter, ctx context.Context, store ObjectStore, objectName string) bool {
+	_, err := store.StatObject(ctx, objectName)
+	if err == nil {
+		loggerFrom(ctx).Warn("Untracked object found under a free UID", "uid", objectName)
+		http.Error(w, fmt.Sprintf("A file is already stored under UID %s", objectUid(objectName)), http.StatusConflict)
+		return true
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		releaseObjectName(objectName)
+		http.Error(w, "Failed to check that the UID is free in MinIO", http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// getPlaintextSize returns the size of the file stored in an object, which also holds the header of its encryption, for
+// the handlers which describe the stored objects without a Cipher. The size of the header depends on the version of the
+// stream, which the first bytes of the object are fetched to tell. Handlers holding a Cipher rely on fetchStreamHeader.
+func getPlaintextSize(ctx context.Context, store ObjectStore, objectInfo minio.ObjectInfo) (int64, error) {
+	// The objects recording the size of their file were all stored since the header has its current version
+	if _, ok := metadataValue(objectInfo.UserMetadata, PLAINTEXT_SIZE_METADATA); ok {
+		return objectInfo.Size - cryptography.HEADER_SIZE, nil
+	}
+	prefix, err := fetchObjectPrefix(ctx, store, objectInfo.Key, cryptography.HEADER_PREFIX_SIZE)
+	if err != nil {
+		return 0, err
+	}
+	headerSize, err := cryptography.StreamHeaderSize(prefix)
+	if err != nil {
+		return 0, err
+	}
+	return objectInfo.Size - int64(headerSize), nil
+}
+
+// fetchStreamHeader returns the header of the encrypted stream held by an object, whose size depends on the version of
+// the stream. Only the first HeaderSize() bytes of the object are fetched, which hold the header of every version.
+func fetchStreamHeader(ctx context.Context, store ObjectStore, cipher cryptography.Cipher, objectName string) ([]byte, error) {
+	prefix, err := fetchObjectPrefix(ctx, store, objectName, int64(cipher.HeaderSize()))
+	if err != nil {
+		return nil, err
+	}
+	headerSize, err := cipher.StreamHeaderSize(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) < headerSize {
+		return nil, fmt.Errorf("the object of %d bytes is shorter than its header", len(prefix))
+	}
+	return prefix[:headerSize], nil
+}
+
+// fetchObjectPrefix returns the first size bytes of an object, or the whole object if it is shorter.
+func fetchObjectPrefix(ctx context.Context, store ObjectStore, objectName string, size int64) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(0, size-1); err != nil {
+		return nil, err
+	}
+	object, err := store.GetObject(ctx, objectName, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(io.LimitReader(object, size))
+}
+
+var errMultipleRanges = errors.New("multiple ranges are not supported")
+
+// parseRange parses a Range header of the form `bytes=start-end`, `bytes=start-` or `bytes=-suffixLength` for a file of
+// the given size. It returns the inclusive bounds of the requested bytes, clamped to the file size.
+// errMultipleRanges is returned if several ranges are requested, and any other error means the range is not satisfiable.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported range unit in %q", header)
+	}
+	if strings.Contains(spec, ",") {
+		return 0, 0, errMultipleRanges
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("malformed range %q", header)
+	}
+
+	var start, end int64
+	if startStr == "" {
+		// A suffix range requests the last bytes of the file
+		suffixLength, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffixLength <= 0 {
+			return 0, 0, fmt.Errorf("malformed range %q", header)
+		}
+		start, end = max(size-suffixLength, 0), size-1
+	} else {
+		var err error
+		start, err = strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return 0, 0, fmt.Errorf("malformed range %q", header)
+		}
+		end = size - 1
+		if endStr != "" {
+			end, err = strconv.ParseInt(endStr, 10, 64)
+			if err != nil || end < start {
+				return 0, 0, fmt.Errorf("malformed range %q", header)
+			}
+			end = min(end, size-1)
+		}
+	}
+	if start >= size {
+		return 0, 0, fmt.Errorf("range %q starts beyond the file size %d", header, size)
+	}
+	return start, end, nil
+}
+
+// serveDecryptedRange sends the plaintext bytes from start to end (inclusive) of the object as a partial content response.
+// The header of the encrypted stream, already fetched by the caller, gives the key and the IV, and only the cipher blocks
+// covering the range are fetched from MinIO.
+func serveDecryptedRange(ctx context.Context, w http.ResponseWriter, store ObjectStore, cipher cryptography.Cipher, objectName string, header []byte, start, end, plaintextSize int64) {
+	// Fetch the ciphertext from the start of the block containing the first requested byte
+	headerSize := int64(len(header))
+	rangeOpts := minio.GetObjectOptions{}
+	if err := rangeOpts.SetRange(headerSize+cryptography.BlockStart(start), headerSize+end); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	object, err := store.GetObject(ctx, objectName, rangeOpts)
+	if err != nil {
+		http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, plaintextSize))
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	sentData := &countingWriter{writer: w}
+	err = cipher.DecryptStreamAt(header, start, object, sentData)
+	downloadedBytesTotal.Add(float64(sentData.count))
+	if err != nil {
+		loggerFrom(ctx).Error("Error during decryption of range", "uid", objectName, "start", start, "end", end, "error", err)
+		return
+	}
+	downloadsTotal.Inc()
+}
+
+// httpError is an error along with the status code it should be reported to the user with.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+// STATUS_CLIENT_CLOSED_REQUEST is the non-standard status, introduced by nginx, of the requests abandoned by their
+// client. It is only recorded in the logs and metrics, since no client is left to read it.
+const STATUS_CLIENT_CLOSED_REQUEST = 499
+
+// contextError returns the error a request stopped by its context is reported with, or nil if the context is not done.
+// A request which timed out fails with a 504, while a cancelled request, usually because the client went away, gets
+// STATUS_CLIENT_CLOSED_REQUEST.
+func contextError(ctx context.Context) *httpError {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &httpError{status: http.StatusGatewayTimeout, message: "The request timed out"}
+	case errors.Is(ctx.Err(), context.Canceled):
+		return &httpError{status: STATUS_CLIENT_CLOSED_REQUEST, message: "The request was cancelled"}
+	}
+	return nil
+}
+
+// writeError sends the error to the client. The requests cancelled by their client are only logged, and recorded
+// with STATUS_CLIENT_CLOSED_REQUEST without a body.
+func writeError(w http.ResponseWriter, ctx context.Context, e *httpError) {
+	if e.status == STATUS_CLIENT_CLOSED_REQUEST {
+		loggerFrom(ctx).Info("Request cancelled by the client")
+		w.WriteHeader(e.status)
+		return
+	}
+	http.Error(w, e.message, e.status)
+}
+
+// firstError keeps the first error reported by concurrent goroutines. Since the goroutines of a pipeline fail in cascade
+// when one of them closes its pipes, the first error is the root cause of the failure.
+type firstError struct {
+	mu  sync.Mutex
+	err *httpError
+}
+
+// set records the error unless another one was already recorded.
+func (f *firstError) set(status int, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = &httpError{status: status, message: message}
+	}
+}
+
+// get returns the recorded error, or nil if none was.
+func (f *firstError) get() *httpError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// uploadedFileDetails holds the information parsed from the uploaded part's header, which is stored alongside the object.
+type uploadedFileDetails struct {
+	filename    string
+	contentType string
+	// compressed tells whether the file is gzipped before its encryption
+	compressed bool
+}
+
+// defaultContentType is used whenever the type of an uploaded file is unknown.
+const defaultContentType = "application/octet-stream"
+
+// Filenames are stored in the metadata of the objects, and cannot be longer than most filesystems allow.
+const MAX_FILENAME_LENGTH = 255
+
+// partFilename returns the name of the file held by an uploaded part, stripped of any directory, whether it was written
+// with slashes or backslashes, so that a file cannot be saved outside of the download directory of the users fetching
+// it. An empty name is returned if the part has none. Names holding control characters are rejected.
+func partFilename(part *multipart.Part) (string, error) {
+	// The multipart reader already strips the directories written with slashes
+	filename := part.FileName()
+	if filename == "" {
+		return "", nil
+	}
+	if i := strings.LastIndex(filename, "\\"); i >= 0 {
+		filename = filename[i+1:]
+	}
+	if err := checkFilename(filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// checkFilename returns an error if the filename, without any directory, cannot be given to a stored file.
+func checkFilename(filename string) error {
+	switch {
+	case filename == "" || filename == "." || filename == ".." || strings.ContainsAny(filename, "/\\"):
+		return fmt.Errorf("the filename %q does not name a file", filename)
+	case len(filename) > MAX_FILENAME_LENGTH:
+		return fmt.Errorf("filenames may not be longer than %d bytes", MAX_FILENAME_LENGTH)
+	case strings.ContainsFunc(filename, unicode.IsControl):
+		return fmt.Errorf("the filename %q holds control characters", filename)
+	}
+	return nil
+}
+
+// partContentType returns the content type declared in the header of an uploaded part.
+// If it is missing or cannot be parsed, the generic binary type is returned instead.
+func partContentType(header textproto.MIMEHeader) string {
+	contentType := header.Get("Content-Type")
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return defaultContentType
+	}
+	return contentType
+}
+
+// contentTypeOrDefault returns the stored content type, or the generic binary type if none was stored.
+func contentTypeOrDefault(contentType string) string {
+	if contentType == "" {
+		return defaultContentType
+	}
+	return contentType
+}
+
+// getRequestedObjectName returns true if an error occurred, meaning the program should return.
+// On the other hand, if it returns false, the returned string is the name of the object stored under the UID in the request's query.
+// The appropriate error and error code will be sent to the user in the function directly.
+func getRequestedObjectName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	uidStr := r.URL.Query().Get("uid")
+	if uidStr == "" {
+		http.Error(w, "Missing UID", http.StatusBadRequest)
+		return "", true
+	}
+	uid, err := strconv.ParseUint(uidStr, 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", true
+	}
+	// Files are only found in the namespace they were uploaded to
+	namespace, err := requestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", true
+	}
+	if !namespaceTrackers.tracker(namespace).Contains(uid) {
+		http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+		return "", true
+	}
+	// Objects are named after the decimal form of their UID, so UIDs written differently, e.g. with leading zeros,
+	// refer to the same object.
+	return objectKey(namespace, uid), false
+}
+
+// clientReader wraps the reader of an uploaded file to mark its errors as clientReadError, telling them apart from the
+// failures of the rest of the upload pipeline.
+type clientReader struct {
+	reader io.Reader
+}
+
+func (r clientReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = clientReadError{err}
+	}
+	return n, err
+}
+
+// clientReadError is an error which occurred while reading the file uploaded by the client.
+type clientReadError struct {
+	err error
+}
+
+func (e clientReadError) Error() string {
+	return e.err.Error()
+}
+
+func (e clientReadError) Unwrap() error {
+	return e.err
 }
@@ -0,0 +1,1742 @@
+package main
+
+import (
+	"api/cryptography"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const testHexKey = "6368616e676520746869732070617373776f726420746f206120736563726574"
+
+// memoryStore is an in-memory uploadStore used to exercise the handlers without MinIO.
+type memoryStore struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	// uploads holds the multipart uploads in progress, by upload ID
+	uploads map[string]*memoryMultipartUpload
+}
+
+// memoryMultipartUpload is a multipart upload of a memoryStore, whose parts are held by part number.
+type memoryMultipartUpload struct {
+	objectName string
+	opts       minio.PutObjectOptions
+	parts      map[int][]byte
+}
+
+type memoryObject struct {
+	data []byte
+	info minio.ObjectInfo
+}
+
+// errObjectNotFound is the error MinIO responds with when an object does not exist.
+var errObjectNotFound = minio.ErrorResponse{Code: "NoSuchKey", Message: "The specified key does not exist.", StatusCode: http.StatusNotFound}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: make(map[string]memoryObject), uploads: make(map[string]*memoryMultipartUpload)}
+}
+
+// PutObject mimics MinIO by reading exactly objectSize bytes from the reader, failing if fewer are available.
+func (s *memoryStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	// Like MinIO, read the exact declared size, or the whole stream when the size is unknown
+	var data []byte
+	var err error
+	if objectSize < 0 {
+		data, err = io.ReadAll(reader)
+		objectSize = int64(len(data))
+	} else {
+		data = make([]byte, objectSize)
+		_, err = io.ReadFull(reader, data)
+	}
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[objectName] = memoryObject{
+		data: data,
+		info: minio.ObjectInfo{
+			Key:          objectName,
+			Size:         objectSize,
+			ContentType:  opts.ContentType,
+			UserMetadata: opts.UserMetadata,
+			UserTags:     opts.UserTags,
+			LastModified: time.Now(),
+		},
+	}
+	// Like MinIO, the ETag of an object is the MD5 checksum of its content
+	etag := md5.Sum(data)
+	return minio.UploadInfo{Key: objectName, Size: objectSize, ETag: hex.EncodeToString(etag[:])}, nil
+}
+
+// GetObject returns the object content, honoring the `bytes=start-end` and `bytes=start-` ranges set in the options.
+func (s *memoryStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[objectName]
+	if !ok {
+		return nil, errObjectNotFound
+	}
+	data := object.data
+	if rangeHeader := opts.Header().Get("Range"); rangeHeader != "" {
+		startStr, endStr, _ := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		end := int64(len(data)) - 1
+		if endStr != "" {
+			if end, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		// Like MinIO, a range ending beyond the object stops at its end
+		data = data[start:min(end+1, int64(len(data)))]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *memoryStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[objectName]
+	if !ok {
+		return minio.ObjectInfo{}, errObjectNotFound
+	}
+	return object.info, nil
+}
+
+func (s *memoryStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[objectName]
+	if !ok {
+		return errObjectNotFound
+	}
+	object.info.ContentType = contentType
+	object.info.UserMetadata = maps.Clone(userMetadata)
+	s.objects[objectName] = object
+	return nil
+}
+
+func (s *memoryStore) CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[srcObjectName]
+	if !ok {
+		return errObjectNotFound
+	}
+	object.info.Key = objectName
+	object.info.ContentType = contentType
+	object.info.UserMetadata = maps.Clone(userMetadata)
+	s.objects[objectName] = object
+	return nil
+}
+
+// ListObjects mimics MinIO by listing the objects in the lexicographic order of their names, after startAfter.
+func (s *memoryStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make(chan minio.ObjectInfo, len(s.objects))
+	for _, objectName := range slices.Sorted(maps.Keys(s.objects)) {
+		if objectName > startAfter {
+			objects <- s.objects[objectName].info
+		}
+	}
+	close(objects)
+	return objects
+}
+
+func (s *memoryStore) RemoveObject(ctx context.Context, objectName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, objectName)
+	return nil
+}
+
+// AbortUpload has nothing to do, as the memory store only keeps objects which were entirely uploaded.
+func (s *memoryStore) AbortUpload(ctx context.Context, objectName string) error {
+	return nil
+}
+
+func (s *memoryStore) NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadID := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+	for s.uploads[uploadID] != nil {
+		uploadID += "+"
+	}
+	s.uploads[uploadID] = &memoryMultipartUpload{objectName: objectName, opts: opts, parts: make(map[int][]byte)}
+	return uploadID, nil
+}
+
+// PutObjectPart reads the exact size of the part, like MinIO, and replaces any part uploaded under the same number.
+func (s *memoryStore) PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return minio.ObjectPart{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.objectName != objectName {
+		return minio.ObjectPart{}, errUploadNotFound
+	}
+	upload.parts[partNumber] = data
+	return minio.ObjectPart{PartNumber: partNumber, ETag: fmt.Sprintf("etag-%d-%d", partNumber, size), Size: size}, nil
+}
+
+// CompleteMultipartUpload stores the concatenation of the given parts, which must be in increasing order, as the object.
+func (s *memoryStore) CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.objectName != objectName {
+		return errUploadNotFound
+	}
+	var data []byte
+	for i, part := range parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || (i > 0 && part.PartNumber <= parts[i-1].PartNumber) {
+			return minio.ErrorResponse{Code: "InvalidPart", Message: "One or more of the specified parts could not be found.", StatusCode: http.StatusBadRequest}
+		}
+		data = append(data, partData...)
+	}
+	delete(s.uploads, uploadID)
+	s.objects[objectName] = memoryObject{
+		data: data,
+		info: minio.ObjectInfo{
+			Key:          objectName,
+			Size:         int64(len(data)),
+			ContentType:  upload.opts.ContentType,
+			UserMetadata: upload.opts.UserMetadata,
+			UserTags:     upload.opts.UserTags,
+			LastModified: time.Now(),
+		},
+	}
+	return nil
+}
+
+func (s *memoryStore) AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.uploads[uploadID]; !ok {
+		return errUploadNotFound
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// errUploadNotFound is the error MinIO responds with when a multipart upload does not exist.
+var errUploadNotFound = minio.ErrorResponse{Code: "NoSuchUpload", Message: "The specified multipart upload does not exist.", StatusCode: http.StatusNotFound}
+
+// newTestCipher returns a stream cipher initialized with the test key.
+func newTestCipher() *cryptography.StreamCipher {
+	c := cryptography.StreamCipher{}
+	c.Init(testHexKey)
+	return &c
+}
+
+// newUploadRequest builds a multipart upload request containing a single file part with the given name, type and content.
+func newUploadRequest(t testing.TB, filename, contentType string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, filename))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", strconv.Itoa(len(content)))
+	return r
+}
+
+// uploadFile runs an upload through the handler and returns the UID the file was stored under.
+func uploadFile(t *testing.T, store uploadStore, filename, contentType string, content []byte) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, newUploadRequest(t, filename, contentType, content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	return uidFromResponse(w.Body.String())
+}
+
+// uidFromResponse extracts the UID from the success message of an upload.
+func uidFromResponse(body string) string {
+	fields := strings.Fields(body)
+	return fields[slices.Index(fields, "UID")+1]
+}
+
+// fetchFile runs a fetch of the given UID through the handler, with the optional extra request headers.
+func fetchFile(store ObjectStore, objectName string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil)
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, r)
+	return w
+}
+
+func TestFetchRestoresContentType(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "notes.txt", "text/plain", []byte("some plain text"))
+
+	w := fetchFile(store, objectName, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/plain")
+	}
+}
+
+func TestFetchDefaultsContentType(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "blob", "", []byte{0x00, 0x01, 0x02})
+
+	w := fetchFile(store, objectName, nil)
+	if got := w.Header().Get("Content-Type"); got != defaultContentType {
+		t.Errorf("Content-Type = %q, want %q", got, defaultContentType)
+	}
+}
+
+func TestFetchRange(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("We took lots of breaks and sat in cafes along the river Seine. The French food we ate was delicious.")
+	objectName := uploadFile(t, store, "seine.txt", "text/plain", content)
+	size := len(content)
+
+	tests := []struct {
+		rangeHeader  string
+		start, end   int
+		contentRange string
+	}{
+		{"bytes=5-20", 5, 20, fmt.Sprintf("bytes 5-20/%d", size)},
+		{"bytes=0-0", 0, 0, fmt.Sprintf("bytes 0-0/%d", size)},
+		{"bytes=17-", 17, size - 1, fmt.Sprintf("bytes 17-%d/%d", size-1, size)},
+		{"bytes=-10", size - 10, size - 1, fmt.Sprintf("bytes %d-%d/%d", size-10, size-1, size)},
+		{"bytes=90-5000", 90, size - 1, fmt.Sprintf("bytes 90-%d/%d", size-1, size)},
+	}
+	for _, test := range tests {
+		w := fetchFile(store, objectName, http.Header{"Range": {test.rangeHeader}})
+		if w.Code != http.StatusPartialContent {
+			t.Fatalf("Range %s: status %d, want %d", test.rangeHeader, w.Code, http.StatusPartialContent)
+		}
+		if got := w.Header().Get("Content-Range"); got != test.contentRange {
+			t.Errorf("Range %s: Content-Range = %q, want %q", test.rangeHeader, got, test.contentRange)
+		}
+		if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+			t.Errorf("Range %s: Accept-Ranges = %q, want %q", test.rangeHeader, got, "bytes")
+		}
+		if got, want := w.Header().Get("Content-Length"), strconv.Itoa(test.end-test.start+1); got != want {
+			t.Errorf("Range %s: Content-Length = %s, want %s", test.rangeHeader, got, want)
+		}
+		if want := content[test.start : test.end+1]; !bytes.Equal(w.Body.Bytes(), want) {
+			t.Errorf("Range %s: body = %q, want %q", test.rangeHeader, w.Body.Bytes(), want)
+		}
+	}
+}
+
+func TestFetchUnsatisfiableRange(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "short.txt", "text/plain", []byte("short"))
+
+	for _, rangeHeader := range []string{"bytes=5-10", "bytes=3-1", "bytes=abc", "items=0-1"} {
+		w := fetchFile(store, objectName, http.Header{"Range": {rangeHeader}})
+		if w.Code != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("Range %s: status %d, want %d", rangeHeader, w.Code, http.StatusRequestedRangeNotSatisfiable)
+		}
+		if got := w.Header().Get("Content-Range"); got != "bytes */5" {
+			t.Errorf("Range %s: Content-Range = %q, want %q", rangeHeader, got, "bytes */5")
+		}
+	}
+}
+
+func TestFetchMultipleRangesFallsBackToFullFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("the whole file")
+	objectName := uploadFile(t, store, "whole.txt", "text/plain", content)
+
+	w := fetchFile(store, objectName, http.Header{"Range": {"bytes=0-1,4-5"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status %d, want %d", w.Code, http.StatusOK)
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Body = %q, want the full file %q", w.Body.Bytes(), content)
+	}
+}
+
+func TestFetchCanonicalizesUid(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Reachable however the UID is written.")
+	objectName := uploadFile(t, store, "zeros.txt", "text/plain", content)
+
+	w := fetchFile(store, "000"+objectName, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetch with leading zeros failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetch with leading zeros returned %q, want %q", w.Body.String(), content)
+	}
+}
+
+func TestFetchSetsContentLength(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	for _, content := range [][]byte{[]byte("a"), []byte("The wines were tasty, too."), bytes.Repeat([]byte{0xab}, 100000)} {
+		objectName := uploadFile(t, store, "file.bin", "", content)
+
+		w := fetchFile(store, objectName, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+		}
+		if got, want := w.Header().Get("Content-Length"), strconv.Itoa(len(content)); got != want {
+			t.Errorf("Content-Length = %s, want %s", got, want)
+		}
+		if !bytes.Equal(w.Body.Bytes(), content) {
+			t.Errorf("Fetched body of length %d differs from the uploaded file of length %d", w.Body.Len(), len(content))
+		}
+	}
+}
+
+// storeLegacyObject stores the content under the object name in the format of the objects stored before the header of
+// the streams was versioned: the IV followed by the ciphertext, with testHexKey and none of the metadata recorded since.
+func storeLegacyObject(t *testing.T, store *memoryStore, objectName, filename string, content []byte) {
+	t.Helper()
+	// CTR mode gives the same ciphertext for the same key and IV, whatever the header preceding it
+	iv := bytes.Repeat([]byte{0x42}, aes.BlockSize)
+	var encrypted bytes.Buffer
+	if err := newTestCipher().EncryptStreamWithIV(bytes.NewReader(content), &encrypted, iv); err != nil {
+		t.Fatal(err)
+	}
+	legacy := append(iv, encrypted.Bytes()[cryptography.HEADER_SIZE:]...)
+	opts := minio.PutObjectOptions{ContentType: "text/plain", UserMetadata: map[string]string{"Filename": filename}}
+	if _, err := store.PutObject(context.Background(), objectName, bytes.NewReader(legacy), int64(len(legacy)), opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFetchLegacyObject(t *testing.T) {
+	uidTracker.Init([]uint64{42})
+	store := newMemoryStore()
+	content := []byte("Stored before the header of the streams held a version and a key ID.")
+	storeLegacyObject(t, store, "42", "legacy.txt", content)
+
+	w := fetchFile(store, "42", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Fatalf("Fetch gave status %d and %q, want %q", w.Code, w.Body.Bytes(), content)
+	}
+	if got, want := w.Header().Get("Content-Length"), strconv.Itoa(len(content)); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+	w = fetchFile(store, "42", http.Header{"Range": {"bytes=20-40"}})
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), content[20:41]) {
+		t.Errorf("Range fetch gave status %d and %q, want %q", w.Code, w.Body.Bytes(), content[20:41])
+	}
+	if size, err := getPlaintextSize(context.Background(), store, store.objects["42"].info); err != nil || size != int64(len(content)) {
+		t.Errorf("getPlaintextSize() = %d, %v, want %d", size, err, len(content))
+	}
+}
+
+func TestUploadRejectsDeclaredSizeOverLimit(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	w := httptest.NewRecorder()
+	cfg := defaultConfig()
+	cfg.maxUploadSize = 10
+	uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, "big.txt", "text/plain", []byte("eleven byte")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+func TestUploadRejectsBodyLargerThanDeclared(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	r := newUploadRequest(t, "liar.txt", "text/plain", bytes.Repeat([]byte("much more than declared "), 100))
+	r.Header.Set("File-Size", "5")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+// The data sent around the files, such as the preamble of the multipart body, is bounded by the declared sizes too.
+func TestUploadBodyLimitedByDeclaredSizes(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	content := []byte("ten bytes!")
+	r := newUploadRequest(t, "padded.txt", "text/plain", content)
+	preamble := bytes.Repeat([]byte("padding\r\n"), 128*1024)
+	body := &countingReader{reader: io.MultiReader(bytes.NewReader(preamble), r.Body)}
+	r.Body = io.NopCloser(body)
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body.String())
+	}
+	if limit := maxUploadBodySize([]int64{int64(len(content))}, 0); body.count > limit+int64(len(preamble))/2 {
+		t.Errorf("%d bytes of the body were read, want about the limit of %d", body.count, limit)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+func TestMaxUploadBodySize(t *testing.T) {
+	for _, test := range []struct {
+		fileSizes []int64
+		sizeLimit int64
+		want      int64
+	}{
+		{[]int64{10}, 100, 10 + 2*MULTIPART_OVERHEAD},
+		{[]int64{10, UNKNOWN_FILE_SIZE}, 100, 110 + 3*MULTIPART_OVERHEAD},
+		{[]int64{math.MaxInt64 - 10}, 0, math.MaxInt64},
+	} {
+		if got := maxUploadBodySize(test.fileSizes, test.sizeLimit); got != test.want {
+			t.Errorf("maxUploadBodySize(%v, %d) = %d, want %d", test.fileSizes, test.sizeLimit, got, test.want)
+		}
+	}
+}
+
+// An empty file is stored as the header of its encryption alone, and must be fetched back as 0 bytes with its filename,
+// whichever way it is uploaded.
+func TestUploadEmptyFileRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		fileSize string
+		compress string
+		spill    bool
+	}{
+		{"declared size", "0", "", false},
+		{"unknown size", "unknown", "", false},
+		{"unknown size spilled", "unknown", "", true},
+		{"compressed", "0", "gzip", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			uidTracker.Init(nil)
+			store := newMemoryStore()
+			cfg := defaultConfig()
+			if test.spill {
+				cfg.spillDir = t.TempDir()
+			}
+			r := newUploadRequest(t, "empty.txt", "text/plain", nil)
+			r.Header.Set("File-Size", test.fileSize)
+			r.Header.Set("Compress", test.compress)
+
+			w := httptest.NewRecorder()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				uploadHandler(store, newTestCipher(), cfg)(w, r)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("The upload of an empty file hung")
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+			}
+			objectName := uidFromResponse(w.Body.String())
+			if test.compress == "" && store.objects[objectName].info.Size != cryptography.HEADER_SIZE {
+				t.Errorf("Stored %d bytes, want only the %d bytes of the header", store.objects[objectName].info.Size, cryptography.HEADER_SIZE)
+			}
+
+			w = fetchFile(store, objectName, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("Fetched %q, want an empty file", w.Body.String())
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="empty.txt"` {
+				t.Errorf("Content-Disposition = %q, want the filename of the upload", got)
+			}
+			if got := w.Header().Get("X-Content-SHA256"); got != hex.EncodeToString(sha256.New().Sum(nil)) {
+				t.Errorf("X-Content-SHA256 = %q, want the checksum of an empty file", got)
+			}
+		})
+	}
+}
+
+func TestUploadEmptyMultipartBodyFailsPromptly(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", "10")
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The upload of an empty multipart body hung instead of failing")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+func TestUploadRoundTripsFilesLargerThanChunk(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.chunkSize = 1000
+
+	for _, size := range []int{0, 1, 999, 1000, 1001, 25_000} {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i * 7)
+		}
+		w := httptest.NewRecorder()
+		uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, "diary.bin", "", content))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Upload of %d bytes failed with status %d: %s", size, w.Code, w.Body.String())
+		}
+		fetched := fetchFile(store, uidFromResponse(w.Body.String()), nil)
+		if !bytes.Equal(fetched.Body.Bytes(), content) {
+			t.Errorf("Fetched file of %d bytes differs from the uploaded one", size)
+		}
+	}
+}
+
+func TestUploadRoundTripsFilesLargerThanDefaultChunk(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	// The file spans several chunks of the default size, and ends in the middle of one
+	content := make([]byte, 3*DEFAULT_CHUNK_SIZE+DEFAULT_CHUNK_SIZE/3)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256.Sum256(content)
+
+	for name, r := range map[string]*http.Request{
+		"declared size": newUploadRequest(t, "large.bin", "", content),
+		"unknown size":  newStreamedUploadRequest("large.bin", content),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Upload of %d bytes failed with status %d: %s", len(content), w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Content-SHA256"); got != hex.EncodeToString(checksum[:]) {
+				t.Errorf("Checksum = %s, want the one of the uploaded file", got)
+			}
+			fetched := fetchFile(store, uidFromResponse(w.Body.String()), nil)
+			if fetched.Body.Len() != len(content) {
+				t.Fatalf("Fetched %d bytes, want %d", fetched.Body.Len(), len(content))
+			}
+			// The first differing byte tells which chunk lost or reordered bytes
+			if fetchedContent := fetched.Body.Bytes(); !bytes.Equal(fetchedContent, content) {
+				offset := 0
+				for fetchedContent[offset] == content[offset] {
+					offset++
+				}
+				t.Errorf("Fetched file differs from the uploaded one from byte %d on, in chunk %d", offset, offset/DEFAULT_CHUNK_SIZE)
+			}
+		})
+	}
+}
+
+// newStreamedUploadRequest builds a multipart upload request whose body is streamed, so that its length is unknown.
+func newStreamedUploadRequest(filename string, content []byte) *http.Request {
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	go func() {
+		part, err := writer.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = part.Write(content)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+	r := httptest.NewRequest(http.MethodPost, "/upload", bodyReader)
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestUploadOfUnknownSize(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.chunkSize = 1000
+	content := bytes.Repeat([]byte("a stream whose end nobody knows in advance "), 1000)
+
+	for _, fileSize := range []string{"", "unknown"} {
+		r := newStreamedUploadRequest("stream.txt", content)
+		if fileSize != "" {
+			r.Header.Set("File-Size", fileSize)
+		}
+		w := httptest.NewRecorder()
+		uploadHandler(store, newTestCipher(), cfg)(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Upload with File-Size %q failed with status %d: %s", fileSize, w.Code, w.Body.String())
+		}
+		objectName := uidFromResponse(w.Body.String())
+		if size := store.objects[objectName].info.Size; size != int64(len(content)+cryptography.HEADER_SIZE) {
+			t.Errorf("Stored object of %d bytes, want %d", size, len(content)+cryptography.HEADER_SIZE)
+		}
+		fetched := fetchFile(store, objectName, nil)
+		if !bytes.Equal(fetched.Body.Bytes(), content) {
+			t.Errorf("Fetched file differs from the one uploaded with File-Size %q", fileSize)
+		}
+	}
+}
+
+func TestUploadOfUnknownSizeRejectsFileOverLimit(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.maxUploadSize = 10
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, newStreamedUploadRequest("big.txt", []byte("eleven byte")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+func TestUploadRejectsBodySmallerThanDeclared(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	r := newUploadRequest(t, "short.txt", "text/plain", []byte("fewer bytes than declared"))
+	r.Header.Set("File-Size", "1000")
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The upload hung instead of failing on the missing bytes")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+func TestUploadReturnsChecksum(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Steve's favorite part of the vacation was the hotel breakfast.")
+	expected := sha256.Sum256(content)
+	expectedChecksum := hex.EncodeToString(expected[:])
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, newUploadRequest(t, "breakfast.txt", "text/plain", content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Content-SHA256"); got != expectedChecksum {
+		t.Errorf("Upload X-Content-SHA256 = %s, want %s", got, expectedChecksum)
+	}
+	if !strings.Contains(w.Body.String(), expectedChecksum) {
+		t.Errorf("Upload response %q does not contain the checksum %s", w.Body.String(), expectedChecksum)
+	}
+
+	objectName := uidFromResponse(w.Body.String())
+	if got := store.objects[objectName].info.UserMetadata["Sha256"]; got != expectedChecksum {
+		t.Errorf("Stored checksum = %s, want %s", got, expectedChecksum)
+	}
+	if got := store.objects[objectName].info.ContentType; got != "text/plain" {
+		t.Errorf("Storing the checksum changed the content type to %q", got)
+	}
+
+	fetched := fetchFile(store, objectName, nil)
+	if got := fetched.Header().Get("X-Content-SHA256"); got != expectedChecksum {
+		t.Errorf("Fetch X-Content-SHA256 = %s, want %s", got, expectedChecksum)
+	}
+}
+
+func TestUploadReturnsUidHeader(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	r := newUploadRequest(t, "chosen.txt", "text/plain", []byte("Stored under a chosen UID"))
+	r.Header.Set("Uid", "0042")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Upload-UID"); got != "42" {
+		t.Errorf("X-Upload-UID = %q, want the canonical UID 42", got)
+	}
+	if _, ok := store.objects[w.Header().Get("X-Upload-UID")]; !ok {
+		t.Error("X-Upload-UID does not name the stored object")
+	}
+
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, newMultiUploadRequest(t, []byte("first"), []byte("second")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var stored []storedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.Header().Get("X-Upload-UID"), stored[0].Uid+","+stored[1].Uid; got != want {
+		t.Errorf("X-Upload-UID = %q, want the UIDs of the files in order %q", got, want)
+	}
+}
+
+func TestUploadDoesNotOverwriteUntrackedObject(t *testing.T) {
+	// The object is stored but its UID is not tracked, as after an incomplete listing at startup
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	existing := []byte("Someone else's file")
+	if _, err := store.PutObject(context.Background(), "42", bytes.NewReader(existing), int64(len(existing)), minio.PutObjectOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newUploadRequest(t, "intruder.txt", "text/plain", []byte("Would overwrite the file"))
+	r.Header.Set("Uid", "42")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Upload to the UID of an untracked object gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	object, ok := store.objects["42"]
+	if !ok || !bytes.Equal(object.data, existing) {
+		t.Error("The existing object was overwritten or deleted")
+	}
+	if !uidTracker.Contains(42) {
+		t.Error("The UID of the existing object was freed")
+	}
+}
+
+func TestRequestedUid(t *testing.T) {
+	tests := []struct {
+		values  []string
+		uid     uint64
+		chosen  bool
+		invalid bool
+	}{
+		{values: nil},
+		{values: []string{""}},
+		{values: []string{"", ""}},
+		{values: []string{"42"}, uid: 42, chosen: true},
+		{values: []string{"", "42"}, uid: 42, chosen: true},
+		{values: []string{"42", "43"}, invalid: true},
+		{values: []string{"forty-two"}, invalid: true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		r.Header["Uid"] = test.values
+		uid, chosen, err := requestedUid(r)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("requestedUid accepted the Uid header %q", test.values)
+			}
+		} else if err != nil || uid != test.uid || chosen != test.chosen {
+			t.Errorf("requestedUid with the Uid header %q = %d, %v, %v, want %d, %v", test.values, uid, chosen, err, test.uid, test.chosen)
+		}
+	}
+}
+
+func TestUploadWithEmptyUidGeneratesOne(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "empty.txt", "text/plain", []byte("Uploaded with an empty Uid"))
+	r.Header["Uid"] = []string{""}
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload with an empty Uid failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := store.objects[uidFromResponse(w.Body.String())]; !ok {
+		t.Error("The file was not stored under the generated UID")
+	}
+}
+
+func TestUploadWithRepeatedUidRejected(t *testing.T) {
+	uidTracker.Init(nil)
+	r := newUploadRequest(t, "repeated.txt", "text/plain", []byte("Uploaded with two Uids"))
+	r.Header["Uid"] = []string{"42", "43"}
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with a repeated Uid gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if uidTracker.Count() != 0 {
+		t.Error("A UID was reserved by the rejected upload")
+	}
+}
+
+func TestUploadChecksumIndependentOfChunkBoundaries(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := make([]byte, 10_000)
+	for i := range content {
+		content[i] = byte(i * 31)
+	}
+	expected := sha256.Sum256(content)
+	expectedChecksum := hex.EncodeToString(expected[:])
+
+	for _, chunkSize := range []int{1, 7, 1000, 4096, 10_000, 65536} {
+		for _, compress := range []string{"", COMPRESSION_GZIP} {
+			cfg := defaultConfig()
+			cfg.chunkSize = chunkSize
+			r := newUploadRequest(t, "chunks.txt", "text/plain", content)
+			r.Header.Set("Compress", compress)
+			w := httptest.NewRecorder()
+			uploadHandler(store, newTestCipher(), cfg)(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Upload with chunks of %d bytes failed with status %d: %s", chunkSize, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Content-SHA256"); got != expectedChecksum {
+				t.Errorf("X-Content-SHA256 with chunks of %d bytes and compression %q = %s, want %s", chunkSize, compress, got, expectedChecksum)
+			}
+		}
+	}
+}
+
+func TestUploadMultipleFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	files := []struct {
+		filename string
+		content  []byte
+	}{
+		{"monday.txt", []byte("Steve had pancakes on Monday.")},
+		{"tuesday.txt", []byte("Waffles on Tuesday.")},
+		// A part without filename is stored under its UID
+		{"", []byte("And on Wednesday, Steve skipped breakfast altogether.")},
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	sizes := make([]string, len(files))
+	for i, file := range files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", `form-data; name="file"`)
+		if file.filename != "" {
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, file.filename))
+		}
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = part.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+		sizes[i] = strconv.Itoa(len(file.content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", strings.Join(sizes, ", "))
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var stored []storedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+		t.Fatalf("Response %q is not a JSON array of files: %v", w.Body.String(), err)
+	}
+	if len(stored) != len(files) {
+		t.Fatalf("Response lists %d files, want %d", len(stored), len(files))
+	}
+
+	uids := make(map[string]bool)
+	for i, file := range files {
+		uids[stored[i].Uid] = true
+		wantFilename := file.filename
+		if wantFilename == "" {
+			wantFilename = stored[i].Uid
+		}
+		if stored[i].Filename != wantFilename {
+			t.Errorf("File %d is named %q, want %q", i, stored[i].Filename, wantFilename)
+		}
+		fetched := fetchFile(store, stored[i].Uid, nil)
+		if fetched.Code != http.StatusOK || !bytes.Equal(fetched.Body.Bytes(), file.content) {
+			t.Errorf("Fetching file %d gave status %d and content %q, want %q", i, fetched.Code, fetched.Body.String(), file.content)
+		}
+	}
+	if len(uids) != len(files) {
+		t.Errorf("Files were stored under %d distinct UIDs, want %d", len(uids), len(files))
+	}
+}
+
+func TestUploadRejectsUndeclaredFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	r := newUploadRequest(t, "monday.txt", "text/plain", []byte("Pancakes"))
+	r.Header.Set("File-Size", "8,12")
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestUploadRejectsMalformedMultipartBodies(t *testing.T) {
+	valid := newUploadRequest(t, "diary.txt", "text/plain", bytes.Repeat([]byte("Dear diary, "), 20))
+	contentType := valid.Header.Get("Content-Type")
+	body, err := io.ReadAll(valid.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closingBoundary := bytes.LastIndex(body, []byte("\r\n--"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{"truncated file", contentType, body[:len(body)/2]},
+		{"missing closing boundary", contentType, body[:closingBoundary]},
+		{"no parts", contentType, []byte("this is not a multipart body")},
+		{"part without headers end", contentType, body[:bytes.Index(body, []byte("\r\n\r\n"))]},
+		{"not multipart", "text/plain", body},
+		{"missing boundary", "multipart/form-data", body},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uidTracker.Init(nil)
+			store := newMemoryStore()
+			r := httptest.NewRequest(http.MethodPost, "/upload", bytes.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			r.Header.Set("File-Size", "240")
+			w := httptest.NewRecorder()
+			uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+			if len(store.objects) != 0 {
+				t.Errorf("%d objects were stored, want none", len(store.objects))
+			}
+		})
+	}
+}
+
+func TestRunServerShutsDownGracefully(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	// A slow handler stands for an upload which is still in flight when the shutdown starts
+	requestStarted := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(requestStarted)
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverDone := make(chan error, 1)
+	go func() {
+		serverDone <- runServer(ctx, server, 5*time.Second)
+	}()
+
+	// Wait for the server to accept connections
+	var response *http.Response
+	requestDone := make(chan error, 1)
+	go func() {
+		for range 50 {
+			response, err = http.Get("http://" + addr + "/slow")
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		requestDone <- err
+	}()
+
+	<-requestStarted
+	cancel()
+
+	select {
+	case err := <-serverDone:
+		if err != nil {
+			t.Errorf("runServer returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server did not shut down within the timeout")
+	}
+
+	if err := <-requestDone; err != nil {
+		t.Fatalf("The in-flight request failed: %v", err)
+	}
+	defer response.Body.Close()
+	if body, _ := io.ReadAll(response.Body); string(body) != "done" {
+		t.Errorf("The in-flight request got %q, want it to complete with %q", body, "done")
+	}
+}
+
+func TestUploadCancelledByClientDisconnect(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	// The body is streamed through a pipe to stop sending it midway, as a disconnecting client would
+	bodyReader, bodyWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(bodyWriter)
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodPost, "/upload", bodyReader).WithContext(ctx)
+	r.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	r.Header.Set("File-Size", "1000000")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		uploadHandler(store, newTestCipher(), defaultConfig())(httptest.NewRecorder(), r)
+	}()
+
+	part, err := multipartWriter.CreateFormFile("file", "interrupted.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(bytes.Repeat([]byte{0x42}, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client disconnects: the request context is cancelled and the body cannot be read anymore
+	cancel()
+	bodyWriter.CloseWithError(io.ErrUnexpectedEOF)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The upload goroutines did not terminate after the client disconnected")
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects remain after the cancelled upload, want none", len(store.objects))
+	}
+}
+
+var errEncryptionFailed = errors.New("encryption failed")
+
+// failingCipher encrypts the first bytes of the stream, then fails.
+type failingCipher struct {
+	*cryptography.StreamCipher
+}
+
+func (c failingCipher) EncryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	if err := c.StreamCipher.EncryptStreamCtx(ctx, io.LimitReader(reader, 10), writer); err != nil {
+		return err
+	}
+	return errEncryptionFailed
+}
+
+func TestUploadEncryptionErrorGivesSingleResponse(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, failingCipher{newTestCipher()}, defaultConfig())(w, newUploadRequest(t, "doomed.txt", "text/plain", bytes.Repeat([]byte("doomed "), 1000)))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, errEncryptionFailed.Error()) {
+		t.Errorf("Response %q does not report the encryption error", body)
+	}
+	if strings.Contains(body, "successfully") || strings.Count(body, "\n") != 1 {
+		t.Errorf("Response %q should only contain the encryption error", body)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects were stored, want none", len(store.objects))
+	}
+}
+
+// partialUploadStore is a memoryStore whose uploads fail once they are numbered failAt, after storing part of the
+// object like MinIO may keep an incomplete upload.
+type partialUploadStore struct {
+	*memoryStore
+	failAt  int
+	uploads int
+	aborted []string
+}
+
+func (s *partialUploadStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	s.uploads++
+	if s.uploads < s.failAt {
+		return s.memoryStore.PutObject(ctx, objectName, reader, objectSize, opts)
+	}
+	if _, err := s.memoryStore.PutObject(ctx, objectName, io.LimitReader(reader, 10), -1, opts); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	return minio.UploadInfo{}, errConnectionReset
+}
+
+func (s *partialUploadStore) AbortUpload(ctx context.Context, objectName string) error {
+	s.aborted = append(s.aborted, objectName)
+	return nil
+}
+
+// newMultiUploadRequest builds a multipart upload request holding a file part per content, declaring their sizes.
+func newMultiUploadRequest(t testing.TB, contents ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	sizes := make([]string, len(contents))
+	for i, content := range contents {
+		part, err := writer.CreateFormFile("file", fmt.Sprintf("file%d.txt", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = part.Write(content); err != nil {
+			t.Fatal(err)
+		}
+		sizes[i] = strconv.Itoa(len(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", strings.Join(sizes, ","))
+	return r
+}
+
+func TestFailedUploadDiscardsObjectsAndUids(t *testing.T) {
+	content := bytes.Repeat([]byte("interrupted "), 100)
+	tests := []struct {
+		name   string
+		failAt int
+		r      func() *http.Request
+	}{
+		{"single file", 1, func() *http.Request { return newUploadRequest(t, "interrupted.txt", "text/plain", content) }},
+		{"second of several files", 2, func() *http.Request { return newMultiUploadRequest(t, content, content, content) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uidTracker.Init(nil)
+			fileNames.reset(nil)
+			store := &partialUploadStore{memoryStore: newMemoryStore(), failAt: test.failAt}
+			cfg := defaultConfig()
+			cfg.uniqueFilenames = true
+
+			w := httptest.NewRecorder()
+			uploadHandler(store, newTestCipher(), cfg)(w, test.r())
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+			}
+			if len(store.objects) != 0 {
+				t.Errorf("%d objects remain after the failed upload, want none", len(store.objects))
+			}
+			if count := uidTracker.Count(); count != 0 {
+				t.Errorf("%d UIDs remain tracked after the failed upload, want none", count)
+			}
+			if len(store.aborted) == 0 {
+				t.Error("The incomplete upload was not aborted")
+			}
+			if len(fileNames.uids) != 0 {
+				t.Errorf("%d filenames remain claimed after the failed upload, want none", len(fileNames.uids))
+			}
+		})
+	}
+}
+
+// discardStore is a memoryStore which does not keep the uploaded content, to measure the upload pipeline alone.
+type discardStore struct {
+	*memoryStore
+}
+
+func (s discardStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	n, err := io.Copy(io.Discard, reader)
+	return minio.UploadInfo{Key: objectName, Size: n}, err
+}
+
+func (s discardStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	return nil
+}
+
+func BenchmarkUpload(b *testing.B) {
+	store := discardStore{newMemoryStore()}
+	handler := uploadHandler(store, newTestCipher(), defaultConfig())
+	content := bytes.Repeat([]byte("Steve's breakfast diary. "), 4096)
+	requests := make([]*http.Request, b.N)
+	for i := range requests {
+		requests[i] = newUploadRequest(b, "diary.txt", "text/plain", content)
+	}
+	// Keep the benchmark output readable
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	uidTracker.Init(nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, r := range requests {
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+// failingDecryptionCipher decrypts the first bytes of the stream, then fails.
+type failingDecryptionCipher struct {
+	*cryptography.StreamCipher
+}
+
+func (c failingDecryptionCipher) DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	if err := c.StreamCipher.DecryptStreamCtx(ctx, io.LimitReader(reader, cryptography.HEADER_SIZE+16), writer); err != nil {
+		return err
+	}
+	return errors.New("decryption failed midway")
+}
+
+func TestFetchDecryptionErrorGivesErrorStatus(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Steve's breakfast diary. "), 10)
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", content)
+
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, failingDecryptionCipher{newTestCipher()}, defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if bytes.Contains(w.Body.Bytes(), content[:16]) {
+		t.Errorf("Part of the file was sent along with the error: %q", w.Body.String())
+	}
+}
+
+func TestFetchCorruptedFileGivesErrorStatus(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", []byte("Steve's breakfast diary."))
+	store.objects[objectName].data[cryptography.HEADER_SIZE] ^= 1
+
+	if w := fetchFile(store, objectName, nil); w.Code != http.StatusInternalServerError {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestFetchLargeFileDecryptionErrorTruncatesBody(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Steve's breakfast diary. "), 10)
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", content)
+	cfg := defaultConfig()
+	cfg.bufferedFetchMaxSize = 0
+
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, failingDecryptionCipher{newTestCipher()}, cfg)(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil))
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(content)) {
+		t.Fatalf("Content-Length = %s, want %d", got, len(content))
+	}
+	if !bytes.Equal(w.Body.Bytes(), content[:16]) {
+		t.Errorf("Body = %q, want only the %d bytes decrypted before the failure", w.Body.String(), 16)
+	}
+}
+
+func TestGetMaxNbrRunSeconds(t *testing.T) {
+	const MiB = 1024 * 1024
+	tests := []struct {
+		name         string
+		size         int64
+		minRate      float64
+		safetyMargin time.Duration
+		want         time.Duration
+	}{
+		{"empty file", 16, MiB, 10 * time.Second, 11 * time.Second},
+		{"small file", 100 * 1024, MiB, 10 * time.Second, 11 * time.Second},
+		{"medium file", 700 * MiB, MiB, 10 * time.Second, 710 * time.Second},
+		{"medium file on a fast network", 700 * MiB, 100 * MiB, 10 * time.Second, 17 * time.Second},
+		{"huge file", 500 * 1024 * MiB, MiB, 10 * time.Second, (500*1024 + 10) * time.Second},
+		{"huge file on a fast network", 500 * 1024 * MiB, 1024 * MiB, time.Minute, 500*time.Second + time.Minute},
+		{"no margin", 2 * MiB, MiB, 0, 2 * time.Second},
+		{"overflowing timeout", MAX_MINIO_OBJECT_SIZE, 1, 10 * time.Second, time.Duration(math.MaxInt64)},
+	}
+	for _, test := range tests {
+		if got := getMaxNbrRunSeconds(test.size, test.minRate, test.safetyMargin); got != test.want {
+			t.Errorf("%s: getMaxNbrRunSeconds(%d, %v, %v) = %v, want %v", test.name, test.size, test.minRate, test.safetyMargin, got, test.want)
+		}
+	}
+}
+
+func TestSetTimeoutRetryAfter(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		min, max int
+	}{
+		{0, 1, 1},
+		{10 * time.Second, 5, 10},
+		{710 * time.Second, 355, 710},
+		{time.Duration(math.MaxInt64), int(MAX_TIMEOUT_RETRY_AFTER.Seconds()) / 2, int(MAX_TIMEOUT_RETRY_AFTER.Seconds())},
+	}
+	for _, test := range tests {
+		// The delay is jittered, so it is drawn several times
+		delays := make(map[int]bool)
+		for range 100 {
+			w := httptest.NewRecorder()
+			setTimeoutRetryAfter(w, test.timeout)
+			retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+			if err != nil || retryAfter < test.min || retryAfter > test.max {
+				t.Fatalf("Retry-After for a timeout of %v = %q, want between %d and %d", test.timeout, w.Header().Get("Retry-After"), test.min, test.max)
+			}
+			delays[retryAfter] = true
+		}
+		if test.max-test.min > 100 && len(delays) == 1 {
+			t.Errorf("Retry-After for a timeout of %v is always %v, want jittered delays", test.timeout, delays)
+		}
+	}
+}
+
+// failingListStore is a memoryStore whose listings fail after yielding the stored objects.
+type failingListStore struct {
+	*memoryStore
+}
+
+func (s failingListStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for object := range s.memoryStore.ListObjects(ctx, startAfter) {
+			sendObjectInfo(ctx, objects, object)
+		}
+		sendObjectInfo(ctx, objects, minio.ObjectInfo{Err: errConnectionReset})
+	}()
+	return objects
+}
+
+func TestFetchUidsFromMinioListingError(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	uploadFile(t, store, "listed.txt", "text/plain", []byte("Listed before the failure"))
+
+	uidTracker.Init([]uint64{7})
+	err := fetchUidsFromMinio(context.Background(), &uidTracker, &fileChecksums, &fileNames, failingListStore{store})
+	if !errors.Is(err, errConnectionReset) {
+		t.Errorf("fetchUidsFromMinio() = %v, want the listing error", err)
+	}
+	if uidTracker.Count() != 1 || !uidTracker.Contains(7) {
+		t.Errorf("The tracker holds %d UIDs after a failed listing, want it left untouched", uidTracker.Count())
+	}
+}
+
+// stalledListStore is a memoryStore whose listings never yield any object, like an unresponsive MinIO deployment.
+type stalledListStore struct {
+	*memoryStore
+}
+
+func (s stalledListStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	return make(chan minio.ObjectInfo)
+}
+
+func TestFetchUidsFromMinioTimeout(t *testing.T) {
+	uidTracker.Init([]uint64{7})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fetchUidsFromMinio(ctx, &uidTracker, &fileChecksums, &fileNames, stalledListStore{newMemoryStore()})
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("fetchUidsFromMinio() = %v, want the deadline to be exceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fetchUidsFromMinio() hung on a stalled listing")
+	}
+	if uidTracker.Count() != 1 {
+		t.Errorf("The tracker holds %d UIDs after a timed out listing, want it left untouched", uidTracker.Count())
+	}
+}
+
+// sizeRecordingStore is a memoryStore recording the object sizes declared to PutObject.
+type sizeRecordingStore struct {
+	*memoryStore
+	declaredSizes []int64
+}
+
+func (s *sizeRecordingStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	s.declaredSizes = append(s.declaredSizes, objectSize)
+	return s.memoryStore.PutObject(ctx, objectName, reader, objectSize, opts)
+}
+
+// paddedCipher writes padding in front of the streams of StreamCipher, standing for a cipher with a larger header.
+type paddedCipher struct {
+	*cryptography.StreamCipher
+	padding int
+}
+
+func (c paddedCipher) HeaderSize() int {
+	return c.StreamCipher.HeaderSize() + c.padding
+}
+
+func (c paddedCipher) StreamHeaderSize(prefix []byte) (int, error) {
+	headerSize, err := c.StreamCipher.StreamHeaderSize(prefix[min(c.padding, len(prefix)):])
+	return headerSize + c.padding, err
+}
+
+func (c paddedCipher) EncryptedSize(plaintextSize int64) int64 {
+	return plaintextSize + int64(c.HeaderSize())
+}
+
+func (c paddedCipher) EncryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	if _, err := writer.Write(make([]byte, c.padding)); err != nil {
+		return err
+	}
+	return c.StreamCipher.EncryptStreamCtx(ctx, reader, writer)
+}
+
+func (c paddedCipher) DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	if _, err := io.CopyN(io.Discard, reader, int64(c.padding)); err != nil {
+		return err
+	}
+	return c.StreamCipher.DecryptStreamCtx(ctx, reader, writer)
+}
+
+func TestHandlersUseCipherHeaderSize(t *testing.T) {
+	uidTracker.Init(nil)
+	store := &sizeRecordingStore{memoryStore: newMemoryStore()}
+	cipher := paddedCipher{StreamCipher: newTestCipher(), padding: 7}
+	content := []byte("Sized by the cipher")
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, cipher, defaultConfig())(w, newUploadRequest(t, "padded.txt", "text/plain", content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if want := []int64{int64(len(content) + cryptography.HEADER_SIZE + 7)}; !slices.Equal(store.declaredSizes, want) {
+		t.Errorf("Object uploaded with the declared sizes %v, want %v", store.declaredSizes, want)
+	}
+
+	objectName := uidFromResponse(w.Body.String())
+	w = httptest.NewRecorder()
+	fetchAndDecryptHandler(store, cipher, defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(content)) {
+		t.Errorf("Content-Length = %s, want %d", got, len(content))
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetched %q, want %q", w.Body.String(), content)
+	}
+}
+
+func TestUploadOfUnknownSizeSpilledToDisk(t *testing.T) {
+	uidTracker.Init(nil)
+	store := &sizeRecordingStore{memoryStore: newMemoryStore()}
+	cfg := defaultConfig()
+	cfg.chunkSize = 1000
+	cfg.spillDir = t.TempDir()
+	content := bytes.Repeat([]byte("chunked without any File-Size "), 1000)
+
+	r := newStreamedUploadRequest("chunked.txt", content)
+	r.TransferEncoding = []string{"chunked"}
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if want := []int64{int64(len(content) + cryptography.HEADER_SIZE)}; !slices.Equal(store.declaredSizes, want) {
+		t.Errorf("Object uploaded with the declared sizes %v, want %v", store.declaredSizes, want)
+	}
+	fetched := fetchFile(store, uidFromResponse(w.Body.String()), nil)
+	if !bytes.Equal(fetched.Body.Bytes(), content) {
+		t.Error("Fetched file differs from the uploaded one")
+	}
+
+	// The spill files are deleted whether the upload succeeded or not
+	cfg.maxUploadSize = 10
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, newStreamedUploadRequest("big.txt", []byte("eleven byte")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload of a file over the limit gave status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if entries, err := os.ReadDir(cfg.spillDir); err != nil || len(entries) != 0 {
+		t.Errorf("The spill directory holds %d files after the uploads (error %v), want none", len(entries), err)
+	}
+}
+
+// blockingStore is a memoryStore whose uploads hang until their context is done, calling onPut once they started.
+type blockingStore struct {
+	*memoryStore
+	onPut func()
+}
+
+func (s blockingStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	if s.onPut != nil {
+		s.onPut()
+	}
+	<-ctx.Done()
+	return minio.UploadInfo{}, ctx.Err()
+}
+
+func TestUploadContextErrors(t *testing.T) {
+	t.Run("timeout", func(t *testing.T) {
+		uidTracker.Init(nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		w := httptest.NewRecorder()
+		r := newUploadRequest(t, "slow.txt", "text/plain", []byte("Never stored")).WithContext(ctx)
+		cfg := defaultConfig()
+		uploadHandler(blockingStore{memoryStore: newMemoryStore()}, newTestCipher(), cfg)(w, r)
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("Status %d, want %d: %s", w.Code, http.StatusGatewayTimeout, w.Body.String())
+		}
+		// The client is told to wait for about the time the upload was given
+		timeout := getMaxNbrRunSeconds(newTestCipher().EncryptedSize(int64(len("Never stored"))), cfg.uploadMinRate, cfg.uploadTimeoutMargin)
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || time.Duration(retryAfter)*time.Second > timeout {
+			t.Errorf("Retry-After = %q, want a number of seconds up to %v", w.Header().Get("Retry-After"), timeout)
+		}
+	})
+	t.Run("cancellation", func(t *testing.T) {
+		uidTracker.Init(nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := httptest.NewRecorder()
+		r := newUploadRequest(t, "abandoned.txt", "text/plain", []byte("Never stored")).WithContext(ctx)
+		uploadHandler(blockingStore{memoryStore: newMemoryStore(), onPut: cancel}, newTestCipher(), defaultConfig())(w, r)
+		if w.Code != STATUS_CLIENT_CLOSED_REQUEST {
+			t.Errorf("Status %d, want %d", w.Code, STATUS_CLIENT_CLOSED_REQUEST)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("Body = %q, want none for a cancelled request", w.Body.String())
+		}
+	})
+}
+
+// blockingDecryptionCipher hangs when decrypting until the context is done, calling onDecrypt once it started.
+type blockingDecryptionCipher struct {
+	*cryptography.StreamCipher
+	onDecrypt func()
+}
+
+func (c blockingDecryptionCipher) DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	if c.onDecrypt != nil {
+		c.onDecrypt()
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestFetchContextErrors(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", []byte("Steve's breakfast diary."))
+	buffered, streamed := defaultConfig(), defaultConfig()
+	streamed.bufferedFetchMaxSize = 0
+
+	for _, test := range []struct {
+		name string
+		cfg  config
+	}{{"buffered", buffered}, {"streamed", streamed}} {
+		t.Run(test.name+" timeout", func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil).WithContext(ctx)
+			fetchAndDecryptHandler(store, blockingDecryptionCipher{StreamCipher: newTestCipher()}, test.cfg)(w, r)
+			if w.Code != http.StatusGatewayTimeout {
+				t.Errorf("Status %d, want %d", w.Code, http.StatusGatewayTimeout)
+			}
+		})
+		t.Run(test.name+" cancellation", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName, nil).WithContext(ctx)
+			fetchAndDecryptHandler(store, blockingDecryptionCipher{StreamCipher: newTestCipher(), onDecrypt: cancel}, test.cfg)(w, r)
+			if w.Code != STATUS_CLIENT_CLOSED_REQUEST {
+				t.Errorf("Status %d, want %d", w.Code, STATUS_CLIENT_CLOSED_REQUEST)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("Body = %q, want none for a cancelled request", w.Body.String())
+			}
+		})
+	}
+}
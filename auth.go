@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// parseAPIKeys splits a comma-separated list of API keys, ignoring blank entries.
+func parseAPIKeys(keyList string) []string {
+	var apiKeys []string
+	for _, key := range strings.Split(keyList, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			apiKeys = append(apiKeys, key)
+		}
+	}
+	return apiKeys
+}
+
+// requireAPIKey only lets requests carrying an `Authorization: Bearer <key>` header with one of the given keys reach the
+// handler, and rejects the others with a 401. Authentication is disabled when no key is given, e.g. for local development.
+func requireAPIKey(apiKeys []string, next http.HandlerFunc) http.HandlerFunc {
+	if len(apiKeys) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !isValidAPIKey(apiKeys, key) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "A valid API key is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isValidAPIKey compares the key with every valid key in constant time, to not leak how close a guess was.
+func isValidAPIKey(apiKeys []string, key string) bool {
+	valid := 0
+	for _, apiKey := range apiKeys {
+		valid |= subtle.ConstantTimeCompare([]byte(apiKey), []byte(key))
+	}
+	return valid == 1
+}
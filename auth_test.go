@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	handler := requireAPIKey([]string{"first-key", "second-key"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid key", "Bearer second-key", http.StatusNoContent},
+		{"missing key", "", http.StatusUnauthorized},
+		{"wrong key", "Bearer third-key", http.StatusUnauthorized},
+		{"wrong scheme", "Basic first-key", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/fetch?uid=1", nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != test.want {
+				t.Errorf("Status = %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestRequireAPIKeyDisabledWithoutKeys(t *testing.T) {
+	handler := requireAPIKey(parseAPIKeys(""), func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/fetch?uid=1", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	if got := parseAPIKeys(" first-key,, second-key ,"); !slices.Equal(got, []string{"first-key", "second-key"}) {
+		t.Errorf("parseAPIKeys() = %q, want [first-key second-key]", got)
+	}
+}
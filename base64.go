@@ -0,0 +1,114 @@
+package main
+
+import (
+	"api/cryptography"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ENCODING_BASE64 is the value of the encoding URL parameter of a fetch returning the file as base64 in a JSON object.
+const ENCODING_BASE64 = "base64"
+
+// base64File is the JSON object a file is sent as when fetched with encoding=base64.
+type base64File struct {
+	Filename string `json:"filename"`
+	Data     string `json:"data"`
+}
+
+var errBase64FileTooLarge = errors.New("file is too large to be fetched as base64")
+
+// isBase64Fetch tells whether a fetch asked for the file as base64 in a JSON object, through the encoding URL parameter.
+func isBase64Fetch(r *http.Request) (bool, error) {
+	switch encoding := r.URL.Query().Get("encoding"); encoding {
+	case "":
+		return false, nil
+	case ENCODING_BASE64:
+		return true, nil
+	default:
+		return false, fmt.Errorf("encoding should be %s, got %q", ENCODING_BASE64, encoding)
+	}
+}
+
+// cappedBuffer is a buffer refusing to hold more than limit bytes. The buffer is not embedded, since its ReadFrom
+// method would be used by io.Copy instead of the capped Write.
+type cappedBuffer struct {
+	buffer bytes.Buffer
+	limit  int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buffer.Len()+len(p)) > b.limit {
+		return 0, errBase64FileTooLarge
+	}
+	return b.buffer.Write(p)
+}
+
+// serveBase64File sends a file as a JSON object holding its filename and its base64-encoded content, for clients which
+// prefer a single JSON payload to a stream. The file is entirely decrypted and verified against its checksum before
+// being sent, and files larger than maxSize are rejected with a 413.
+func serveBase64File(ctx context.Context, w http.ResponseWriter, r *http.Request, store ObjectStore, cipher cryptography.Cipher, objectInfo minio.ObjectInfo, filename string, maxSize int64) {
+	// The size of a compressed file is only known once it was decompressed, which the buffer then limits
+	compressed := objectInfo.UserMetadata["Compression"] == COMPRESSION_GZIP
+	if !compressed {
+		header, err := fetchStreamHeader(ctx, store, cipher, objectInfo.Key)
+		if err != nil {
+			http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+			return
+		}
+		if plaintextSize := objectInfo.Size - int64(len(header)); plaintextSize > maxSize {
+			http.Error(w, fmt.Sprintf("Files larger than %d bytes cannot be fetched as base64", maxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	object, err := store.GetObject(ctx, objectInfo.Key, minio.GetObjectOptions{})
+	if err != nil {
+		http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	plaintext := &cappedBuffer{limit: maxSize}
+	if compressed {
+		err = decryptAndDecompress(r.Context(), cipher, object, plaintext)
+	} else {
+		err = cipher.DecryptStreamCtx(r.Context(), object, plaintext)
+	}
+	if errors.Is(err, errBase64FileTooLarge) {
+		http.Error(w, fmt.Sprintf("Files larger than %d bytes cannot be fetched as base64", maxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		if ctxError := contextError(r.Context()); ctxError != nil {
+			writeError(w, ctx, ctxError)
+			return
+		}
+		loggerFrom(ctx).Error("Error during decryption", "uid", objectInfo.Key, "error", err)
+		http.Error(w, "Error during decryption", http.StatusInternalServerError)
+		return
+	}
+	checksum := sha256.Sum256(plaintext.buffer.Bytes())
+	if storedChecksum, ok := objectInfo.UserMetadata[CHECKSUM_METADATA]; ok && hex.EncodeToString(checksum[:]) != storedChecksum {
+		loggerFrom(ctx).Error("Checksum mismatch", "uid", objectInfo.Key, "stored", storedChecksum, "computed", hex.EncodeToString(checksum[:]))
+		http.Error(w, "The stored file is corrupted", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-SHA256", hex.EncodeToString(checksum[:]))
+	if err := json.NewEncoder(w).Encode(base64File{Filename: filename, Data: base64.StdEncoding.EncodeToString(plaintext.buffer.Bytes())}); err != nil {
+		loggerFrom(ctx).Error("Unable to send the file as base64", "uid", objectInfo.Key, "error", err)
+		return
+	}
+	downloadedBytesTotal.Add(float64(plaintext.buffer.Len()))
+	downloadsTotal.Inc()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func fetchBase64(store uploadStore, objectName string, cfg config) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, newTestCipher(), cfg)(w, httptest.NewRequest(http.MethodGet, "/fetch?encoding=base64&uid="+objectName, nil))
+	return w
+}
+
+func TestFetchAsBase64(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Steve's breakfast diary.")
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", content)
+
+	w := fetchBase64(store, objectName, defaultConfig())
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	var file base64File
+	if err := json.NewDecoder(w.Body).Decode(&file); err != nil {
+		t.Fatal(err)
+	}
+	if file.Filename != "diary.txt" {
+		t.Errorf("Filename = %q, want diary.txt", file.Filename)
+	}
+	if data, err := base64.StdEncoding.DecodeString(file.Data); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Data = %q, want the base64 of %q", file.Data, content)
+	}
+}
+
+func TestFetchAsBase64RejectsFileOverCap(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Steve's breakfast diary. "), 100)
+	objectName := uploadFile(t, store, "diary.bin", "", content)
+	r := newUploadRequest(t, "compressed.txt", "text/plain", content)
+	r.Header.Set("Compress", COMPRESSION_GZIP)
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	compressedName := uidFromResponse(w.Body.String())
+	cfg := defaultConfig()
+	cfg.base64FetchMaxSize = int64(len(content)) - 1
+
+	// The size of a compressed file is only found out while it is decompressed
+	for _, name := range []string{objectName, compressedName} {
+		if w := fetchBase64(store, name, cfg); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Fetch of %s over the cap gave status %d, want %d", name, w.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestFetchRejectsInvalidEncoding(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "diary.txt", "text/plain", []byte("Steve's breakfast diary."))
+
+	for _, query := range []string{"&encoding=base32", "&encoding=base64&raw=true"} {
+		w := httptest.NewRecorder()
+		fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Fetch with %q gave status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+)
+
+// CHECKSUM_SHA256 names the checksum every uploaded file gets, stored under CHECKSUM_METADATA.
+const CHECKSUM_SHA256 = "sha256"
+
+// checksumAlgorithm is an additional checksum which can be requested for the uploaded files through X-Checksums.
+type checksumAlgorithm struct {
+	// label names the checksum in the response to a single file upload
+	label string
+	// metadata is the metadata the checksum is stored under
+	metadata string
+	newHash  func() hash.Hash
+}
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumAlgorithms are the additional checksums supported by X-Checksums, by name. CRC32C is the checksum S3 clients
+// commonly use to verify their transfers.
+var checksumAlgorithms = map[string]checksumAlgorithm{
+	"sha1":   {label: "SHA-1", metadata: "Sha1", newHash: sha1.New},
+	"md5":    {label: "MD5", metadata: "Md5", newHash: md5.New},
+	"crc32c": {label: "CRC32C", metadata: "Crc32c", newHash: func() hash.Hash { return crc32.New(castagnoliTable) }},
+}
+
+// parseChecksums parses the X-Checksums header of an upload, a comma-separated list of the checksums to compute for
+// the uploaded files in addition to their SHA-256 checksum, which may be listed too. The additional checksums are
+// returned in the order they were listed.
+func parseChecksums(header string) ([]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+	var algorithms []string
+	for _, name := range strings.Split(header, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := checksumAlgorithms[name]; !ok && name != CHECKSUM_SHA256 {
+			return nil, fmt.Errorf("unsupported checksum %q, X-Checksums should list some of sha256, sha1, md5 and crc32c", name)
+		}
+		if name != CHECKSUM_SHA256 && !slices.Contains(algorithms, name) {
+			algorithms = append(algorithms, name)
+		}
+	}
+	return algorithms, nil
+}
+
+// checksumSet computes the additional checksums of a file while it is written to it.
+type checksumSet struct {
+	algorithms []string
+	hashes     []hash.Hash
+}
+
+func newChecksumSet(algorithms []string) *checksumSet {
+	set := &checksumSet{algorithms: algorithms}
+	for _, name := range algorithms {
+		set.hashes = append(set.hashes, checksumAlgorithms[name].newHash())
+	}
+	return set
+}
+
+// writer returns the writer to which the file must be written to compute its checksums.
+func (s *checksumSet) writer() io.Writer {
+	writers := make([]io.Writer, len(s.hashes))
+	for i, h := range s.hashes {
+		writers[i] = h
+	}
+	return io.MultiWriter(writers...)
+}
+
+// sums returns the hex-encoded checksums of the file by name, or nil if none was requested.
+func (s *checksumSet) sums() map[string]string {
+	if len(s.algorithms) == 0 {
+		return nil
+	}
+	sums := make(map[string]string, len(s.algorithms))
+	for i, name := range s.algorithms {
+		sums[name] = hex.EncodeToString(s.hashes[i].Sum(nil))
+	}
+	return sums
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// expectedChecksums computes the checksums of the content independently from the upload.
+func expectedChecksums(content []byte) map[string]string {
+	sha1Sum := sha1.Sum(content)
+	md5Sum := md5.Sum(content)
+	crc := crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli))
+	return map[string]string{
+		"sha1":   hex.EncodeToString(sha1Sum[:]),
+		"md5":    hex.EncodeToString(md5Sum[:]),
+		"crc32c": hex.EncodeToString([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}),
+	}
+}
+
+func TestParseChecksums(t *testing.T) {
+	for header, want := range map[string][]string{
+		"":                        nil,
+		"sha256":                  nil,
+		"CRC32C, sha1":            {"crc32c", "sha1"},
+		"md5,sha256,md5,sha1,md5": {"md5", "sha1"},
+	} {
+		got, err := parseChecksums(header)
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("parseChecksums(%q) = %v, %v, want %v", header, got, err, want)
+		}
+	}
+	for _, header := range []string{"sha512", "sha1,", "sha1;md5"} {
+		if _, err := parseChecksums(header); err == nil {
+			t.Errorf("parseChecksums(%q) accepted unsupported checksums", header)
+		}
+	}
+}
+
+func TestUploadComputesRequestedChecksums(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Steve's breakfast diary.")
+	want := expectedChecksums(content)
+
+	r := newUploadRequest(t, "diary.txt", "text/plain", content)
+	r.Header.Set("X-Checksums", "sha256,sha1,md5,crc32c")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	objectName := uidFromResponse(w.Body.String())
+	for name, label := range map[string]string{"sha1": "SHA-1", "md5": "MD5", "crc32c": "CRC32C"} {
+		if line := label + " checksum: " + want[name]; !strings.Contains(w.Body.String(), line) {
+			t.Errorf("Response %q does not hold %q", w.Body.String(), line)
+		}
+	}
+
+	metadata := store.objects[objectName].info.UserMetadata
+	sha256Sum := sha256.Sum256(content)
+	if got := metadata[CHECKSUM_METADATA]; got != hex.EncodeToString(sha256Sum[:]) {
+		t.Errorf("Stored SHA-256 checksum = %q, want %q", got, hex.EncodeToString(sha256Sum[:]))
+	}
+	for name, sum := range want {
+		if got := metadata[checksumAlgorithms[name].metadata]; got != sum {
+			t.Errorf("Stored %s checksum = %q, want %q", name, got, sum)
+		}
+	}
+}
+
+func TestUploadMultipleFilesReturnsRequestedChecksums(t *testing.T) {
+	uidTracker.Init(nil)
+	contents := [][]byte{[]byte("Steve's breakfast diary."), []byte("Steve's lunch diary.")}
+	r := newMultiUploadRequest(t, contents...)
+	r.Header.Set("X-Checksums", "crc32c")
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var storedFiles []storedFile
+	if err := json.NewDecoder(w.Body).Decode(&storedFiles); err != nil {
+		t.Fatal(err)
+	}
+	for i, file := range storedFiles {
+		if want := map[string]string{"crc32c": expectedChecksums(contents[i])["crc32c"]}; len(file.Checksums) != 1 || file.Checksums["crc32c"] != want["crc32c"] {
+			t.Errorf("Checksums of file %d = %v, want %v", i, file.Checksums, want)
+		}
+	}
+}
+
+func TestUploadRejectsUnsupportedChecksum(t *testing.T) {
+	uidTracker.Init(nil)
+	r := newUploadRequest(t, "diary.txt", "text/plain", []byte("Steve's breakfast diary."))
+	r.Header.Set("X-Checksums", "sha512")
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with an unsupported checksum gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
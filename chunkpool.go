@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// MIN_CHUNK_SIZE is the size in bytes of the smallest buffer used to read uploaded files, below which smaller buffers
+// would only slow the copy down.
+const MIN_CHUNK_SIZE = 4096
+
+// chunkPool recycles the buffers used to read uploaded files, which are large enough to weigh on the garbage
+// collector if every upload allocated its own.
+// The buffers are sized after the declared size of the files, so that small files do not hold a whole chunk. Their
+// sizes are classes doubling from MIN_CHUNK_SIZE up to the configured chunk size, each class having its own pool.
+type chunkPool struct {
+	// sizes are the sizes of the buffers in ascending order, the last one being the configured chunk size
+	sizes []int
+	pools []sync.Pool
+}
+
+// newChunkPool creates a pool of buffers of at most chunkSize bytes.
+func newChunkPool(chunkSize int) *chunkPool {
+	var sizes []int
+	for size := MIN_CHUNK_SIZE; size < chunkSize; size *= 2 {
+		sizes = append(sizes, size)
+	}
+	sizes = append(sizes, chunkSize)
+	p := &chunkPool{sizes: sizes, pools: make([]sync.Pool, len(sizes))}
+	for i, size := range sizes {
+		p.pools[i].New = func() any {
+			chunk := make([]byte, size)
+			return &chunk
+		}
+	}
+	return p
+}
+
+// class returns the index of the smallest size class holding fileSize bytes. Files of unknown size, or larger than a
+// chunk, are read with buffers of the chunk size.
+func (p *chunkPool) class(fileSize int64) int {
+	if fileSize == UNKNOWN_FILE_SIZE {
+		return len(p.sizes) - 1
+	}
+	for i, size := range p.sizes {
+		if fileSize <= int64(size) {
+			return i
+		}
+	}
+	return len(p.sizes) - 1
+}
+
+// get returns a buffer from the pool fit to read a file of fileSize bytes, which must be given back with put once it
+// is no longer used.
+// Pointers to the slices are pooled, to not allocate when storing them in the pool.
+func (p *chunkPool) get(fileSize int64) *[]byte {
+	return p.pools[p.class(fileSize)].Get().(*[]byte)
+}
+
+func (p *chunkPool) put(chunk *[]byte) {
+	p.pools[p.class(int64(len(*chunk)))].Put(chunk)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChunkPoolSizesBuffersAfterFiles(t *testing.T) {
+	pool := newChunkPool(100_000)
+	for _, tc := range []struct {
+		fileSize int64
+		want     int
+	}{
+		{0, MIN_CHUNK_SIZE},
+		{1, MIN_CHUNK_SIZE},
+		{MIN_CHUNK_SIZE, MIN_CHUNK_SIZE},
+		{MIN_CHUNK_SIZE + 1, 2 * MIN_CHUNK_SIZE},
+		{70_000, 100_000},
+		{100_000, 100_000},
+		{5_000_000, 100_000},
+		{UNKNOWN_FILE_SIZE, 100_000},
+	} {
+		chunk := pool.get(tc.fileSize)
+		if len(*chunk) != tc.want {
+			t.Errorf("Buffer for a file of %d bytes holds %d bytes, want %d", tc.fileSize, len(*chunk), tc.want)
+		}
+		pool.put(chunk)
+		if again := pool.get(tc.fileSize); len(*again) != tc.want {
+			t.Errorf("Recycled buffer for a file of %d bytes holds %d bytes, want %d", tc.fileSize, len(*again), tc.want)
+		}
+	}
+
+	// Chunks smaller than the smallest class are used for every file
+	pool = newChunkPool(1000)
+	if chunk := pool.get(1); len(*chunk) != 1000 {
+		t.Errorf("Buffer with a chunk size of 1000 bytes holds %d bytes", len(*chunk))
+	}
+}
+
+func TestUploadRoundTripsAcrossChunkSizes(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.chunkSize = 5 * MIN_CHUNK_SIZE
+
+	for _, size := range []int{0, 1, MIN_CHUNK_SIZE - 1, MIN_CHUNK_SIZE, MIN_CHUNK_SIZE + 1, 3 * MIN_CHUNK_SIZE, cfg.chunkSize, cfg.chunkSize + 1, 100_000} {
+		content := make([]byte, size)
+		for i := range content {
+			content[i] = byte(i * 13)
+		}
+		for _, streamed := range []bool{false, true} {
+			r := newUploadRequest(t, "sized.bin", "", content)
+			if streamed {
+				r = newStreamedUploadRequest("sized.bin", content)
+			}
+			w := httptest.NewRecorder()
+			uploadHandler(store, newTestCipher(), cfg)(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Upload of %d bytes failed with status %d: %s", size, w.Code, w.Body.String())
+			}
+			fetched := fetchFile(store, uidFromResponse(w.Body.String()), nil)
+			if !bytes.Equal(fetched.Body.Bytes(), content) {
+				t.Errorf("Fetched file of %d bytes differs from the uploaded one", size)
+			}
+		}
+	}
+}
+
+// BenchmarkChunkPoolGet measures the buffers allocated to read files of various sizes. Every iteration uses a new pool,
+// like after a garbage collection emptied it, so that small files are shown to allocate small buffers.
+func BenchmarkChunkPoolGet(b *testing.B) {
+	for _, fileSize := range []int64{1024, 1024 * 1024, UNKNOWN_FILE_SIZE} {
+		b.Run(fmt.Sprintf("fileSize=%d", fileSize), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				pool := newChunkPool(DEFAULT_CHUNK_SIZE)
+				pool.put(pool.get(fileSize))
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"api/cryptography"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// cryptLocalFile encrypts or decrypts the file at inPath into the file at outPath, in the same format as the objects
+// stored in MinIO, so that files can be checked without MinIO. The path "-" stands for stdin or stdout.
+// If the operation fails, the partially written output file is removed.
+func cryptLocalFile(cipher cryptography.Cipher, decrypt bool, inPath, outPath string, stdin io.Reader, stdout io.Writer) (err error) {
+	input := stdin
+	if inPath != "-" {
+		inFile, err := os.Open(inPath)
+		if err != nil {
+			return err
+		}
+		defer inFile.Close()
+		input = inFile
+	}
+
+	output := stdout
+	if outPath != "-" {
+		outFile, createErr := os.Create(outPath)
+		if createErr != nil {
+			return createErr
+		}
+		defer func() {
+			err = errors.Join(err, outFile.Close())
+			if err != nil {
+				os.Remove(outPath)
+			}
+		}()
+		output = outFile
+	}
+
+	if decrypt {
+		if err := cipher.DecryptStream(input, output); err != nil {
+			return fmt.Errorf("decryption failed: %v", err)
+		}
+		return nil
+	}
+	if err := cipher.EncryptStream(input, output); err != nil {
+		return fmt.Errorf("encryption failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCryptLocalFileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("Steve kept a copy of his breakfast diary offline. "), 100)
+	plaintextPath := filepath.Join(dir, "diary.txt")
+	encryptedPath := filepath.Join(dir, "diary.bin")
+	decryptedPath := filepath.Join(dir, "decrypted.txt")
+	if err := os.WriteFile(plaintextPath, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cryptLocalFile(newTestCipher(), false, plaintextPath, encryptedPath, nil, nil); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if err := cryptLocalFile(newTestCipher(), true, encryptedPath, decryptedPath, nil, nil); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	encrypted, _ := os.ReadFile(encryptedPath)
+	if bytes.Contains(encrypted, content[:32]) {
+		t.Errorf("Encrypted file contains the plaintext")
+	}
+	if decrypted, _ := os.ReadFile(decryptedPath); !bytes.Equal(decrypted, content) {
+		t.Errorf("Decrypted file differs from the original one")
+	}
+}
+
+func TestCryptLocalFileStdinStdout(t *testing.T) {
+	content := []byte("Piped through stdin and stdout.")
+	var encrypted, decrypted bytes.Buffer
+	if err := cryptLocalFile(newTestCipher(), false, "-", "-", bytes.NewReader(content), &encrypted); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if err := cryptLocalFile(newTestCipher(), true, "-", "-", &encrypted, &decrypted); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), content) {
+		t.Errorf("Decrypted %q, want %q", decrypted.Bytes(), content)
+	}
+}
+
+func TestCryptLocalFileRemovesOutputOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	encryptedPath := filepath.Join(dir, "truncated.bin")
+	outputPath := filepath.Join(dir, "output.txt")
+	if err := os.WriteFile(encryptedPath, []byte("shorter than an IV"[:10]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cryptLocalFile(newTestCipher(), true, encryptedPath, outputPath, nil, nil); err == nil {
+		t.Fatalf("Decrypting a file shorter than the IV succeeded")
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("Output file of the failed decryption was kept")
+	}
+}
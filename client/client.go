@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// UNKNOWN_SIZE is the size of the files whose size is not known in advance, which the server reads until their end.
+const UNKNOWN_SIZE = -1
+
+// MAX_ERROR_MESSAGE_SIZE bounds the part of the body of a failed response kept as the message of its StatusError.
+const MAX_ERROR_MESSAGE_SIZE = 4096
+
+// Client uploads files to the API and downloads them, building the multipart requests with their File-Size and Uid
+// headers.
+type Client struct {
+	// BaseURL is the address of the API, such as http://localhost:8080.
+	BaseURL string
+	// APIKey is sent as a bearer token when it is not empty, for servers which require one of their API_KEYS.
+	APIKey string
+	// HTTPClient sends the requests, http.DefaultClient being used if it is nil.
+	HTTPClient *http.Client
+}
+
+// New creates a client of the API at baseURL, authenticated with apiKey if it is not empty.
+func New(baseURL string, apiKey string) *Client {
+	return &Client{BaseURL: baseURL, APIKey: apiKey}
+}
+
+// StatusError is returned when the server answers with an error status.
+type StatusError struct {
+	StatusCode int
+	// Message is the body of the response, which explains the error.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server answered %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// UidConflictError is returned when uploading to a UID which is already used, along with the UID the server
+// recommended instead. The upload can be retried with the recommended UID, which is not reserved by the server and
+// can be taken in the meantime.
+type UidConflictError struct {
+	Uid         uint64
+	Recommended uint64
+}
+
+func (e *UidConflictError) Error() string {
+	return fmt.Sprintf("UID %d is already used, %d is recommended instead", e.Uid, e.Recommended)
+}
+
+// ErrChecksumMismatch is returned when the checksum of a file sent by the server differs from the one of the file
+// which was read.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Upload uploads the size bytes read from r under the given filename, and returns the UID the server stored it under.
+// The size can be UNKNOWN_SIZE if it is not known in advance.
+func (c *Client) Upload(ctx context.Context, filename string, r io.Reader, size int64) (uint64, error) {
+	return c.upload(ctx, nil, filename, r, size)
+}
+
+// UploadWithUid uploads a file like Upload, under the given UID. If the UID is already used, it fails with a
+// *UidConflictError holding the UID recommended by the server.
+func (c *Client) UploadWithUid(ctx context.Context, uid uint64, filename string, r io.Reader, size int64) (uint64, error) {
+	return c.upload(ctx, &uid, filename, r, size)
+}
+
+func (c *Client) upload(ctx context.Context, uid *uint64, filename string, r io.Reader, size int64) (uint64, error) {
+	// The multipart body is streamed, so that the file is never held in memory
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	hash := sha256.New()
+	go func() {
+		part, err := writer.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(io.MultiWriter(part, hash), r)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+	// The request stops reading the body once it fails, which must unblock the goroutine
+	defer bodyReader.Close()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/upload", bodyReader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if size == UNKNOWN_SIZE {
+		req.Header.Set("File-Size", "unknown")
+	} else {
+		req.Header.Set("File-Size", strconv.FormatInt(size, 10))
+	}
+	if uid != nil {
+		req.Header.Set("Uid", strconv.FormatUint(*uid, 10))
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		var statusErr *StatusError
+		if uid != nil && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+			if recommended, ok := parseRecommendedUid(statusErr.Message); ok {
+				return 0, &UidConflictError{Uid: *uid, Recommended: recommended}
+			}
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	storedUid, err := strconv.ParseUint(resp.Header.Get("X-Upload-UID"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid UID %q in the response: %w", resp.Header.Get("X-Upload-UID"), err)
+	}
+	// A deduplicated file is the one already stored, which has the same checksum
+	if checksum := resp.Header.Get("X-Content-SHA256"); checksum != "" && checksum != hex.EncodeToString(hash.Sum(nil)) {
+		return storedUid, fmt.Errorf("file stored under UID %d: %w", storedUid, ErrChecksumMismatch)
+	}
+	return storedUid, nil
+}
+
+// parseRecommendedUid reads the UID recommended by the server in the message of a conflict, which ends with it.
+func parseRecommendedUid(message string) (uint64, bool) {
+	_, recommendedStr, ok := strings.Cut(message, "please retry with ")
+	if !ok {
+		return 0, false
+	}
+	recommended, err := strconv.ParseUint(strings.TrimSpace(recommendedStr), 10, 64)
+	return recommended, err == nil
+}
+
+// Download writes the file stored under the given UID to w. Since the file is streamed, w may have received part of it
+// when an error is returned, such as ErrChecksumMismatch once the whole file was written.
+func (c *Client) Download(ctx context.Context, uid uint64, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/fetch?uid="+strconv.FormatUint(uid, 10), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(w, hash), resp.Body); err != nil {
+		return err
+	}
+	if checksum := resp.Header.Get("X-Content-SHA256"); checksum != "" && checksum != hex.EncodeToString(hash.Sum(nil)) {
+		return fmt.Errorf("file stored under UID %d: %w", uid, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	return req, nil
+}
+
+// do sends the request, and turns the responses with an error status into a *StatusError.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, MAX_ERROR_MESSAGE_SIZE))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	return resp, nil
+}
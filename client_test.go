@@ -0,0 +1,98 @@
+package main
+
+import (
+	"api/client"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAPIServer serves the upload and fetch handlers, requiring the given API key.
+func newAPIServer(t *testing.T, store uploadStore, apiKey string) *httptest.Server {
+	t.Helper()
+	uidTracker.Init(nil)
+	cfg := defaultConfig()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", requireAPIKey([]string{apiKey}, uploadHandler(store, newTestCipher(), cfg)))
+	mux.HandleFunc("/fetch", requireAPIKey([]string{apiKey}, fetchAndDecryptHandler(store, newTestCipher(), cfg)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	server := newAPIServer(t, newMemoryStore(), "secret")
+	c := client.New(server.URL, "secret")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name        string
+		unknownSize bool
+	}{
+		{"known.txt", false},
+		{"streamed.txt", true},
+	} {
+		content := bytes.Repeat([]byte("Sent by the client of "+tc.name+" "), 1000)
+		size := int64(len(content))
+		if tc.unknownSize {
+			size = client.UNKNOWN_SIZE
+		}
+		uid, err := c.Upload(ctx, tc.name, bytes.NewReader(content), size)
+		if err != nil {
+			t.Fatalf("Upload of %s failed: %v", tc.name, err)
+		}
+		var fetched bytes.Buffer
+		if err := c.Download(ctx, uid, &fetched); err != nil {
+			t.Fatalf("Download of %s failed: %v", tc.name, err)
+		}
+		if !bytes.Equal(fetched.Bytes(), content) {
+			t.Errorf("Downloaded %s differs from the uploaded one", tc.name)
+		}
+	}
+}
+
+func TestClientUidConflict(t *testing.T) {
+	server := newAPIServer(t, newMemoryStore(), "secret")
+	c := client.New(server.URL, "secret")
+	ctx := context.Background()
+
+	if uid, err := c.UploadWithUid(ctx, 42, "first.txt", strings.NewReader("first"), 5); err != nil || uid != 42 {
+		t.Fatalf("Upload with UID 42 gave UID %d and error %v", uid, err)
+	}
+	_, err := c.UploadWithUid(ctx, 42, "second.txt", strings.NewReader("second"), 6)
+	var conflict *client.UidConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Upload to a used UID gave %v, want a UidConflictError", err)
+	}
+	if conflict.Uid != 42 || conflict.Recommended == 42 {
+		t.Errorf("Conflict on UID %d recommended %d", conflict.Uid, conflict.Recommended)
+	}
+	// The recommended UID is free
+	uid, err := c.UploadWithUid(ctx, conflict.Recommended, "second.txt", strings.NewReader("second"), 6)
+	if err != nil || uid != conflict.Recommended {
+		t.Errorf("Upload with the recommended UID gave UID %d and error %v", uid, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newAPIServer(t, newMemoryStore(), "secret")
+	ctx := context.Background()
+
+	var statusErr *client.StatusError
+	_, err := client.New(server.URL, "wrong").Upload(ctx, "file.txt", strings.NewReader("content"), 7)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Upload with a wrong API key gave %v, want a 401 StatusError", err)
+	}
+	err = client.New(server.URL, "secret").Download(ctx, 404, &bytes.Buffer{})
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Download of a missing file gave %v, want a 404 StatusError", err)
+	}
+	_, err = client.New(server.URL, "secret").Upload(ctx, "file.txt", strings.NewReader("content"), 3)
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Upload of a file larger than declared gave %v, want a 413 StatusError", err)
+	}
+}
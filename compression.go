@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"api/cryptography"
+)
+
+// COMPRESSION_GZIP is the value of the Compress header asking for the file to be gzipped before its encryption,
+// which is also stored in the Compression metadata of such objects.
+const COMPRESSION_GZIP = "gzip"
+
+// parseCompression returns whether the Compress header asks for the file to be compressed.
+func parseCompression(header string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(header)) {
+	case "":
+		return false, nil
+	case COMPRESSION_GZIP:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unsupported compression %q, only %s is supported", header, COMPRESSION_GZIP)
+	}
+}
+
+// isCompressible tells whether compressing content of the given type is worth it. Media and archive formats are already
+// compressed, so gzipping them would only cost CPU time without making them any smaller.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return true
+	}
+	if kind, _, _ := strings.Cut(mediaType, "/"); kind == "image" || kind == "video" || kind == "audio" {
+		// SVG images are plain text
+		return mediaType == "image/svg+xml"
+	}
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/x-bzip2", "application/x-xz",
+		"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed", "application/vnd.rar",
+		"application/pdf":
+		return false
+	}
+	return true
+}
+
+// decryptAndDecompress decrypts an object whose plaintext was gzipped before its encryption, and writes the decompressed
+// file to the writer. The decryption stops once the context is done.
+func decryptAndDecompress(ctx context.Context, cipher cryptography.Cipher, object io.Reader, writer io.Writer) error {
+	compressedReader, compressedWriter := io.Pipe()
+	go func() {
+		compressedWriter.CloseWithError(cipher.DecryptStreamCtx(ctx, object, compressedWriter))
+	}()
+	// Stop the decryption if the decompression fails
+	defer compressedReader.Close()
+
+	gzipReader, err := gzip.NewReader(compressedReader)
+	if err != nil {
+		return fmt.Errorf("unable to decompress file: %w", err)
+	}
+	if _, err := io.Copy(writer, gzipReader); err != nil {
+		return fmt.Errorf("unable to decompress file: %w", err)
+	}
+	return gzipReader.Close()
+}
+
+// isCompressedObject tells whether an object's metadata marks it as gzipped before its encryption.
+func isCompressedObject(userMetadata map[string]string) bool {
+	value, _ := metadataValue(userMetadata, "Compression")
+	return value == COMPRESSION_GZIP
+}
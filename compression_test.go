@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressedUploadRoundTrip(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Steve logged every breakfast of the vacation in this file. "), 1000)
+
+	r := newUploadRequest(t, "breakfasts.txt", "text/plain", content)
+	r.Header.Set("Compress", "gzip")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	objectName := uidFromResponse(w.Body.String())
+	stored := store.objects[objectName]
+	if got := stored.info.UserMetadata["Compression"]; got != COMPRESSION_GZIP {
+		t.Errorf("Stored Compression metadata = %q, want %q", got, COMPRESSION_GZIP)
+	}
+	if len(stored.data) >= len(content) {
+		t.Errorf("Stored object is %d bytes long, want less than the %d bytes of the file", len(stored.data), len(content))
+	}
+
+	fetched := fetchFile(store, objectName, http.Header{"Range": {"bytes=0-9"}})
+	if fetched.Code != http.StatusOK {
+		t.Fatalf("Fetch status = %d, want %d", fetched.Code, http.StatusOK)
+	}
+	if !bytes.Equal(fetched.Body.Bytes(), content) {
+		t.Errorf("Fetched file differs from the uploaded one")
+	}
+	if got := fetched.Header().Get("X-Content-SHA256"); got != w.Header().Get("X-Content-SHA256") {
+		t.Errorf("Fetch X-Content-SHA256 = %s, want the upload checksum %s", got, w.Header().Get("X-Content-SHA256"))
+	}
+}
+
+func TestCompressionSkipsCompressedFormats(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte{0xff, 0xd8}, 1000)
+
+	r := newUploadRequest(t, "photo.jpg", "image/jpeg", content)
+	r.Header.Set("Compress", "gzip")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	objectName := uidFromResponse(w.Body.String())
+	if _, ok := store.objects[objectName].info.UserMetadata["Compression"]; ok {
+		t.Errorf("A JPEG image was compressed")
+	}
+	if fetched := fetchFile(store, objectName, nil); !bytes.Equal(fetched.Body.Bytes(), content) {
+		t.Errorf("Fetched file differs from the uploaded one")
+	}
+}
+
+func TestUploadRejectsUnknownCompression(t *testing.T) {
+	uidTracker.Init(nil)
+	r := newUploadRequest(t, "notes.txt", "text/plain", []byte("notes"))
+	r.Header.Set("Compress", "brotli")
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
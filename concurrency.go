@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Uploads rarely take less than a few seconds, so clients rejected because too many uploads are in progress are told
+// to wait that long before retrying.
+const UPLOAD_SLOTS_RETRY_AFTER = 5 * time.Second
+
+// uploadSlots bounds the number of uploads in progress at once, each of them holding a slot of the buffered channel
+// until it is over.
+type uploadSlots struct {
+	slots chan struct{}
+}
+
+// newUploadSlots creates slots for the given number of concurrent uploads.
+func newUploadSlots(maxUploads int) *uploadSlots {
+	return &uploadSlots{slots: make(chan struct{}, maxUploads)}
+}
+
+// limit wraps an upload handler to reject the uploads arriving while every slot is taken with a 503, along with a
+// Retry-After header telling the clients how many seconds to wait. The slot of an upload is only released once its
+// handler returned, after its files were stored or discarded.
+func (s *uploadSlots) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(int(UPLOAD_SLOTS_RETRY_AFTER.Seconds())))
+			http.Error(w, "Too many uploads in progress, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-s.slots }()
+		next(w, r)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestUploadRejectedWhileSlotsAreTaken(t *testing.T) {
+	uidTracker.Init(nil)
+	const maxUploads = 3
+	slots := newUploadSlots(maxUploads)
+	// The uploads to the blocking store stay in progress until their request is canceled
+	started := make(chan struct{}, maxUploads)
+	blocked := slots.limit(uploadHandler(blockingStore{memoryStore: newMemoryStore(), onPut: func() { started <- struct{}{} }}, newTestCipher(), defaultConfig()))
+	upload := slots.limit(uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	for range maxUploads {
+		r := newUploadRequest(t, "blocked.txt", "text/plain", []byte("In progress")).WithContext(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			blocked(httptest.NewRecorder(), r)
+		}()
+	}
+	for range maxUploads {
+		<-started
+	}
+
+	w := httptest.NewRecorder()
+	upload(w, newUploadRequest(t, "rejected.txt", "text/plain", []byte("One upload too many")))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Upload beyond the %d concurrent ones gave status %d, want %d", maxUploads, w.Code, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") != "5" {
+		t.Errorf("Retry-After = %q, want 5", w.Header().Get("Retry-After"))
+	}
+
+	// The slots are released once the uploads are over
+	cancel()
+	wg.Wait()
+	w = httptest.NewRecorder()
+	upload(w, newUploadRequest(t, "accepted.txt", "text/plain", []byte("A slot is free")))
+	if w.Code != http.StatusOK {
+		t.Errorf("Upload after the others ended gave status %d: %s", w.Code, w.Body.String())
+	}
+}
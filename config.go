@@ -0,0 +1,430 @@
+package main
+
+import (
+	"api/cryptography"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+// config holds the settings of the service, which can be overridden through environment variables.
+type config struct {
+	// minioEndpoint is the host:port address of the MinIO server.
+	minioEndpoint string
+	// bucketName is the MinIO bucket in which the encrypted files are stored.
+	bucketName string
+	// region is the region in which the bucket is created if it does not exist yet.
+	region string
+	// listenAddr is the address the HTTP server listens on.
+	listenAddr string
+	// chunkSize is the size in bytes of the buffer used to read uploaded files. Files declared smaller are read
+	// with smaller buffers.
+	chunkSize int
+	// maxUploadSize is the largest accepted file size in bytes.
+	maxUploadSize int64
+	// retry is the policy applied to MinIO operations failing with transient errors.
+	retry retryPolicy
+	// presignExpiry is how long the presigned download URLs remain valid.
+	presignExpiry time.Duration
+	// uploadRateLimit is the average number of uploads per second allowed for every client, 0 disabling the rate limiting.
+	uploadRateLimit float64
+	// uploadRateBurst is the number of uploads a client can send at once before being rate limited.
+	uploadRateBurst int
+	// maxConcurrentUploads is the number of uploads which can be in progress at once, 0 disabling the limit.
+	maxConcurrentUploads int
+	// reaperInterval is the interval between two deletions of the expired objects.
+	reaperInterval time.Duration
+	// uploadMinRate is the slowest upload rate to MinIO expected on the deployment, in bytes per second.
+	uploadMinRate float64
+	// uploadTimeoutMargin is added to the time uploads take at uploadMinRate to get their timeout.
+	uploadTimeoutMargin time.Duration
+	// uploadIdleTimeout is how long an upload may receive nothing from its client before it is aborted, 0 disabling the limit.
+	uploadIdleTimeout time.Duration
+	// bufferedFetchMaxSize is the size in bytes up to which fetched files are decrypted and verified before being sent.
+	bufferedFetchMaxSize int64
+	// uploadPartSize is the size in bytes of the parts sent to MinIO for the files whose size is unknown upfront.
+	uploadPartSize int64
+	// deduplicate tells whether a file uploaded again is given the UID of the object already holding it, instead of
+	// being stored twice.
+	deduplicate bool
+	// uniqueFilenames tells whether a file uploaded under the name of a stored file is renamed with its UID, so that
+	// downloaded files do not collide.
+	uniqueFilenames bool
+	// detectContentType tells whether the type of a file uploaded without a type, or with the generic binary type, is
+	// detected from its extension or its first bytes.
+	detectContentType bool
+	// encryptionBufferSize is the size in bytes of the buffer through which every file is encrypted and decrypted.
+	encryptionBufferSize int
+	// allowedOrigins is the comma-separated list of the origins from which browsers may call the upload and fetch
+	// routes, none by default.
+	allowedOrigins string
+	// startupTimeout bounds the time spent listing the stored objects at startup.
+	startupTimeout time.Duration
+	// spillDir is the directory in which the files whose size is unknown upfront are written before being uploaded with
+	// their size, instead of being uploaded by parts held in memory. Empty disables spilling.
+	spillDir string
+	// cipherMode is the mode in which files are encrypted, selecting the implementation of the Cipher interface.
+	cipherMode string
+	// maxObjects is the number of files which can be stored at once, 0 disabling the limit.
+	maxObjects int
+	// maxTotalBytes is the number of bytes the stored objects can take in MinIO at once, 0 disabling the limit.
+	maxTotalBytes int64
+	// scrubInterval is the interval between two verifications of all the stored files, 0 disabling them.
+	scrubInterval time.Duration
+	// base64FetchMaxSize is the size in bytes up to which files can be fetched as base64 in a JSON object.
+	base64FetchMaxSize int64
+	// verifyETag tells whether the ETag of the stored objects is compared to the MD5 checksum of the uploaded data,
+	// which must be disabled on buckets using server-side encryption, whose ETags are not MD5 checksums.
+	verifyETag bool
+}
+
+const DEFAULT_MINIO_ENDPOINT = "minio:9000"
+const DEFAULT_BUCKET_NAME = "challenge-taurus"
+const DEFAULT_REGION = "us-east-1"
+const DEFAULT_LISTEN_ADDR = ":8080"
+
+// The chunk size was chosen for extreme cases where the daemon has very little RAM. For faster uploads, chunks of 16-64MB can easily be used.
+const DEFAULT_CHUNK_SIZE = 1024 * 1024 * 8
+
+// Chunks larger than this would defeat the purpose of streaming uploads on a daemon with little RAM.
+const MAX_CHUNK_SIZE = 1024 * 1024 * 512
+
+// MinIO cannot store objects larger than 5TiB, which bounds the size of uploaded files along with the encryption header
+// stored in front of them.
+const MAX_MINIO_OBJECT_SIZE int64 = 5 * 1024 * 1024 * 1024 * 1024
+const MAX_FILE_SIZE = MAX_MINIO_OBJECT_SIZE - cryptography.HEADER_SIZE
+
+const DEFAULT_MINIO_MAX_ATTEMPTS = 3
+const DEFAULT_MINIO_RETRY_DELAY = 100 * time.Millisecond
+
+// S3 presigned URLs cannot be valid for more than 7 days.
+const DEFAULT_PRESIGN_EXPIRY = 15 * time.Minute
+const MAX_PRESIGN_EXPIRY = 7 * 24 * time.Hour
+
+const DEFAULT_UPLOAD_RATE_LIMIT = 1.0
+const DEFAULT_UPLOAD_RATE_BURST = 5
+
+// Every upload holds a chunk buffer and the buffers of its pipeline in memory, so a daemon with little RAM can only
+// afford a couple of them at once.
+const DEFAULT_MAX_CONCURRENT_UPLOADS = 2
+
+const DEFAULT_REAPER_INTERVAL = time.Minute
+
+// On the small daemons this service targets, uploads to MinIO should not be slower than 1MB/s. Starting an upload may
+// have a little overhead, which is covered by the timeout margin.
+const DEFAULT_UPLOAD_MIN_RATE = 1024 * 1024
+const DEFAULT_UPLOAD_TIMEOUT_MARGIN = 10 * time.Second
+
+// Clients uploading files are expected to send data continuously, a client which stalls for longer has likely gone.
+const DEFAULT_UPLOAD_IDLE_TIMEOUT = 30 * time.Second
+
+// Fetched files up to this size are held in memory to be verified before being sent, which must remain affordable on
+// daemons with little RAM.
+const DEFAULT_BUFFERED_FETCH_MAX_SIZE = 1024 * 1024
+
+// Files fetched as base64 are held in memory twice, once decrypted and once encoded, and are meant to be small.
+const DEFAULT_BASE64_FETCH_MAX_SIZE = 1024 * 1024
+
+// Files whose size is unknown upfront, because they are compressed or uploaded without a File-Size, are sent to MinIO
+// in parts which are each held in memory. S3 requires parts between 5MiB and 5GiB, and at most 10000 parts per object.
+const MIN_UPLOAD_PART_SIZE = 1024 * 1024 * 5
+const MAX_UPLOAD_PART_SIZE = 1024 * 1024 * 1024 * 5
+const MAX_UPLOAD_PARTS = 10000
+const DEFAULT_UPLOAD_PART_SIZE = MIN_UPLOAD_PART_SIZE
+
+// Files are encrypted and decrypted through buffers of this size, which gave the best throughput on the upload pipeline
+// in BenchmarkEncryptStream while remaining small.
+const DEFAULT_ENCRYPTION_BUFFER_SIZE = cryptography.DEFAULT_BUFFER_SIZE
+
+// Listing the objects at startup should be quick, a MinIO deployment which does not answer in time is considered down.
+const DEFAULT_STARTUP_TIMEOUT = 30 * time.Second
+
+// The modes in which files can be encrypted, selected by CIPHER_MODE. Only the unauthenticated CTR mode is implemented
+// for now. GCM, which would detect tampered files, is recognized but rejected until an authenticated cipher exists, at
+// which point it should become the default.
+const CIPHER_MODE_CTR = "ctr"
+const CIPHER_MODE_GCM = "gcm"
+const DEFAULT_CIPHER_MODE = CIPHER_MODE_CTR
+
+// defaultConfig returns the configuration used when no environment variable overrides it.
+func defaultConfig() config {
+	return config{
+		minioEndpoint: DEFAULT_MINIO_ENDPOINT,
+		bucketName:    DEFAULT_BUCKET_NAME,
+		region:        DEFAULT_REGION,
+		listenAddr:    DEFAULT_LISTEN_ADDR,
+		chunkSize:     DEFAULT_CHUNK_SIZE,
+		maxUploadSize: MAX_FILE_SIZE,
+		retry:         retryPolicy{maxAttempts: DEFAULT_MINIO_MAX_ATTEMPTS, baseDelay: DEFAULT_MINIO_RETRY_DELAY},
+		presignExpiry: DEFAULT_PRESIGN_EXPIRY,
+
+		uploadRateLimit: DEFAULT_UPLOAD_RATE_LIMIT,
+		uploadRateBurst: DEFAULT_UPLOAD_RATE_BURST,
+		reaperInterval:  DEFAULT_REAPER_INTERVAL,
+
+		maxConcurrentUploads: DEFAULT_MAX_CONCURRENT_UPLOADS,
+
+		uploadMinRate:       DEFAULT_UPLOAD_MIN_RATE,
+		uploadTimeoutMargin: DEFAULT_UPLOAD_TIMEOUT_MARGIN,
+		uploadIdleTimeout:   DEFAULT_UPLOAD_IDLE_TIMEOUT,
+
+		bufferedFetchMaxSize: DEFAULT_BUFFERED_FETCH_MAX_SIZE,
+		uploadPartSize:       DEFAULT_UPLOAD_PART_SIZE,
+		detectContentType:    true,
+		encryptionBufferSize: DEFAULT_ENCRYPTION_BUFFER_SIZE,
+		startupTimeout:       DEFAULT_STARTUP_TIMEOUT,
+		cipherMode:           DEFAULT_CIPHER_MODE,
+		base64FetchMaxSize:   DEFAULT_BASE64_FETCH_MAX_SIZE,
+		verifyETag:           true,
+	}
+}
+
+// loadConfig builds the configuration from the defaults, overridden by the MINIO_ENDPOINT, BUCKET_NAME, MINIO_REGION,
+// LISTEN_ADDR, CHUNK_SIZE, MAX_UPLOAD_SIZE, MINIO_MAX_ATTEMPTS, MINIO_RETRY_DELAY, PRESIGN_EXPIRY, UPLOAD_RATE_LIMIT,
+// UPLOAD_RATE_BURST, MAX_CONCURRENT_UPLOADS, REAPER_INTERVAL, UPLOAD_MIN_RATE, UPLOAD_TIMEOUT_MARGIN, UPLOAD_IDLE_TIMEOUT, BUFFERED_FETCH_MAX_SIZE, UPLOAD_PART_SIZE, DEDUPLICATE, UNIQUE_FILENAMES, DETECT_CONTENT_TYPE, ENCRYPTION_BUFFER_SIZE, ALLOWED_ORIGINS, STARTUP_TIMEOUT, SPILL_DIR, CIPHER_MODE, MAX_OBJECTS, MAX_TOTAL_BYTES, SCRUB_INTERVAL, BASE64_FETCH_MAX_SIZE and VERIFY_ETAG environment variables when they are set. An error is returned if any value is invalid.
+func loadConfig() (config, error) {
+	cfg := defaultConfig()
+
+	if endpoint := os.Getenv("MINIO_ENDPOINT"); endpoint != "" {
+		cfg.minioEndpoint = endpoint
+	}
+	if bucketName := os.Getenv("BUCKET_NAME"); bucketName != "" {
+		if err := s3utils.CheckValidBucketNameStrict(bucketName); err != nil {
+			return config{}, fmt.Errorf("BUCKET_NAME %q is invalid: %v", bucketName, err)
+		}
+		cfg.bucketName = bucketName
+	}
+	if region := os.Getenv("MINIO_REGION"); region != "" {
+		cfg.region = region
+	}
+	if listenAddr := os.Getenv("LISTEN_ADDR"); listenAddr != "" {
+		cfg.listenAddr = listenAddr
+	}
+	if chunkSizeStr := os.Getenv("CHUNK_SIZE"); chunkSizeStr != "" {
+		chunkSize, err := strconv.Atoi(chunkSizeStr)
+		if err != nil || chunkSize <= 0 || chunkSize > MAX_CHUNK_SIZE {
+			return config{}, fmt.Errorf("CHUNK_SIZE should be a number of bytes between 1 and %d, got %q", MAX_CHUNK_SIZE, chunkSizeStr)
+		}
+		cfg.chunkSize = chunkSize
+	}
+	// A maximal upload size larger than what MinIO can store is lowered to the largest file MinIO can store.
+	if maxUploadSizeStr := os.Getenv("MAX_UPLOAD_SIZE"); maxUploadSizeStr != "" {
+		maxUploadSize, err := strconv.ParseInt(maxUploadSizeStr, 10, 64)
+		if err != nil || maxUploadSize < 0 {
+			return config{}, fmt.Errorf("MAX_UPLOAD_SIZE should be a number of bytes, got %q", maxUploadSizeStr)
+		}
+		cfg.maxUploadSize = min(maxUploadSize, MAX_FILE_SIZE)
+	}
+	if maxAttemptsStr := os.Getenv("MINIO_MAX_ATTEMPTS"); maxAttemptsStr != "" {
+		maxAttempts, err := strconv.Atoi(maxAttemptsStr)
+		if err != nil || maxAttempts <= 0 {
+			return config{}, fmt.Errorf("MINIO_MAX_ATTEMPTS should be a positive number, got %q", maxAttemptsStr)
+		}
+		cfg.retry.maxAttempts = maxAttempts
+	}
+	if retryDelayStr := os.Getenv("MINIO_RETRY_DELAY"); retryDelayStr != "" {
+		retryDelay, err := time.ParseDuration(retryDelayStr)
+		if err != nil || retryDelay < 0 {
+			return config{}, fmt.Errorf("MINIO_RETRY_DELAY should be a duration such as 100ms, got %q", retryDelayStr)
+		}
+		cfg.retry.baseDelay = retryDelay
+	}
+	if presignExpiryStr := os.Getenv("PRESIGN_EXPIRY"); presignExpiryStr != "" {
+		presignExpiry, err := time.ParseDuration(presignExpiryStr)
+		if err != nil || presignExpiry < time.Second || presignExpiry > MAX_PRESIGN_EXPIRY {
+			return config{}, fmt.Errorf("PRESIGN_EXPIRY should be a duration between 1s and %s, got %q", MAX_PRESIGN_EXPIRY, presignExpiryStr)
+		}
+		cfg.presignExpiry = presignExpiry
+	}
+	if rateLimitStr := os.Getenv("UPLOAD_RATE_LIMIT"); rateLimitStr != "" {
+		rateLimit, err := strconv.ParseFloat(rateLimitStr, 64)
+		if err != nil || rateLimit < 0 || math.IsInf(rateLimit, 0) {
+			return config{}, fmt.Errorf("UPLOAD_RATE_LIMIT should be a number of uploads per second, got %q", rateLimitStr)
+		}
+		cfg.uploadRateLimit = rateLimit
+	}
+	if rateBurstStr := os.Getenv("UPLOAD_RATE_BURST"); rateBurstStr != "" {
+		rateBurst, err := strconv.Atoi(rateBurstStr)
+		if err != nil || rateBurst <= 0 {
+			return config{}, fmt.Errorf("UPLOAD_RATE_BURST should be a positive number, got %q", rateBurstStr)
+		}
+		cfg.uploadRateBurst = rateBurst
+	}
+	if maxConcurrentUploadsStr := os.Getenv("MAX_CONCURRENT_UPLOADS"); maxConcurrentUploadsStr != "" {
+		maxConcurrentUploads, err := strconv.Atoi(maxConcurrentUploadsStr)
+		if err != nil || maxConcurrentUploads < 0 {
+			return config{}, fmt.Errorf("MAX_CONCURRENT_UPLOADS should be a number of uploads, or 0 for no limit, got %q", maxConcurrentUploadsStr)
+		}
+		cfg.maxConcurrentUploads = maxConcurrentUploads
+	}
+	if reaperIntervalStr := os.Getenv("REAPER_INTERVAL"); reaperIntervalStr != "" {
+		reaperInterval, err := time.ParseDuration(reaperIntervalStr)
+		if err != nil || reaperInterval <= 0 {
+			return config{}, fmt.Errorf("REAPER_INTERVAL should be a positive duration such as 1m, got %q", reaperIntervalStr)
+		}
+		cfg.reaperInterval = reaperInterval
+	}
+	if minRateStr := os.Getenv("UPLOAD_MIN_RATE"); minRateStr != "" {
+		minRate, err := strconv.ParseFloat(minRateStr, 64)
+		if err != nil || minRate <= 0 || math.IsInf(minRate, 0) {
+			return config{}, fmt.Errorf("UPLOAD_MIN_RATE should be a positive number of bytes per second, got %q", minRateStr)
+		}
+		cfg.uploadMinRate = minRate
+	}
+	if timeoutMarginStr := os.Getenv("UPLOAD_TIMEOUT_MARGIN"); timeoutMarginStr != "" {
+		timeoutMargin, err := time.ParseDuration(timeoutMarginStr)
+		if err != nil || timeoutMargin < 0 {
+			return config{}, fmt.Errorf("UPLOAD_TIMEOUT_MARGIN should be a duration such as 10s, got %q", timeoutMarginStr)
+		}
+		cfg.uploadTimeoutMargin = timeoutMargin
+	}
+	if idleTimeoutStr := os.Getenv("UPLOAD_IDLE_TIMEOUT"); idleTimeoutStr != "" {
+		idleTimeout, err := time.ParseDuration(idleTimeoutStr)
+		if err != nil || idleTimeout < 0 {
+			return config{}, fmt.Errorf("UPLOAD_IDLE_TIMEOUT should be a duration such as 30s, or 0 for no limit, got %q", idleTimeoutStr)
+		}
+		cfg.uploadIdleTimeout = idleTimeout
+	}
+	if bufferedFetchMaxSizeStr := os.Getenv("BUFFERED_FETCH_MAX_SIZE"); bufferedFetchMaxSizeStr != "" {
+		bufferedFetchMaxSize, err := strconv.ParseInt(bufferedFetchMaxSizeStr, 10, 64)
+		if err != nil || bufferedFetchMaxSize < 0 || bufferedFetchMaxSize > MAX_CHUNK_SIZE {
+			return config{}, fmt.Errorf("BUFFERED_FETCH_MAX_SIZE should be a number of bytes between 0 and %d, got %q", MAX_CHUNK_SIZE, bufferedFetchMaxSizeStr)
+		}
+		cfg.bufferedFetchMaxSize = bufferedFetchMaxSize
+	}
+	if partSizeStr := os.Getenv("UPLOAD_PART_SIZE"); partSizeStr != "" {
+		partSize, err := strconv.ParseInt(partSizeStr, 10, 64)
+		if err != nil || partSize < MIN_UPLOAD_PART_SIZE || partSize > MAX_UPLOAD_PART_SIZE {
+			return config{}, fmt.Errorf("UPLOAD_PART_SIZE should be a number of bytes between %d and %d, got %q", MIN_UPLOAD_PART_SIZE, MAX_UPLOAD_PART_SIZE, partSizeStr)
+		}
+		cfg.uploadPartSize = partSize
+	}
+	if deduplicateStr := os.Getenv("DEDUPLICATE"); deduplicateStr != "" {
+		deduplicate, err := strconv.ParseBool(deduplicateStr)
+		if err != nil {
+			return config{}, fmt.Errorf("DEDUPLICATE should be true or false, got %q", deduplicateStr)
+		}
+		cfg.deduplicate = deduplicate
+	}
+	if uniqueFilenamesStr := os.Getenv("UNIQUE_FILENAMES"); uniqueFilenamesStr != "" {
+		uniqueFilenames, err := strconv.ParseBool(uniqueFilenamesStr)
+		if err != nil {
+			return config{}, fmt.Errorf("UNIQUE_FILENAMES should be true or false, got %q", uniqueFilenamesStr)
+		}
+		cfg.uniqueFilenames = uniqueFilenames
+	}
+	if detectContentTypeStr := os.Getenv("DETECT_CONTENT_TYPE"); detectContentTypeStr != "" {
+		detectContentType, err := strconv.ParseBool(detectContentTypeStr)
+		if err != nil {
+			return config{}, fmt.Errorf("DETECT_CONTENT_TYPE should be true or false, got %q", detectContentTypeStr)
+		}
+		cfg.detectContentType = detectContentType
+	}
+	if bufferSizeStr := os.Getenv("ENCRYPTION_BUFFER_SIZE"); bufferSizeStr != "" {
+		bufferSize, err := strconv.Atoi(bufferSizeStr)
+		if err != nil || bufferSize <= 0 || bufferSize > MAX_CHUNK_SIZE {
+			return config{}, fmt.Errorf("ENCRYPTION_BUFFER_SIZE should be a number of bytes between 1 and %d, got %q", MAX_CHUNK_SIZE, bufferSizeStr)
+		}
+		cfg.encryptionBufferSize = bufferSize
+	}
+	if allowedOrigins := os.Getenv("ALLOWED_ORIGINS"); allowedOrigins != "" {
+		if _, err := parseAllowedOrigins(allowedOrigins); err != nil {
+			return config{}, fmt.Errorf("ALLOWED_ORIGINS should be a comma-separated list of origins: %v", err)
+		}
+		cfg.allowedOrigins = allowedOrigins
+	}
+	if startupTimeoutStr := os.Getenv("STARTUP_TIMEOUT"); startupTimeoutStr != "" {
+		startupTimeout, err := time.ParseDuration(startupTimeoutStr)
+		if err != nil || startupTimeout <= 0 {
+			return config{}, fmt.Errorf("STARTUP_TIMEOUT should be a positive duration such as 30s, got %q", startupTimeoutStr)
+		}
+		cfg.startupTimeout = startupTimeout
+	}
+	if spillDir := os.Getenv("SPILL_DIR"); spillDir != "" {
+		if info, err := os.Stat(spillDir); err != nil || !info.IsDir() {
+			return config{}, fmt.Errorf("SPILL_DIR should be an existing directory, got %q", spillDir)
+		}
+		cfg.spillDir = spillDir
+	}
+	if cipherMode := os.Getenv("CIPHER_MODE"); cipherMode != "" {
+		switch cipherMode {
+		case CIPHER_MODE_CTR:
+		case CIPHER_MODE_GCM:
+			return config{}, fmt.Errorf("CIPHER_MODE %s is not implemented yet, use %s", CIPHER_MODE_GCM, CIPHER_MODE_CTR)
+		default:
+			return config{}, fmt.Errorf("CIPHER_MODE should be %s, got %q", CIPHER_MODE_CTR, cipherMode)
+		}
+		cfg.cipherMode = cipherMode
+	}
+	if maxObjectsStr := os.Getenv("MAX_OBJECTS"); maxObjectsStr != "" {
+		maxObjects, err := strconv.Atoi(maxObjectsStr)
+		if err != nil || maxObjects < 0 {
+			return config{}, fmt.Errorf("MAX_OBJECTS should be a number of files, or 0 for no limit, got %q", maxObjectsStr)
+		}
+		cfg.maxObjects = maxObjects
+	}
+	if maxTotalBytesStr := os.Getenv("MAX_TOTAL_BYTES"); maxTotalBytesStr != "" {
+		maxTotalBytes, err := strconv.ParseInt(maxTotalBytesStr, 10, 64)
+		if err != nil || maxTotalBytes < 0 {
+			return config{}, fmt.Errorf("MAX_TOTAL_BYTES should be a number of bytes, or 0 for no limit, got %q", maxTotalBytesStr)
+		}
+		cfg.maxTotalBytes = maxTotalBytes
+	}
+	if scrubIntervalStr := os.Getenv("SCRUB_INTERVAL"); scrubIntervalStr != "" {
+		scrubInterval, err := time.ParseDuration(scrubIntervalStr)
+		if err != nil || scrubInterval < 0 {
+			return config{}, fmt.Errorf("SCRUB_INTERVAL should be a duration such as 24h, or 0 to disable scrubbing, got %q", scrubIntervalStr)
+		}
+		cfg.scrubInterval = scrubInterval
+	}
+	if base64FetchMaxSizeStr := os.Getenv("BASE64_FETCH_MAX_SIZE"); base64FetchMaxSizeStr != "" {
+		base64FetchMaxSize, err := strconv.ParseInt(base64FetchMaxSizeStr, 10, 64)
+		if err != nil || base64FetchMaxSize < 0 || base64FetchMaxSize > MAX_CHUNK_SIZE {
+			return config{}, fmt.Errorf("BASE64_FETCH_MAX_SIZE should be a number of bytes between 0 and %d, got %q", MAX_CHUNK_SIZE, base64FetchMaxSizeStr)
+		}
+		cfg.base64FetchMaxSize = base64FetchMaxSize
+	}
+	if verifyETagStr := os.Getenv("VERIFY_ETAG"); verifyETagStr != "" {
+		verifyETag, err := strconv.ParseBool(verifyETagStr)
+		if err != nil {
+			return config{}, fmt.Errorf("VERIFY_ETAG should be true or false, got %q", verifyETagStr)
+		}
+		cfg.verifyETag = verifyETag
+	}
+	return cfg, nil
+}
+
+// validateConfig checks the environment variables the server cannot run without: SYM_KEY must be a hex-encoded AES key
+// of 16, 24 or 32 bytes, and MINIO_USER and MINIO_PWD must be set, as MinIO would otherwise only reject the first
+// request. The optional OBJECT_KEY_SECRET is checked along, being a secret too, and the other optional variables by
+// loadConfig. Every problem found is returned, to be fixed at once.
+func validateConfig() []error {
+	var problems []error
+	if symKey := os.Getenv("SYM_KEY"); symKey == "" {
+		problems = append(problems, errors.New("SYM_KEY is not set"))
+	} else if key, err := hex.DecodeString(symKey); err != nil {
+		problems = append(problems, errors.New("SYM_KEY should be hex-encoded"))
+	} else if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		problems = append(problems, fmt.Errorf("SYM_KEY should be a key of 16, 24 or 32 bytes, got %d bytes", len(key)))
+	}
+	if secret := os.Getenv("OBJECT_KEY_SECRET"); secret != "" {
+		if key, err := hex.DecodeString(secret); err != nil || len(key) < MIN_OBJECT_KEY_SECRET_SIZE {
+			problems = append(problems, fmt.Errorf("OBJECT_KEY_SECRET should be a hex-encoded secret of at least %d bytes", MIN_OBJECT_KEY_SECRET_SIZE))
+		}
+	}
+	for _, env := range []string{"MINIO_USER", "MINIO_PWD"} {
+		if os.Getenv(env) == "" {
+			problems = append(problems, fmt.Errorf("%s is not set", env))
+		}
+	}
+	if _, err := loadConfig(); err != nil {
+		problems = append(problems, err)
+	}
+	return problems
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadConfigDefaults(t *testing.T) {
+	for _, env := range []string{"MINIO_ENDPOINT", "BUCKET_NAME", "MINIO_REGION", "LISTEN_ADDR", "CHUNK_SIZE", "MAX_UPLOAD_SIZE", "MINIO_MAX_ATTEMPTS", "MINIO_RETRY_DELAY", "PRESIGN_EXPIRY", "UPLOAD_RATE_LIMIT", "UPLOAD_RATE_BURST", "MAX_CONCURRENT_UPLOADS", "REAPER_INTERVAL", "UPLOAD_MIN_RATE", "UPLOAD_TIMEOUT_MARGIN", "UPLOAD_IDLE_TIMEOUT", "BUFFERED_FETCH_MAX_SIZE", "UPLOAD_PART_SIZE", "DEDUPLICATE", "UNIQUE_FILENAMES", "DETECT_CONTENT_TYPE", "ENCRYPTION_BUFFER_SIZE", "ALLOWED_ORIGINS", "STARTUP_TIMEOUT", "SPILL_DIR", "CIPHER_MODE", "MAX_OBJECTS", "MAX_TOTAL_BYTES", "SCRUB_INTERVAL", "BASE64_FETCH_MAX_SIZE", "VERIFY_ETAG"} {
+		t.Setenv(env, "")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Loading the default configuration failed: %v", err)
+	}
+	if cfg != defaultConfig() {
+		t.Errorf("loadConfig() = %+v, want the defaults %+v", cfg, defaultConfig())
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	t.Setenv("MINIO_ENDPOINT", "localhost:9100")
+	t.Setenv("BUCKET_NAME", "other-bucket")
+	t.Setenv("MINIO_REGION", "eu-west-1")
+	t.Setenv("LISTEN_ADDR", "127.0.0.1:9090")
+	t.Setenv("CHUNK_SIZE", "65536")
+	t.Setenv("MAX_UPLOAD_SIZE", "1048576")
+	t.Setenv("MINIO_MAX_ATTEMPTS", "5")
+	t.Setenv("MINIO_RETRY_DELAY", "1s")
+	t.Setenv("PRESIGN_EXPIRY", "1h")
+	t.Setenv("UPLOAD_RATE_LIMIT", "0.5")
+	t.Setenv("UPLOAD_RATE_BURST", "2")
+	t.Setenv("MAX_CONCURRENT_UPLOADS", "8")
+	t.Setenv("REAPER_INTERVAL", "30s")
+	t.Setenv("UPLOAD_MIN_RATE", "104857600")
+	t.Setenv("UPLOAD_TIMEOUT_MARGIN", "2s")
+	t.Setenv("UPLOAD_IDLE_TIMEOUT", "1m")
+	t.Setenv("BUFFERED_FETCH_MAX_SIZE", "4096")
+	t.Setenv("UPLOAD_PART_SIZE", "16777216")
+	t.Setenv("DEDUPLICATE", "true")
+	t.Setenv("UNIQUE_FILENAMES", "true")
+	t.Setenv("DETECT_CONTENT_TYPE", "false")
+	t.Setenv("ENCRYPTION_BUFFER_SIZE", "1048576")
+	t.Setenv("ALLOWED_ORIGINS", "https://app.example.com, http://localhost:3000")
+	t.Setenv("STARTUP_TIMEOUT", "2m")
+	spillDir := t.TempDir()
+	t.Setenv("SPILL_DIR", spillDir)
+	t.Setenv("CIPHER_MODE", "ctr")
+	t.Setenv("MAX_OBJECTS", "1000")
+	t.Setenv("MAX_TOTAL_BYTES", "1073741824")
+	t.Setenv("SCRUB_INTERVAL", "24h")
+	t.Setenv("BASE64_FETCH_MAX_SIZE", "65536")
+	t.Setenv("VERIFY_ETAG", "false")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Loading the configuration failed: %v", err)
+	}
+	want := config{
+		minioEndpoint: "localhost:9100",
+		bucketName:    "other-bucket",
+		region:        "eu-west-1",
+		listenAddr:    "127.0.0.1:9090",
+		chunkSize:     65536,
+		maxUploadSize: 1048576,
+		retry:         retryPolicy{maxAttempts: 5, baseDelay: time.Second},
+		presignExpiry: time.Hour,
+
+		uploadRateLimit: 0.5,
+		uploadRateBurst: 2,
+		reaperInterval:  30 * time.Second,
+
+		maxConcurrentUploads: 8,
+
+		uploadMinRate:       104857600,
+		uploadTimeoutMargin: 2 * time.Second,
+		uploadIdleTimeout:   time.Minute,
+
+		bufferedFetchMaxSize: 4096,
+		uploadPartSize:       16777216,
+		deduplicate:          true,
+		uniqueFilenames:      true,
+		detectContentType:    false,
+		encryptionBufferSize: 1048576,
+		allowedOrigins:       "https://app.example.com, http://localhost:3000",
+		startupTimeout:       2 * time.Minute,
+		spillDir:             spillDir,
+		cipherMode:           CIPHER_MODE_CTR,
+		maxObjects:           1000,
+		maxTotalBytes:        1073741824,
+		scrubInterval:        24 * time.Hour,
+		base64FetchMaxSize:   65536,
+		verifyETag:           false,
+	}
+	if cfg != want {
+		t.Errorf("loadConfig() = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigCapsMaxUploadSize(t *testing.T) {
+	t.Setenv("MAX_UPLOAD_SIZE", "99999999999999999")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Loading the configuration failed: %v", err)
+	}
+	if cfg.maxUploadSize != MAX_FILE_SIZE {
+		t.Errorf("maxUploadSize = %d, want it capped to %d", cfg.maxUploadSize, MAX_FILE_SIZE)
+	}
+}
+
+func TestLoadConfigInvalidValues(t *testing.T) {
+	tests := []struct {
+		env   string
+		value string
+	}{
+		{"BUCKET_NAME", "Invalid_Bucket"},
+		{"CHUNK_SIZE", "0"},
+		{"CHUNK_SIZE", "-1"},
+		{"CHUNK_SIZE", "8MB"},
+		{"CHUNK_SIZE", "99999999999"},
+		{"MAX_UPLOAD_SIZE", "-1"},
+		{"MAX_UPLOAD_SIZE", "1MB"},
+		{"MINIO_MAX_ATTEMPTS", "0"},
+		{"MINIO_MAX_ATTEMPTS", "many"},
+		{"MINIO_RETRY_DELAY", "100"},
+		{"MINIO_RETRY_DELAY", "-1s"},
+		{"PRESIGN_EXPIRY", "0s"},
+		{"PRESIGN_EXPIRY", "8d"},
+		{"PRESIGN_EXPIRY", "200h"},
+		{"UPLOAD_PART_SIZE", "1048576"},
+		{"UPLOAD_PART_SIZE", "99999999999"},
+		{"DEDUPLICATE", "sometimes"},
+		{"UNIQUE_FILENAMES", "2"},
+		{"DETECT_CONTENT_TYPE", "maybe"},
+		{"VERIFY_ETAG", "sometimes"},
+		{"ENCRYPTION_BUFFER_SIZE", "0"},
+		{"ENCRYPTION_BUFFER_SIZE", "1MB"},
+		{"ALLOWED_ORIGINS", "example.com"},
+		{"ALLOWED_ORIGINS", "https://example.com/app"},
+		{"ALLOWED_ORIGINS", "ftp://example.com"},
+		{"UPLOAD_IDLE_TIMEOUT", "-1s"},
+		{"UPLOAD_IDLE_TIMEOUT", "30"},
+		{"STARTUP_TIMEOUT", "0s"},
+		{"STARTUP_TIMEOUT", "30"},
+		{"SPILL_DIR", "/nonexistent/spill"},
+		{"SPILL_DIR", "config_test.go"},
+		{"CIPHER_MODE", "gcm"},
+		{"CIPHER_MODE", "CTR"},
+		{"CIPHER_MODE", "aes"},
+		{"MAX_CONCURRENT_UPLOADS", "-1"},
+		{"MAX_CONCURRENT_UPLOADS", "many"},
+		{"MAX_OBJECTS", "-1"},
+		{"MAX_OBJECTS", "many"},
+		{"MAX_TOTAL_BYTES", "-1"},
+		{"MAX_TOTAL_BYTES", "1GB"},
+		{"SCRUB_INTERVAL", "-1h"},
+		{"SCRUB_INTERVAL", "daily"},
+		{"BASE64_FETCH_MAX_SIZE", "-1"},
+		{"BASE64_FETCH_MAX_SIZE", "1MB"},
+	}
+	for _, test := range tests {
+		t.Run(test.env+"="+test.value, func(t *testing.T) {
+			t.Setenv(test.env, test.value)
+			if _, err := loadConfig(); err == nil {
+				t.Errorf("loadConfig() accepted %s=%q", test.env, test.value)
+			}
+		})
+	}
+}
+
+// setValidEnv sets the required environment variables to valid values, and clears the optional ones checked with them.
+func setValidEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("SYM_KEY", "6368616e676520746869732070617373776f726420746f206120736563726574")
+	t.Setenv("MINIO_USER", "minio")
+	t.Setenv("MINIO_PWD", "minio-password")
+	t.Setenv("OBJECT_KEY_SECRET", "")
+	t.Setenv("CHUNK_SIZE", "")
+}
+
+func TestValidateConfigAcceptsValidEnv(t *testing.T) {
+	setValidEnv(t)
+	if problems := validateConfig(); len(problems) != 0 {
+		t.Errorf("validateConfig() = %v for a valid configuration", problems)
+	}
+}
+
+func TestValidateConfigReportsProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"missing key", map[string]string{"SYM_KEY": ""}},
+		{"key not hex", map[string]string{"SYM_KEY": "not a hex key"}},
+		{"key too short", map[string]string{"SYM_KEY": "6368616e6765"}},
+		{"missing user", map[string]string{"MINIO_USER": ""}},
+		{"missing password", map[string]string{"MINIO_PWD": ""}},
+		{"object key secret not hex", map[string]string{"OBJECT_KEY_SECRET": "not a hex secret"}},
+		{"object key secret too short", map[string]string{"OBJECT_KEY_SECRET": "6368616e6765"}},
+		{"invalid optional value", map[string]string{"CHUNK_SIZE": "large"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setValidEnv(t)
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+			if problems := validateConfig(); len(problems) != 1 {
+				t.Errorf("validateConfig() = %v, want a single problem", problems)
+			}
+		})
+	}
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	t.Setenv("OBJECT_KEY_SECRET", "")
+	t.Setenv("SYM_KEY", "")
+	t.Setenv("MINIO_USER", "")
+	t.Setenv("MINIO_PWD", "")
+	t.Setenv("CHUNK_SIZE", "")
+	if problems := validateConfig(); len(problems) != 3 {
+		t.Errorf("validateConfig() = %v, want the 3 missing variables", problems)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// SNIFF_LENGTH is the number of bytes at the beginning of a file from which its type is detected, all that
+// http.DetectContentType considers.
+const SNIFF_LENGTH = 512
+
+// detectContentType returns the type of a file uploaded without a specific one, from the extension of its filename or
+// else from its first bytes. The file must then be read from the returned reader, which holds the bytes read to
+// detect its type.
+func detectContentType(filename string, file io.Reader) (string, io.Reader) {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType, file
+	}
+	// A read error is kept by the buffered reader, and returned again when the file is read
+	buffered := bufio.NewReaderSize(file, SNIFF_LENGTH)
+	head, _ := buffered.Peek(SNIFF_LENGTH)
+	return http.DetectContentType(head), buffered
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// PNG_SIGNATURE starts every PNG image.
+var PNG_SIGNATURE = []byte("\x89PNG\r\n\x1a\n")
+
+func TestUploadDetectsContentType(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	// Longer than the sniffed bytes, to check that they are not lost
+	image := append(append([]byte{}, PNG_SIGNATURE...), bytes.Repeat([]byte{0x42}, 2*SNIFF_LENGTH)...)
+
+	for _, tc := range []struct {
+		filename    string
+		contentType string
+		content     []byte
+		want        string
+	}{
+		{"photo.png", "", []byte("not sniffed"), "image/png"},
+		{"photo", "", image, "image/png"},
+		{"photo", defaultContentType, image, "image/png"},
+		{"photo.png", "image/webp", image, "image/webp"},
+		{"blob", "", []byte{0x00, 0x01}, defaultContentType},
+	} {
+		objectName := uploadFile(t, store, tc.filename, tc.contentType, tc.content)
+		w := fetchFile(store, objectName, nil)
+		if got := w.Header().Get("Content-Type"); got != tc.want {
+			t.Errorf("Content-Type of %s uploaded as %q = %q, want %q", tc.filename, tc.contentType, got, tc.want)
+		}
+		if !bytes.Equal(w.Body.Bytes(), tc.content) {
+			t.Errorf("Fetched %s differs from the uploaded one", tc.filename)
+		}
+	}
+}
+
+func TestUploadContentTypeDetectionDisabled(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.detectContentType = false
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, "photo.png", "", PNG_SIGNATURE))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	fetched := fetchFile(store, uidFromResponse(w.Body.String()), nil)
+	if got := fetched.Header().Get("Content-Type"); got != defaultContentType {
+		t.Errorf("Content-Type = %q, want %q", got, defaultContentType)
+	}
+}
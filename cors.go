@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The request headers browsers may send cross-origin, besides the custom metadata headers, and the response headers
+// they may read.
+const CORS_ALLOWED_HEADERS = "Authorization, Content-Type, File-Size, Uid, Compress, TTL-Seconds, Range, X-Namespace, X-Storage-Class, X-Tags, X-Checksums, X-Overwrite"
+const CORS_EXPOSED_HEADERS = "Content-Disposition, Content-Length, Content-Range, Accept-Ranges, X-Content-SHA256, X-Compression, Retry-After, X-Upload-UID, X-Missing-UIDs"
+
+// Browsers cache the result of a preflight request for this duration.
+const CORS_MAX_AGE = 10 * time.Minute
+
+// parseAllowedOrigins splits a comma-separated list of origins such as https://example.com, ignoring blank entries.
+// The wildcard * allows every origin.
+func parseAllowedOrigins(originList string) ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(originList, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+				return nil, fmt.Errorf("%q is not an origin such as https://example.com", origin)
+			}
+		}
+		origins = append(origins, strings.ToLower(origin))
+	}
+	return origins, nil
+}
+
+// allowCORS lets browsers call the handler from the allowed origins with the given methods. It answers the preflight
+// requests itself, so that they do not need an API key, and lets the other requests through. Requests from other
+// origins are not given any CORS header, so that browsers block them. CORS is disabled when no origin is allowed.
+func allowCORS(allowedOrigins []string, methods string, next http.HandlerFunc) http.HandlerFunc {
+	if len(allowedOrigins) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		// The response depends on the origin, which caches must take into account
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !isAllowedOrigin(allowedOrigins, origin) {
+			if preflight {
+				http.Error(w, "Cross-origin requests are not allowed from this origin", http.StatusForbidden)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+			next(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", methods+", OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", allowedHeaders(r.Header.Get("Access-Control-Request-Headers")))
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(CORS_MAX_AGE.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// isAllowedOrigin tells whether the origin is one of the allowed origins, or if every origin is allowed.
+func isAllowedOrigin(allowedOrigins []string, origin string) bool {
+	return slices.Contains(allowedOrigins, "*") || slices.Contains(allowedOrigins, strings.ToLower(origin))
+}
+
+// allowedHeaders returns the headers a preflight request may announce: the known headers of the service, along with the
+// custom metadata headers it asked for, whose names cannot be listed upfront.
+func allowedHeaders(requestedHeaders string) string {
+	headers := CORS_ALLOWED_HEADERS
+	for _, header := range strings.Split(requestedHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if len(header) > len(CUSTOM_METADATA_HEADER_PREFIX) && strings.EqualFold(header[:len(CUSTOM_METADATA_HEADER_PREFIX)], CUSTOM_METADATA_HEADER_PREFIX) {
+			headers += ", " + header
+		}
+	}
+	return headers
+}
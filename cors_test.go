@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newCORSRequest(method, origin string) *http.Request {
+	r := httptest.NewRequest(method, "/upload", nil)
+	r.Header.Set("Origin", origin)
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	reached := false
+	handler := allowCORS([]string{"https://app.example.com"}, http.MethodPost, requireAPIKey([]string{"secret"}, func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	r := newCORSRequest(http.MethodOptions, "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "authorization, file-size, uid, x-meta-project, x-storage-class, x-tags, x-checksums, x-overwrite")
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Preflight status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if reached {
+		t.Error("The preflight request reached the handler")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(got, http.MethodPost) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want it to allow %s", got, http.MethodPost)
+	}
+	allowedHeaders := strings.ToLower(w.Header().Get("Access-Control-Allow-Headers"))
+	for _, header := range []string{"authorization", "file-size", "uid", "x-meta-project", "x-storage-class", "x-tags", "x-checksums", "x-overwrite"} {
+		if !strings.Contains(allowedHeaders, header) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want it to allow %s", allowedHeaders, header)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		allowed        bool
+	}{
+		{"allowed origin", []string{"https://app.example.com", "http://localhost:3000"}, "http://localhost:3000", true},
+		{"origin in another case", []string{"https://app.example.com"}, "https://APP.example.com", true},
+		{"disallowed origin", []string{"https://app.example.com"}, "https://evil.example.com", false},
+		{"wildcard", []string{"*"}, "https://evil.example.com", true},
+		{"CORS disabled", nil, "https://app.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := allowCORS(test.allowedOrigins, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("uploaded"))
+			})
+
+			w := httptest.NewRecorder()
+			handler(w, newCORSRequest(http.MethodPost, test.origin))
+			if w.Code != http.StatusOK || w.Body.String() != "uploaded" {
+				t.Errorf("Request gave status %d and body %q, want it to reach the handler", w.Code, w.Body.String())
+			}
+			gotOrigin := w.Header().Get("Access-Control-Allow-Origin")
+			if test.allowed && gotOrigin != test.origin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", gotOrigin, test.origin)
+			}
+			if !test.allowed && gotOrigin != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q for a disallowed origin", gotOrigin)
+			}
+
+			preflight := newCORSRequest(http.MethodOptions, test.origin)
+			preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			w = httptest.NewRecorder()
+			handler(w, preflight)
+			if test.allowed && w.Code != http.StatusNoContent {
+				t.Errorf("Preflight status %d, want %d", w.Code, http.StatusNoContent)
+			}
+			if !test.allowed && w.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Error("The preflight request of a disallowed origin was allowed")
+			}
+		})
+	}
+}
+
+func TestCORSExposesResponseHeaders(t *testing.T) {
+	handler := allowCORS([]string{"https://app.example.com"}, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	handler(w, newCORSRequest(http.MethodPost, "https://app.example.com"))
+
+	exposedHeaders := strings.ToLower(w.Header().Get("Access-Control-Expose-Headers"))
+	for _, header := range []string{"x-content-sha256", "retry-after", "x-upload-uid", "x-missing-uids"} {
+		if !strings.Contains(exposedHeaders, header) {
+			t.Errorf("Access-Control-Expose-Headers = %q, want it to expose %s", exposedHeaders, header)
+		}
+	}
+}
@@ -1,76 +1,523 @@
 package cryptography
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 )
 
 // Cipher interface provides methods for stream encryption and decryption.
 type Cipher interface {
-	Init()
 	EncryptStream(reader io.Reader, writer io.Writer) error
-	DecryptStream(ciphertext []byte) []byte
+	DecryptStream(reader io.Reader, writer io.Writer) error
+	EncryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error
+	DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error
+	DecryptStreamAt(header []byte, offset int64, reader io.Reader, writer io.Writer) error
+	NewHeader() ([]byte, error)
+	EncryptStreamAt(header []byte, offset int64, reader io.Reader, writer io.Writer) error
+	EncryptedSize(plaintextSize int64) int64
+	HeaderSize() int
+	StreamHeaderSize(prefix []byte) (int, error)
 }
 
+// DEFAULT_BUFFER_SIZE is the size of the buffer through which streams are encrypted and decrypted, unless
+// StreamCipher.BufferSize is set. See BenchmarkEncryptStream for the throughput obtained with different sizes.
+const DEFAULT_BUFFER_SIZE = 256 * 1024
+
+// DEFAULT_KEY_ID is the ID of the key registered by Init.
+const DEFAULT_KEY_ID byte = 0
+
+// Encrypted streams start with a header made of HEADER_MAGIC, the version of the format of the stream, the ID of the key
+// which encrypted the stream and the IV. The version lets streams of different formats coexist if the format evolves.
+const HEADER_MAGIC = "ENC"
+const FORMAT_VERSION byte = 1
+
+// HEADER_SIZE is the size of the header of the streams of the current FORMAT_VERSION.
+const HEADER_SIZE = versionOffset + 1 + 1 + aes.BlockSize
+
+// Streams encrypted before the header held a version start with the IV alone, and were encrypted with the key of
+// DEFAULT_KEY_ID. Streams without HEADER_MAGIC are read as such streams of LEGACY_VERSION, whose header is the IV.
+const LEGACY_VERSION byte = 0
+const LEGACY_HEADER_SIZE = aes.BlockSize
+
+// HEADER_PREFIX_SIZE is the number of bytes at the beginning of a stream which tell its version, and so the size of its
+// header.
+const HEADER_PREFIX_SIZE = keyIDOffset
+
+// The offsets of the fields of the header. versionOffset is len(HEADER_MAGIC), spelled out to keep HEADER_SIZE untyped.
+const (
+	versionOffset = 3
+	keyIDOffset   = versionOffset + 1
+	ivOffset      = keyIDOffset + 1
+)
+
+// ErrUnsupportedVersion is returned when decrypting a stream whose format version is unknown.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// ErrNotInitialized is returned when using a StreamCipher which was not given any key, e.g. because Init was not called.
+var ErrNotInitialized = errors.New("cipher not initialized")
+
+// StreamCipher implements Cipher with AES in CTR mode, the ID of the key and the IV being written in the header of the
+// encrypted stream. Streams are encrypted with the current key, and decrypted with the key whose ID they start with,
+// so that the current key can be rotated while the streams encrypted with the previous ones can still be decrypted.
+// The keys must all be registered before the cipher is used.
 type StreamCipher struct {
-	block cipher.Block
+	keys         map[byte]cipher.Block
+	currentKeyID byte
+	// BufferSize is the size in bytes of the buffer through which streams are encrypted and decrypted, each stream
+	// using its own buffer. DEFAULT_BUFFER_SIZE is used if it is not positive.
+	BufferSize int
 }
 
+var _ Cipher = (*StreamCipher)(nil)
+
 // EncryptStream reads data from the provided io.Reader and encrypts it using a stream cipher which is written to the io.Writer.
 func (c *StreamCipher) EncryptStream(reader io.Reader, writer io.Writer) error {
+	sw, err := c.EncryptingWriter(writer)
+	if err != nil {
+		return err
+	}
+
+	// Stream and encrypt the data
+	_, err = io.CopyBuffer(sw, reader, c.newBuffer())
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
+// EncryptingWriter returns a writer encrypting everything written to it into the io.Writer with the current key, after
+// writing the key ID and the IV at the beginning of the stream. Closing it closes the io.Writer if it is an io.Closer.
+func (c *StreamCipher) EncryptingWriter(writer io.Writer) (io.WriteCloser, error) {
 	iv := make([]byte, aes.BlockSize)
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	return c.encryptingWriter(writer, iv)
+}
+
+// EncryptStreamWithIV encrypts a stream like EncryptStream, but with the given IV instead of a random one, so that the
+// same plaintext always gives the same stream. It is only meant for tests and tools needing reproducible streams:
+// reusing an IV with the same key lets the XOR of two plaintexts be recovered from their streams.
+func (c *StreamCipher) EncryptStreamWithIV(reader io.Reader, writer io.Writer, iv []byte) error {
+	if len(iv) != aes.BlockSize {
+		return fmt.Errorf("invalid IV length %d, want %d", len(iv), aes.BlockSize)
+	}
+	sw, err := c.encryptingWriter(writer, iv)
+	if err != nil {
 		return err
 	}
+	_, err = io.CopyBuffer(sw, reader, c.newBuffer())
+	return err
+}
 
-	// StreamWriter will encrypt data and write it to the writer as it is read from the reader
-	stream := cipher.NewCTR(c.block, iv)
+// encryptingWriter returns a writer encrypting everything written to it into the io.Writer with the current key and
+// the given IV, after writing the header.
+func (c *StreamCipher) encryptingWriter(writer io.Writer, iv []byte) (io.WriteCloser, error) {
+	if len(c.keys) == 0 {
+		return nil, ErrNotInitialized
+	}
+	block, ok := c.keys[c.currentKeyID]
+	if !ok {
+		return nil, fmt.Errorf("no key with ID %d", c.currentKeyID)
+	}
+	header := c.newHeader(iv)
 
-	// Write nonce to the output (important for decryption)
-	if _, err := writer.Write(iv); err != nil {
-		return err
+	// StreamWriter will encrypt data and write it to the writer as it is written to it
+	stream := cipher.NewCTR(block, iv)
+
+	// Write the header with the key ID and the nonce to the output (important for decryption)
+	if _, err := writer.Write(header); err != nil {
+		return nil, err
 	}
+	return &cipher.StreamWriter{S: stream, W: writer}, nil
+}
 
-	// Stream and encrypt the data
-	sw := &cipher.StreamWriter{S: stream, W: writer}
+// NewHeader returns the header of a new stream encrypted with the current key and a random IV, for the stream to be
+// encrypted by portions with EncryptStreamAt.
+func (c *StreamCipher) NewHeader() ([]byte, error) {
+	if len(c.keys) == 0 {
+		return nil, ErrNotInitialized
+	}
+	if _, ok := c.keys[c.currentKeyID]; !ok {
+		return nil, fmt.Errorf("no key with ID %d", c.currentKeyID)
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	return c.newHeader(iv), nil
+}
 
-	_, err := io.Copy(sw, reader)
+// newHeader returns the header of a stream encrypted with the current key and the given IV.
+func (c *StreamCipher) newHeader(iv []byte) []byte {
+	header := make([]byte, HEADER_SIZE)
+	copy(header, HEADER_MAGIC)
+	header[versionOffset] = FORMAT_VERSION
+	header[keyIDOffset] = c.currentKeyID
+	copy(header[ivOffset:], iv)
+	return header
+}
+
+// DecryptStream reads the stream of ciphertext from the io.Reader and decrypts it on the fly into the io.Writer.
+func (c *StreamCipher) DecryptStream(reader io.Reader, writer io.Writer) error {
+	sr, err := c.DecryptingReader(reader)
 	if err != nil {
 		return err
 	}
+
+	// Copy the decrypted stream to the writer
+	if _, err := io.CopyBuffer(writer, sr, c.newBuffer()); err != nil {
+		return fmt.Errorf("error while decrypting stream: %w", err)
+	}
+
 	return nil
 }
 
-// DecryptStream reads the stream of ciphertext from the io.Reader and decrypts it on the fly into the io.Writer.
-func (c *StreamCipher) DecryptStream(reader io.Reader, writer io.Writer) error {
-	// Read iv from the beginning of the stream
-	iv := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(reader, iv); err != nil {
-		return fmt.Errorf("unable to read iv: %v", err)
+// EncryptStreamCtx encrypts a stream like EncryptStream, but stops with the error of the context once it is done.
+// The context is checked between the chunks read from the io.Reader, so a chunk being read is not interrupted.
+func (c *StreamCipher) EncryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	return c.EncryptStream(contextReader{ctx: ctx, reader: reader}, writer)
+}
+
+// DecryptStreamCtx decrypts a stream like DecryptStream, but stops with the error of the context once it is done.
+// The context is checked between the chunks read from the io.Reader, so a chunk being read is not interrupted.
+func (c *StreamCipher) DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	return c.DecryptStream(contextReader{ctx: ctx, reader: reader}, writer)
+}
+
+// contextReader fails with the error of its context once it is done, instead of reading further.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// DecryptingReader reads the header at the beginning of a stream produced by EncryptStream or EncryptingWriter, and
+// returns a reader decrypting the rest of the stream with the key it names as it is read.
+func (c *StreamCipher) DecryptingReader(reader io.Reader) (io.Reader, error) {
+	// Fail before consuming the header
+	if len(c.keys) == 0 {
+		return nil, ErrNotInitialized
+	}
+	// Read the magic and the version, which tell how long the rest of the header is
+	header := make([]byte, HEADER_PREFIX_SIZE, HEADER_SIZE)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("unable to read the header: %v", err)
+	}
+	headerSize, err := StreamHeaderSize(header)
+	if err != nil {
+		return nil, err
+	}
+	// Read the key ID and the iv, or the rest of the iv of a legacy stream
+	header = header[:headerSize]
+	if _, err := io.ReadFull(reader, header[HEADER_PREFIX_SIZE:]); err != nil {
+		return nil, fmt.Errorf("unable to read iv: %v", err)
+	}
+	block, iv, err := c.parseHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := cipher.NewCTR(block, iv)
+	return &cipher.StreamReader{S: stream, R: reader}, nil
+}
+
+// parseVersion returns the format version of a stream starting with the given bytes, which must hold at least
+// HEADER_PREFIX_SIZE bytes. Streams which do not start with HEADER_MAGIC are of LEGACY_VERSION.
+func parseVersion(header []byte) (byte, error) {
+	if len(header) < HEADER_PREFIX_SIZE {
+		return 0, fmt.Errorf("the stream of %d bytes is shorter than its header", len(header))
+	}
+	if string(header[:versionOffset]) != HEADER_MAGIC {
+		return LEGACY_VERSION, nil
+	}
+	if version := header[versionOffset]; version != FORMAT_VERSION {
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	return header[versionOffset], nil
+}
+
+// StreamHeaderSize returns the size of the header of a stream starting with the given bytes, which must hold at least
+// HEADER_PREFIX_SIZE bytes. It depends on the format version of the stream.
+func StreamHeaderSize(prefix []byte) (int, error) {
+	version, err := parseVersion(prefix)
+	if err != nil {
+		return 0, err
+	}
+	if version == LEGACY_VERSION {
+		return LEGACY_HEADER_SIZE, nil
+	}
+	return HEADER_SIZE, nil
+}
+
+// parseHeader returns the key and the IV of a stream with the given header.
+func (c *StreamCipher) parseHeader(header []byte) (cipher.Block, []byte, error) {
+	if len(c.keys) == 0 {
+		return nil, nil, ErrNotInitialized
+	}
+	headerSize, err := StreamHeaderSize(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(header) != headerSize {
+		return nil, nil, fmt.Errorf("invalid header length %d", len(header))
+	}
+	keyID, err := KeyID(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, ok := c.keys[keyID]
+	if !ok {
+		return nil, nil, fmt.Errorf("the stream was encrypted with the unknown key ID %d", keyID)
+	}
+	// The IV ends the header of every version
+	return block, header[headerSize-aes.BlockSize:], nil
+}
+
+// KeyID returns the ID of the key which encrypted the stream with the given header. The header of a legacy stream holds
+// no key ID, and DEFAULT_KEY_ID is returned for it.
+func KeyID(header []byte) (byte, error) {
+	headerSize, err := StreamHeaderSize(header)
+	if err != nil {
+		return 0, err
+	}
+	if len(header) < headerSize {
+		return 0, fmt.Errorf("invalid header length %d", len(header))
+	}
+	if headerSize == LEGACY_HEADER_SIZE {
+		return DEFAULT_KEY_ID, nil
+	}
+	return header[keyIDOffset], nil
+}
+
+// DecryptStreamAt decrypts a portion of a stream produced by EncryptStream, starting at the given plaintext offset.
+// Since CTR mode is seekable, the header at the beginning of the full stream is enough to
+// resume decryption anywhere. The reader must be positioned on the ciphertext at BlockStart(offset), i.e. the beginning
+// of the block holding the offset.
+func (c *StreamCipher) DecryptStreamAt(header []byte, offset int64, reader io.Reader, writer io.Writer) error {
+	block, iv, err := c.parseHeader(header)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return fmt.Errorf("invalid negative offset %d", offset)
 	}
 
-	stream := cipher.NewCTR(c.block, iv)
+	// Advance the counter to the block containing the offset
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+	addToCounter(counter, uint64(offset/aes.BlockSize))
+
+	stream := cipher.NewCTR(block, counter)
 	sr := &cipher.StreamReader{S: stream, R: reader}
 
-	// Copy the decrypted stream to the writer
-	if _, err := io.Copy(writer, sr); err != nil {
+	// Drop the decrypted bytes preceding the offset in its block
+	if _, err := io.CopyN(io.Discard, sr, offset%aes.BlockSize); err != nil {
+		return fmt.Errorf("unable to reach offset %d: %v", offset, err)
+	}
+
+	if _, err := io.CopyBuffer(writer, sr, c.newBuffer()); err != nil {
 		return fmt.Errorf("error while decrypting stream: %v", err)
 	}
+	return nil
+}
+
+// DecryptToWriterAt decrypts a whole stream produced by EncryptStream, of the given size in bytes and header included,
+// and writes the plaintext at its offsets in the io.WriterAt, e.g. a file being downloaded. Since CTR mode is seekable,
+// the ciphertext is split into up to parallelism ranges which are decrypted concurrently. The ranges start on block
+// boundaries past the header, so that the counter of each range is the one of its first block. An error is returned if
+// any range fails.
+func (c *StreamCipher) DecryptToWriterAt(reader io.ReaderAt, size int64, w io.WriterAt, parallelism int) error {
+	if parallelism < 1 {
+		return fmt.Errorf("invalid parallelism %d, at least 1 range must be decrypted at once", parallelism)
+	}
+	if size < HEADER_PREFIX_SIZE {
+		return fmt.Errorf("the stream of %d bytes is shorter than its header", size)
+	}
+	// Read the beginning of the header, which tells its size
+	header := make([]byte, HEADER_PREFIX_SIZE, HEADER_SIZE)
+	if _, err := reader.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("unable to read the header: %v", err)
+	}
+	headerSize, err := StreamHeaderSize(header)
+	if err != nil {
+		return err
+	}
+	if size < int64(headerSize) {
+		return fmt.Errorf("the stream of %d bytes is shorter than its header", size)
+	}
+	header = header[:headerSize]
+	if _, err := reader.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("unable to read the header: %v", err)
+	}
+	if _, _, err := c.parseHeader(header); err != nil {
+		return err
+	}
+
+	// The ranges are a whole number of blocks long, so that every one of them but the last ends on a block boundary
+	ciphertextSize := size - int64(headerSize)
+	rangeSize := (ciphertextSize + int64(parallelism) - 1) / int64(parallelism)
+	rangeSize = max(BlockStart(rangeSize+aes.BlockSize-1), aes.BlockSize)
+
+	var wg sync.WaitGroup
+	var errs []error
+	var mu sync.Mutex
+	for offset := int64(0); offset < ciphertextSize; offset += rangeSize {
+		length := min(rangeSize, ciphertextSize-offset)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ciphertext := io.NewSectionReader(reader, int64(headerSize)+offset, length)
+			plaintext := &countingWriter{writer: io.NewOffsetWriter(w, offset)}
+			err := c.DecryptStreamAt(header, offset, ciphertext, plaintext)
+			// A reader shorter than the given size ends the range early without failing
+			if err == nil && plaintext.count != length {
+				err = fmt.Errorf("decrypted %d bytes, want %d", plaintext.count, length)
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("range at offset %d: %w", offset, err))
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// EncryptStreamAt encrypts a portion of the plaintext of the stream with the given header, as returned by NewHeader,
+// starting at the given plaintext offset. The ciphertext written to the io.Writer is the one EncryptStream would write
+// at the same offset past the header, so that the portions of a stream can be encrypted separately and in any order.
+// The header itself is not written.
+func (c *StreamCipher) EncryptStreamAt(header []byte, offset int64, reader io.Reader, writer io.Writer) error {
+	block, iv, err := c.parseHeader(header)
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return fmt.Errorf("invalid negative offset %d", offset)
+	}
 
+	// Advance the counter to the block containing the offset, and skip the key stream preceding the offset in its block
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+	addToCounter(counter, uint64(offset/aes.BlockSize))
+	stream := cipher.NewCTR(block, counter)
+	skipped := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skipped, skipped)
+
+	sw := &cipher.StreamWriter{S: stream, W: writer}
+	if _, err := io.CopyBuffer(sw, reader, c.newBuffer()); err != nil {
+		return fmt.Errorf("error while encrypting stream: %w", err)
+	}
 	return nil
 }
 
-// Init initializes the stream cipher using a secret key. If this key is derived from a passcode, ensure it was passed through a KDF.
+// EncryptedSize returns the exact size of the stream EncryptStream produces from a plaintext of the given size. In CTR
+// mode, the ciphertext is as long as the plaintext, and only the header is added.
+func (c *StreamCipher) EncryptedSize(plaintextSize int64) int64 {
+	return plaintextSize + int64(c.HeaderSize())
+}
+
+// HeaderSize returns the number of bytes which precede the ciphertext in the streams EncryptStream produces, and which
+// DecryptStreamAt expects as their header. The streams of a previous version may have a shorter header, whose size
+// StreamHeaderSize returns.
+func (c *StreamCipher) HeaderSize() int {
+	return HEADER_SIZE
+}
+
+// StreamHeaderSize returns the size of the header of the stream starting with the given bytes, which must hold the first
+// HeaderSize() bytes of the stream, or the whole stream if it is shorter.
+func (c *StreamCipher) StreamHeaderSize(prefix []byte) (int, error) {
+	return StreamHeaderSize(prefix)
+}
+
+// newBuffer allocates the buffer a stream is copied through.
+func (c *StreamCipher) newBuffer() []byte {
+	if c.BufferSize > 0 {
+		return make([]byte, c.BufferSize)
+	}
+	return make([]byte, DEFAULT_BUFFER_SIZE)
+}
+
+// BlockStart returns the offset of the beginning of the cipher block containing the given offset.
+func BlockStart(offset int64) int64 {
+	return offset - offset%aes.BlockSize
+}
+
+// addToCounter adds n to the counter, interpreted as a big-endian integer, in the same way CTR mode increments it.
+func addToCounter(counter []byte, n uint64) {
+	for i := len(counter) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(counter[i]) + n&0xff
+		counter[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+}
+
+// Init initializes the stream cipher using a secret key, registered as the current key under DEFAULT_KEY_ID.
+// If this key is derived from a passcode, ensure it was passed through a KDF. It panics if the key is invalid, while
+// NewStreamCipher returns an error.
 func (c *StreamCipher) Init(hexKey string) {
-	key, _ := hex.DecodeString(hexKey)
+	c.keys = nil
+	if err := c.AddKey(DEFAULT_KEY_ID, hexKey); err != nil {
+		panic(err.Error())
+	}
+	c.currentKeyID = DEFAULT_KEY_ID
+}
+
+// AddKey registers a secret key under the given ID, to decrypt the streams which start with it. The key is only used
+// to encrypt streams once UseKey makes it the current key. Adding a key under an ID which is registered replaces it.
+func (c *StreamCipher) AddKey(id byte, hexKey string) error {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return fmt.Errorf("key %d is not hex-encoded: %v", id, err)
+	}
+	return c.addRawKey(id, key)
+}
+
+// addRawKey registers the AES key under the given ID, like AddKey.
+func (c *StreamCipher) addRawKey(id byte, key []byte) error {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		panic(err.Error())
+		return fmt.Errorf("key %d is invalid: %v", id, err)
+	}
+	if c.keys == nil {
+		c.keys = make(map[byte]cipher.Block)
 	}
-	c.block = block
+	c.keys[id] = block
+	return nil
+}
+
+// UseKey makes the key registered under the given ID the current key, which encrypts the new streams.
+func (c *StreamCipher) UseKey(id byte) error {
+	if _, ok := c.keys[id]; !ok {
+		return fmt.Errorf("no key with ID %d", id)
+	}
+	c.currentKeyID = id
+	return nil
 }
@@ -2,7 +2,15 @@ package cryptography
 
 import (
 	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -60,3 +68,592 @@ func TestFileEncryptionSanity(t *testing.T) {
 
 	}
 }
+
+// Decrypting from any offset should yield the plaintext from that offset onwards
+func TestDecryptStreamAt(t *testing.T) {
+	plaintext := []byte("I never wanted it to end. I spent eight days in Paris, France. My best friends, Henry and Steve, went with me.")
+
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+
+	var encryptedBuffer bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &encryptedBuffer); err != nil {
+		t.Fatal(err)
+	}
+	header := encryptedBuffer.Bytes()[:HEADER_SIZE]
+	ciphertext := encryptedBuffer.Bytes()[HEADER_SIZE:]
+
+	for _, offset := range []int64{0, 1, 15, 16, 17, 40, int64(len(plaintext)) - 1, int64(len(plaintext))} {
+		var decryptedBuffer bytes.Buffer
+		if err := c.DecryptStreamAt(header, offset, bytes.NewReader(ciphertext[BlockStart(offset):]), &decryptedBuffer); err != nil {
+			t.Fatalf("Decryption at offset %d failed: %v", offset, err)
+		}
+		if !bytes.Equal(decryptedBuffer.Bytes(), plaintext[offset:]) {
+			t.Errorf("DecryptStreamAt(%d) = %q, want %q", offset, decryptedBuffer.Bytes(), plaintext[offset:])
+		}
+	}
+}
+
+// The counter increment must carry over bytes the same way CTR mode does when it overflows
+func TestDecryptStreamAtCounterCarry(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("carry"), 20)
+
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+
+	iv := bytes.Repeat([]byte{0xff}, aes.BlockSize)
+	iv[aes.BlockSize-1] = 0xfe
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(c.keys[DEFAULT_KEY_ID], iv).XORKeyStream(ciphertext, plaintext)
+
+	const offset = 40
+	var decryptedBuffer bytes.Buffer
+	header := append([]byte(HEADER_MAGIC), FORMAT_VERSION, DEFAULT_KEY_ID)
+	header = append(header, iv...)
+	if err := c.DecryptStreamAt(header, offset, bytes.NewReader(ciphertext[BlockStart(offset):]), &decryptedBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedBuffer.Bytes(), plaintext[offset:]) {
+		t.Errorf("DecryptStreamAt(%d) = %q, want %q", offset, decryptedBuffer.Bytes(), plaintext[offset:])
+	}
+}
+
+// writerAtBuffer is an in-memory io.WriterAt, safe for concurrent writes to distinct offsets.
+type writerAtBuffer struct {
+	data []byte
+}
+
+func (b *writerAtBuffer) WriteAt(p []byte, offset int64) (int, error) {
+	return copy(b.data[offset:], p), nil
+}
+
+func TestDecryptToWriterAt(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+
+	for _, size := range []int{0, 1, 15, 16, 17, 1000, 64*1024 + 123} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+		var encryptedBuffer bytes.Buffer
+		if err := c.EncryptStream(bytes.NewReader(plaintext), &encryptedBuffer); err != nil {
+			t.Fatal(err)
+		}
+		var sequential bytes.Buffer
+		if err := c.DecryptStream(bytes.NewReader(encryptedBuffer.Bytes()), &sequential); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, parallelism := range []int{1, 2, 3, 7, 64} {
+			parallel := &writerAtBuffer{data: make([]byte, size)}
+			if err := c.DecryptToWriterAt(bytes.NewReader(encryptedBuffer.Bytes()), int64(encryptedBuffer.Len()), parallel, parallelism); err != nil {
+				t.Fatalf("Parallel decryption of %d bytes with parallelism %d failed: %v", size, parallelism, err)
+			}
+			if !bytes.Equal(parallel.data, sequential.Bytes()) {
+				t.Errorf("Parallel decryption of %d bytes with parallelism %d differs from the sequential one", size, parallelism)
+			}
+		}
+	}
+}
+
+func TestDecryptToWriterAtInvalidInput(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	var encryptedBuffer bytes.Buffer
+	if err := c.EncryptStream(strings.NewReader("Steve's breakfast diary."), &encryptedBuffer); err != nil {
+		t.Fatal(err)
+	}
+	stream := encryptedBuffer.Bytes()
+	output := &writerAtBuffer{data: make([]byte, len(stream))}
+
+	if err := c.DecryptToWriterAt(bytes.NewReader(stream), int64(len(stream)), output, 0); err == nil {
+		t.Error("Decryption with a parallelism of 0 succeeded")
+	}
+	if err := c.DecryptToWriterAt(bytes.NewReader(stream), HEADER_SIZE-1, output, 2); err == nil {
+		t.Error("Decryption of a stream shorter than its header succeeded")
+	}
+	// The reader holds fewer bytes than the given size
+	if err := c.DecryptToWriterAt(bytes.NewReader(stream[:len(stream)-3]), int64(len(stream)), output, 2); err == nil {
+		t.Error("Decryption of a truncated stream succeeded")
+	}
+}
+
+// The size of the streams must be known before they are encrypted, to be uploaded to MinIO as they are produced
+func TestEncryptedSize(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	for _, size := range []int64{0, 1, aes.BlockSize - 1, aes.BlockSize, aes.BlockSize + 1, 1000, DEFAULT_BUFFER_SIZE + 1} {
+		var encryptedBuffer bytes.Buffer
+		if err := c.EncryptStream(bytes.NewReader(make([]byte, size)), &encryptedBuffer); err != nil {
+			t.Fatal(err)
+		}
+		if got := c.EncryptedSize(size); got != int64(encryptedBuffer.Len()) {
+			t.Errorf("EncryptedSize(%d) = %d, but EncryptStream produced %d bytes", size, got, encryptedBuffer.Len())
+		}
+	}
+	header, err := c.NewHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HeaderSize() != len(header) {
+		t.Errorf("HeaderSize() = %d, but NewHeader produced %d bytes", c.HeaderSize(), len(header))
+	}
+}
+
+// Portions of a stream encrypted separately and in any order must make up a stream which decrypts as a whole
+func TestEncryptStreamAt(t *testing.T) {
+	plaintext := []byte("We visited the Louvre, and saw the Mona Lisa from behind a crowd of fifty people holding up their phones.")
+
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+
+	header, err := c.NewHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID, err := KeyID(header); err != nil || keyID != DEFAULT_KEY_ID {
+		t.Fatalf("KeyID(NewHeader()) = %d, %v, want %d", keyID, err, DEFAULT_KEY_ID)
+	}
+
+	offsets := []int64{0, 7, 16, 33, 80, int64(len(plaintext))}
+	ciphertext := make([]byte, len(plaintext))
+	for i := len(offsets) - 2; i >= 0; i-- {
+		start, end := offsets[i], offsets[i+1]
+		var portion bytes.Buffer
+		if err := c.EncryptStreamAt(header, start, bytes.NewReader(plaintext[start:end]), &portion); err != nil {
+			t.Fatalf("Encryption at offset %d failed: %v", start, err)
+		}
+		copy(ciphertext[start:], portion.Bytes())
+	}
+
+	var decryptedBuffer bytes.Buffer
+	if err := c.DecryptStream(bytes.NewReader(append(header, ciphertext...)), &decryptedBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decryptedBuffer.Bytes(), plaintext) {
+		t.Errorf("Decrypted %q, want %q", decryptedBuffer.Bytes(), plaintext)
+	}
+
+	if err := c.EncryptStreamAt(header, -1, bytes.NewReader(plaintext), io.Discard); err == nil {
+		t.Error("Encryption at a negative offset should fail")
+	}
+	if _, err := (&StreamCipher{}).NewHeader(); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("NewHeader of an uninitialized cipher failed with %v, want %v", err, ErrNotInitialized)
+	}
+}
+
+// Check that the wrappers compose with io.Copy, and produce streams compatible with EncryptStream and DecryptStream
+func TestEncryptingWriterDecryptingReader(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	plaintext := bytes.Repeat([]byte("We took lots of breaks and sat in cafes along the river Seine. "), 1000)
+
+	var encryptedBuffer bytes.Buffer
+	writer, err := c.EncryptingWriter(&encryptedBuffer)
+	if err != nil {
+		t.Fatalf("Creating the encrypting writer failed: %v", err)
+	}
+	if _, err := io.Copy(writer, bytes.NewReader(plaintext)); err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Closing the encrypting writer failed: %v", err)
+	}
+	if encryptedBuffer.Len() != len(plaintext)+HEADER_SIZE {
+		t.Errorf("Encrypted stream is %d bytes long, want %d", encryptedBuffer.Len(), len(plaintext)+HEADER_SIZE)
+	}
+
+	ciphertext := bytes.Clone(encryptedBuffer.Bytes())
+	reader, err := c.DecryptingReader(&encryptedBuffer)
+	if err != nil {
+		t.Fatalf("Creating the decrypting reader failed: %v", err)
+	}
+	var decryptedBuffer bytes.Buffer
+	if _, err := io.Copy(&decryptedBuffer, reader); err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if !bytes.Equal(decryptedBuffer.Bytes(), plaintext) {
+		t.Errorf("DecryptingReader(EncryptingWriter(pt)) differs from the plaintext")
+	}
+
+	// DecryptStream understands the streams of EncryptingWriter
+	decryptedBuffer.Reset()
+	if err := c.DecryptStream(bytes.NewReader(ciphertext), &decryptedBuffer); err != nil || !bytes.Equal(decryptedBuffer.Bytes(), plaintext) {
+		t.Errorf("DecryptStream(EncryptingWriter(pt)) differs from the plaintext, error: %v", err)
+	}
+}
+
+func TestDecryptingReaderMissingIV(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	if _, err := c.DecryptingReader(bytes.NewReader([]byte("short"))); err == nil {
+		t.Errorf("A stream shorter than the IV was accepted")
+	}
+}
+
+const rotatedHexKey = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+
+// Streams encrypted before a key rotation are decrypted with their key, and the new ones with the new key
+func TestKeyRotation(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	before := []byte("Encrypted with the first key.")
+	var encryptedBefore bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(before), &encryptedBefore); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.AddKey(1, rotatedHexKey); err != nil {
+		t.Fatalf("Adding a key failed: %v", err)
+	}
+	if err := c.UseKey(1); err != nil {
+		t.Fatalf("Using the added key failed: %v", err)
+	}
+	after := []byte("Encrypted with the second key.")
+	var encryptedAfter bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(after), &encryptedAfter); err != nil {
+		t.Fatal(err)
+	}
+	idBefore, _ := KeyID(encryptedBefore.Bytes())
+	idAfter, _ := KeyID(encryptedAfter.Bytes())
+	if idBefore != DEFAULT_KEY_ID || idAfter != 1 {
+		t.Errorf("Streams start with the key IDs %d and %d, want %d and 1", idBefore, idAfter, DEFAULT_KEY_ID)
+	}
+
+	for _, test := range []struct {
+		encrypted *bytes.Buffer
+		plaintext []byte
+	}{{&encryptedBefore, before}, {&encryptedAfter, after}} {
+		header := test.encrypted.Bytes()[:HEADER_SIZE]
+		var decryptedAt bytes.Buffer
+		if err := c.DecryptStreamAt(header, 0, bytes.NewReader(test.encrypted.Bytes()[HEADER_SIZE:]), &decryptedAt); err != nil || !bytes.Equal(decryptedAt.Bytes(), test.plaintext) {
+			t.Errorf("DecryptStreamAt() = %q with error %v, want %q", decryptedAt.Bytes(), err, test.plaintext)
+		}
+		var decrypted bytes.Buffer
+		if err := c.DecryptStream(test.encrypted, &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), test.plaintext) {
+			t.Errorf("DecryptStream() = %q with error %v, want %q", decrypted.Bytes(), err, test.plaintext)
+		}
+	}
+}
+
+// A stream encrypted with a key which is not registered cannot be decrypted
+func TestDecryptUnknownKeyID(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	if err := c.AddKey(7, rotatedHexKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UseKey(7); err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader([]byte("secret")), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	other := StreamCipher{}
+	other.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	if err := other.DecryptStream(&encrypted, io.Discard); err == nil {
+		t.Error("A stream encrypted with an unknown key ID was decrypted")
+	}
+}
+
+func TestAddKeyInvalid(t *testing.T) {
+	c := StreamCipher{}
+	for _, hexKey := range []string{"not hex", "0011", ""} {
+		if err := c.AddKey(1, hexKey); err == nil {
+			t.Errorf("AddKey(1, %q) accepted an invalid key", hexKey)
+		}
+	}
+	if err := c.UseKey(1); err == nil {
+		t.Error("UseKey accepted a key ID which was never added")
+	}
+}
+
+// Streams start with the magic and the current format version, and round-trip through DecryptStream and DecryptingReader
+func TestHeaderFormatVersion(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	plaintext := []byte("Versioned stream.")
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+	header := encrypted.Bytes()[:HEADER_SIZE]
+	if string(header[:len(HEADER_MAGIC)]) != HEADER_MAGIC || header[len(HEADER_MAGIC)] != FORMAT_VERSION {
+		t.Fatalf("Stream starts with %q, want the magic %q and the version %d", header[:len(HEADER_MAGIC)+1], HEADER_MAGIC, FORMAT_VERSION)
+	}
+
+	var decrypted bytes.Buffer
+	if err := c.DecryptStream(bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("DecryptStream() = %q with error %v, want %q", decrypted.Bytes(), err, plaintext)
+	}
+	reader, err := c.DecryptingReader(bytes.NewReader(encrypted.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := io.ReadAll(reader); err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptingReader() = %q with error %v, want %q", decrypted, err, plaintext)
+	}
+}
+
+// Streams of an unknown format version are rejected
+func TestDecryptUnsupportedHeader(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader([]byte("secret")), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	future := bytes.Clone(encrypted.Bytes())
+	future[len(HEADER_MAGIC)] = FORMAT_VERSION + 1
+	if err := c.DecryptStream(bytes.NewReader(future), io.Discard); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("DecryptStream() of a stream of version %d returned %v, want ErrUnsupportedVersion", FORMAT_VERSION+1, err)
+	}
+	if err := c.DecryptStreamAt(future[:HEADER_SIZE], 0, bytes.NewReader(future[HEADER_SIZE:]), io.Discard); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("DecryptStreamAt() of a stream of version %d returned %v, want ErrUnsupportedVersion", FORMAT_VERSION+1, err)
+	}
+}
+
+// encryptLegacyStream encrypts the plaintext in the format of the streams preceding the versioned header: the IV
+// followed by the ciphertext, without any magic or key ID.
+func encryptLegacyStream(t *testing.T, hexKey string, iv []byte, plaintext []byte) []byte {
+	t.Helper()
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := bytes.Clone(iv)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plaintext)
+	return append(stream, ciphertext...)
+}
+
+// Streams stored before the header held a magic and a version are still decrypted, with the key of DEFAULT_KEY_ID
+func TestDecryptLegacyStream(t *testing.T) {
+	const legacyHexKey = "6368616e676520746869732070617373776f726420746f206120736563726574"
+	c := StreamCipher{}
+	c.Init(legacyHexKey)
+	// The current key was rotated since the stream was stored
+	if err := c.AddKey(1, rotatedHexKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UseKey(1); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("Stored before the header was versioned, and long enough to span several blocks.")
+	legacy := encryptLegacyStream(t, legacyHexKey, bytes.Repeat([]byte{0x42}, aes.BlockSize), plaintext)
+
+	if headerSize, err := StreamHeaderSize(legacy); err != nil || headerSize != LEGACY_HEADER_SIZE {
+		t.Errorf("StreamHeaderSize() = %d, %v, want %d", headerSize, err, LEGACY_HEADER_SIZE)
+	}
+	if keyID, err := KeyID(legacy[:LEGACY_HEADER_SIZE]); err != nil || keyID != DEFAULT_KEY_ID {
+		t.Errorf("KeyID() = %d, %v, want %d", keyID, err, DEFAULT_KEY_ID)
+	}
+	var decrypted bytes.Buffer
+	if err := c.DecryptStream(bytes.NewReader(legacy), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("DecryptStream() = %q with error %v, want %q", decrypted.Bytes(), err, plaintext)
+	}
+	offset := int64(20)
+	var decryptedAt bytes.Buffer
+	ciphertext := bytes.NewReader(legacy[LEGACY_HEADER_SIZE+BlockStart(offset):])
+	if err := c.DecryptStreamAt(legacy[:LEGACY_HEADER_SIZE], offset, ciphertext, &decryptedAt); err != nil || !bytes.Equal(decryptedAt.Bytes(), plaintext[offset:]) {
+		t.Errorf("DecryptStreamAt() = %q with error %v, want %q", decryptedAt.Bytes(), err, plaintext[offset:])
+	}
+	parallel := &writerAtBuffer{data: make([]byte, len(plaintext))}
+	if err := c.DecryptToWriterAt(bytes.NewReader(legacy), int64(len(legacy)), parallel, 3); err != nil || !bytes.Equal(parallel.data, plaintext) {
+		t.Errorf("DecryptToWriterAt() = %q with error %v, want %q", parallel.data, err, plaintext)
+	}
+}
+
+// onlyReader and onlyWriter hide the WriterTo and ReaderFrom implementations of their wrapped values, so that copies go
+// through the cipher's buffer like they do with the pipes used by the service.
+type onlyReader struct{ io.Reader }
+type onlyWriter struct{ io.Writer }
+
+// BenchmarkEncryptStream measures the encryption throughput for various buffer sizes. Like in the service, the plaintext
+// is written by chunks of 8MB into a pipe, and the ciphertext read from another pipe.
+// Check that a given IV gives reproducible streams, while EncryptStream still draws a random IV for every stream
+func TestEncryptStreamWithIV(t *testing.T) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	plaintext := []byte("Steve's favorite part of the vacation was the hotel breakfast.")
+	iv := bytes.Repeat([]byte{0x42}, aes.BlockSize)
+
+	var first, second bytes.Buffer
+	if err := c.EncryptStreamWithIV(bytes.NewReader(plaintext), &first, iv); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EncryptStreamWithIV(bytes.NewReader(plaintext), &second, iv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("The same plaintext and IV gave different streams")
+	}
+	if !bytes.Equal(first.Bytes()[HEADER_SIZE-aes.BlockSize:HEADER_SIZE], iv) {
+		t.Error("The stream header does not hold the given IV")
+	}
+	var decrypted bytes.Buffer
+	if err := c.DecryptStream(bytes.NewReader(first.Bytes()), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decryption of a stream with a given IV failed: %v", err)
+	}
+
+	first.Reset()
+	second.Reset()
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &second); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("EncryptStream gave the same stream twice, its IV is not random")
+	}
+
+	for _, invalid := range [][]byte{nil, iv[:aes.BlockSize-1], append(iv, 0)} {
+		if err := c.EncryptStreamWithIV(bytes.NewReader(plaintext), &bytes.Buffer{}, invalid); err == nil {
+			t.Errorf("Encryption with an IV of %d bytes succeeded", len(invalid))
+		}
+	}
+}
+
+// Check that a cipher without key fails with ErrNotInitialized instead of panicking
+func TestUninitializedCipher(t *testing.T) {
+	initialized := StreamCipher{}
+	initialized.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	var encrypted bytes.Buffer
+	if err := initialized.EncryptStream(strings.NewReader("Encrypted before"), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	var c StreamCipher
+	for name, stream := range map[string]func() error{
+		"EncryptStream": func() error { return c.EncryptStream(strings.NewReader("plaintext"), io.Discard) },
+		"EncryptStreamCtx": func() error {
+			return c.EncryptStreamCtx(context.Background(), strings.NewReader("plaintext"), io.Discard)
+		},
+		"EncryptStreamWithIV": func() error {
+			return c.EncryptStreamWithIV(strings.NewReader("plaintext"), io.Discard, make([]byte, aes.BlockSize))
+		},
+		"DecryptStream": func() error { return c.DecryptStream(bytes.NewReader(encrypted.Bytes()), io.Discard) },
+		"DecryptStreamCtx": func() error {
+			return c.DecryptStreamCtx(context.Background(), bytes.NewReader(encrypted.Bytes()), io.Discard)
+		},
+		"DecryptStreamAt": func() error {
+			return c.DecryptStreamAt(encrypted.Bytes()[:HEADER_SIZE], 0, bytes.NewReader(encrypted.Bytes()[HEADER_SIZE:]), io.Discard)
+		},
+	} {
+		if err := stream(); !errors.Is(err, ErrNotInitialized) {
+			t.Errorf("%s on an uninitialized cipher returned %v, want %v", name, err, ErrNotInitialized)
+		}
+	}
+}
+
+// cancellingReader cancels its context once it read the given number of bytes.
+type cancellingReader struct {
+	reader io.Reader
+	cancel context.CancelFunc
+	after  int
+	read   int
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += n
+	if r.read >= r.after {
+		r.cancel()
+	}
+	return n, err
+}
+
+// Check that the streams stop with the error of their context once it is cancelled, instead of reading until their end
+func TestStreamCtxCancelledMidStream(t *testing.T) {
+	c := StreamCipher{BufferSize: 1024}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	plaintext := bytes.Repeat([]byte("cancelled "), 10_000)
+	var encrypted bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var partial bytes.Buffer
+	err := c.EncryptStreamCtx(ctx, &cancellingReader{reader: bytes.NewReader(plaintext), cancel: cancel, after: 2048}, &partial)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled encryption returned %v, want %v", err, context.Canceled)
+	}
+	if partial.Len() >= encrypted.Len() {
+		t.Errorf("Cancelled encryption wrote the whole stream of %d bytes", partial.Len())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	partial.Reset()
+	err = c.DecryptStreamCtx(ctx, &cancellingReader{reader: bytes.NewReader(encrypted.Bytes()), cancel: cancel, after: 2048}, &partial)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled decryption returned %v, want %v", err, context.Canceled)
+	}
+	if partial.Len() >= len(plaintext) {
+		t.Errorf("Cancelled decryption wrote the whole file of %d bytes", partial.Len())
+	}
+
+	// Without cancellation, the streams round-trip
+	var decrypted bytes.Buffer
+	if err := c.DecryptStreamCtx(context.Background(), bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decryption with a live context failed: %v", err)
+	}
+}
+
+func BenchmarkEncryptStream(b *testing.B) {
+	chunk := make([]byte, 8*1024*1024)
+	for _, bufferSize := range []int{32 * 1024, 128 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%dKB", bufferSize/1024), func(b *testing.B) {
+			c := StreamCipher{BufferSize: bufferSize}
+			c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+			b.SetBytes(int64(8 * len(chunk)))
+			for i := 0; i < b.N; i++ {
+				plaintextReader, plaintextWriter := io.Pipe()
+				ciphertextReader, ciphertextWriter := io.Pipe()
+				go func() {
+					for j := 0; j < 8; j++ {
+						plaintextWriter.Write(chunk)
+					}
+					plaintextWriter.Close()
+				}()
+				go func() {
+					ciphertextWriter.CloseWithError(c.EncryptStream(plaintextReader, ciphertextWriter))
+				}()
+				if _, err := io.Copy(onlyWriter{io.Discard}, ciphertextReader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecryptStream measures the decryption throughput for various buffer sizes.
+func BenchmarkDecryptStream(b *testing.B) {
+	c := StreamCipher{}
+	c.Init("6368616e676520746869732070617373776f726420746f206120736563726574")
+	var ciphertext bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(make([]byte, 64*1024*1024)), &ciphertext); err != nil {
+		b.Fatal(err)
+	}
+	for _, bufferSize := range []int{32 * 1024, 128 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("buffer=%dKB", bufferSize/1024), func(b *testing.B) {
+			c.BufferSize = bufferSize
+			b.SetBytes(int64(ciphertext.Len() - HEADER_SIZE))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.DecryptStream(onlyReader{bytes.NewReader(ciphertext.Bytes())}, onlyWriter{io.Discard}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package cryptography
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// MODE_CTR is the mode StreamCipher encrypts in, the only one it implements.
+const MODE_CTR = "ctr"
+
+// The parameters of the scrypt derivation of the keys given as passphrases, recommended for interactive logins, which
+// make deriving a key take about 100ms. KEY_SIZE selects AES-256.
+const (
+	SCRYPT_N = 1 << 15
+	SCRYPT_R = 8
+	SCRYPT_P = 1
+	KEY_SIZE = 32
+)
+
+// MIN_SALT_SIZE is the size in bytes below which a salt could be shared by different deployments by chance.
+const MIN_SALT_SIZE = 16
+
+// Option configures the StreamCipher built by NewStreamCipher, and fails if its setting is invalid.
+type Option func(c *StreamCipher) error
+
+// NewStreamCipher returns a StreamCipher configured with the given options, ready to encrypt and decrypt streams. A key
+// must be given, with WithHexKey or WithPassphrase, or ErrNotInitialized is returned. When several keys are given, the
+// last one is used.
+func NewStreamCipher(opts ...Option) (*StreamCipher, error) {
+	c := &StreamCipher{}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.keys) == 0 {
+		return nil, ErrNotInitialized
+	}
+	return c, nil
+}
+
+// WithHexKey registers the hex-encoded AES key as the current key, under DEFAULT_KEY_ID like Init does.
+func WithHexKey(hexKey string) Option {
+	return func(c *StreamCipher) error {
+		if err := c.AddKey(DEFAULT_KEY_ID, hexKey); err != nil {
+			return err
+		}
+		c.currentKeyID = DEFAULT_KEY_ID
+		return nil
+	}
+}
+
+// WithPassphrase registers the AES-256 key derived from the passphrase and the salt with scrypt as the current key,
+// under DEFAULT_KEY_ID. The same passphrase and salt must be given to decrypt the streams, and the salt must be at least
+// MIN_SALT_SIZE bytes long.
+func WithPassphrase(passphrase string, salt []byte) Option {
+	return func(c *StreamCipher) error {
+		if passphrase == "" {
+			return errors.New("passphrase is empty")
+		}
+		if len(salt) < MIN_SALT_SIZE {
+			return fmt.Errorf("salt should be at least %d bytes long, got %d", MIN_SALT_SIZE, len(salt))
+		}
+		key, err := scrypt.Key([]byte(passphrase), salt, SCRYPT_N, SCRYPT_R, SCRYPT_P, KEY_SIZE)
+		if err != nil {
+			return fmt.Errorf("unable to derive a key from the passphrase: %v", err)
+		}
+		if err := c.addRawKey(DEFAULT_KEY_ID, key); err != nil {
+			return err
+		}
+		c.currentKeyID = DEFAULT_KEY_ID
+		return nil
+	}
+}
+
+// WithMode checks that the cipher is asked to encrypt in the mode it implements, MODE_CTR, so that callers selecting
+// the mode from their configuration fail instead of silently encrypting in another one.
+func WithMode(mode string) Option {
+	return func(c *StreamCipher) error {
+		if mode != MODE_CTR {
+			return fmt.Errorf("unsupported mode %q, StreamCipher only implements %s", mode, MODE_CTR)
+		}
+		return nil
+	}
+}
+
+// WithBufferSize sets the size in bytes of the buffer through which streams are encrypted and decrypted, which the
+// format of the streams does not depend on.
+func WithBufferSize(size int) Option {
+	return func(c *StreamCipher) error {
+		if size <= 0 {
+			return fmt.Errorf("buffer size should be positive, got %d", size)
+		}
+		c.BufferSize = size
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package cryptography
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const testHexKey = "6368616e676520746869732070617373776f726420746f206120736563726574"
+
+var testSalt = []byte("a salt of the test")
+
+func TestNewStreamCipher(t *testing.T) {
+	c, err := NewStreamCipher(WithHexKey(testHexKey), WithMode(MODE_CTR), WithBufferSize(4096))
+	if err != nil {
+		t.Fatalf("NewStreamCipher failed: %v", err)
+	}
+	if c.BufferSize != 4096 {
+		t.Errorf("BufferSize = %d, want 4096", c.BufferSize)
+	}
+
+	// The constructed cipher is interchangeable with one initialized with Init
+	initialized := &StreamCipher{}
+	initialized.Init(testHexKey)
+	plaintext := []byte("Encrypted by the constructed cipher, decrypted by the initialized one")
+	var ciphertext, decrypted bytes.Buffer
+	if err := c.EncryptStream(bytes.NewReader(plaintext), &ciphertext); err != nil {
+		t.Fatal(err)
+	}
+	if err := initialized.DecryptStream(&ciphertext, &decrypted); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decrypted %q, want %q", decrypted.Bytes(), plaintext)
+	}
+}
+
+func TestNewStreamCipherWithPassphrase(t *testing.T) {
+	encrypting, err := NewStreamCipher(WithPassphrase("correct horse battery staple", testSalt))
+	if err != nil {
+		t.Fatalf("NewStreamCipher failed: %v", err)
+	}
+	plaintext := []byte("Encrypted with a key derived from a passphrase")
+	var ciphertext bytes.Buffer
+	if err := encrypting.EncryptStream(bytes.NewReader(plaintext), &ciphertext); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same passphrase and salt derive the same key, and another passphrase a different one
+	decrypting, err := NewStreamCipher(WithPassphrase("correct horse battery staple", testSalt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	if err := decrypting.DecryptStream(bytes.NewReader(ciphertext.Bytes()), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Errorf("Decrypting with the same passphrase gave %q, %v", decrypted.Bytes(), err)
+	}
+	other, err := NewStreamCipher(WithPassphrase("another passphrase", testSalt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted.Reset()
+	if err := other.DecryptStream(bytes.NewReader(ciphertext.Bytes()), &decrypted); err == nil && bytes.Equal(decrypted.Bytes(), plaintext) {
+		t.Error("Another passphrase decrypted the stream")
+	}
+}
+
+func TestNewStreamCipherInvalidOptions(t *testing.T) {
+	tests := map[string][]Option{
+		"no key":           nil,
+		"key not hex":      {WithHexKey("not a hex key")},
+		"key too short":    {WithHexKey("6368616e6765")},
+		"empty passphrase": {WithPassphrase("", testSalt)},
+		"salt too short":   {WithPassphrase("correct horse battery staple", []byte("short"))},
+		"unsupported mode": {WithHexKey(testHexKey), WithMode("gcm")},
+		"zero buffer":      {WithHexKey(testHexKey), WithBufferSize(0)},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if c, err := NewStreamCipher(opts...); err == nil {
+				t.Errorf("NewStreamCipher() = %+v, want an error", c)
+			}
+		})
+	}
+	if _, err := NewStreamCipher(); !errors.Is(err, ErrNotInitialized) {
+		t.Errorf("NewStreamCipher() without a key failed with %v, want ErrNotInitialized", err)
+	}
+}
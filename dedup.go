@@ -0,0 +1,84 @@
+package main
+
+import (
+	"sync"
+)
+
+// CHECKSUM_METADATA is the metadata holding the hex-encoded SHA-256 checksum of an object's plaintext.
+const CHECKSUM_METADATA = "Sha256"
+
+// checksumIndex maps the checksums of the stored files to the UID of the object holding them, so that a file uploaded
+// again can be given the UID of the object already holding it instead of being stored twice.
+// Only files which never expire are indexed, so that a UID given to several uploaders never disappears.
+type checksumIndex struct {
+	uids map[string]string
+	mu   sync.Mutex
+}
+
+// reset initializes the index with the given checksum to UID mapping.
+func (i *checksumIndex) reset(uids map[string]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.uids = make(map[string]string, len(uids))
+	for checksum, uid := range uids {
+		i.uids[checksum] = uid
+	}
+}
+
+// lookupOrAdd returns the UID of the object holding a file with the given checksum, and true, if there is one.
+// Otherwise, the object with the given UID is recorded as holding that file, and false is returned.
+func (i *checksumIndex) lookupOrAdd(checksum string, uid string) (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if existing, ok := i.uids[checksum]; ok {
+		return existing, true
+	}
+	if i.uids == nil {
+		i.uids = make(map[string]string)
+	}
+	i.uids[checksum] = uid
+	return uid, false
+}
+
+// remove forgets that the object with the given UID holds the file with the given checksum.
+func (i *checksumIndex) remove(checksum string, uid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.uids[checksum] == uid {
+		delete(i.uids, checksum)
+	}
+}
+
+// rename records that the file with the given checksum, held by the object with the UID oldUid, is now held by the
+// object with the UID newUid.
+func (i *checksumIndex) rename(checksum string, oldUid string, newUid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.uids[checksum] == oldUid {
+		i.uids[checksum] = newUid
+	}
+}
+
+// holds tells whether the object with the given UID is recorded as holding the file with the given checksum.
+func (i *checksumIndex) holds(checksum string, uid string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.uids[checksum] == uid
+}
+
+// isSharedObject tells whether the object may hold a file whose UID was given to several uploaders by the
+// deduplication. Such an object cannot be replaced, moved or renamed on behalf of one of them, which would change the
+// file the others were given.
+func isSharedObject(cfg config, objectName string, userMetadata map[string]string) bool {
+	checksum, ok := objectChecksum(userMetadata)
+	return cfg.deduplicate && ok && fileChecksums.holds(checksum, objectName)
+}
+
+// SHARED_OBJECT_MESSAGE is the error the changes to an object which may be shared by several uploaders fail with.
+const SHARED_OBJECT_MESSAGE = "The file stored under the UID may have been given to other uploaders by the deduplication, and cannot be changed"
+
+// objectChecksum returns the plaintext checksum stored in an object's metadata, if any.
+func objectChecksum(userMetadata map[string]string) (string, bool) {
+	value, _ := metadataValue(userMetadata, CHECKSUM_METADATA)
+	return value, value != ""
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// uploadWithConfig runs an upload of a single file through the handler with the given configuration, and returns the
+// UID the file was stored under.
+func uploadWithConfig(t *testing.T, store uploadStore, cfg config, r *http.Request) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	return uidFromResponse(w.Body.String())
+}
+
+func TestUploadDeduplicatesIdenticalFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	content := []byte("The same report, sent by two colleagues.")
+
+	first := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.txt", "text/plain", content))
+	second := uploadWithConfig(t, store, cfg, newUploadRequest(t, "copy.txt", "text/plain", content))
+	if second != first {
+		t.Errorf("Second upload got UID %s, want the UID %s of the first one", second, first)
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("%d objects were stored, want 1", len(store.objects))
+	}
+
+	other := uploadWithConfig(t, store, cfg, newUploadRequest(t, "other.txt", "text/plain", []byte("Another report.")))
+	if other == first {
+		t.Error("A different file was given the UID of an existing one")
+	}
+}
+
+func TestUploadDeduplicationDisabled(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	content := []byte("Stored twice on purpose.")
+
+	first := uploadWithConfig(t, store, defaultConfig(), newUploadRequest(t, "a.txt", "text/plain", content))
+	second := uploadWithConfig(t, store, defaultConfig(), newUploadRequest(t, "b.txt", "text/plain", content))
+	if first == second || len(store.objects) != 2 {
+		t.Errorf("Got UIDs %s and %s with %d objects, want two distinct objects", first, second, len(store.objects))
+	}
+}
+
+func TestUploadDoesNotDeduplicateExpiringFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	content := []byte("Only needed for an hour.")
+
+	first := uploadWithConfig(t, store, cfg, newUploadRequest(t, "a.txt", "text/plain", content))
+	r := newUploadRequest(t, "b.txt", "text/plain", content)
+	r.Header.Set("TTL-Seconds", "3600")
+	second := uploadWithConfig(t, store, cfg, r)
+	if first == second || len(store.objects) != 2 {
+		t.Errorf("Got UIDs %s and %s with %d objects, want two distinct objects", first, second, len(store.objects))
+	}
+}
+
+func TestFetchUidsFromMinioRestoresChecksumIndex(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	content := bytes.Repeat([]byte("persisted "), 100)
+
+	first := uploadWithConfig(t, store, cfg, newUploadRequest(t, "a.txt", "text/plain", content))
+	// Simulate a restart of the service
+	fileChecksums.reset(nil)
+	if err := fetchUidsFromMinio(context.Background(), &uidTracker, &fileChecksums, &fileNames, store); err != nil {
+		t.Fatal(err)
+	}
+	firstUid, _ := strconv.ParseUint(first, 10, 64)
+	if !uidTracker.Contains(firstUid) {
+		t.Fatalf("UID %s was not restored", first)
+	}
+
+	second := uploadWithConfig(t, store, cfg, newUploadRequest(t, "b.txt", "text/plain", content))
+	if second != first {
+		t.Errorf("Upload after a restart got UID %s, want %s", second, first)
+	}
+	if len(store.objects) != 1 {
+		t.Errorf("%d objects were stored, want 1", len(store.objects))
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// The dispositions a fetched file can be sent with. An attachment is saved by browsers, while an inline file is
+// displayed by them when they can render its type, such as images or PDFs.
+const DISPOSITION_ATTACHMENT = "attachment"
+const DISPOSITION_INLINE = "inline"
+
+// getDisposition returns the disposition requested with the disposition URL parameter of a fetch, attachment by default.
+func getDisposition(r *http.Request) (string, error) {
+	switch disposition := r.URL.Query().Get("disposition"); disposition {
+	case "", DISPOSITION_ATTACHMENT:
+		return DISPOSITION_ATTACHMENT, nil
+	case DISPOSITION_INLINE:
+		return DISPOSITION_INLINE, nil
+	default:
+		return "", fmt.Errorf("disposition should be %s or %s, got %q", DISPOSITION_ATTACHMENT, DISPOSITION_INLINE, disposition)
+	}
+}
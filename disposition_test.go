@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchDisposition(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "chart.png", "image/png", []byte("\x89PNG\r\n\x1a\nNot quite a chart"))
+
+	for query, want := range map[string]string{
+		"":                        `attachment; filename="chart.png"`,
+		"&disposition=attachment": `attachment; filename="chart.png"`,
+		"&disposition=inline":     `inline; filename="chart.png"`,
+	} {
+		w := httptest.NewRecorder()
+		fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Fetch with %q failed with status %d: %s", query, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("Fetch with %q gave Content-Disposition %q, want %q", query, got, want)
+		}
+		if got := w.Header().Get("Content-Type"); got != "image/png" {
+			t.Errorf("Fetch with %q gave Content-Type %q, want image/png", query, got)
+		}
+	}
+}
+
+func TestFetchRejectsInvalidDisposition(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "chart.png", "image/png", []byte("Not quite a chart"))
+
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid="+objectName+"&disposition=popup", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Fetch with an invalid disposition gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
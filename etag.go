@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/sse"
+)
+
+// checkETag compares the ETag MinIO reported for an uploaded object to the MD5 checksum of the ciphertext which was
+// sent, to detect data corrupted on its way to MinIO. The ETag of an object uploaded by parts is not the MD5 checksum
+// of the object but that of the checksums of its parts, suffixed with their number, e.g. "<md5>-3", and cannot be
+// checked. Neither can an empty ETag, which some stores do not report.
+func checkETag(etag string, md5sum []byte) error {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return nil
+	}
+	if sent := hex.EncodeToString(md5sum); !strings.EqualFold(etag, sent) {
+		return fmt.Errorf("MinIO reported the ETag %s, but the MD5 checksum of the sent data is %s", etag, sent)
+	}
+	return nil
+}
+
+// encryptionGetter is the subset of the MinIO client used to read the default encryption of the bucket at startup.
+type encryptionGetter interface {
+	GetBucketEncryption(ctx context.Context, bucketName string) (*sse.Configuration, error)
+}
+
+// usesServerSideEncryption tells whether the bucket encrypts the objects stored in it by default, in which case MinIO
+// reports ETags which are not the MD5 checksums of their content, and checkETag would reject every upload.
+func usesServerSideEncryption(ctx context.Context, getter encryptionGetter, bucketName string) (bool, error) {
+	encryption, err := getter.GetBucketEncryption(ctx, bucketName)
+	if minio.ToErrorResponse(err).Code == "ServerSideEncryptionConfigurationNotFoundError" {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to read the encryption of bucket %s: %v", bucketName, err)
+	}
+	return len(encryption.Rules) > 0, nil
+}
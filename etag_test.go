@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/sse"
+)
+
+func TestCheckETag(t *testing.T) {
+	sum := md5.Sum([]byte("Steve's breakfast diary."))
+	for _, etag := range []string{"", "e5f8b9c7a1d3b2c4e6f8a0b1c2d3e4f5-3", `"` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"`} {
+		if err := checkETag(etag, sum[:]); err != nil {
+			t.Errorf("checkETag(%q) failed: %v", etag, err)
+		}
+	}
+	if err := checkETag("e5f8b9c7a1d3b2c4e6f8a0b1c2d3e4f5", sum[:]); err == nil {
+		t.Error("checkETag accepted an ETag which is not the MD5 checksum of the data")
+	}
+}
+
+// corruptingStore is a memoryStore reporting an ETag which does not match the uploaded data, as MinIO would if the
+// data was corrupted on its way.
+type corruptingStore struct {
+	*memoryStore
+}
+
+func (s corruptingStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	uploadInfo, err := s.memoryStore.PutObject(ctx, objectName, reader, objectSize, opts)
+	uploadInfo.ETag = "00000000000000000000000000000000"
+	return uploadInfo, err
+}
+
+func TestUploadFailsOnETagMismatch(t *testing.T) {
+	uidTracker.Init(nil)
+	store := corruptingStore{newMemoryStore()}
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, newUploadRequest(t, "diary.txt", "text/plain", []byte("Steve's breakfast diary.")))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(w.Body.String(), "Integrity check failed") {
+		t.Errorf("Body = %q, want an integrity error", w.Body.String())
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects remain after the failed upload, want none", len(store.objects))
+	}
+	if count := uidTracker.Count(); count != 0 {
+		t.Errorf("%d UIDs are in use after the failed upload, want none", count)
+	}
+}
+
+func TestUploadSkipsETagCheckWhenDisabled(t *testing.T) {
+	uidTracker.Init(nil)
+	store := corruptingStore{newMemoryStore()}
+	cfg := defaultConfig()
+	cfg.verifyETag = false
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, "diary.txt", "text/plain", []byte("Steve's breakfast diary.")))
+	if w.Code != http.StatusOK {
+		t.Errorf("Upload to a store reporting other ETags gave status %d with the check disabled: %s", w.Code, w.Body.String())
+	}
+}
+
+// fakeEncryptionGetter returns the given bucket encryption, or fails with the given error.
+type fakeEncryptionGetter struct {
+	encryption *sse.Configuration
+	err        error
+}
+
+func (g fakeEncryptionGetter) GetBucketEncryption(ctx context.Context, bucketName string) (*sse.Configuration, error) {
+	return g.encryption, g.err
+}
+
+func TestUsesServerSideEncryption(t *testing.T) {
+	notFound := minio.ErrorResponse{Code: "ServerSideEncryptionConfigurationNotFoundError", StatusCode: http.StatusNotFound}
+	tests := map[string]struct {
+		getter  fakeEncryptionGetter
+		want    bool
+		wantErr bool
+	}{
+		"encrypted":      {fakeEncryptionGetter{encryption: sse.NewConfigurationSSES3()}, true, false},
+		"not configured": {fakeEncryptionGetter{err: notFound}, false, false},
+		"no rules":       {fakeEncryptionGetter{encryption: &sse.Configuration{}}, false, false},
+		"unreachable":    {fakeEncryptionGetter{err: errors.New("connection refused")}, false, true},
+	}
+	for name, test := range tests {
+		encrypted, err := usesServerSideEncryption(context.Background(), test.getter, "challenge-taurus")
+		if encrypted != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: usesServerSideEncryption() = %t, %v, want %t with error %t", name, encrypted, err, test.want, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"api/uid"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EXPIRY_METADATA is the metadata holding the time after which an object expires and gets deleted, in RFC 3339 format.
+const EXPIRY_METADATA = "Expires-At"
+
+// parseTTL parses the TTL-Seconds header, holding the number of seconds the uploaded files should be kept for.
+// A zero duration is returned if the header is missing, in which case the files never expire.
+func parseTTL(header string) (time.Duration, error) {
+	if header == "" {
+		return 0, nil
+	}
+	ttlSeconds, err := strconv.ParseInt(header, 10, 64)
+	if err != nil || ttlSeconds <= 0 || ttlSeconds > int64(MAX_TTL/time.Second) {
+		return 0, fmt.Errorf("TTL-Seconds should be a number of seconds between 1 and %d, got %q", int64(MAX_TTL/time.Second), header)
+	}
+	return time.Duration(ttlSeconds) * time.Second, nil
+}
+
+// Files can be kept for up to 100 years, which keeps the expiry time far from overflowing.
+const MAX_TTL = 100 * 365 * 24 * time.Hour
+
+// objectExpiry returns the expiry time stored in an object's metadata, if any.
+func objectExpiry(userMetadata map[string]string) (time.Time, bool) {
+	value, ok := metadataValue(userMetadata, EXPIRY_METADATA)
+	if !ok {
+		return time.Time{}, false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, value)
+	return expiresAt, err == nil
+}
+
+// isExpired tells whether an object with the given metadata expired at the given time.
+func isExpired(userMetadata map[string]string, now time.Time) bool {
+	expiresAt, ok := objectExpiry(userMetadata)
+	return ok && !now.Before(expiresAt)
+}
+
+// reapExpiredObjects deletes the objects which expired at the given time, and frees their UIDs, filenames and storage.
+// It returns the number of deleted objects, and stops at the first error.
+func reapExpiredObjects(ctx context.Context, store ObjectStore, tracker *uid.UidTracker, filenames *filenameIndex, now time.Time) (int, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	nbrReaped := 0
+	for obj := range store.ListObjects(listCtx, "") {
+		if obj.Err != nil {
+			return nbrReaped, obj.Err
+		}
+		if !isExpired(obj.UserMetadata, now) {
+			continue
+		}
+		if err := store.RemoveObject(ctx, obj.Key); err != nil {
+			return nbrReaped, err
+		}
+		if namespace, expiredUid, err := parseObjectKey(obj.Key); err == nil && namespace == "" {
+			tracker.Remove(expiredUid)
+		} else if err == nil {
+			namespaceTrackers.tracker(namespace).Remove(expiredUid)
+		}
+		if filename, ok := objectFilename(obj.UserMetadata); ok {
+			filenames.release(filename, obj.Key)
+		}
+		storedBytes.remove(obj.Size)
+		nbrReaped++
+	}
+	return nbrReaped, nil
+}
+
+// runReaper deletes the expired objects, frees the UIDs whose reservation expired and abandons the expired upload
+// sessions every interval, until the context is done.
+func runReaper(ctx context.Context, store uploadStore, tracker *uid.UidTracker, filenames *filenameIndex, reservations *reservationIndex, sessions *uploadSessionIndex, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if nbrExpired := reservations.expire(tracker, now); nbrExpired > 0 {
+				loggerFrom(ctx).Info("Freed expired UID reservations", "count", nbrExpired)
+			}
+			if nbrAbandoned := abandonUploadSessions(ctx, store, sessions, filenames, now); nbrAbandoned > 0 {
+				loggerFrom(ctx).Info("Abandoned expired upload sessions", "count", nbrAbandoned)
+			}
+			nbrReaped, err := reapExpiredObjects(ctx, store, tracker, filenames, now)
+			if err != nil {
+				loggerFrom(ctx).Error("Failed to delete expired objects", "error", err)
+			}
+			if nbrReaped > 0 {
+				loggerFrom(ctx).Info("Deleted expired objects", "count", nbrReaped)
+			}
+		}
+	}
+}
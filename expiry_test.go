@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"60", time.Minute, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"1h", 0, true},
+		{"99999999999999", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseTTL(test.header)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("parseTTL(%q) = %v, %v, want %v with error %t", test.header, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestIsExpired(t *testing.T) {
+	now := time.Date(2024, 11, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     bool
+	}{
+		{"no expiry", map[string]string{"Filename": "notes.txt"}, false},
+		{"future expiry", map[string]string{EXPIRY_METADATA: "2024-11-02T10:00:01Z"}, false},
+		{"past expiry", map[string]string{EXPIRY_METADATA: "2024-11-02T09:59:59Z"}, true},
+		{"expiry reached", map[string]string{EXPIRY_METADATA: "2024-11-02T10:00:00Z"}, true},
+		{"listed metadata", map[string]string{"X-Amz-Meta-Expires-At": "2024-11-01T00:00:00Z"}, true},
+		{"invalid expiry", map[string]string{EXPIRY_METADATA: "tomorrow"}, false},
+	}
+	for _, test := range tests {
+		if got := isExpired(test.metadata, now); got != test.want {
+			t.Errorf("%s: isExpired() = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReaperRemovesExpiredObjects(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	kept := uploadFile(t, store, "kept.txt", "text/plain", []byte("Kept forever"))
+
+	r := newUploadRequest(t, "expiring.txt", "text/plain", []byte("Kept for a minute"))
+	r.Header.Set("TTL-Seconds", "60")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	expiring := uidFromResponse(w.Body.String())
+
+	// Nothing expired yet
+	nbrReaped, err := reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now())
+	if err != nil || nbrReaped != 0 {
+		t.Fatalf("Reaping before the expiry deleted %d objects with error %v, want none", nbrReaped, err)
+	}
+
+	nbrReaped, err = reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now().Add(2*time.Minute))
+	if err != nil || nbrReaped != 1 {
+		t.Fatalf("Reaping after the expiry deleted %d objects with error %v, want 1", nbrReaped, err)
+	}
+	if _, ok := store.objects[expiring]; ok {
+		t.Errorf("Expired object is still stored")
+	}
+	if _, ok := store.objects[kept]; !ok {
+		t.Errorf("Object without expiry was deleted")
+	}
+	expiringUid, _ := strconv.ParseUint(expiring, 10, 64)
+	if uidTracker.Contains(expiringUid) {
+		t.Errorf("UID of the expired object is still tracked")
+	}
+}
+
+func TestFetchExpiredObject(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "expired.txt", "text/plain", []byte("Already gone"))
+	store.objects[objectName].info.UserMetadata[EXPIRY_METADATA] = time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+
+	if w := fetchFile(store, objectName, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Fetching an expired object gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// filenameIndex maps the filenames of the stored files to the UID of the object holding them, so that files uploaded
+// under a name which is already used can be renamed, and downloaded without their names colliding. Filenames are unique
+// within a namespace only, like UIDs, so that the files of a namespace are not renamed after those of another one. The
+// UIDs are given as object names, from which the namespace of the filename is read.
+type filenameIndex struct {
+	uids map[filenameKey]string
+	mu   sync.Mutex
+}
+
+// filenameKey is a filename in the namespace of the files using it.
+type filenameKey struct {
+	namespace string
+	filename  string
+}
+
+// newFilenameKey returns the key of the filename used by the object with the given name.
+func newFilenameKey(filename string, objectName string) filenameKey {
+	namespace, _, _ := parseObjectKey(objectName)
+	return filenameKey{namespace: namespace, filename: filename}
+}
+
+// reset initializes the index with the given filename to UID mapping.
+func (i *filenameIndex) reset(uids map[filenameKey]string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.uids = make(map[filenameKey]string, len(uids))
+	for key, uid := range uids {
+		i.uids[key] = uid
+	}
+}
+
+// claim records that the object with the given UID holds a file with the given name, and returns that name.
+// If the name is already used by another object of the namespace, the UID is appended to it, before its extension, and
+// that disambiguated name is returned instead.
+func (i *filenameIndex) claim(filename string, uid string) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.uids == nil {
+		i.uids = make(map[filenameKey]string)
+	}
+	if owner, ok := i.uids[newFilenameKey(filename, uid)]; ok && owner != uid {
+		extension := filepath.Ext(filename)
+		filename = strings.TrimSuffix(filename, extension) + "-" + objectUid(uid) + extension
+	}
+	i.uids[newFilenameKey(filename, uid)] = uid
+	return filename
+}
+
+// release frees the filename used by the object with the given UID.
+func (i *filenameIndex) release(filename string, uid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	key := newFilenameKey(filename, uid)
+	if i.uids[key] == uid {
+		delete(i.uids, key)
+	}
+}
+
+// rename records that the filename used by the object with the UID oldUid is now used by the object with the UID
+// newUid, in the same namespace.
+func (i *filenameIndex) rename(filename string, oldUid string, newUid string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	key := newFilenameKey(filename, oldUid)
+	if i.uids[key] == oldUid {
+		i.uids[key] = newUid
+	}
+}
+
+// objectFilename returns the filename stored in an object's metadata, if any.
+func objectFilename(userMetadata map[string]string) (string, bool) {
+	return metadataValue(userMetadata, "Filename")
+}
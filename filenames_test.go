@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUploadRenamesDuplicateFilenames(t *testing.T) {
+	uidTracker.Init(nil)
+	fileNames.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.uniqueFilenames = true
+
+	first := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.pdf", "application/pdf", []byte("Q1 figures")))
+	second := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.pdf", "application/pdf", []byte("Q2 figures")))
+
+	firstDisposition := fetchFile(store, first, nil).Header().Get("Content-Disposition")
+	secondDisposition := fetchFile(store, second, nil).Header().Get("Content-Disposition")
+	if want := `attachment; filename="report.pdf"`; firstDisposition != want {
+		t.Errorf("First download disposition = %s, want %s", firstDisposition, want)
+	}
+	if want := `attachment; filename="report-` + second + `.pdf"`; secondDisposition != want {
+		t.Errorf("Second download disposition = %s, want %s", secondDisposition, want)
+	}
+}
+
+func TestUploadKeepsDuplicateFilenamesByDefault(t *testing.T) {
+	uidTracker.Init(nil)
+	fileNames.reset(nil)
+	store := newMemoryStore()
+
+	first := uploadFile(t, store, "report.pdf", "application/pdf", []byte("Q1 figures"))
+	second := uploadFile(t, store, "report.pdf", "application/pdf", []byte("Q2 figures"))
+	for _, objectName := range []string{first, second} {
+		if got := fetchFile(store, objectName, nil).Header().Get("Content-Disposition"); got != `attachment; filename="report.pdf"` {
+			t.Errorf("Download disposition of %s = %s, want the uploaded filename", objectName, got)
+		}
+	}
+}
+
+func TestFailedUploadReleasesFilename(t *testing.T) {
+	uidTracker.Init(nil)
+	fileNames.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.uniqueFilenames = true
+
+	r := newUploadRequest(t, "report.pdf", "application/pdf", []byte("more than declared"))
+	r.Header.Set("File-Size", "4")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, r)
+	if w.Code == http.StatusOK {
+		t.Fatal("Upload of a file larger than declared succeeded")
+	}
+
+	objectName := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.pdf", "application/pdf", []byte("Q1 figures")))
+	if got := fetchFile(store, objectName, nil).Header().Get("Content-Disposition"); got != `attachment; filename="report.pdf"` {
+		t.Errorf("Download disposition = %s, want the name of the failed upload to be free again", got)
+	}
+}
+
+func TestUniqueFilenamesPerNamespace(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	fileNames.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.uniqueFilenames = true
+	uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.pdf", "application/pdf", []byte("Q1 figures")))
+
+	// The name used in the default namespace is free in another one
+	r := newUploadRequest(t, "report.pdf", "application/pdf", []byte("Alice's figures"))
+	r.Header.Set("X-Namespace", "alice")
+	r.Header.Set("Uid", "42")
+	uploadWithConfig(t, store, cfg, r)
+	w := fetchFile(store, "42", http.Header{"X-Namespace": {"alice"}})
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="report.pdf"` {
+		t.Errorf("Download disposition in the namespace = %s, want the uploaded filename", got)
+	}
+}
+
+func TestFilenameIndexClaim(t *testing.T) {
+	var index filenameIndex
+	tests := []struct {
+		filename string
+		uid      string
+		want     string
+	}{
+		{"notes.txt", "1", "notes.txt"},
+		{"notes.txt", "2", "notes-2.txt"},
+		{"notes.txt", "1", "notes.txt"},
+		{"archive.tar.gz", "3", "archive.tar.gz"},
+		{"archive.tar.gz", "4", "archive.tar-4.gz"},
+		{"README", "5", "README"},
+		{"README", "6", "README-6"},
+		// Filenames are unique within a namespace
+		{"notes.txt", "alice/7", "notes.txt"},
+		{"notes.txt", "alice/8", "notes-8.txt"},
+		{"notes.txt", "bob/8", "notes.txt"},
+	}
+	for _, test := range tests {
+		if got := index.claim(test.filename, test.uid); got != test.want {
+			t.Errorf("claim(%q, %q) = %q, want %q", test.filename, test.uid, got, test.want)
+		}
+	}
+}
+
+func TestUploadSanitizesFilenames(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"../../etc/passwd", "passwd"},
+		{"/var/www/index.html", "index.html"},
+		{`..\..\windows\system.ini`, "system.ini"},
+		{"notes.txt", "notes.txt"},
+	}
+	for _, test := range tests {
+		uidTracker.Init(nil)
+		store := newMemoryStore()
+		objectName := uploadFile(t, store, test.filename, "text/plain", []byte("contents"))
+		if got := store.objects[objectName].info.UserMetadata["Filename"]; got != test.want {
+			t.Errorf("File uploaded as %q was stored as %q, want %q", test.filename, got, test.want)
+		}
+	}
+}
+
+func TestUploadRejectsInvalidFilenames(t *testing.T) {
+	// The backslash is escaped in the quoted filename of the part header
+	for _, filename := range []string{"..", `dir\\`, "bell\x07.txt", "line\nfeed.txt", strings.Repeat("a", MAX_FILENAME_LENGTH+1)} {
+		uidTracker.Init(nil)
+		store := newMemoryStore()
+		w := httptest.NewRecorder()
+		uploadHandler(store, newTestCipher(), defaultConfig())(w, newUploadRequest(t, filename, "text/plain", []byte("contents")))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Upload of a file named %q gave status %d, want %d", filename, w.Code, http.StatusBadRequest)
+		}
+		if len(store.objects) != 0 || uidTracker.Count() != 0 {
+			t.Errorf("Upload of a file named %q left %d objects and %d UIDs, want none", filename, len(store.objects), uidTracker.Count())
+		}
+	}
+}
+
+func TestUploadRejectsDuplicateFilenamesInRequest(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	// The names only match once their directories are stripped
+	for _, filename := range []string{"a/report.pdf", "other.pdf", "b/report.pdf"} {
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("Q1"))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", "2,2,2")
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.objects) != 0 || uidTracker.Count() != 0 {
+		t.Errorf("The rejected upload left %d objects and %d UIDs, want none", len(store.objects), uidTracker.Count())
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"maps"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// OBJECT_NAME_METADATA is the metadata holding the name an object stored under a hashed key is known by, i.e. the
+// namespace and UID of its file, so that the listings can tell which files are stored. The name is sealed under the
+// secret, so that the metadata does not reveal the UIDs the keys hide.
+const OBJECT_NAME_METADATA = "Object-Name"
+
+// OBJECT_NAME_KEY_LABEL is the message whose HMAC under the secret is the key sealing the object names. It cannot be
+// an object name, which holds no space, so the sealing key is none of the object keys.
+const OBJECT_NAME_KEY_LABEL = "object name sealing key"
+
+// MIN_OBJECT_KEY_SECRET_SIZE is the size in bytes below which an OBJECT_KEY_SECRET could be brute-forced.
+const MIN_OBJECT_KEY_SECRET_SIZE = 16
+
+// hashedKeyStore stores the objects of another store under the HMAC-SHA256 of their names, keyed with a secret, so that
+// the keys listed in the bucket do not reveal the UIDs in use. The handlers keep naming the objects after their
+// namespace and UID: the names given to the store are hashed, and the objects it describes are given back their names
+// from their metadata, where they are sealed with AES-GCM.
+type hashedKeyStore struct {
+	store  uploadStore
+	secret []byte
+}
+
+// mac returns the HMAC-SHA256 of the message under the secret.
+func (s *hashedKeyStore) mac(message string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// objectKey returns the key the object with the given name is stored under.
+func (s *hashedKeyStore) objectKey(objectName string) string {
+	return hex.EncodeToString(s.mac(objectName))
+}
+
+// nameSealer returns the AEAD sealing the object names, keyed with the HMAC of OBJECT_NAME_KEY_LABEL.
+func (s *hashedKeyStore) nameSealer() cipher.AEAD {
+	// AES-256 accepts the 32 bytes of the HMAC-SHA256, and GCM any AES block, so neither fails
+	block, _ := aes.NewCipher(s.mac(OBJECT_NAME_KEY_LABEL))
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+// sealName returns the hex-encoded nonce and sealed object name recorded in the metadata. The nonce is the beginning of
+// the HMAC of the name, which only differs between different names and so is never reused with another name.
+func (s *hashedKeyStore) sealName(objectName string) string {
+	aead := s.nameSealer()
+	nonce := s.mac(objectName)[:aead.NonceSize()]
+	return hex.EncodeToString(aead.Seal(nonce, nonce, []byte(objectName), nil))
+}
+
+// openName returns the object name sealed by sealName, or false if the value was not sealed under the secret.
+func (s *hashedKeyStore) openName(sealed string) (string, bool) {
+	aead := s.nameSealer()
+	data, err := hex.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", false
+	}
+	objectName, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", false
+	}
+	return string(objectName), true
+}
+
+// named gives an object described by the underlying store the name sealed in its metadata. An object without one,
+// e.g. stored before the keys were hashed, keeps its key as name.
+func (s *hashedKeyStore) named(objectInfo minio.ObjectInfo) minio.ObjectInfo {
+	if sealed, ok := metadataValue(objectInfo.UserMetadata, OBJECT_NAME_METADATA); ok {
+		if objectName, ok := s.openName(sealed); ok {
+			objectInfo.Key = objectName
+		}
+	}
+	return objectInfo
+}
+
+// withObjectName returns a copy of the metadata, recording the sealed name of the object.
+func (s *hashedKeyStore) withObjectName(userMetadata map[string]string, objectName string) map[string]string {
+	metadata := maps.Clone(userMetadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[OBJECT_NAME_METADATA] = s.sealName(objectName)
+	return metadata
+}
+
+func (s *hashedKeyStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	opts.UserMetadata = s.withObjectName(opts.UserMetadata, objectName)
+	info, err := s.store.PutObject(ctx, s.objectKey(objectName), reader, objectSize, opts)
+	info.Key = objectName
+	return info, err
+}
+
+func (s *hashedKeyStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	return s.store.GetObject(ctx, s.objectKey(objectName), opts)
+}
+
+func (s *hashedKeyStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	objectInfo, err := s.store.StatObject(ctx, s.objectKey(objectName))
+	if err != nil {
+		return objectInfo, err
+	}
+	return s.named(objectInfo), nil
+}
+
+// ListObjects lists the objects in the order of their hashed keys, after the object with the name startAfter.
+func (s *hashedKeyStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	if startAfter != "" {
+		startAfter = s.objectKey(startAfter)
+	}
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for objectInfo := range s.store.ListObjects(ctx, startAfter) {
+			if objectInfo.Err == nil {
+				objectInfo = s.named(objectInfo)
+			}
+			sendObjectInfo(ctx, objects, objectInfo)
+		}
+	}()
+	return objects
+}
+
+// ReplaceMetadata replaces the metadata of the object, keeping the name it is recorded under.
+func (s *hashedKeyStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	return s.store.ReplaceMetadata(ctx, s.objectKey(objectName), contentType, s.withObjectName(userMetadata, objectName))
+}
+
+// CopyObject copies the object to another one, recorded under its own name.
+func (s *hashedKeyStore) CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error {
+	return s.store.CopyObject(ctx, s.objectKey(srcObjectName), s.objectKey(objectName), contentType, s.withObjectName(userMetadata, objectName))
+}
+
+func (s *hashedKeyStore) RemoveObject(ctx context.Context, objectName string) error {
+	return s.store.RemoveObject(ctx, s.objectKey(objectName))
+}
+
+func (s *hashedKeyStore) AbortUpload(ctx context.Context, objectName string) error {
+	return s.store.AbortUpload(ctx, s.objectKey(objectName))
+}
+
+func (s *hashedKeyStore) NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error) {
+	opts.UserMetadata = s.withObjectName(opts.UserMetadata, objectName)
+	return s.store.NewMultipartUpload(ctx, s.objectKey(objectName), opts)
+}
+
+func (s *hashedKeyStore) PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	return s.store.PutObjectPart(ctx, s.objectKey(objectName), uploadID, partNumber, reader, size)
+}
+
+func (s *hashedKeyStore) CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error {
+	return s.store.CompleteMultipartUpload(ctx, s.objectKey(objectName), uploadID, parts, opts)
+}
+
+func (s *hashedKeyStore) AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error {
+	return s.store.AbortMultipartUpload(ctx, s.objectKey(objectName), uploadID)
+}
+
+// hashedKeyPresigner presigns the objects of a hashedKeyStore, under their hashed keys.
+type hashedKeyPresigner struct {
+	presigner presigner
+	keys      *hashedKeyStore
+}
+
+func (p *hashedKeyPresigner) PresignedGetObject(ctx context.Context, objectName string, expiry time.Duration) (*url.URL, error) {
+	return p.presigner.PresignedGetObject(ctx, p.keys.objectKey(objectName), expiry)
+}
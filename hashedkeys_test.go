@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHashedKeyStore returns a store hashing the keys of the objects it stores in the returned memory store.
+func newHashedKeyStore() (*hashedKeyStore, *memoryStore) {
+	memory := newMemoryStore()
+	return &hashedKeyStore{store: memory, secret: []byte("a secret of the test, long enough")}, memory
+}
+
+func TestHashedKeyStoreHidesUids(t *testing.T) {
+	uidTracker.Init(nil)
+	store, memory := newHashedKeyStore()
+	content := []byte("Stored under a key which does not tell its UID")
+	objectName := uploadFile(t, store, "hidden.txt", "text/plain", content)
+
+	if _, err := strconv.ParseUint(objectName, 10, 64); err != nil {
+		t.Errorf("The file was given the UID %q, want a number", objectName)
+	}
+	if len(memory.objects) != 1 {
+		t.Fatalf("%d objects were stored, want 1", len(memory.objects))
+	}
+	for key := range memory.objects {
+		if key == objectName {
+			t.Errorf("The object is stored under its UID %s", key)
+		}
+		if decoded, err := hex.DecodeString(key); err != nil || len(decoded) != 32 {
+			t.Errorf("The object is stored under %q, want a hex-encoded HMAC-SHA256", key)
+		}
+		// Neither does the metadata, which MinIO lists along with the keys
+		for name, value := range memory.objects[key].info.UserMetadata {
+			if strings.Contains(value, objectName) {
+				t.Errorf("The metadata %s = %q reveals the UID %s", name, value, objectName)
+			}
+		}
+	}
+
+	if fetched := fetchFile(store, objectName, nil); fetched.Code != http.StatusOK || !bytes.Equal(fetched.Body.Bytes(), content) {
+		t.Errorf("Fetching the file by its UID gave status %d and %q", fetched.Code, fetched.Body.String())
+	}
+	if _, err := memory.StatObject(context.Background(), objectName); err == nil {
+		t.Error("The bucket holds an object named after the UID")
+	}
+}
+
+func TestHashedKeyStoreListsObjectNames(t *testing.T) {
+	uidTracker.Init(nil)
+	store, _ := newHashedKeyStore()
+	objectName := uploadFile(t, store, "listed.txt", "text/plain", []byte("Listed under its UID"))
+
+	// The UIDs in use are recovered from the listing at startup
+	uidTracker.Init(nil)
+	if err := fetchUidsFromMinio(context.Background(), &uidTracker, &fileChecksums, &fileNames, store); err != nil {
+		t.Fatal(err)
+	}
+	fileUid, _ := strconv.ParseUint(objectName, 10, 64)
+	if !uidTracker.Contains(fileUid) {
+		t.Errorf("UID %s was not recovered from the listing", objectName)
+	}
+	objectInfo, err := store.StatObject(context.Background(), objectName)
+	if err != nil || objectInfo.Key != objectName {
+		t.Errorf("StatObject described the object as %q, %v, want its name %s", objectInfo.Key, err, objectName)
+	}
+}
+
+func TestHashedKeyStoreDeletesExpiredObjects(t *testing.T) {
+	uidTracker.Init(nil)
+	store, memory := newHashedKeyStore()
+	r := newUploadRequest(t, "expiring.txt", "text/plain", []byte("Deleted under its hashed key"))
+	r.Header.Set("TTL-Seconds", "60")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	nbrReaped, err := reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now().Add(time.Hour))
+	if err != nil || nbrReaped != 1 {
+		t.Fatalf("reapExpiredObjects() = %d, %v, want the expired file deleted", nbrReaped, err)
+	}
+	if len(memory.objects) != 0 {
+		t.Errorf("%d objects are left in the bucket after their expiry", len(memory.objects))
+	}
+	if uidTracker.Count() != 0 {
+		t.Errorf("The UID of the expired file is still in use")
+	}
+}
+
+func TestHashedKeyDependsOnSecret(t *testing.T) {
+	store := &hashedKeyStore{secret: []byte("a secret of the test, long enough")}
+	other := &hashedKeyStore{secret: []byte("another secret, as long as the first")}
+	if store.objectKey("42") != store.objectKey("42") {
+		t.Error("The same name was hashed to different keys")
+	}
+	if store.objectKey("42") == other.objectKey("42") {
+		t.Error("Different secrets hashed a name to the same key")
+	}
+	if store.objectKey("42") == store.objectKey("space/42") {
+		t.Error("The same UID was hashed to the same key in different namespaces")
+	}
+}
+
+func TestSealedObjectNameNeedsSecret(t *testing.T) {
+	store := &hashedKeyStore{secret: []byte("a secret of the test, long enough")}
+	other := &hashedKeyStore{secret: []byte("another secret, as long as the first")}
+	sealed := store.sealName("space/42")
+	if objectName, ok := store.openName(sealed); !ok || objectName != "space/42" {
+		t.Errorf("openName(sealName(%q)) = %q, %t", "space/42", objectName, ok)
+	}
+	if objectName, ok := other.openName(sealed); ok {
+		t.Errorf("A name sealed under another secret was opened as %q", objectName)
+	}
+	if _, ok := store.openName("space/42"); ok {
+		t.Error("A name which was not sealed was opened")
+	}
+}
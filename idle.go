@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// errUploadIdle is the error of the reads of an upload body which received nothing for longer than the idle timeout.
+var errUploadIdle = errors.New("no data was received from the client within the idle timeout")
+
+// idleTimeoutReader fails the reads of a request body which stall for longer than timeout, so that a client which
+// stopped sending its upload does not hold the upload goroutines and its MinIO upload forever. The read deadline of
+// the connection is pushed back before every read, so that only the pauses between two reads are limited.
+type idleTimeoutReader struct {
+	body            io.ReadCloser
+	timeout         time.Duration
+	setReadDeadline func(time.Time) error
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	if err := r.setReadDeadline(time.Now().Add(r.timeout)); err != nil {
+		return 0, err
+	}
+	n, err := r.body.Read(p)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = fmt.Errorf("%w (%s)", errUploadIdle, r.timeout)
+	case err == io.EOF:
+		// Once the body was read, the server reads the connection in the background to notice the client leaving,
+		// which must not time out while the upload completes
+		r.setReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	return r.body.Close()
+}
+
+// limitIdleReads makes the reads of the request body fail with errUploadIdle once the client sent nothing for timeout,
+// 0 disabling the limit. The limit relies on the read deadline of the connection, and is not applied to the requests
+// whose writer cannot set it, such as the recorders of the tests.
+func limitIdleReads(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	controller := http.NewResponseController(w)
+	if err := controller.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	r.Body = &idleTimeoutReader{body: r.Body, timeout: timeout, setReadDeadline: controller.SetReadDeadline}
+}
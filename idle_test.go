@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadStalledBodyTimesOut(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.uploadIdleTimeout = 100 * time.Millisecond
+	// The read deadline the timeout relies on is only available on the connections of a real server
+	server := httptest.NewServer(uploadHandler(store, newTestCipher(), cfg))
+	defer server.Close()
+
+	// The client sends the beginning of its file, then stalls without disconnecting
+	bodyReader, bodyWriter := io.Pipe()
+	defer bodyWriter.Close()
+	multipartWriter := multipart.NewWriter(bodyWriter)
+	r, err := http.NewRequest(http.MethodPost, server.URL+"/upload", bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	r.Header.Set("File-Size", "1000000")
+	go func() {
+		part, err := multipartWriter.CreateFormFile("file", "stalled.bin")
+		if err == nil {
+			part.Write(bytes.Repeat([]byte{0x42}, 1000))
+		}
+	}()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+			return
+		}
+		responses <- response
+	}()
+
+	select {
+	case response, ok := <-responses:
+		if !ok {
+			return
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		if response.StatusCode != http.StatusRequestTimeout {
+			t.Errorf("Stalled upload gave status %d, want %d: %s", response.StatusCode, http.StatusRequestTimeout, body)
+		}
+		if !strings.Contains(string(body), "stalled") {
+			t.Errorf("Body = %q, want it to tell that the upload stalled", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The stalled upload was not aborted")
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("%d objects remain after the stalled upload, want none", len(store.objects))
+	}
+}
+
+func TestUploadIdleTimeoutAllowsSlowSteadyBody(t *testing.T) {
+	uidTracker.Init(nil)
+	cfg := defaultConfig()
+	cfg.uploadIdleTimeout = 200 * time.Millisecond
+	server := httptest.NewServer(uploadHandler(newMemoryStore(), newTestCipher(), cfg))
+	defer server.Close()
+
+	// The whole upload takes longer than the idle timeout, but no pause does
+	bodyReader, bodyWriter := io.Pipe()
+	multipartWriter := multipart.NewWriter(bodyWriter)
+	content := bytes.Repeat([]byte("Steve's breakfast diary. "), 40)
+	go func() {
+		part, err := multipartWriter.CreateFormFile("file", "diary.txt")
+		if err != nil {
+			bodyWriter.CloseWithError(err)
+			return
+		}
+		for chunk := range slices.Chunk(content, 100) {
+			time.Sleep(50 * time.Millisecond)
+			part.Write(chunk)
+		}
+		bodyWriter.CloseWithError(multipartWriter.Close())
+	}()
+	r, err := http.NewRequest(http.MethodPost, server.URL+"/upload", bodyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+	r.Header.Set("File-Size", "1000")
+
+	response, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if body, _ := io.ReadAll(response.Body); response.StatusCode != http.StatusOK {
+		t.Errorf("Slow upload gave status %d, want %d: %s", response.StatusCode, http.StatusOK, body)
+	}
+}
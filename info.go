@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// The metadata recording when a file was uploaded, in RFC 3339 format, and the size in bytes of the file before it was
+// compressed and encrypted. Their words are separated by hyphens, so that MinIO, which canonicalizes the case of the
+// metadata names, keeps them readable.
+// The files stored before they existed do not have them. Those uploaded by parts are given them once they are complete.
+const UPLOADED_AT_METADATA = "Uploaded-At"
+const PLAINTEXT_SIZE_METADATA = "Plaintext-Size"
+
+// objectUploadedAt returns when the file held by an object was uploaded, from its metadata if it was recorded, or else
+// the last modification of the object.
+func objectUploadedAt(objectInfo minio.ObjectInfo) time.Time {
+	value, _ := metadataValue(objectInfo.UserMetadata, UPLOADED_AT_METADATA)
+	if uploadedAt, err := time.Parse(time.RFC3339, value); err == nil {
+		return uploadedAt.UTC()
+	}
+	return objectInfo.LastModified.UTC()
+}
+
+// objectPlaintextSize returns the size of the file held by an object, from its metadata if it was recorded, or else
+// from the size of the object and of the header of its stream. The size of a compressed file is only known if it was
+// recorded.
+func objectPlaintextSize(ctx context.Context, store ObjectStore, objectInfo minio.ObjectInfo) (int64, bool) {
+	value, _ := metadataValue(objectInfo.UserMetadata, PLAINTEXT_SIZE_METADATA)
+	if size, err := strconv.ParseInt(value, 10, 64); err == nil && size >= 0 {
+		return size, true
+	}
+	if isCompressedObject(objectInfo.UserMetadata) {
+		return 0, false
+	}
+	size, err := getPlaintextSize(ctx, store, objectInfo)
+	if err != nil {
+		loggerFrom(ctx).Warn("Unable to read the header of the object", "uid", objectInfo.Key, "error", err)
+		return 0, false
+	}
+	return size, true
+}
+
+// fileInfo describes a stored file without its content.
+type fileInfo struct {
+	Uid         string `json:"uid"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	// Size is the size of the plaintext file in bytes. It is unknown for compressed files stored without their size.
+	Size       *int64    `json:"size,omitempty"`
+	Compressed bool      `json:"compressed"`
+	UploadedAt time.Time `json:"uploadedAt"`
+	Sha256     string    `json:"sha256,omitempty"`
+	// ExpiresAt is the time after which the file is deleted, if it expires
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Metadata holds the custom metadata attached to the file on upload
+	Metadata map[string]string `json:"metadata"`
+	// StorageClass is the MinIO storage class chosen for the file on upload, if any
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// infoHandler describes the file stored under the given UID as JSON, so that clients can check it before downloading it.
+func infoHandler(store ObjectStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+
+		objectInfo, err := store.StatObject(r.Context(), objectName)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get object metadata", http.StatusInternalServerError)
+			}
+			return
+		}
+		if isExpired(objectInfo.UserMetadata, time.Now()) {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
+
+		info := fileInfo{
+			Uid:          objectUid(objectName),
+			Filename:     objectInfo.UserMetadata["Filename"],
+			ContentType:  contentTypeOrDefault(objectInfo.ContentType),
+			Compressed:   objectInfo.UserMetadata["Compression"] == COMPRESSION_GZIP,
+			UploadedAt:   objectUploadedAt(objectInfo),
+			Sha256:       objectInfo.UserMetadata[CHECKSUM_METADATA],
+			Metadata:     customMetadata(objectInfo.UserMetadata),
+			StorageClass: objectInfo.UserMetadata[STORAGE_CLASS_METADATA],
+		}
+		if expiresAt, ok := objectExpiry(objectInfo.UserMetadata); ok {
+			info.ExpiresAt = &expiresAt
+		}
+		if size, ok := objectPlaintextSize(r.Context(), store, objectInfo); ok {
+			info.Size = &size
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the file info", "uid", objectName, "error", err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api/cryptography"
+)
+
+func TestInfoDescribesFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Steve rated every croissant of the trip.")
+	objectName := uploadFile(t, store, "croissants.txt", "text/plain", content)
+
+	w := httptest.NewRecorder()
+	infoHandler(store)(w, httptest.NewRequest(http.MethodGet, "/info?uid="+objectName, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Info failed with status %d: %s", w.Code, w.Body.String())
+	}
+	var info fileInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Response %q is not JSON: %v", w.Body.String(), err)
+	}
+	if info.Size == nil || *info.Size != int64(len(content)) {
+		t.Errorf("Reported size = %v, want %d", info.Size, len(content))
+	}
+	if *info.Size != store.objects[objectName].info.Size-cryptography.HEADER_SIZE {
+		t.Errorf("Reported size %d is not the object size minus the encryption header", *info.Size)
+	}
+	if info.Filename != "croissants.txt" || info.ContentType != "text/plain" || info.Uid != objectName {
+		t.Errorf("Info = %+v, want croissants.txt of type text/plain under UID %s", info, objectName)
+	}
+	if info.UploadedAt.IsZero() {
+		t.Errorf("Upload time is missing")
+	}
+}
+
+func TestInfoUnknownUid(t *testing.T) {
+	uidTracker.Init(nil)
+	w := httptest.NewRecorder()
+	infoHandler(newMemoryStore())(w, httptest.NewRequest(http.MethodGet, "/info?uid=42", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestInfoObjectMissingFromStore(t *testing.T) {
+	uidTracker.Init([]uint64{42})
+	w := httptest.NewRecorder()
+	infoHandler(newMemoryStore())(w, httptest.NewRequest(http.MethodGet, "/info?uid=42", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestInfoReturnsCustomMetadata(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "plan.txt", "text/plain", []byte("Breakfast at 8, pool at 10."))
+	r.Header.Set("X-Meta-Project", "alpha")
+	r.Header.Set("x-meta-owner", "Steve")
+	r.Header.Set("X-Meta-Filename", "not-the-filename.txt")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	objectName := uidFromResponse(w.Body.String())
+
+	w = httptest.NewRecorder()
+	infoHandler(store)(w, httptest.NewRequest(http.MethodGet, "/info?uid="+objectName, nil))
+	var info fileInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Response %q is not JSON: %v", w.Body.String(), err)
+	}
+	want := map[string]string{"Project": "alpha", "Owner": "Steve", "Filename": "not-the-filename.txt"}
+	if !maps.Equal(info.Metadata, want) {
+		t.Errorf("Metadata = %v, want %v", info.Metadata, want)
+	}
+	if info.Filename != "plan.txt" {
+		t.Errorf("Custom metadata overrode the filename with %q", info.Filename)
+	}
+}
+
+func TestUploadRejectsInvalidCustomMetadata(t *testing.T) {
+	tests := map[string]string{
+		"X-Meta-Pro_ject": "alpha",
+		"X-Meta-Project":  "café",
+		"X-Meta-Notes":    strings.Repeat("a", MAX_CUSTOM_METADATA_SIZE),
+	}
+	for name, value := range tests {
+		uidTracker.Init(nil)
+		r := newUploadRequest(t, "plan.txt", "text/plain", []byte("Breakfast at 8."))
+		r.Header[name] = []string{value}
+		w := httptest.NewRecorder()
+		uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Upload with %s header got status %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestInfoReportsRecordedUploadTimeAndSize(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte(strings.Repeat("Steve rated every croissant of the trip. ", 20))
+	r := newUploadRequest(t, "croissants.txt", "text/plain", content)
+	r.Header.Set("Compress", COMPRESSION_GZIP)
+	before := time.Now().Truncate(time.Second)
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	objectName := uidFromResponse(w.Body.String())
+
+	metadata := store.objects[objectName].info.UserMetadata
+	if got := metadata[PLAINTEXT_SIZE_METADATA]; got != strconv.Itoa(len(content)) {
+		t.Errorf("Stored plaintext size = %q, want %d", got, len(content))
+	}
+	uploadedAt, err := time.Parse(time.RFC3339, metadata[UPLOADED_AT_METADATA])
+	if err != nil || uploadedAt.Before(before) || uploadedAt.After(time.Now()) {
+		t.Errorf("Stored upload time = %q, want an RFC 3339 time of the upload", metadata[UPLOADED_AT_METADATA])
+	}
+	// The recorded upload time is reported rather than the last modification of the object
+	object := store.objects[objectName]
+	object.info.LastModified = time.Time{}
+	store.objects[objectName] = object
+
+	w = httptest.NewRecorder()
+	infoHandler(store)(w, httptest.NewRequest(http.MethodGet, "/info?uid="+objectName, nil))
+	var info fileInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Response %q is not JSON: %v", w.Body.String(), err)
+	}
+	// The size of a compressed file cannot be told from its object, only from the recorded size
+	if !info.Compressed || info.Size == nil || *info.Size != int64(len(content)) {
+		t.Errorf("Info of the compressed file reports compressed %t and size %v, want %d", info.Compressed, info.Size, len(content))
+	}
+	if !info.UploadedAt.Equal(uploadedAt) {
+		t.Errorf("Reported upload time = %v, want %v", info.UploadedAt, uploadedAt)
+	}
+}
@@ -0,0 +1,204 @@
+//go:build integration
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	tcminio "github.com/testcontainers/testcontainers-go/modules/minio"
+)
+
+// MINIO_IMAGE is the image of the MinIO container the integration tests run against, as in the compose file.
+const MINIO_IMAGE = "quay.io/minio/minio"
+
+// newIntegrationServer starts a MinIO container, and serves the real handlers on top of it, wired like in main.
+// Run with: go test -tags integration -run Integration
+func newIntegrationServer(t *testing.T) (*httptest.Server, uploadStore) {
+	t.Helper()
+	ctx := context.Background()
+	container, err := tcminio.Run(ctx, MINIO_IMAGE)
+	if err != nil {
+		t.Fatalf("Unable to start MinIO: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Errorf("Unable to stop MinIO: %v", err)
+		}
+	})
+	endpoint, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minioClient, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(container.Username, container.Password, ""),
+		Secure: false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	if err := ensureBucket(ctx, minioClient, cfg.bucketName, cfg.region); err != nil {
+		t.Fatal(err)
+	}
+	store := &retryStore{store: &instrumentedStore{store: &minioStore{client: minioClient, bucket: cfg.bucketName}}, policy: cfg.retry}
+	uidTracker.Init(nil)
+	if err := fetchUidsFromMinio(ctx, &uidTracker, &fileChecksums, &fileNames, store); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", uploadHandler(store, newTestCipher(), cfg))
+	mux.HandleFunc("/fetch", fetchAndDecryptHandler(store, newTestCipher(), cfg))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+// postUpload sends an upload request with the given headers to the server.
+func postUpload(t *testing.T, server *httptest.Server, body io.Reader, header http.Header) *http.Response {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodPost, server.URL+"/upload", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header = header
+	resp, err := server.Client().Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// multipartBody builds the body of an upload of a single file, which has no filename if filename is empty.
+func multipartBody(t *testing.T, filename string, content []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	var part io.Writer
+	var err error
+	if filename != "" {
+		part, err = writer.CreateFormFile("file", filename)
+	} else {
+		part, err = writer.CreateFormField("file")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, writer.FormDataContentType()
+}
+
+// getFetch fetches the file stored under the given UID from the server.
+func getFetch(t *testing.T, server *httptest.Server, uid string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + "/fetch?uid=" + uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestIntegrationUploadFetchDelete(t *testing.T) {
+	server, store := newIntegrationServer(t)
+	// Larger than a chunk and than the buffered fetches, to go through every stage of the pipelines
+	content := make([]byte, 3*DEFAULT_CHUNK_SIZE+12345)
+	for i := range content {
+		content[i] = byte(i * 31)
+	}
+
+	body, contentType := multipartBody(t, "diary.bin", content)
+	resp := postUpload(t, server, body, http.Header{
+		"Content-Type": {contentType},
+		"File-Size":    {strconv.Itoa(len(content))},
+		"Ttl-Seconds":  {"1"},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload failed with status %d", resp.StatusCode)
+	}
+	uid := resp.Header.Get("X-Upload-UID")
+
+	fetched, fetchedContent := getFetch(t, server, uid)
+	if fetched.StatusCode != http.StatusOK || !bytes.Equal(fetchedContent, content) {
+		t.Fatalf("Fetch gave status %d with %d bytes, want the %d uploaded bytes", fetched.StatusCode, len(fetchedContent), len(content))
+	}
+	if got := fetched.Header.Get("X-Content-SHA256"); got != resp.Header.Get("X-Content-SHA256") {
+		t.Errorf("Fetched checksum %q differs from the uploaded one %q", got, resp.Header.Get("X-Content-SHA256"))
+	}
+
+	// The API has no delete endpoint, files are deleted by the reaper once expired
+	nbrReaped, err := reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now().Add(time.Minute))
+	if err != nil || nbrReaped != 1 {
+		t.Fatalf("Reaper deleted %d objects with error %v, want 1", nbrReaped, err)
+	}
+	if fetched, _ = getFetch(t, server, uid); fetched.StatusCode != http.StatusNotFound {
+		t.Errorf("Fetch of a deleted file gave status %d, want %d", fetched.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestIntegrationMissingHeaders(t *testing.T) {
+	server, _ := newIntegrationServer(t)
+	content := []byte("Sent without File-Size")
+
+	// Without Content-Type, the body cannot be parsed
+	body, _ := multipartBody(t, "untyped.txt", content)
+	if resp := postUpload(t, server, body, http.Header{"File-Size": {strconv.Itoa(len(content))}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Upload without Content-Type gave status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// Without File-Size, the file is uploaded as if its size was unknown
+	body, contentType := multipartBody(t, "unsized.txt", content)
+	resp := postUpload(t, server, body, http.Header{"Content-Type": {contentType}})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload without File-Size failed with status %d", resp.StatusCode)
+	}
+	if _, fetchedContent := getFetch(t, server, resp.Header.Get("X-Upload-UID")); !bytes.Equal(fetchedContent, content) {
+		t.Errorf("Fetched %q, want %q", fetchedContent, content)
+	}
+}
+
+func TestIntegrationMissingFilename(t *testing.T) {
+	server, _ := newIntegrationServer(t)
+	content := []byte("Sent without filename")
+
+	body, contentType := multipartBody(t, "", content)
+	resp := postUpload(t, server, body, http.Header{
+		"Content-Type": {contentType},
+		"File-Size":    {strconv.Itoa(len(content))},
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload without filename failed with status %d", resp.StatusCode)
+	}
+	uid := resp.Header.Get("X-Upload-UID")
+
+	// The file is named after its UID
+	fetched, fetchedContent := getFetch(t, server, uid)
+	if !bytes.Equal(fetchedContent, content) {
+		t.Errorf("Fetched %q, want %q", fetchedContent, content)
+	}
+	if disposition := fetched.Header.Get("Content-Disposition"); !strings.Contains(disposition, `filename="`+uid+`"`) {
+		t.Errorf("Content-Disposition = %q, want the UID %s as filename", disposition, uid)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"api/cryptography"
+)
+
+// loadKeys registers the keys of the cipher: the current key, encrypting the uploaded files under the given key ID,
+// and the previous keys, only decrypting the files uploaded before they were rotated. The previous keys are listed as
+// comma-separated <id>:<hex key> entries. A missing key ID stands for cryptography.DEFAULT_KEY_ID, which is also the
+// ID of the key decrypting the files stored before their header held a key ID.
+func loadKeys(c *cryptography.StreamCipher, currentKey string, currentKeyIDStr string, previousKeys string) error {
+	currentKeyID := cryptography.DEFAULT_KEY_ID
+	if currentKeyIDStr != "" {
+		id, err := strconv.ParseUint(currentKeyIDStr, 10, 8)
+		if err != nil {
+			return fmt.Errorf("SYM_KEY_ID should be a number between 0 and 255, got %q", currentKeyIDStr)
+		}
+		currentKeyID = byte(id)
+	}
+
+	for _, entry := range strings.Split(previousKeys, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		idStr, hexKey, ok := strings.Cut(entry, ":")
+		id, err := strconv.ParseUint(idStr, 10, 8)
+		if !ok || err != nil {
+			return fmt.Errorf("OLD_SYM_KEYS entries should be <id>:<hex key>, with an id between 0 and 255")
+		}
+		if byte(id) == currentKeyID {
+			return fmt.Errorf("OLD_SYM_KEYS holds a key with the ID %d of SYM_KEY", id)
+		}
+		if err := c.AddKey(byte(id), hexKey); err != nil {
+			return fmt.Errorf("OLD_SYM_KEYS: %v", err)
+		}
+	}
+
+	if err := c.AddKey(currentKeyID, currentKey); err != nil {
+		return fmt.Errorf("SYM_KEY: %v", err)
+	}
+	return c.UseKey(currentKeyID)
+}
+
+// newCipher returns the implementation of the Cipher interface of the given CIPHER_MODE, encrypting with the keys loaded
+// into the stream cipher, so that the rest of the service does not depend on the mode.
+func newCipher(mode string, keys *cryptography.StreamCipher) (cryptography.Cipher, error) {
+	switch mode {
+	case CIPHER_MODE_CTR:
+		return keys, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher mode %q", mode)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"api/cryptography"
+)
+
+const previousHexKey = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+
+func TestLoadKeysRotation(t *testing.T) {
+	// A file encrypted before the rotation, with the key which had the default ID
+	before := cryptography.StreamCipher{}
+	if err := loadKeys(&before, previousHexKey, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("Uploaded before the key rotation.")
+	var encrypted bytes.Buffer
+	if err := before.EncryptStream(bytes.NewReader(content), &encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	after := cryptography.StreamCipher{}
+	if err := loadKeys(&after, testHexKey, "1", "0:"+previousHexKey); err != nil {
+		t.Fatalf("Loading the rotated keys failed: %v", err)
+	}
+	var decrypted bytes.Buffer
+	if err := after.DecryptStream(bytes.NewReader(encrypted.Bytes()), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), content) {
+		t.Errorf("Decrypting a file of the previous key = %q with error %v, want %q", decrypted.Bytes(), err, content)
+	}
+
+	var reencrypted bytes.Buffer
+	if err := after.EncryptStream(bytes.NewReader(content), &reencrypted); err != nil {
+		t.Fatal(err)
+	}
+	if keyID, err := cryptography.KeyID(reencrypted.Bytes()); err != nil || keyID != 1 {
+		t.Errorf("New files are encrypted with the key ID %d (error %v), want 1", keyID, err)
+	}
+}
+
+func TestLoadKeysInvalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		currentKey   string
+		currentKeyID string
+		previousKeys string
+	}{
+		{"invalid current key", "0011", "", ""},
+		{"key ID out of range", testHexKey, "256", ""},
+		{"key ID not a number", testHexKey, "one", ""},
+		{"previous key without ID", testHexKey, "1", previousHexKey},
+		{"previous key with the current ID", testHexKey, "1", "1:" + previousHexKey},
+		{"invalid previous key", testHexKey, "1", "0:zz"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := cryptography.StreamCipher{}
+			if err := loadKeys(&c, test.currentKey, test.currentKeyID, test.previousKeys); err == nil {
+				t.Errorf("loadKeys(%q, %q, %q) succeeded", test.currentKey, test.currentKeyID, test.previousKeys)
+			}
+		})
+	}
+}
+
+func TestNewCipherSelectsMode(t *testing.T) {
+	keys := &cryptography.StreamCipher{}
+	keys.Init(testHexKey)
+
+	cipher, err := newCipher(CIPHER_MODE_CTR, keys)
+	if err != nil {
+		t.Fatalf("Selecting the %s mode failed: %v", CIPHER_MODE_CTR, err)
+	}
+	if streamCipher, ok := cipher.(*cryptography.StreamCipher); !ok || streamCipher != keys {
+		t.Errorf("The %s mode selected %T, want the *cryptography.StreamCipher holding the keys", CIPHER_MODE_CTR, cipher)
+	}
+	for _, mode := range []string{CIPHER_MODE_GCM, "", "aes"} {
+		if cipher, err := newCipher(mode, keys); err == nil {
+			t.Errorf("The %q mode selected %T, want an error", mode, cipher)
+		}
+	}
+}
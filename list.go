@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Pages of the file listing hold DEFAULT_LIST_LIMIT files unless the client asks for another number, up to
+// MAX_LIST_LIMIT.
+const DEFAULT_LIST_LIMIT = 100
+const MAX_LIST_LIMIT = 1000
+
+// listedFile describes a file in the listing of the stored files.
+type listedFile struct {
+	Uid      string `json:"uid"`
+	Filename string `json:"filename"`
+	// Size is the size of the plaintext file in bytes. It is unknown for compressed files stored without it.
+	Size       *int64    `json:"size,omitempty"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// filePage is a page of the listing of the stored files. NextCursor is set if more files follow, and is to be sent as
+// the cursor of the request for the next page.
+type filePage struct {
+	Files      []listedFile `json:"files"`
+	NextCursor string       `json:"nextCursor,omitempty"`
+}
+
+// listHandler lists the files of the namespace given by X-Namespace by pages, in the stable order in which MinIO lists
+// the objects. That is the lexicographic order of their names, or of their hashed keys if OBJECT_KEY_SECRET is set, and
+// in neither case the numeric order of the UIDs. The cursor parameter is the last UID of the previous page, and limit
+// the number of files in the page. Each tag parameter, written as key=value, restricts the listing to the files holding
+// that tag.
+func listHandler(store ObjectStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		namespace, err := requestNamespace(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := DEFAULT_LIST_LIMIT
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			var err error
+			limit, err = strconv.Atoi(limitStr)
+			if err != nil || limit <= 0 || limit > MAX_LIST_LIMIT {
+				http.Error(w, fmt.Sprintf("limit should be a number of files between 1 and %d", MAX_LIST_LIMIT), http.StatusBadRequest)
+				return
+			}
+		}
+		cursor := ""
+		if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+			cursorUid, err := strconv.ParseUint(cursorStr, 10, 64)
+			if err != nil {
+				http.Error(w, "cursor should be the last UID of the previous page", http.StatusBadRequest)
+				return
+			}
+			cursor = objectKey(namespace, cursorUid)
+		}
+		var wantedTags map[string]string
+		for _, tag := range r.URL.Query()["tag"] {
+			key, value, err := parseTag(tag)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if wantedTags == nil {
+				wantedTags = make(map[string]string)
+			}
+			if otherValue, ok := wantedTags[key]; ok && otherValue != value {
+				http.Error(w, fmt.Sprintf("tag %q is filtered with several values", key), http.StatusBadRequest)
+				return
+			}
+			wantedTags[key] = value
+		}
+
+		page, err := listFiles(r.Context(), store, namespace, cursor, limit, wantedTags, time.Now())
+		if err != nil {
+			loggerFrom(r.Context()).Error("Unable to list the files", "error", err)
+			http.Error(w, "Unable to list the objects in MinIO", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the file listing", "error", err)
+		}
+	}
+}
+
+// listFiles returns the page of at most limit files of the namespace which follows the object named cursor, among the
+// files holding all the wanted tags. Expired files and objects which are not named after a UID are left out. The tags of
+// the objects are listed by MinIO along with their metadata.
+func listFiles(ctx context.Context, store ObjectStore, namespace string, cursor string, limit int, wantedTags map[string]string, now time.Time) (filePage, error) {
+	// The listing is stopped once the page is full
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	page := filePage{Files: make([]listedFile, 0, limit)}
+	for obj := range store.ListObjects(listCtx, cursor) {
+		if obj.Err != nil {
+			return filePage{}, obj.Err
+		}
+		// The objects of the other namespaces are listed along, as the hashed keys cannot be listed by namespace
+		objectNamespace, _, err := parseObjectKey(obj.Key)
+		if err != nil || objectNamespace != namespace || isExpired(obj.UserMetadata, now) || !hasTags(obj.UserTags, wantedTags) {
+			continue
+		}
+		// A file beyond the page tells that another page follows
+		if len(page.Files) == limit {
+			page.NextCursor = page.Files[limit-1].Uid
+			break
+		}
+		file := listedFile{Uid: objectUid(obj.Key), UploadedAt: objectUploadedAt(obj)}
+		file.Filename, _ = objectFilename(obj.UserMetadata)
+		if size, ok := objectPlaintextSize(ctx, store, obj); ok {
+			file.Size = &size
+		}
+		page.Files = append(page.Files, file)
+	}
+	return page, nil
+}
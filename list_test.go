@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func listPage(t *testing.T, store ObjectStore, query string) (filePage, int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	listHandler(store)(w, httptest.NewRequest(http.MethodGet, "/list?"+query, nil))
+	var page filePage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Response %q is not a page of files: %v", w.Body.String(), err)
+		}
+	}
+	return page, w.Code
+}
+
+func TestListFilesByPages(t *testing.T) {
+	for _, nbrFiles := range []int{7, 6, 0} {
+		t.Run(fmt.Sprintf("%d files", nbrFiles), func(t *testing.T) {
+			uidTracker.Init(nil)
+			store := newMemoryStore()
+			uploaded := make(map[string]bool)
+			for i := 0; i < nbrFiles; i++ {
+				uploaded[uploadFile(t, store, fmt.Sprintf("file%d.txt", i), "text/plain", []byte("listed"))] = true
+			}
+
+			listed := make(map[string]bool)
+			cursor := ""
+			for nbrPages := 1; ; nbrPages++ {
+				if nbrPages > nbrFiles+1 {
+					t.Fatal("The listing does not end")
+				}
+				page, status := listPage(t, store, "limit=3&cursor="+cursor)
+				if status != http.StatusOK {
+					t.Fatalf("Listing failed with status %d", status)
+				}
+				if len(page.Files) > 3 {
+					t.Errorf("Page holds %d files, want at most 3", len(page.Files))
+				}
+				for _, file := range page.Files {
+					if listed[file.Uid] {
+						t.Errorf("File %s was listed twice", file.Uid)
+					}
+					listed[file.Uid] = true
+					if file.Size == nil || *file.Size != int64(len("listed")) {
+						t.Errorf("File %s is listed with the size %v, want %d", file.Uid, file.Size, len("listed"))
+					}
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if len(listed) != len(uploaded) {
+				t.Errorf("Listed %d files, want %d", len(listed), len(uploaded))
+			}
+			for uid := range uploaded {
+				if !listed[uid] {
+					t.Errorf("File %s was not listed", uid)
+				}
+			}
+		})
+	}
+}
+
+func TestListSkipsExpiredFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	kept := uploadFile(t, store, "kept.txt", "text/plain", []byte("kept"))
+	r := newUploadRequest(t, "expiring.txt", "text/plain", []byte("expiring"))
+	r.Header.Set("TTL-Seconds", "60")
+	expiring := uploadWithConfig(t, store, defaultConfig(), r)
+
+	page, err := listFiles(context.Background(), store, "", "", DEFAULT_LIST_LIMIT, nil, time.Now().Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Files) != 1 || page.Files[0].Uid != kept {
+		t.Errorf("Listing after %s expired gave %v, want only %s", expiring, page.Files, kept)
+	}
+}
+
+func TestListFilesByTag(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "alpha.txt", "text/plain", []byte("Tagged alpha"))
+	r.Header.Set("X-Tags", "project=alpha, team=storage")
+	alpha := uploadWithConfig(t, store, defaultConfig(), r)
+	r = newUploadRequest(t, "beta.txt", "text/plain", []byte("Tagged beta"))
+	r.Header.Set("X-Tags", "project=beta,team=storage")
+	beta := uploadWithConfig(t, store, defaultConfig(), r)
+	uploadFile(t, store, "untagged.txt", "text/plain", []byte("Not tagged"))
+
+	page, status := listPage(t, store, "tag=project=alpha")
+	if status != http.StatusOK {
+		t.Fatalf("Listing by tag gave status %d", status)
+	}
+	if len(page.Files) != 1 || page.Files[0].Uid != alpha {
+		t.Errorf("Listing the files tagged project=alpha gave %v, want only %s", page.Files, alpha)
+	}
+
+	// Every tag of the filter must match
+	page, _ = listPage(t, store, "tag=team=storage&tag=project=beta")
+	if len(page.Files) != 1 || page.Files[0].Uid != beta {
+		t.Errorf("Listing the files tagged team=storage and project=beta gave %v, want only %s", page.Files, beta)
+	}
+	if page, _ = listPage(t, store, "tag=team=storage"); len(page.Files) != 2 {
+		t.Errorf("Listing the files tagged team=storage gave %d files, want 2", len(page.Files))
+	}
+}
+
+func TestListInvalidParameters(t *testing.T) {
+	for _, query := range []string{"limit=0", "limit=many", fmt.Sprintf("limit=%d", MAX_LIST_LIMIT+1), "cursor=abc", "tag=project", "tag==alpha", "tag=project=alpha&tag=project=beta"} {
+		if _, status := listPage(t, newMemoryStore(), query); status != http.StatusBadRequest {
+			t.Errorf("Listing with %q gave status %d, want %d", query, status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestListFilesOfNamespace(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	defaultUid := uploadFile(t, store, "default.txt", "text/plain", []byte("In the default namespace"))
+	for _, fileUid := range []string{"7", "42"} {
+		if w := uploadToNamespace(t, store, "alice", fileUid, []byte("Alice's notes")); w.Code != http.StatusOK {
+			t.Fatalf("Upload to the namespace failed with status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	uploadToNamespace(t, store, "bob", "8", []byte("Bob's notes"))
+
+	listNamespace := func(query string) filePage {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/list?"+query, nil)
+		r.Header.Set("X-Namespace", "alice")
+		listHandler(store)(w, r)
+		var page filePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Response %q is not a page of files: %v", w.Body.String(), err)
+		}
+		return page
+	}
+	// The UIDs are listed without their namespace, and the cursor is a UID of the namespace
+	page := listNamespace("limit=1")
+	if len(page.Files) != 1 || page.Files[0].Uid != "42" || page.NextCursor != "42" {
+		t.Fatalf("First page of the namespace = %+v, want the UID 42 followed by another page", page)
+	}
+	page = listNamespace("limit=1&cursor=42")
+	if len(page.Files) != 1 || page.Files[0].Uid != "7" || page.NextCursor != "" {
+		t.Errorf("Second page of the namespace = %+v, want only the UID 7", page)
+	}
+
+	page, _ = listPage(t, store, "")
+	if len(page.Files) != 1 || page.Files[0].Uid != defaultUid {
+		t.Errorf("Listing without namespace gave %+v, want only %s", page.Files, defaultUid)
+	}
+}
+
+func TestListPrefersRecordedPlaintextSize(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Compressed well. "), 100)
+	r := newUploadRequest(t, "compressed.txt", "text/plain", content)
+	r.Header.Set("Compress", "gzip")
+	objectName := uploadWithConfig(t, store, defaultConfig(), r)
+	if !isCompressedObject(store.objects[objectName].info.UserMetadata) {
+		t.Fatal("The file was not compressed")
+	}
+
+	page, _ := listPage(t, store, "")
+	if len(page.Files) != 1 || page.Files[0].Uid != objectName {
+		t.Fatalf("Listing gave %+v, want the uploaded file", page.Files)
+	}
+	if size := page.Files[0].Size; size == nil || *size != int64(len(content)) {
+		t.Errorf("Listed size %v, want the recorded size %d", size, len(content))
+	}
+}
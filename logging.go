@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type requestLoggerKey struct{}
+type requestDetailsKey struct{}
+
+// requestDetails gathers the details of a request which are only known once the handler processed it.
+type requestDetails struct {
+	uid string
+}
+
+// logRequests wraps a handler to log every request it serves, with its method, path, UID, status, transferred bytes
+// and duration. Each request is given an ID, returned in the X-Request-ID header and attached to the logger which
+// handlers get from loggerFrom, so that all the logs of a request can be correlated.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestID := newRequestID()
+		requestLogger := logger.With("request_id", requestID)
+
+		// The UID is known upfront for fetches and uploads under a chosen UID, and set by the handler otherwise
+		details := &requestDetails{uid: r.URL.Query().Get("uid")}
+		if details.uid == "" {
+			details.uid = r.Header.Get("Uid")
+		}
+		ctx := context.WithValue(r.Context(), requestLoggerKey{}, requestLogger)
+		ctx = context.WithValue(ctx, requestDetailsKey{}, details)
+
+		body := &countingBody{ReadCloser: r.Body}
+		r = r.WithContext(ctx)
+		r.Body = body
+		w.Header().Set("X-Request-ID", requestID)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		requestLogger.LogAttrs(ctx, slog.LevelInfo, "Request served",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("uid", details.uid),
+			slog.Int("status", recorder.status),
+			slog.Int64("bytes_in", body.count),
+			slog.Int64("bytes_out", recorder.written),
+			slog.Duration("duration", time.Since(start)),
+		)
+	})
+}
+
+// loggerFrom returns the logger of the request the context belongs to, or the default logger outside of requests.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(requestLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// setRequestUID records the UID a request is about, for it to be logged once the request was served.
+func setRequestUID(ctx context.Context, uid string) {
+	if details, ok := ctx.Value(requestDetailsKey{}).(*requestDetails); ok {
+		details.uid = uid
+	}
+}
+
+// newRequestID returns a random identifier for a request.
+func newRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// countingBody counts the bytes read from a request body.
+type countingBody struct {
+	io.ReadCloser
+	count int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogRequestsRecordsFields(t *testing.T) {
+	uidTracker.Init(nil)
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	content := []byte("Steve wrote down the opening hours of the hotel restaurant.")
+	handler := logRequests(logger, uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig()))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newUploadRequest(t, "hours.txt", "text/plain", content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	requestID := w.Header().Get("X-Request-ID")
+	if requestID == "" {
+		t.Fatalf("X-Request-ID header is missing")
+	}
+
+	// Every log line of the request carries its ID, and the last one describes the served request
+	var entry map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		entry = nil
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("Log line %q is not JSON: %v", line, err)
+		}
+		if entry["request_id"] != requestID {
+			t.Errorf("Log line %q does not carry the request ID %s", line, requestID)
+		}
+	}
+	want := map[string]any{
+		"msg":       "Request served",
+		"method":    http.MethodPost,
+		"path":      "/upload",
+		"uid":       uidFromResponse(w.Body.String()),
+		"status":    float64(http.StatusOK),
+		"bytes_out": float64(w.Body.Len()),
+	}
+	for field, value := range want {
+		if entry[field] != value {
+			t.Errorf("Logged %s = %v, want %v", field, entry[field], value)
+		}
+	}
+	if bytesIn, _ := entry["bytes_in"].(float64); bytesIn < float64(len(content)) {
+		t.Errorf("Logged bytes_in = %v, want at least the %d bytes of the file", entry["bytes_in"], len(content))
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Errorf("Logged request has no duration")
+	}
+}
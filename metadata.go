@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Users attach their own metadata to uploaded files through headers with this prefix.
+const CUSTOM_METADATA_HEADER_PREFIX = "X-Meta-"
+
+// Custom metadata is stored under this prefix, so that users cannot override the metadata the service relies on,
+// such as the filename or the checksum.
+const CUSTOM_METADATA_PREFIX = "Meta-"
+
+// S3 limits the user metadata of an object to 2KB, part of which is kept for the metadata of the service.
+const MAX_CUSTOM_METADATA_SIZE = 1024
+
+// parseCustomMetadata extracts the custom metadata from the X-Meta- headers of a request, keyed by the header names
+// without their prefix. Keys may only hold letters, digits and hyphens, and values printable ASCII characters, since
+// they are stored as HTTP headers.
+func parseCustomMetadata(header http.Header) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for name, values := range header {
+		if key, ok := strings.CutPrefix(http.CanonicalHeaderKey(name), CUSTOM_METADATA_HEADER_PREFIX); ok {
+			metadata[key] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	if err := checkCustomMetadata(metadata); err != nil {
+		return nil, err
+	}
+	return metadata, nil
+}
+
+// checkCustomMetadata returns an error if the custom metadata cannot be stored, because of its keys, values or size.
+func checkCustomMetadata(metadata map[string]string) error {
+	size := 0
+	for key, value := range metadata {
+		if !isValidMetadataKey(key) {
+			return fmt.Errorf("invalid metadata key %q, only letters, digits and hyphens are allowed", key)
+		}
+		if !isValidMetadataValue(value) {
+			return fmt.Errorf("invalid value for metadata %q, only printable ASCII characters are allowed", key)
+		}
+		size += len(key) + len(value)
+	}
+	if size > MAX_CUSTOM_METADATA_SIZE {
+		return fmt.Errorf("custom metadata exceeds %d bytes", MAX_CUSTOM_METADATA_SIZE)
+	}
+	return nil
+}
+
+// customMetadata returns the custom metadata among the user metadata of an object, without their storage prefix.
+func customMetadata(userMetadata map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range userMetadata {
+		if customKey, ok := strings.CutPrefix(key, CUSTOM_METADATA_PREFIX); ok {
+			metadata[customKey] = value
+		}
+	}
+	return metadata
+}
+
+func isValidMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidMetadataValue(value string) bool {
+	for _, c := range value {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+)
+
+// METADATA_PART_NAME is the form name of the optional part preceding the files of an upload, which holds their metadata
+// as JSON instead of headers.
+const METADATA_PART_NAME = "metadata"
+
+// MAX_METADATA_PART_SIZE bounds the size of the metadata part, well above what valid metadata needs, and well within
+// the MULTIPART_OVERHEAD allowed in the body of an upload.
+const MAX_METADATA_PART_SIZE = 16 * 1024
+
+// uploadMetadata holds the metadata of the files of an upload sent in its metadata part. The fields which are set
+// override the corresponding headers of the request and of the file parts.
+type uploadMetadata struct {
+	// Filename names the file, which must then be the only one of the upload
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"contentType"`
+	Tags        map[string]string `json:"tags"`
+	TTLSeconds  int64             `json:"ttlSeconds"`
+	// Compressed tells whether the files are gzipped before their encryption, as the Compress header does. It is a
+	// pointer so that false can override the header.
+	Compressed *bool `json:"compressed"`
+}
+
+// parseMetadataPart reads the JSON metadata held by a metadata part, rejecting unknown fields, parts larger than
+// MAX_METADATA_PART_SIZE and invalid values. The errors reading the part are wrapped, so that the body being too large
+// or stalling can be told apart.
+func parseMetadataPart(part io.Reader) (uploadMetadata, error) {
+	data, err := io.ReadAll(io.LimitReader(part, MAX_METADATA_PART_SIZE+1))
+	if err != nil {
+		return uploadMetadata{}, fmt.Errorf("unable to read the metadata part: %w", err)
+	}
+	if len(data) > MAX_METADATA_PART_SIZE {
+		return uploadMetadata{}, fmt.Errorf("the metadata part should not be larger than %d bytes", MAX_METADATA_PART_SIZE)
+	}
+	var metadata uploadMetadata
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&metadata); err != nil {
+		return uploadMetadata{}, fmt.Errorf("the metadata part should be a JSON object with a filename, contentType, tags, ttlSeconds and/or compressed: %v", err)
+	}
+	if decoder.More() {
+		return uploadMetadata{}, errors.New("the metadata part should hold a single JSON object")
+	}
+	if metadata.Filename != "" {
+		if err := checkFilename(metadata.Filename); err != nil {
+			return uploadMetadata{}, err
+		}
+	}
+	if metadata.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(metadata.ContentType); err != nil {
+			return uploadMetadata{}, fmt.Errorf("invalid content type %q: %v", metadata.ContentType, err)
+		}
+	}
+	if len(metadata.Tags) > 0 {
+		if err := checkTags(metadata.Tags); err != nil {
+			return uploadMetadata{}, err
+		}
+	}
+	if metadata.TTLSeconds < 0 || metadata.TTLSeconds > int64(MAX_TTL/time.Second) {
+		return uploadMetadata{}, fmt.Errorf("ttlSeconds should be a number of seconds between 1 and %d, got %d", int64(MAX_TTL/time.Second), metadata.TTLSeconds)
+	}
+	return metadata, nil
+}
+
+// apply sets the upload options given by the metadata, from the given time on for the expiry. Files which expire or are
+// tagged are not deduplicated, as with the headers.
+func (m uploadMetadata) apply(opts *uploadOptions, now time.Time) {
+	if m.ContentType != "" {
+		opts.contentType = m.ContentType
+	}
+	if m.Compressed != nil {
+		opts.compress = *m.Compressed
+	}
+	if len(m.Tags) > 0 {
+		opts.tags = m.Tags
+		opts.deduplicate = false
+	}
+	if m.TTLSeconds > 0 {
+		opts.expiresAt = now.Add(time.Duration(m.TTLSeconds) * time.Second)
+		opts.deduplicate = false
+	}
+}
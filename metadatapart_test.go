@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMetadataUploadRequest builds a multipart upload request whose file part, with the given name and content, is
+// preceded by a metadata part holding the given JSON.
+func newMetadataUploadRequest(t *testing.T, metadata string, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.WriteField(METADATA_PART_NAME, metadata); err != nil {
+		t.Fatal(err)
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", strconv.Itoa(len(content)))
+	return r
+}
+
+func TestUploadAppliesMetadataPart(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Described by the metadata part")
+	r := newMetadataUploadRequest(t, `{"filename":"report.txt","contentType":"text/plain","tags":{"project":"alpha"},"ttlSeconds":3600}`, "upload.bin", content)
+	// The metadata part overrides the headers
+	r.Header.Set("X-Tags", "project=beta")
+	w := httptest.NewRecorder()
+	before := time.Now()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	objectName := uidFromResponse(w.Body.String())
+
+	info := store.objects[objectName].info
+	if info.UserMetadata["Filename"] != "report.txt" {
+		t.Errorf("Filename = %q, want report.txt", info.UserMetadata["Filename"])
+	}
+	if info.ContentType != "text/plain" {
+		t.Errorf("Content type = %q, want text/plain", info.ContentType)
+	}
+	if !maps.Equal(info.UserTags, map[string]string{"project": "alpha"}) {
+		t.Errorf("Tags = %v, want project=alpha", info.UserTags)
+	}
+	expiresAt, ok := objectExpiry(info.UserMetadata)
+	if !ok || expiresAt.Before(before.Add(time.Hour).Truncate(time.Second)) || expiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("Expiry = %v, %t, want an hour after the upload", expiresAt, ok)
+	}
+	if w := fetchFile(store, objectName, nil); !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetched %q, want the uploaded file", w.Body.Bytes())
+	}
+}
+
+func TestUploadMetadataPartChoosesCompression(t *testing.T) {
+	tests := map[string]struct {
+		metadata string
+		header   string
+		want     bool
+	}{
+		"compressed":              {`{"compressed":true}`, "", true},
+		"not compressed":          {`{"compressed":false}`, COMPRESSION_GZIP, false},
+		"left to the header":      {`{}`, COMPRESSION_GZIP, true},
+		"neither part nor header": {`{}`, "", false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			uidTracker.Init(nil)
+			store := newMemoryStore()
+			content := bytes.Repeat([]byte("Compressible text. "), 100)
+			r := newMetadataUploadRequest(t, test.metadata, "notes.txt", content)
+			r.Header.Set("Compress", test.header)
+			objectName := uploadWithConfig(t, store, defaultConfig(), r)
+
+			if got := isCompressedObject(store.objects[objectName].info.UserMetadata); got != test.want {
+				t.Errorf("Compressed = %t, want %t", got, test.want)
+			}
+			if w := fetchFile(store, objectName, nil); !bytes.Equal(w.Body.Bytes(), content) {
+				t.Errorf("Fetched %d bytes, want the uploaded file", w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestUploadRejectsInvalidMetadataPart(t *testing.T) {
+	tests := map[string]string{
+		"not JSON":          "filename=report.txt",
+		"unknown field":     `{"owner":"alice"}`,
+		"invalid filename":  `{"filename":"../report.txt"}`,
+		"invalid type":      `{"contentType":"text/"}`,
+		"invalid tags":      `{"tags":{"project":"<alpha>"}}`,
+		"negative TTL":      `{"ttlSeconds":-1}`,
+		"compressed string": `{"compressed":"gzip"}`,
+		"too large":         fmt.Sprintf(`{"filename":"%s"}`, strings.Repeat("a", MAX_METADATA_PART_SIZE)),
+		"several documents": `{"filename":"a.txt"}{"filename":"b.txt"}`,
+	}
+	for name, metadata := range tests {
+		t.Run(name, func(t *testing.T) {
+			uidTracker.Init(nil)
+			w := httptest.NewRecorder()
+			uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, newMetadataUploadRequest(t, metadata, "upload.bin", []byte("Content")))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Upload gave status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// checkMethod makes sure the request uses one of the methods allowed by its endpoint. Otherwise, it answers with 405
+// Method Not Allowed and an Allow header listing them, rather than letting the handler fail confusingly.
+func checkMethod(w http.ResponseWriter, r *http.Request, allowed ...string) (errOccurred bool) {
+	if slices.Contains(allowed, r.Method) {
+		return false
+	}
+	allowedList := strings.Join(allowed, ", ")
+	w.Header().Set("Allow", allowedList)
+	http.Error(w, "Method "+r.Method+" is not allowed, use "+allowedList, http.StatusMethodNotAllowed)
+	return true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlersRejectWrongMethods(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	for _, route := range []struct {
+		path     string
+		handler  http.HandlerFunc
+		rejected []string
+		allow    string
+	}{
+		{"/upload", uploadHandler(store, newTestCipher(), cfg), []string{http.MethodGet, http.MethodPut, http.MethodDelete}, "POST"},
+		{"/upload/progress?uid=1", uploadProgressHandler(&progressIndex{}), []string{http.MethodPost}, "GET"},
+		{"/upload/start", startUploadHandler(store, newTestCipher(), cfg, &uploadSessionIndex{}), []string{http.MethodGet, http.MethodPut}, "POST"},
+		{"/upload/part?session=1&part=1", uploadPartHandler(store, newTestCipher(), cfg, &uploadSessionIndex{}), []string{http.MethodGet, http.MethodPost}, "PUT"},
+		{"/upload/complete?session=1", completeUploadHandler(store, newTestCipher(), &uploadSessionIndex{}), []string{http.MethodGet, http.MethodPut}, "POST"},
+		{"/fetch?uid=1", fetchAndDecryptHandler(store, newTestCipher(), cfg), []string{http.MethodPost, http.MethodDelete}, "GET, HEAD"},
+		{"/fetch-zip?uid=1", fetchZipHandler(store, newTestCipher()), []string{http.MethodPost, http.MethodHead}, "GET"},
+		{"/verify?uid=1", verifyHandler(store, newTestCipher()), []string{http.MethodPost, http.MethodHead}, "GET"},
+		{"/list", listHandler(store), []string{http.MethodPost}, "GET, HEAD"},
+		{"/info?uid=1", infoHandler(store), []string{http.MethodPost}, "GET, HEAD"},
+		{"/metadata?uid=1", updateMetadataHandler(store, cfg), []string{http.MethodGet, http.MethodPost}, "PATCH"},
+		{"/presign?uid=1", presignHandler(newPresignTestStore(t), time.Minute), []string{http.MethodPost}, "GET, HEAD"},
+		{"/stats", statsHandler(store, 0), []string{http.MethodDelete}, "GET, HEAD"},
+		{"/selftest", selfTestHandler(newTestCipher()), []string{http.MethodPost}, "GET, HEAD"},
+		{"/rekey?uid=1", rekeyHandler(store, newTestCipher(), cfg), []string{http.MethodGet}, "POST"},
+		{"/reserve?count=1", reserveHandler(UID_RESERVATION_DURATION), []string{http.MethodGet}, "POST"},
+	} {
+		for _, method := range route.rejected {
+			w := httptest.NewRecorder()
+			route.handler(w, httptest.NewRequest(method, route.path, nil))
+			if w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s gave status %d, want %d", method, route.path, w.Code, http.StatusMethodNotAllowed)
+			}
+			if got := w.Header().Get("Allow"); got != route.allow {
+				t.Errorf("%s %s gave Allow %q, want %q", method, route.path, got, route.allow)
+			}
+		}
+	}
+	if count := uidTracker.Count(); count != 0 {
+		t.Errorf("Requests with wrong methods reserved %d UIDs", count)
+	}
+}
+
+func TestFetchAllowsHead(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "head.txt", "text/plain", []byte("Only described"))
+
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, httptest.NewRequest(http.MethodHead, "/fetch?uid="+objectName, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("HEAD /fetch gave status %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"api/uid"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are registered in the default Prometheus registry, which is served on /metrics.
+var (
+	uploadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_upload_uploads_total",
+		Help: "Number of files successfully uploaded.",
+	})
+	downloadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_upload_downloads_total",
+		Help: "Number of files, or ranges of files, successfully downloaded.",
+	})
+	uploadedBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_upload_uploaded_bytes_total",
+		Help: "Number of plaintext bytes of the successfully uploaded files.",
+	})
+	downloadedBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "file_upload_downloaded_bytes_total",
+		Help: "Number of decrypted bytes sent to clients.",
+	})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_upload_errors_total",
+		Help: "Number of requests which failed, by handler and HTTP status.",
+	}, []string{"handler", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "file_upload_request_duration_seconds",
+		Help:    "Time taken to serve requests, by handler.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"handler"})
+	encryptionThroughput = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "file_upload_encryption_throughput_bytes_per_second",
+		Help:    "Rate at which uploaded files were encrypted.",
+		Buckets: prometheus.ExponentialBuckets(1024*1024, 2, 12),
+	})
+	minioRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_upload_minio_requests_total",
+		Help: "Number of requests sent to MinIO, by operation and result.",
+	}, []string{"operation", "result"})
+	minioRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "file_upload_minio_request_duration_seconds",
+		Help:    "Time taken by the requests sent to MinIO, by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"operation"})
+	verificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_upload_verifications_total",
+		Help: "Number of stored files checked through /verify, by result: ok, corrupted or failed.",
+	}, []string{"result"})
+	scrubbedObjectsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "file_upload_scrubbed_objects_total",
+		Help: "Number of stored files checked by the background scrubber, by result: ok, corrupted or failed.",
+	}, []string{"result"})
+	uidGenerationAttempts = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "file_upload_uid_generation_attempts",
+		Help:    "Number of UIDs drawn to generate an unused one, including the generations which gave up.",
+		Buckets: prometheus.LinearBuckets(1, 1, uid.MAX_GENERATION_ATTEMPTS),
+	})
+)
+
+// instrument wraps a handler to measure its latency and count the requests it fails.
+func instrument(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		requestDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+		if recorder.status >= http.StatusBadRequest {
+			errorsTotal.WithLabelValues(handler, strconv.Itoa(recorder.status)).Inc()
+		}
+	}
+}
+
+// statusRecorder keeps track of the status code and the number of body bytes written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	written     int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer, e.g. to flush it.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+// instrumentedStore wraps an uploadStore to measure the requests sent to MinIO.
+type instrumentedStore struct {
+	store uploadStore
+}
+
+// observeMinioRequest records the outcome and duration of a MinIO request which started at the given time.
+func observeMinioRequest(operation string, start time.Time, err error) {
+	minioRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	minioRequestsTotal.WithLabelValues(operation, result).Inc()
+}
+
+func (s *instrumentedStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	start := time.Now()
+	uploadInfo, err := s.store.PutObject(ctx, objectName, reader, objectSize, opts)
+	observeMinioRequest("PutObject", start, err)
+	return uploadInfo, err
+}
+
+// GetObject only measures the time until the object starts being streamed.
+func (s *instrumentedStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	start := time.Now()
+	object, err := s.store.GetObject(ctx, objectName, opts)
+	observeMinioRequest("GetObject", start, err)
+	return object, err
+}
+
+func (s *instrumentedStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	start := time.Now()
+	objectInfo, err := s.store.StatObject(ctx, objectName)
+	observeMinioRequest("StatObject", start, err)
+	return objectInfo, err
+}
+
+// ListObjects records the listing once it was entirely consumed.
+func (s *instrumentedStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	start := time.Now()
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		var err error
+		for object := range s.store.ListObjects(ctx, startAfter) {
+			if object.Err != nil {
+				err = object.Err
+			}
+			sendObjectInfo(ctx, objects, object)
+		}
+		observeMinioRequest("ListObjects", start, err)
+	}()
+	return objects
+}
+
+func (s *instrumentedStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	start := time.Now()
+	err := s.store.ReplaceMetadata(ctx, objectName, contentType, userMetadata)
+	observeMinioRequest("ReplaceMetadata", start, err)
+	return err
+}
+
+func (s *instrumentedStore) CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error {
+	start := time.Now()
+	err := s.store.CopyObject(ctx, srcObjectName, objectName, contentType, userMetadata)
+	observeMinioRequest("CopyObject", start, err)
+	return err
+}
+
+func (s *instrumentedStore) RemoveObject(ctx context.Context, objectName string) error {
+	start := time.Now()
+	err := s.store.RemoveObject(ctx, objectName)
+	observeMinioRequest("RemoveObject", start, err)
+	return err
+}
+
+func (s *instrumentedStore) AbortUpload(ctx context.Context, objectName string) error {
+	start := time.Now()
+	err := s.store.AbortUpload(ctx, objectName)
+	observeMinioRequest("AbortUpload", start, err)
+	return err
+}
+
+func (s *instrumentedStore) NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error) {
+	start := time.Now()
+	uploadID, err := s.store.NewMultipartUpload(ctx, objectName, opts)
+	observeMinioRequest("NewMultipartUpload", start, err)
+	return uploadID, err
+}
+
+func (s *instrumentedStore) PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	start := time.Now()
+	part, err := s.store.PutObjectPart(ctx, objectName, uploadID, partNumber, reader, size)
+	observeMinioRequest("PutObjectPart", start, err)
+	return part, err
+}
+
+func (s *instrumentedStore) CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error {
+	start := time.Now()
+	err := s.store.CompleteMultipartUpload(ctx, objectName, uploadID, parts, opts)
+	observeMinioRequest("CompleteMultipartUpload", start, err)
+	return err
+}
+
+func (s *instrumentedStore) AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error {
+	start := time.Now()
+	err := s.store.AbortMultipartUpload(ctx, objectName, uploadID)
+	observeMinioRequest("AbortMultipartUpload", start, err)
+	return err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetric reads the value of an unlabelled metric from the /metrics endpoint.
+func scrapeMetric(t *testing.T, name string) float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), name+" "); ok {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Metric %s has the invalid value %q", name, value)
+			}
+			return number
+		}
+	}
+	t.Fatalf("Metric %s not found in /metrics", name)
+	return 0
+}
+
+func TestMetricsCountUploads(t *testing.T) {
+	uidTracker.Init(nil)
+	content := []byte("Steve counted the pastries at the hotel breakfast.")
+	uploadsBefore := scrapeMetric(t, "file_upload_uploads_total")
+	bytesBefore := scrapeMetric(t, "file_upload_uploaded_bytes_total")
+
+	uploadFile(t, newMemoryStore(), "pastries.txt", "text/plain", content)
+
+	if got := scrapeMetric(t, "file_upload_uploads_total"); got != uploadsBefore+1 {
+		t.Errorf("file_upload_uploads_total = %v, want %v", got, uploadsBefore+1)
+	}
+	if got := scrapeMetric(t, "file_upload_uploaded_bytes_total"); got != bytesBefore+float64(len(content)) {
+		t.Errorf("file_upload_uploaded_bytes_total = %v, want %v", got, bytesBefore+float64(len(content)))
+	}
+}
+
+func TestInstrumentCountsErrors(t *testing.T) {
+	handler := instrument("test", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Missing UID", http.StatusBadRequest)
+	})
+	// Create the series so that it can be scraped before the first error
+	errorsTotal.WithLabelValues("test", "400")
+	before := scrapeMetric(t, `file_upload_errors_total{handler="test",status="400"}`)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fetch", nil))
+	if got := scrapeMetric(t, `file_upload_errors_total{handler="test",status="400"}`); got != before+1 {
+		t.Errorf("file_upload_errors_total = %v, want %v", got, before+1)
+	}
+}
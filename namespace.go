@@ -0,0 +1,126 @@
+package main
+
+import (
+	"api/uid"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Namespaces are short enough to fit in object keys, and in URLs.
+const MAX_NAMESPACE_LENGTH = 63
+
+// requestNamespace returns the namespace given in the X-Namespace header of a request, which scopes the files it
+// uploads or fetches. Requests without it use the default namespace, which is empty.
+func requestNamespace(r *http.Request) (string, error) {
+	namespace := r.Header.Get("X-Namespace")
+	if namespace == "" {
+		return "", nil
+	}
+	if err := checkNamespace(namespace); err != nil {
+		return "", err
+	}
+	return namespace, nil
+}
+
+// checkNamespace returns an error if the namespace cannot prefix the name of an object: it must be made of lowercase
+// letters, digits and hyphens, without starting with a hyphen.
+func checkNamespace(namespace string) error {
+	if len(namespace) == 0 || len(namespace) > MAX_NAMESPACE_LENGTH || namespace[0] == '-' {
+		return fmt.Errorf("X-Namespace should hold between 1 and %d characters, not starting with a hyphen", MAX_NAMESPACE_LENGTH)
+	}
+	for _, c := range namespace {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return fmt.Errorf("X-Namespace may only hold lowercase letters, digits and hyphens, got %q", namespace)
+		}
+	}
+	return nil
+}
+
+// objectKey returns the name of the object holding the file of the given UID in the namespace. The objects of the
+// default namespace are named after their UID alone.
+func objectKey(namespace string, fileUid uint64) string {
+	if namespace == "" {
+		return strconv.FormatUint(fileUid, 10)
+	}
+	return namespace + "/" + strconv.FormatUint(fileUid, 10)
+}
+
+// parseObjectKey returns the namespace and the UID of the file held by the object with the given name.
+func parseObjectKey(key string) (string, uint64, error) {
+	namespace, uidStr, namespaced := strings.Cut(key, "/")
+	if !namespaced {
+		namespace, uidStr = "", key
+	} else if err := checkNamespace(namespace); err != nil {
+		return "", 0, err
+	}
+	fileUid, err := strconv.ParseUint(uidStr, 10, 64)
+	return namespace, fileUid, err
+}
+
+// objectUid returns the UID of the file held by the object with the given name, without its namespace, as told to
+// users.
+func objectUid(objectName string) string {
+	return objectName[strings.LastIndex(objectName, "/")+1:]
+}
+
+// namespaceIndex holds the UIDs in use in every namespace, so that the same UID can name different files in different
+// namespaces. The UIDs of the default namespace are held by uidTracker.
+type namespaceIndex struct {
+	trackers map[string]*uid.UidTracker
+	mu       sync.Mutex
+}
+
+// tracker returns the tracker of the UIDs in use in the namespace, created on its first use.
+func (i *namespaceIndex) tracker(namespace string) *uid.UidTracker {
+	if namespace == "" {
+		return &uidTracker
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.trackers == nil {
+		i.trackers = make(map[string]*uid.UidTracker)
+	}
+	tracker, ok := i.trackers[namespace]
+	if !ok {
+		tracker = &uid.UidTracker{}
+		tracker.Init(nil)
+		i.trackers[namespace] = tracker
+	}
+	return tracker
+}
+
+// count returns the number of UIDs in use in the namespaces other than the default one.
+func (i *namespaceIndex) count() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	total := 0
+	for _, tracker := range i.trackers {
+		total += tracker.Count()
+	}
+	return total
+}
+
+// reset initializes the trackers of the namespaces with the UIDs in use in each of them.
+func (i *namespaceIndex) reset(uids map[string][]uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.trackers = make(map[string]*uid.UidTracker, len(uids))
+	for namespace, namespaceUids := range uids {
+		tracker := &uid.UidTracker{}
+		tracker.Init(namespaceUids)
+		i.trackers[namespace] = tracker
+	}
+}
+
+// namespaceTrackers holds the UIDs in use in the namespaces other than the default one.
+var namespaceTrackers = namespaceIndex{}
+
+// releaseObjectName frees the UID of the object with the given name in its namespace.
+func releaseObjectName(objectName string) {
+	if namespace, fileUid, err := parseObjectKey(objectName); err == nil {
+		namespaceTrackers.tracker(namespace).Remove(fileUid)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// uploadToNamespace uploads a file under the given UID in the namespace, and returns the response.
+func uploadToNamespace(t *testing.T, store uploadStore, namespace, uidStr string, content []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newUploadRequest(t, "notes.txt", "text/plain", content)
+	r.Header.Set("X-Namespace", namespace)
+	r.Header.Set("Uid", uidStr)
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	return w
+}
+
+func TestNamespacesIsolateUids(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+
+	contents := map[string]string{"": "default", "alice": "Alice's notes", "bob": "Bob's notes"}
+	for namespace, content := range contents {
+		w := uploadToNamespace(t, store, namespace, "42", []byte(content))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Upload to UID 42 of namespace %q failed with status %d: %s", namespace, w.Code, w.Body.String())
+		}
+		if got := w.Header().Get("X-Upload-UID"); got != "42" {
+			t.Errorf("X-Upload-UID = %q in namespace %q, want the UID without namespace", got, namespace)
+		}
+	}
+	for _, objectName := range []string{"42", "alice/42", "bob/42"} {
+		if _, ok := store.objects[objectName]; !ok {
+			t.Errorf("No object named %s", objectName)
+		}
+	}
+	if w := uploadToNamespace(t, store, "alice", "42", []byte("again")); w.Code != http.StatusConflict {
+		t.Errorf("Second upload to UID 42 of a namespace gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	for namespace, content := range contents {
+		w := fetchFile(store, "42", http.Header{"X-Namespace": {namespace}})
+		if w.Code != http.StatusOK || w.Body.String() != content {
+			t.Errorf("Fetch of UID 42 in namespace %q gave status %d with %q, want %q", namespace, w.Code, w.Body.String(), content)
+		}
+	}
+	if w := fetchFile(store, "42", http.Header{"X-Namespace": {"carol"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Fetch from a namespace without files gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestNamespaceInvalid(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	for _, namespace := range []string{"Alice", "a/b", "-alice", "ali ce", "../alice", strings.Repeat("a", MAX_NAMESPACE_LENGTH+1)} {
+		if w := uploadToNamespace(t, store, namespace, "1", []byte("content")); w.Code != http.StatusBadRequest {
+			t.Errorf("Upload to namespace %q gave status %d, want %d", namespace, w.Code, http.StatusBadRequest)
+		}
+		if w := fetchFile(store, "1", http.Header{"X-Namespace": {namespace}}); w.Code != http.StatusBadRequest {
+			t.Errorf("Fetch from namespace %q gave status %d, want %d", namespace, w.Code, http.StatusBadRequest)
+		}
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("Uploads to invalid namespaces stored %d objects", len(store.objects))
+	}
+}
+
+func TestNamespacesRestoredAndReaped(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	if w := uploadToNamespace(t, store, "alice", "7", []byte("kept")); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	r := newUploadRequest(t, "expiring.txt", "text/plain", []byte("expiring"))
+	r.Header.Set("X-Namespace", "alice")
+	r.Header.Set("TTL-Seconds", "60")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	expiring := uidFromResponse(w.Body.String())
+
+	// Simulate a restart of the service
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	if err := fetchUidsFromMinio(context.Background(), &uidTracker, &fileChecksums, &fileNames, store); err != nil {
+		t.Fatal(err)
+	}
+	if uidTracker.Count() != 0 || namespaceTrackers.tracker("alice").Count() != 2 {
+		t.Fatalf("Restored %d UIDs in the default namespace and %d in alice, want 0 and 2", uidTracker.Count(), namespaceTrackers.tracker("alice").Count())
+	}
+
+	nbrReaped, err := reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now().Add(2*time.Minute))
+	if err != nil || nbrReaped != 1 {
+		t.Fatalf("Reaping deleted %d objects with error %v, want 1", nbrReaped, err)
+	}
+	if _, ok := store.objects["alice/"+expiring]; ok {
+		t.Error("The expired object of the namespace is still stored")
+	}
+	if w := fetchFile(store, expiring, http.Header{"X-Namespace": {"alice"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Fetch of the expired file gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if !namespaceTrackers.tracker("alice").Contains(7) {
+		t.Error("The reaper freed the UID of a file which did not expire")
+	}
+}
+
+func TestNamespacedUploadSpilledToDisk(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.spillDir = t.TempDir()
+	content := []byte("Alice's notes of unknown size")
+
+	r := newStreamedUploadRequest("notes.txt", content)
+	r.Header.Set("X-Namespace", "alice")
+	r.Header.Set("Uid", "42")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := fetchFile(store, "42", http.Header{"X-Namespace": {"alice"}}); w.Body.String() != string(content) {
+		t.Errorf("Fetched %q, want the uploaded file", w.Body.String())
+	}
+}
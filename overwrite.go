@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// parseOverwrite parses the X-Overwrite header of an upload, which allows it to replace the file stored under its Uid.
+func parseOverwrite(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	overwrite, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("X-Overwrite should be true or false, got %q", value)
+	}
+	return overwrite, nil
+}
+
+// overwriteTarget is the object an upload with X-Overwrite is stored in.
+type overwriteTarget struct {
+	objectName string
+	// keep is true if the UID was already in use or names an object, in which case neither are discarded if the
+	// upload fails
+	keep bool
+	// previous describes the object stored under the UID before the upload, nil if there was none
+	previous *minio.ObjectInfo
+}
+
+// getOverwriteTarget returns true if an error occurred, after rejecting the request. Otherwise, it returns the object
+// the file uploaded with the Uid of the request is stored in, whether the UID is in use or not. The UID is tracked
+// from then on, and only freed if the upload fails and it named no file before it.
+// A file which may have been given to other uploaders by the deduplication cannot be replaced.
+func getOverwriteTarget(w http.ResponseWriter, r *http.Request, store uploadStore, cfg config) (overwriteTarget, bool) {
+	namespace, err := requestNamespace(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return overwriteTarget{}, true
+	}
+	suggestedUid, _, err := requestedUid(r)
+	if errors.Is(err, errRepeatedUid) {
+		http.Error(w, "A single Uid header should be given", http.StatusBadRequest)
+		return overwriteTarget{}, true
+	} else if err != nil {
+		http.Error(w, "The UID provided in the header cannot be parsed as a uint64.", http.StatusPreconditionFailed)
+		return overwriteTarget{}, true
+	}
+	target := overwriteTarget{objectName: objectKey(namespace, suggestedUid)}
+	// A reserved UID is already tracked, but names no file yet
+	if !(namespace == "" && uidReservations.claim(suggestedUid)) {
+		target.keep = namespaceTrackers.tracker(namespace).AddOrKeep(suggestedUid)
+	}
+	// The file being replaced is looked up to be removed from the indexes once the upload succeeded. A UID which is
+	// free in the tracker may also name an object, which is kept like any file being replaced.
+	objectInfo, err := store.StatObject(r.Context(), target.objectName)
+	if err == nil && isSharedObject(cfg, target.objectName, objectInfo.UserMetadata) {
+		if !target.keep {
+			releaseObjectName(target.objectName)
+		}
+		http.Error(w, SHARED_OBJECT_MESSAGE, http.StatusConflict)
+		return overwriteTarget{}, true
+	} else if err == nil {
+		target.keep = true
+		target.previous = &objectInfo
+	} else if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		if !target.keep {
+			releaseObjectName(target.objectName)
+		}
+		http.Error(w, "Failed to look up the file stored under the UID in MinIO", http.StatusInternalServerError)
+		return overwriteTarget{}, true
+	}
+	return target, false
+}
+
+// forgetReplacedFile removes the file which was stored in an object before it was overwritten by the given file from
+// the checksum and filename indexes, and its size from the storage usage.
+func forgetReplacedFile(previous minio.ObjectInfo, file storedFile, objectName string) {
+	if checksum, ok := objectChecksum(previous.UserMetadata); ok && checksum != file.Sha256 {
+		fileChecksums.remove(checksum, objectName)
+	}
+	if filename, ok := objectFilename(previous.UserMetadata); ok && filename != file.Filename {
+		fileNames.release(filename, objectName)
+	}
+	storedBytes.remove(previous.Size)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestParseOverwrite(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "true": true, "false": false, "1": true} {
+		overwrite, err := parseOverwrite(value)
+		if err != nil || overwrite != want {
+			t.Errorf("parseOverwrite(%q) = %v, %v, want %v", value, overwrite, err, want)
+		}
+	}
+	if _, err := parseOverwrite("always"); err == nil {
+		t.Error("parseOverwrite accepted an invalid value")
+	}
+}
+
+// uploadWithUid uploads a file under the given UID through the handler, with the given X-Overwrite header if any.
+func uploadWithUid(t *testing.T, store uploadStore, uidStr, filename string, content []byte, overwrite string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := newUploadRequest(t, filename, "text/plain", content)
+	r.Header.Set("Uid", uidStr)
+	if overwrite != "" {
+		r.Header.Set("X-Overwrite", overwrite)
+	}
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	return w
+}
+
+func TestUploadToUsedUidConflictsWithoutOverwrite(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	original := []byte("The original file")
+	if w := uploadWithUid(t, store, "42", "original.txt", original, ""); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, overwrite := range []string{"", "false"} {
+		w := uploadWithUid(t, store, "42", "replacement.txt", []byte("The replacement"), overwrite)
+		if w.Code != http.StatusConflict {
+			t.Errorf("Upload to a used UID with X-Overwrite %q gave status %d, want %d", overwrite, w.Code, http.StatusConflict)
+		}
+	}
+	if fetched := fetchFile(store, "42", nil); !bytes.Equal(fetched.Body.Bytes(), original) {
+		t.Errorf("Fetched %q after the conflicting uploads, want the original file", fetched.Body.String())
+	}
+}
+
+func TestUploadOverwritesUsedUid(t *testing.T) {
+	uidTracker.Init(nil)
+	fileNames.reset(nil)
+	storedBytes.reset(0)
+	store := newMemoryStore()
+	if w := uploadWithUid(t, store, "42", "original.txt", []byte("The original file"), ""); w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	replacement := []byte("The replacement, which is longer than the original")
+	w := uploadWithUid(t, store, "42", "replacement.txt", replacement, "true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Overwriting upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if uid := uidFromResponse(w.Body.String()); uid != "42" {
+		t.Errorf("The replacement was stored under UID %s, want 42", uid)
+	}
+	fetched := fetchFile(store, "42", nil)
+	if !bytes.Equal(fetched.Body.Bytes(), replacement) {
+		t.Errorf("Fetched %q after the overwrite, want the replacement", fetched.Body.String())
+	}
+	if count := uidTracker.Count(); count != 1 {
+		t.Errorf("%d UIDs are in use after the overwrite, want 1", count)
+	}
+	if used, want := storedBytes.total(), int64(len(store.objects["42"].data)); used != want {
+		t.Errorf("%d bytes are counted as stored after the overwrite, want the %d of the replacement", used, want)
+	}
+	// The name of the replaced file is free again
+	if name := fileNames.claim("original.txt", "7"); name != "original.txt" {
+		t.Errorf("The name of the replaced file is still in use, another file was renamed %s", name)
+	}
+}
+
+func TestUploadOverwritesFreeUid(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	w := uploadWithUid(t, store, "42", "new.txt", []byte("Nothing to replace"), "true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Overwriting upload to a free UID failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if !uidTracker.Contains(42) {
+		t.Error("The UID of the uploaded file is not tracked")
+	}
+}
+
+func TestOverwriteRequiresUid(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "file.txt", "text/plain", []byte("Overwrites nothing"))
+	r.Header.Set("X-Overwrite", "true")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Overwriting upload without a Uid gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = uploadWithUid(t, store, "42", "file.txt", []byte("Overwrites nothing"), "always")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with an invalid X-Overwrite gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if uidTracker.Count() != 0 {
+		t.Error("A UID was reserved by a rejected upload")
+	}
+}
+
+func TestOverwriteOfDeduplicatedFileConflicts(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	content := []byte("The same report, sent by two colleagues.")
+	objectName := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.txt", "text/plain", content))
+	if second := uploadWithConfig(t, store, cfg, newUploadRequest(t, "copy.txt", "text/plain", content)); second != objectName {
+		t.Fatalf("Second upload got UID %s, want the UID %s of the first one", second, objectName)
+	}
+
+	// Replacing the file would change the one the other uploader was given
+	r := newUploadRequest(t, "report.txt", "text/plain", []byte("A report only one colleague sent"))
+	r.Header.Set("Uid", objectName)
+	r.Header.Set("X-Overwrite", "true")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Overwrite of a deduplicated file gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := fetchFile(store, objectName, nil); !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetched %q after the rejected overwrite, want the deduplicated file", w.Body.Bytes())
+	}
+	if fileUid, _ := strconv.ParseUint(objectName, 10, 64); !uidTracker.Contains(fileUid) {
+		t.Error("The UID of the deduplicated file was freed")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// presigner generates time-limited URLs giving direct read access to stored objects.
+type presigner interface {
+	PresignedGetObject(ctx context.Context, objectName string, expiry time.Duration) (*url.URL, error)
+}
+
+func (s *minioStore) PresignedGetObject(ctx context.Context, objectName string, expiry time.Duration) (*url.URL, error) {
+	return s.client.PresignedGetObject(ctx, s.bucket, objectName, expiry, nil)
+}
+
+// presignHandler returns a URL from which the object stored under the given UID can be downloaded directly from MinIO,
+// until the expiry elapses. The object is served as stored, so clients must decrypt it themselves: it starts with the
+// header of the encrypted stream, followed by the AES-CTR ciphertext of the file.
+func presignHandler(presigner presigner, expiry time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+
+		presignedUrl, err := presigner.PresignedGetObject(r.Context(), objectName, expiry)
+		if err != nil {
+			http.Error(w, "Unable to generate a download URL", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Expires", time.Now().Add(expiry).UTC().Format(http.TimeFormat))
+		fmt.Fprintln(w, presignedUrl.String())
+	}
+}
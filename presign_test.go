@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newPresignTestStore returns a store backed by a MinIO client which can presign URLs without reaching the server,
+// since its region is known in advance.
+func newPresignTestStore(t *testing.T) *minioStore {
+	t.Helper()
+	client, err := minio.New("minio:9000", &minio.Options{
+		Creds:  credentials.NewStaticV4("access", "secret", ""),
+		Region: DEFAULT_REGION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &minioStore{client: client, bucket: DEFAULT_BUCKET_NAME}
+}
+
+func TestPresignReturnsExpiringURL(t *testing.T) {
+	uidTracker.Init([]uint64{42})
+	expiry := 90 * time.Second
+
+	w := httptest.NewRecorder()
+	presignHandler(newPresignTestStore(t), expiry)(w, httptest.NewRequest(http.MethodGet, "/presign?uid=42", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Presign failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	presignedUrl, err := url.Parse(strings.TrimSpace(w.Body.String()))
+	if err != nil {
+		t.Fatalf("The returned URL %q is malformed: %v", w.Body.String(), err)
+	}
+	if presignedUrl.Host != "minio:9000" || presignedUrl.Path != "/"+DEFAULT_BUCKET_NAME+"/42" {
+		t.Errorf("The URL %s does not point to object 42 of the bucket", presignedUrl)
+	}
+	if got := presignedUrl.Query().Get("X-Amz-Expires"); got != "90" {
+		t.Errorf("X-Amz-Expires = %q, want %q", got, "90")
+	}
+	if presignedUrl.Query().Get("X-Amz-Signature") == "" {
+		t.Error("The URL is not signed")
+	}
+	expires, err := http.ParseTime(w.Header().Get("Expires"))
+	if err != nil {
+		t.Fatalf("Invalid Expires header: %v", err)
+	}
+	if until := time.Until(expires); until <= 0 || until > expiry {
+		t.Errorf("Expires header is %s away, want within %s", until, expiry)
+	}
+}
+
+func TestPresignUnknownUid(t *testing.T) {
+	uidTracker.Init(nil)
+
+	w := httptest.NewRecorder()
+	presignHandler(newPresignTestStore(t), time.Minute)(w, httptest.NewRequest(http.MethodGet, "/presign?uid=42", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
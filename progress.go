@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// The progress of the uploads of unknown size is reported every time this many more bytes were received.
+const PROGRESS_UNKNOWN_SIZE_STEP = 1024 * 1024
+
+// uploadProgress tracks the number of bytes received for a file being uploaded. It is an io.Writer counting the bytes
+// of the file written to it, whose subscribers are notified whenever the percentage received changes.
+type uploadProgress struct {
+	// total is the declared size of the file, or UNKNOWN_FILE_SIZE
+	total    int64
+	received int64
+	// finished is set once the upload is over, and failed if the file was not stored
+	finished bool
+	failed   bool
+	// changed is closed, and replaced, whenever the progress is reported to change
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+// progressSnapshot is the state of an upload's progress at a point in time, as reported to the user.
+type progressSnapshot struct {
+	Received int64 `json:"received"`
+	// Total and Percent are omitted for the files whose size was not declared
+	Total   int64 `json:"total,omitempty"`
+	Percent int   `json:"percent,omitempty"`
+	// finished and failed tell whether the upload is over, and whether it failed
+	finished bool
+	failed   bool
+}
+
+// percent returns the whole percentage of the file received out of total bytes, considering an empty file as entirely
+// received.
+func percent(received, total int64) int {
+	if total <= 0 {
+		return 100
+	}
+	return int(received * 100 / total)
+}
+
+func (p *uploadProgress) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	previous := p.received
+	p.received += int64(len(data))
+	if p.total == UNKNOWN_FILE_SIZE {
+		if previous/PROGRESS_UNKNOWN_SIZE_STEP != p.received/PROGRESS_UNKNOWN_SIZE_STEP {
+			p.notify()
+		}
+	} else if percent(previous, p.total) != percent(p.received, p.total) {
+		p.notify()
+	}
+	return len(data), nil
+}
+
+// notify wakes the subscribers up. The lock must be held.
+func (p *uploadProgress) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// finish marks the upload as over, whether the file was stored or not.
+func (p *uploadProgress) finish(stored bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished = true
+	p.failed = !stored
+	p.notify()
+}
+
+// snapshot returns the current progress, along with a channel closed once it changes.
+func (p *uploadProgress) snapshot() (progressSnapshot, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snapshot := progressSnapshot{Received: p.received, finished: p.finished, failed: p.failed}
+	if p.total != UNKNOWN_FILE_SIZE {
+		snapshot.Total = p.total
+		snapshot.Percent = percent(p.received, p.total)
+	}
+	return snapshot, p.changed
+}
+
+// progressIndex holds the progress of the uploads in progress, by object name.
+type progressIndex struct {
+	uploads map[string]*uploadProgress
+	mu      sync.Mutex
+}
+
+// uploadProgresses holds the progress of the files being uploaded through /upload, for /upload/progress to report it.
+var uploadProgresses = progressIndex{}
+
+// start begins tracking the upload of a file of the given size, or UNKNOWN_FILE_SIZE, to the object with the given name.
+func (i *progressIndex) start(objectName string, total int64) *uploadProgress {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.uploads == nil {
+		i.uploads = make(map[string]*uploadProgress)
+	}
+	progress := &uploadProgress{total: total, changed: make(chan struct{})}
+	i.uploads[objectName] = progress
+	return progress
+}
+
+// get returns the progress of the upload to the object with the given name, if it is in progress.
+func (i *progressIndex) get(objectName string) (*uploadProgress, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	progress, ok := i.uploads[objectName]
+	return progress, ok
+}
+
+// finish stops tracking the upload to the object with the given name, after notifying its subscribers of its outcome.
+func (i *progressIndex) finish(objectName string, stored bool) {
+	i.mu.Lock()
+	progress, ok := i.uploads[objectName]
+	delete(i.uploads, objectName)
+	i.mu.Unlock()
+	if ok {
+		progress.finish(stored)
+	}
+}
+
+// uploadProgressHandler streams the progress of the upload of the file with the given UID as server-sent events, until
+// the upload is over. Each progress event holds the number of bytes received, and the declared size of the file along
+// with the percentage received if the size was declared. The stream ends with a done or failed event.
+func uploadProgressHandler(progresses *progressIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+		progress, ok := progresses.get(objectName)
+		if !ok {
+			http.Error(w, "No upload of a file with the provided UID is in progress", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		controller := http.NewResponseController(w)
+		for {
+			snapshot, changed := progress.snapshot()
+			event := "progress"
+			if snapshot.finished && snapshot.failed {
+				event = "failed"
+			} else if snapshot.finished {
+				event = "done"
+			}
+			data, err := json.Marshal(snapshot)
+			if err != nil {
+				loggerFrom(r.Context()).Error("Unable to encode the upload progress", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				loggerFrom(r.Context()).Error("Unable to flush the upload progress", "error", err)
+				return
+			}
+			if snapshot.finished {
+				return
+			}
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// progressEvent is a server-sent event of /upload/progress.
+type progressEvent struct {
+	name     string
+	snapshot progressSnapshot
+}
+
+// readProgressEvent reads the next server-sent event of a progress stream.
+func readProgressEvent(t *testing.T, events *bufio.Reader) progressEvent {
+	t.Helper()
+	var event progressEvent
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Unable to read the progress stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.snapshot); err != nil {
+				t.Fatalf("Progress event %q is not valid: %v", line, err)
+			}
+		}
+	}
+}
+
+func TestUploadProgressEvents(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	const nbrChunks = 10
+	content := bytes.Repeat([]byte("Steve lost his map near the Eiffel Tower. "), 100)
+	chunkSize := len(content) / nbrChunks
+
+	// The body is sent through a pipe, so that the upload stays in progress until it is entirely written
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	if _, err := writer.CreateFormFile("file", "progress.txt"); err != nil {
+		t.Fatal(err)
+	}
+	prefix := slices.Clone(multipartBody.Bytes())
+	multipartBody.Reset()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	trailer := multipartBody.Bytes()
+
+	bodyReader, bodyWriter := io.Pipe()
+	r := httptest.NewRequest(http.MethodPost, "/upload", bodyReader)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("File-Size", strconv.Itoa(len(content)))
+	r.Header.Set("Uid", "42")
+	w := httptest.NewRecorder()
+	uploaded := make(chan struct{})
+	go func() {
+		defer close(uploaded)
+		uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	}()
+	go bodyWriter.Write(prefix)
+
+	// Subscribe once the upload started
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ok := uploadProgresses.get("42"); !ok; _, ok = uploadProgresses.get("42") {
+		if time.Now().After(deadline) {
+			t.Fatal("The upload was never tracked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	server := httptest.NewServer(uploadProgressHandler(&uploadProgresses))
+	defer server.Close()
+	response, err := http.Get(server.URL + "?uid=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Subscribing gave status %d with type %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	events := bufio.NewReader(response.Body)
+	event := readProgressEvent(t, events)
+
+	// Every chunk of the file moves the progress forward
+	percents := []int{event.snapshot.Percent}
+	for i := range nbrChunks {
+		if _, err := bodyWriter.Write(content[i*chunkSize : (i+1)*chunkSize]); err != nil {
+			t.Fatal(err)
+		}
+		if i == nbrChunks-1 {
+			// The end of the body completes the upload
+			go func() {
+				bodyWriter.Write(trailer)
+				bodyWriter.Close()
+			}()
+		}
+		event = readProgressEvent(t, events)
+		if event.snapshot.Total != int64(len(content)) {
+			t.Errorf("Progress event of %d bytes in total, want %d", event.snapshot.Total, len(content))
+		}
+		percents = append(percents, event.snapshot.Percent)
+		if event.name != "progress" {
+			break
+		}
+	}
+	for event.name == "progress" {
+		event = readProgressEvent(t, events)
+		percents = append(percents, event.snapshot.Percent)
+	}
+	<-uploaded
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if event.name != "done" || event.snapshot.Received != int64(len(content)) {
+		t.Errorf("Last event %q with %d bytes received, want done with %d", event.name, event.snapshot.Received, len(content))
+	}
+	if len(percents) < nbrChunks || !slices.IsSorted(percents) || percents[0] == 100 || percents[len(percents)-1] != 100 {
+		t.Errorf("Percentages %v should increase up to 100", percents)
+	}
+	if _, ok := uploadProgresses.get("42"); ok {
+		t.Error("The progress of the upload is still tracked once it completed")
+	}
+}
+
+func TestUploadProgressWithoutUpload(t *testing.T) {
+	uidTracker.Init(nil)
+	objectName := uploadFile(t, newMemoryStore(), "over.txt", "text/plain", []byte("Already uploaded"))
+	w := httptest.NewRecorder()
+	uploadProgressHandler(&uploadProgresses)(w, httptest.NewRequest(http.MethodGet, "/upload/progress?uid="+objectName, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Following an upload which is not in progress gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"api/cryptography"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// storageUsage tracks the number of bytes the stored objects take in MinIO, to enforce MAX_TOTAL_BYTES without listing
+// the bucket on every upload.
+type storageUsage struct {
+	bytes int64
+	mu    sync.Mutex
+}
+
+// add records that an object of the given size was stored.
+func (u *storageUsage) add(size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes += size
+}
+
+// remove records that an object of the given size was deleted. The usage never goes below 0.
+func (u *storageUsage) remove(size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes = max(u.bytes-size, 0)
+}
+
+// reset sets the usage to the total size of the stored objects.
+func (u *storageUsage) reset(size int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bytes = size
+}
+
+// total returns the number of bytes the stored objects take.
+func (u *storageUsage) total() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.bytes
+}
+
+// storedBytes holds the size of the objects stored in MinIO, in every namespace.
+var storedBytes = storageUsage{}
+
+// storedObjectCount returns the number of UIDs in use in every namespace, which includes the reserved UIDs and those of
+// the uploads in progress.
+func storedObjectCount() int {
+	return uidTracker.Count() + namespaceTrackers.count()
+}
+
+// checkQuota returns true if an upload of files of the given sizes would exceed MAX_OBJECTS or MAX_TOTAL_BYTES, after
+// rejecting it with a 507. Files of unknown size are only rejected once the byte quota is reached. Uploads running at
+// the same time are checked independently, so they can exceed the quotas by the size of the files they hold.
+func checkQuota(w http.ResponseWriter, cfg config, cipher cryptography.Cipher, fileSizes []int64) bool {
+	if cfg.maxObjects > 0 && storedObjectCount()+len(fileSizes) > cfg.maxObjects {
+		http.Error(w, fmt.Sprintf("The storage quota of %d files is reached", cfg.maxObjects), http.StatusInsufficientStorage)
+		return true
+	}
+	if cfg.maxTotalBytes > 0 {
+		usedBytes := storedBytes.total()
+		if usedBytes >= cfg.maxTotalBytes {
+			http.Error(w, fmt.Sprintf("The storage quota of %d bytes is reached", cfg.maxTotalBytes), http.StatusInsufficientStorage)
+			return true
+		}
+		// Declared sizes are bounded by the maximal upload size, so their sum cannot overflow for a sensible number of files
+		for _, fileSize := range fileSizes {
+			if fileSize != UNKNOWN_FILE_SIZE {
+				usedBytes += cipher.EncryptedSize(fileSize)
+			}
+		}
+		if usedBytes > cfg.maxTotalBytes {
+			http.Error(w, fmt.Sprintf("The uploaded files would exceed the storage quota of %d bytes", cfg.maxTotalBytes), http.StatusInsufficientStorage)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadRejectedOverObjectQuota(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.maxObjects = 2
+
+	for i, filename := range []string{"first.txt", "second.txt"} {
+		w := httptest.NewRecorder()
+		uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, filename, "text/plain", []byte("Within the quota")))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Upload %d failed with status %d: %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), cfg)(w, newUploadRequest(t, "third.txt", "text/plain", []byte("Over the quota")))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Upload over the object quota gave status %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+	if count := uidTracker.Count(); count != 2 {
+		t.Errorf("%d UIDs are in use after the rejected upload, want 2", count)
+	}
+}
+
+func TestUploadRejectedOverByteQuota(t *testing.T) {
+	uidTracker.Init(nil)
+	storedBytes.reset(0)
+	store := newMemoryStore()
+	cipher := newTestCipher()
+	content := bytes.Repeat([]byte("x"), 100)
+	cfg := defaultConfig()
+	cfg.maxTotalBytes = 2*cipher.EncryptedSize(int64(len(content))) - 1
+
+	w := httptest.NewRecorder()
+	uploadHandler(store, cipher, cfg)(w, newUploadRequest(t, "first.bin", "", content))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if used := storedBytes.total(); used != cipher.EncryptedSize(int64(len(content))) {
+		t.Errorf("%d bytes are recorded as stored, want %d", used, cipher.EncryptedSize(int64(len(content))))
+	}
+
+	// The declared size of the second file would exceed the quota
+	w = httptest.NewRecorder()
+	uploadHandler(store, cipher, cfg)(w, newUploadRequest(t, "second.bin", "", content))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Upload exceeding the byte quota gave status %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+
+	// Once the quota is reached, files of unknown size are rejected too
+	storedBytes.add(cfg.maxTotalBytes)
+	w = httptest.NewRecorder()
+	uploadHandler(store, cipher, cfg)(w, newStreamedUploadRequest("third.bin", content))
+	if w.Code != http.StatusInsufficientStorage {
+		t.Errorf("Upload of unknown size with the byte quota reached gave status %d, want %d", w.Code, http.StatusInsufficientStorage)
+	}
+	storedBytes.reset(0)
+}
+
+func TestStoredBytesFreedByExpiry(t *testing.T) {
+	uidTracker.Init(nil)
+	storedBytes.reset(0)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "expiring.txt", "text/plain", []byte("Gone in a second"))
+	r.Header.Set("TTL-Seconds", "1")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if storedBytes.total() == 0 {
+		t.Fatal("The uploaded file was not recorded as stored")
+	}
+
+	if _, err := reapExpiredObjects(context.Background(), store, &uidTracker, &fileNames, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if used := storedBytes.total(); used != 0 {
+		t.Errorf("%d bytes are recorded as stored once the only file expired, want 0", used)
+	}
+}
+
+func TestStorageUsageNeverNegative(t *testing.T) {
+	usage := storageUsage{}
+	usage.add(10)
+	usage.remove(25)
+	if total := usage.total(); total != 0 {
+		t.Errorf("total() = %d after removing more than was added, want 0", total)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiters of clients which did not send any request for this long are forgotten, to not grow the map forever.
+const RATE_LIMITER_IDLE_TIMEOUT = 10 * time.Minute
+
+// clientRateLimiter throttles the requests of every client with its own token bucket.
+// Clients are identified by their API key if they provide one, or by their IP address otherwise.
+type clientRateLimiter struct {
+	requestsPerSecond rate.Limit
+	burst             int
+	// now returns the current time, and is only replaced in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+// clientLimiter is the token bucket of a single client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newClientRateLimiter creates a rate limiter letting every client send requestsPerSecond requests per second on average,
+// with bursts of up to burst requests.
+func newClientRateLimiter(requestsPerSecond float64, burst int) *clientRateLimiter {
+	return &clientRateLimiter{
+		requestsPerSecond: rate.Limit(requestsPerSecond),
+		burst:             burst,
+		now:               time.Now,
+		clients:           make(map[string]*clientLimiter),
+	}
+}
+
+// limit wraps a handler to reject the requests of clients exceeding their rate with a 429, along with a Retry-After
+// header telling them how many seconds to wait.
+func (l *clientRateLimiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if delay := l.reserve(clientKey(r)); delay > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too many requests, retry later", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// reserve takes a token from the client's bucket and returns zero, or returns how long the client has to wait for one
+// if the bucket is empty, in which case no token is taken.
+func (l *clientRateLimiter) reserve(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	client, ok := l.clients[key]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.requestsPerSecond, l.burst)}
+		l.clients[key] = client
+	}
+	client.lastSeen = now
+
+	reservation := client.limiter.ReserveN(now, 1)
+	// A bucket which can never hold a token rejects every request
+	if !reservation.OK() {
+		return RATE_LIMITER_IDLE_TIMEOUT
+	}
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// sweep forgets the clients which have been idle for too long. It must be called with the mutex held.
+func (l *clientRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < RATE_LIMITER_IDLE_TIMEOUT {
+		return
+	}
+	for key, client := range l.clients {
+		if now.Sub(client.lastSeen) >= RATE_LIMITER_IDLE_TIMEOUT {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientKey identifies the client sending the request by its API key, or by its IP address if it has none.
+func clientKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRejectsThenRecovers(t *testing.T) {
+	limiter := newClientRateLimiter(1, 2)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	handler := limiter.limit(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/upload", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// The burst is let through, then the client is throttled
+	for i := 0; i < 2; i++ {
+		if w := send("192.0.2.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d of the burst got status %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		w := send("192.0.2.1:1235")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Request over the limit got status %d, want %d", w.Code, http.StatusTooManyRequests)
+		}
+		if got := w.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Retry-After = %q, want %q", got, "1")
+		}
+	}
+
+	// Other clients are not affected
+	if w := send("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("Another client got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Rejected requests do not consume tokens, so a single token is available after a second
+	now = now.Add(time.Second)
+	if w := send("192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("Request after the window got status %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := send("192.0.2.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Second request after the window got status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimiterKeysByAPIKey(t *testing.T) {
+	limiter := newClientRateLimiter(1, 1)
+	first := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	first.Header.Set("Authorization", "Bearer first-key")
+	second := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	second.Header.Set("Authorization", "Bearer second-key")
+
+	if limiter.reserve(clientKey(first)) != 0 || limiter.reserve(clientKey(second)) != 0 {
+		t.Errorf("Clients with distinct API keys from the same address share their rate limit")
+	}
+	if limiter.reserve(clientKey(first)) == 0 {
+		t.Errorf("A client exceeding its rate limit was not throttled")
+	}
+}
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	limiter := newClientRateLimiter(1, 1)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+	limiter.reserve("ip:192.0.2.1")
+
+	now = now.Add(RATE_LIMITER_IDLE_TIMEOUT)
+	limiter.reserve("ip:192.0.2.2")
+	if _, ok := limiter.clients["ip:192.0.2.1"]; ok {
+		t.Errorf("Idle client was not forgotten")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// isRawFetch tells whether a fetch request asks for the encrypted object instead of the file, through its raw parameter.
+func isRawFetch(r *http.Request) (bool, error) {
+	rawStr := r.URL.Query().Get("raw")
+	if rawStr == "" {
+		return false, nil
+	}
+	raw, err := strconv.ParseBool(rawStr)
+	if err != nil {
+		return false, fmt.Errorf("raw should be true or false, got %q", rawStr)
+	}
+	return raw, nil
+}
+
+// serveRawObject sends an object exactly as stored, for clients holding the key to decrypt it themselves. The object
+// starts with the header of the encrypted stream, holding its format version, key ID and IV, followed by the ciphertext.
+// The checksum and compression of the file are sent in headers, as the client needs them once it decrypted the object.
+func serveRawObject(ctx context.Context, w http.ResponseWriter, store ObjectStore, objectInfo minio.ObjectInfo, filename string) {
+	object, err := store.GetObject(ctx, objectInfo.Key, minio.GetObjectOptions{})
+	if err != nil {
+		http.Error(w, "Unable to fetch file from MinIO", http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.enc\"", filename))
+	w.Header().Set("Content-Length", strconv.FormatInt(objectInfo.Size, 10))
+	if checksum, ok := objectInfo.UserMetadata[CHECKSUM_METADATA]; ok {
+		w.Header().Set("X-Content-SHA256", checksum)
+	}
+	if compression, ok := objectInfo.UserMetadata["Compression"]; ok {
+		w.Header().Set("X-Compression", compression)
+	}
+
+	sentData := &countingWriter{writer: w}
+	_, err = io.Copy(sentData, object)
+	downloadedBytesTotal.Add(float64(sentData.count))
+	if err != nil {
+		// The status was already sent, the client notices the failure through the body being shorter than announced
+		loggerFrom(ctx).Error("Unable to send the encrypted file", "uid", objectInfo.Key, "error", err)
+		return
+	}
+	downloadsTotal.Inc()
+}
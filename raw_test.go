@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func fetchRaw(store uploadStore, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	fetchAndDecryptHandler(store, newTestCipher(), defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?"+query, nil))
+	return w
+}
+
+func TestFetchRawDecryptsLocally(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Only the client should read this. "), 100)
+	objectName := uploadFile(t, store, "secret.txt", "text/plain", content)
+
+	w := fetchRaw(store, "uid="+objectName+"&raw=true")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Raw fetch failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), store.objects[objectName].data) {
+		t.Error("The raw fetch did not send the object as stored")
+	}
+	if got, want := w.Header().Get("Content-Length"), strconv.Itoa(w.Body.Len()); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="secret.txt.enc"` {
+		t.Errorf("Content-Disposition = %s, want the filename with the .enc extension", got)
+	}
+	if got := w.Header().Get("X-Content-SHA256"); got == "" {
+		t.Error("The raw fetch did not send the checksum of the file")
+	}
+
+	// The client decrypts the object with its own copy of the key
+	var decrypted bytes.Buffer
+	if err := newTestCipher().DecryptStream(w.Body, &decrypted); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), content) {
+		t.Errorf("The locally decrypted file is %q, want %q", decrypted.Bytes(), content)
+	}
+}
+
+func TestFetchRawParameter(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Decrypted by the server.")
+	objectName := uploadFile(t, store, "plain.txt", "text/plain", content)
+
+	if w := fetchRaw(store, "uid="+objectName+"&raw=false"); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetch with raw=false gave status %d and content %q, want the decrypted file", w.Code, w.Body.String())
+	}
+	if w := fetchRaw(store, "uid="+objectName+"&raw=maybe"); w.Code != http.StatusBadRequest {
+		t.Errorf("Fetch with an invalid raw parameter gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
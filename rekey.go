@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"api/cryptography"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// rekeyHandler re-encrypts the object with the given UID under the current key, e.g. to migrate the objects encrypted
+// with a key which was rotated.
+func rekeyHandler(store uploadStore, cipher cryptography.Cipher, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+		// The re-encryption is not interrupted if the client disconnects, to not waste the work already done
+		if err := rekeyObject(context.WithoutCancel(r.Context()), store, cipher, objectName, cfg.uploadPartSize); err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			} else {
+				loggerFrom(r.Context()).Error("Unable to re-encrypt the file", "uid", objectName, "error", err)
+				http.Error(w, "Unable to re-encrypt the file", http.StatusInternalServerError)
+			}
+			return
+		}
+		fmt.Fprintf(w, "File with UID %s successfully re-encrypted with the current key\n", objectUid(objectName))
+	}
+}
+
+// rekeyObject re-encrypts an object under the current key of the cipher. The object is streamed from MinIO, decrypted,
+// encrypted again and uploaded under the same name by parts of partSize bytes, along with its metadata.
+// MinIO only replaces the object once the new one was entirely uploaded, so the original object is kept if the
+// re-encryption fails or is interrupted. Objects with a checksum are verified before being replaced.
+func rekeyObject(ctx context.Context, store uploadStore, cipher cryptography.Cipher, objectName string, partSize int64) error {
+	objectInfo, err := store.StatObject(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	object, err := store.GetObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+
+	// The object is streamed through 2 goroutines, decrypting it and encrypting it again, while it is uploaded.
+	plaintextReader, plaintextWriter := io.Pipe()
+	ciphertextReader, ciphertextWriter := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// The checksum is that of the file, which only matches the decrypted stream if it is not compressed
+	storedChecksum, hasChecksum := objectInfo.UserMetadata[CHECKSUM_METADATA]
+	verify := hasChecksum && objectInfo.UserMetadata["Compression"] != COMPRESSION_GZIP
+
+	go func() {
+		defer wg.Done()
+		plaintextHash := sha256.New()
+		err := cipher.DecryptStreamCtx(ctx, object, io.MultiWriter(plaintextWriter, plaintextHash))
+		// A corrupted object fails the upload, instead of replacing the original with a re-encrypted corrupted file
+		if err == nil && verify && hex.EncodeToString(plaintextHash.Sum(nil)) != storedChecksum {
+			err = fmt.Errorf("checksum mismatch")
+		}
+		plaintextWriter.CloseWithError(err)
+	}()
+	go func() {
+		defer wg.Done()
+		err := cipher.EncryptStreamCtx(ctx, plaintextReader, ciphertextWriter)
+		plaintextReader.CloseWithError(err)
+		ciphertextWriter.CloseWithError(err)
+	}()
+
+	// The object is uploaded as if its size was unknown, so that MinIO waits for the end of the stream, which comes
+	// after the checksum was verified, to complete the upload.
+	start := time.Now()
+	_, err = store.PutObject(ctx, objectName, ciphertextReader, -1, minio.PutObjectOptions{
+		ContentType:  objectInfo.ContentType,
+		UserMetadata: objectInfo.UserMetadata,
+		StorageClass: objectInfo.UserMetadata[STORAGE_CLASS_METADATA],
+		UserTags:     objectInfo.UserTags,
+		PartSize:     uint64(partSize),
+	})
+	// Unblock the pipeline if the upload stopped early
+	ciphertextReader.CloseWithError(err)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	loggerFrom(ctx).Info("Re-encrypted file", "uid", objectName, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"api/cryptography"
+)
+
+const newHexKey = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+
+// newRotatedCipher returns a cipher encrypting with a new key under the ID 1, which still decrypts with the test key.
+func newRotatedCipher(t *testing.T) *cryptography.StreamCipher {
+	t.Helper()
+	c := &cryptography.StreamCipher{}
+	if err := loadKeys(c, newHexKey, "1", "0:"+testHexKey); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestRekeyReencryptsUnderCurrentKey(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Encrypted before the rotation. "), 100)
+	objectName := uploadFile(t, store, "old.txt", "text/plain", content)
+	before := store.objects[objectName]
+
+	w := httptest.NewRecorder()
+	rekeyHandler(store, newRotatedCipher(t), defaultConfig())(w, httptest.NewRequest(http.MethodPost, "/rekey?uid="+objectName, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Rekey failed with status %d: %s", w.Code, w.Body.String())
+	}
+	after := store.objects[objectName]
+	if keyID, err := cryptography.KeyID(after.data); err != nil || keyID != 1 {
+		t.Errorf("Object is encrypted with the key ID %d (error %v), want 1", keyID, err)
+	}
+	if after.info.Size != before.info.Size || after.info.ContentType != "text/plain" || after.info.UserMetadata["Filename"] != "old.txt" {
+		t.Errorf("Rekey changed the object's size, type or metadata: %+v", after.info)
+	}
+
+	// Only the new key decrypts the object
+	newOnly := &cryptography.StreamCipher{}
+	if err := loadKeys(newOnly, newHexKey, "1", ""); err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	if err := newOnly.DecryptStream(bytes.NewReader(after.data), &decrypted); err != nil || !bytes.Equal(decrypted.Bytes(), content) {
+		t.Errorf("Decrypting with the new key = %q with error %v, want the uploaded file", decrypted.Bytes(), err)
+	}
+	if err := newTestCipher().DecryptStream(bytes.NewReader(after.data), &bytes.Buffer{}); err == nil {
+		t.Error("The re-encrypted object can still be decrypted with the old key")
+	}
+}
+
+// Objects stored before the header held a key ID are decrypted with the key of ID 0, and migrated to the current format
+func TestRekeyMigratesLegacyObject(t *testing.T) {
+	uidTracker.Init([]uint64{7})
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Encrypted before the key IDs. "), 100)
+	storeLegacyObject(t, store, "7", "legacy.txt", content)
+	legacySize := store.objects["7"].info.Size
+	cipher := newRotatedCipher(t)
+
+	w := httptest.NewRecorder()
+	rekeyHandler(store, cipher, defaultConfig())(w, httptest.NewRequest(http.MethodPost, "/rekey?uid=7", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Rekey failed with status %d: %s", w.Code, w.Body.String())
+	}
+	after := store.objects["7"]
+	if keyID, err := cryptography.KeyID(after.data); err != nil || keyID != 1 || !bytes.HasPrefix(after.data, []byte(cryptography.HEADER_MAGIC)) {
+		t.Errorf("Object is encrypted with the key ID %d (error %v), want 1 under a versioned header", keyID, err)
+	}
+	if want := legacySize - cryptography.LEGACY_HEADER_SIZE + cryptography.HEADER_SIZE; after.info.Size != want {
+		t.Errorf("Migrated object is %d bytes long, want %d", after.info.Size, want)
+	}
+
+	w = httptest.NewRecorder()
+	fetchAndDecryptHandler(store, cipher, defaultConfig())(w, httptest.NewRequest(http.MethodGet, "/fetch?uid=7", nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetch after the migration gave status %d and %d bytes, want the stored file", w.Code, w.Body.Len())
+	}
+}
+
+func TestRekeyKeepsCorruptedObject(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "old.txt", "text/plain", []byte("Corrupted before the rotation."))
+	store.objects[objectName].data[cryptography.HEADER_SIZE] ^= 1
+	corrupted := bytes.Clone(store.objects[objectName].data)
+
+	w := httptest.NewRecorder()
+	rekeyHandler(store, newRotatedCipher(t), defaultConfig())(w, httptest.NewRequest(http.MethodPost, "/rekey?uid="+objectName, nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !bytes.Equal(store.objects[objectName].data, corrupted) {
+		t.Error("The corrupted object was replaced")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// remapHandler moves the file stored under the UID given by the from URL parameter to the UID given by the to
+// parameter, which must be free. The object is copied to the new UID before the old one is deleted, so that the file is
+// stored under one of the UIDs whatever fails: the copy is deleted and the new UID freed if the move cannot complete.
+// A file which may have been given to other uploaders by the deduplication cannot be moved.
+func remapHandler(store uploadStore, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		namespace, err := requestNamespace(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fromUid, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+		if err != nil {
+			http.Error(w, "The from parameter should be the UID of a stored file", http.StatusBadRequest)
+			return
+		}
+		toUid, err := strconv.ParseUint(r.URL.Query().Get("to"), 10, 64)
+		if err != nil {
+			http.Error(w, "The to parameter should be the UID to store the file under", http.StatusBadRequest)
+			return
+		}
+		if fromUid == toUid {
+			http.Error(w, "The file is already stored under the UID it should be moved to", http.StatusBadRequest)
+			return
+		}
+		tracker := namespaceTrackers.tracker(namespace)
+		if !tracker.Contains(fromUid) {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
+		fromName, toName := objectKey(namespace, fromUid), objectKey(namespace, toUid)
+
+		// The new UID is taken before the file is copied, so that no upload can be given it meanwhile
+		if _, err := tracker.AddUid(toUid); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if checkObjectNameFree(w, r.Context(), store, toName) {
+			return
+		}
+		objectInfo, err := store.StatObject(r.Context(), fromName)
+		if err != nil {
+			releaseObjectName(toName)
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get object metadata", http.StatusInternalServerError)
+			}
+			return
+		}
+		if isExpired(objectInfo.UserMetadata, time.Now()) {
+			releaseObjectName(toName)
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
+		if isSharedObject(cfg, fromName, objectInfo.UserMetadata) {
+			releaseObjectName(toName)
+			http.Error(w, SHARED_OBJECT_MESSAGE, http.StatusConflict)
+			return
+		}
+
+		// The copy is deleted even if the client disconnected
+		if err := store.CopyObject(r.Context(), fromName, toName, objectInfo.ContentType, objectInfo.UserMetadata); err != nil {
+			loggerFrom(r.Context()).Error("Unable to copy the object to its new UID", "uid", fromName, "to", toName, "error", err)
+			rollbackRemap(context.WithoutCancel(r.Context()), store, toName)
+			http.Error(w, "Unable to copy the file to its new UID in MinIO", http.StatusInternalServerError)
+			return
+		}
+		if err := store.RemoveObject(r.Context(), fromName); err != nil {
+			loggerFrom(r.Context()).Error("Unable to delete the object under its old UID", "uid", fromName, "to", toName, "error", err)
+			rollbackRemap(context.WithoutCancel(r.Context()), store, toName)
+			http.Error(w, "Unable to delete the file under its old UID in MinIO", http.StatusInternalServerError)
+			return
+		}
+
+		// The indexes now point at the new UID
+		if checksum, ok := objectChecksum(objectInfo.UserMetadata); ok {
+			fileChecksums.rename(checksum, fromName, toName)
+		}
+		if filename, ok := objectFilename(objectInfo.UserMetadata); ok {
+			fileNames.rename(filename, fromName, toName)
+		}
+		releaseObjectName(fromName)
+		fmt.Fprintf(w, "The file stored under UID %d is now stored under UID %d\n", fromUid, toUid)
+	}
+}
+
+// rollbackRemap deletes the copy of a file made by a remap which failed, and frees its UID. The UID stays in use if
+// the copy cannot be deleted, as it still names an object.
+func rollbackRemap(ctx context.Context, store uploadStore, toName string) {
+	ctx, cancel := context.WithTimeout(ctx, DISCARD_TIMEOUT)
+	defer cancel()
+	if err := store.RemoveObject(ctx, toName); err != nil {
+		loggerFrom(ctx).Error("Unable to delete the copy of a failed remap", "uid", toName, "error", err)
+		return
+	}
+	releaseObjectName(toName)
+}
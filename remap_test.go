@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// remapFile runs a remap of the from UID to the to UID through the handler.
+func remapFile(store uploadStore, from, to string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	remapHandler(store, defaultConfig())(w, httptest.NewRequest(http.MethodPost, "/remap?from="+from+"&to="+to, nil))
+	return w
+}
+
+func TestRemapMovesFileToNewUid(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	fileNames.reset(nil)
+	store := newMemoryStore()
+	content := []byte("Moved to another UID")
+	objectName := uploadFile(t, store, "moved.txt", "text/plain", content)
+	// Filenames are only indexed when they are made unique
+	fileNames.claim("moved.txt", objectName)
+
+	if w := remapFile(store, objectName, "4242"); w.Code != http.StatusOK {
+		t.Fatalf("Remap failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := fetchFile(store, objectName, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Fetching the old UID gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := fetchFile(store, "4242", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Fatalf("Fetching the new UID gave status %d and %q, want the uploaded file", w.Code, w.Body.Bytes())
+	}
+	if w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type = %q, want text/plain", w.Header().Get("Content-Type"))
+	}
+	if !uidTracker.Contains(4242) {
+		t.Error("The new UID is not tracked")
+	}
+
+	// The indexes point at the new UID
+	if owner := fileNames.uids[filenameKey{filename: "moved.txt"}]; owner != "4242" {
+		t.Errorf("The filename is held by the UID %q, want 4242", owner)
+	}
+	for checksum, owner := range fileChecksums.uids {
+		if owner != "4242" {
+			t.Errorf("The checksum %s is held by the UID %q, want 4242", checksum, owner)
+		}
+	}
+}
+
+func TestRemapToUsedUidConflicts(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Stays where it is")
+	from := uploadFile(t, store, "from.txt", "text/plain", content)
+	to := uploadFile(t, store, "to.txt", "text/plain", []byte("Already there"))
+
+	if w := remapFile(store, from, to); w.Code != http.StatusConflict {
+		t.Errorf("Remap to a used UID gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := fetchFile(store, from, nil); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetching the file after a rejected remap gave status %d and %q", w.Code, w.Body.Bytes())
+	}
+}
+
+func TestRemapRejectsInvalidParameters(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "file.txt", "text/plain", []byte("A file"))
+	tests := map[string]struct {
+		from, to string
+		want     int
+	}{
+		"from not a UID": {"file", "1", http.StatusBadRequest},
+		"to not a UID":   {objectName, "", http.StatusBadRequest},
+		"same UID":       {objectName, objectName, http.StatusBadRequest},
+		"unknown from":   {"123456789", "1", http.StatusNotFound},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := remapFile(store, test.from, test.to); w.Code != test.want {
+				t.Errorf("Remap gave status %d, want %d: %s", w.Code, test.want, w.Body.String())
+			}
+		})
+	}
+}
+
+// undeletableStore fails to delete the given object.
+type undeletableStore struct {
+	*memoryStore
+	objectName string
+}
+
+func (s undeletableStore) RemoveObject(ctx context.Context, objectName string) error {
+	if objectName == s.objectName {
+		return errors.New("object is locked")
+	}
+	return s.memoryStore.RemoveObject(ctx, objectName)
+}
+
+func TestRemapRollsBackWhenOldObjectCannotBeDeleted(t *testing.T) {
+	uidTracker.Init(nil)
+	memory := newMemoryStore()
+	content := []byte("Cannot be moved")
+	objectName := uploadFile(t, memory, "locked.txt", "text/plain", content)
+
+	if w := remapFile(undeletableStore{memory, objectName}, objectName, "4242"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Remap gave status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if _, ok := memory.objects["4242"]; ok {
+		t.Error("The copy under the new UID was not deleted")
+	}
+	if uidTracker.Contains(4242) {
+		t.Error("The new UID is still tracked")
+	}
+	if w := fetchFile(memory, objectName, nil); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetching the file after a failed remap gave status %d and %q", w.Code, w.Body.Bytes())
+	}
+}
+
+func TestRemapOfDeduplicatedFileConflicts(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	objectName := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.txt", "text/plain", []byte("A shared report")))
+
+	w := httptest.NewRecorder()
+	remapHandler(store, cfg)(w, httptest.NewRequest(http.MethodPost, "/remap?from="+objectName+"&to=4242", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Remap of a deduplicated file gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if _, ok := store.objects[objectName]; !ok || uidTracker.Contains(4242) {
+		t.Error("The rejected remap moved the file or kept the new UID")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"api/uid"
+)
+
+// A single request cannot reserve more UIDs than this, to not let a client exhaust the memory of the tracker.
+const MAX_RESERVED_UIDS = 1000
+
+// Reserved UIDs which were not used by an upload within this duration are freed.
+const UID_RESERVATION_DURATION = time.Hour
+
+// reservationIndex tracks the UIDs reserved for future uploads, along with the time their reservation expires.
+type reservationIndex struct {
+	expiries map[uint64]time.Time
+	mu       sync.Mutex
+}
+
+// add records the reservation of the UIDs until the given time.
+func (i *reservationIndex) add(uids []uint64, expiresAt time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.expiries == nil {
+		i.expiries = make(map[uint64]time.Time)
+	}
+	for _, reserved := range uids {
+		i.expiries[reserved] = expiresAt
+	}
+}
+
+// claim ends the reservation of a UID, for an upload to use it, and tells whether it was reserved.
+func (i *reservationIndex) claim(reserved uint64) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, ok := i.expiries[reserved]
+	delete(i.expiries, reserved)
+	return ok
+}
+
+// reserved tells whether a UID is reserved.
+func (i *reservationIndex) reserved(reservedUid uint64) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	_, ok := i.expiries[reservedUid]
+	return ok
+}
+
+// expire frees the UIDs whose reservation expired at the given time, and returns their number.
+func (i *reservationIndex) expire(tracker *uid.UidTracker, now time.Time) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	nbrExpired := 0
+	for reserved, expiresAt := range i.expiries {
+		if !now.Before(expiresAt) {
+			delete(i.expiries, reserved)
+			tracker.Remove(reserved)
+			nbrExpired++
+		}
+	}
+	return nbrExpired
+}
+
+// reservedUids lists UIDs reserved for future uploads, as reported to the user.
+type reservedUids struct {
+	Uids      []string  `json:"uids"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// reserveHandler reserves the number of UIDs given by the count parameter, so that a client can reference files
+// before uploading them. Each UID is then used by uploading a file with it in the Uid header, before the reservation
+// expires.
+func reserveHandler(duration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		count, err := strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count <= 0 || count > MAX_RESERVED_UIDS {
+			http.Error(w, fmt.Sprintf("count should be a number of UIDs between 1 and %d", MAX_RESERVED_UIDS), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 100*time.Millisecond)
+		defer cancel()
+		uids, err := uidTracker.GenerateAndAddBatch(ctx, count)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reservation := reservedUids{Uids: make([]string, len(uids)), ExpiresAt: time.Now().Add(duration).UTC()}
+		uidReservations.add(uids, reservation.ExpiresAt)
+		for i, reserved := range uids {
+			reservation.Uids[i] = strconv.FormatUint(reserved, 10)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(reservation); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the reserved UIDs", "error", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reserveUids(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reserveHandler(UID_RESERVATION_DURATION)(w, httptest.NewRequest(http.MethodPost, "/reserve?"+query, nil))
+	return w
+}
+
+func TestReserveUidsForUploads(t *testing.T) {
+	uidTracker.Init(nil)
+	uidReservations = reservationIndex{}
+	store := newMemoryStore()
+
+	w := reserveUids(t, "count=3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Reservation failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"expiresAt":`) {
+		t.Errorf("Response %q lacks the expiresAt field", w.Body.String())
+	}
+	var reservation reservedUids
+	if err := json.Unmarshal(w.Body.Bytes(), &reservation); err != nil {
+		t.Fatalf("Response %q is not a reservation: %v", w.Body.String(), err)
+	}
+	if len(reservation.Uids) != 3 || uidTracker.Count() != 3 {
+		t.Fatalf("Reserved %d UIDs with %d tracked, want 3", len(reservation.Uids), uidTracker.Count())
+	}
+	if !reservation.ExpiresAt.After(time.Now()) {
+		t.Errorf("Reservation expires at %s, want a future time", reservation.ExpiresAt)
+	}
+
+	// A reserved UID can be uploaded to once
+	r := newUploadRequest(t, "planned.txt", "text/plain", []byte("Referenced before its upload"))
+	r.Header.Set("Uid", reservation.Uids[0])
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload with a reserved UID failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := store.objects[reservation.Uids[0]]; !ok {
+		t.Error("The file was not stored under its reserved UID")
+	}
+	r = newUploadRequest(t, "again.txt", "text/plain", []byte("Second upload"))
+	r.Header.Set("Uid", reservation.Uids[0])
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Second upload with a reserved UID gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	// The unused UIDs are freed once their reservation expires
+	if nbrExpired := uidReservations.expire(&uidTracker, reservation.ExpiresAt); nbrExpired != 2 {
+		t.Errorf("%d reservations expired, want 2", nbrExpired)
+	}
+	for _, reserved := range reservation.Uids[1:] {
+		reservedUid, _ := strconv.ParseUint(reserved, 10, 64)
+		if uidTracker.Contains(reservedUid) {
+			t.Errorf("Expired reserved UID %s is still tracked", reserved)
+		}
+	}
+	usedUid, _ := strconv.ParseUint(reservation.Uids[0], 10, 64)
+	if !uidTracker.Contains(usedUid) {
+		t.Error("The expiry of the reservations freed the UID of the uploaded file")
+	}
+}
+
+func TestReserveInvalidCount(t *testing.T) {
+	uidTracker.Init(nil)
+	for _, query := range []string{"", "count=0", "count=-2", "count=many", "count=" + strconv.Itoa(MAX_RESERVED_UIDS+1)} {
+		if w := reserveUids(t, query); w.Code != http.StatusBadRequest {
+			t.Errorf("Reservation with %q gave status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+	if count := uidTracker.Count(); count != 0 {
+		t.Errorf("%d UIDs were reserved by invalid requests", count)
+	}
+}
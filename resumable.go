@@ -0,0 +1,500 @@
+package main
+
+import (
+	"api/cryptography"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Upload sessions which did not receive any part within this duration are abandoned, and their parts deleted.
+const UPLOAD_SESSION_DURATION = 24 * time.Hour
+
+// uploadSession is a resumable upload, whose file is sent by parts to a MinIO multipart upload.
+// The part N holds the plaintext of the file from offset (N-1)*partSize, encrypted as the stream with the session's
+// header would be at that offset, and the first part also starts with the header. The completed object is therefore
+// the same as if the file had been uploaded at once.
+type uploadSession struct {
+	objectName string
+	uploadID   string
+	header     []byte
+	partSize   int64
+	// filename is the name of the file, claimed in fileNames if filenames are made unique
+	filename string
+	// contentType and metadata are those the object is given when it starts, to which the checksum of the file is
+	// added once it is completed, as for /upload
+	contentType string
+	metadata    map[string]string
+	// indexChecksum tells whether the file is recorded in fileChecksums once completed, as the stored files which
+	// may be deduplicated are
+	indexChecksum bool
+	// parts holds the parts uploaded to MinIO, and plaintextSizes their sizes before encryption, by part number
+	parts          map[int]minio.ObjectPart
+	plaintextSizes map[int]int64
+	expiresAt      time.Time
+}
+
+// uploadSessionIndex holds the upload sessions in progress, by session ID.
+type uploadSessionIndex struct {
+	sessions map[string]*uploadSession
+	mu       sync.Mutex
+}
+
+// uploadSessions holds the sessions started through /upload/start, until they are completed or abandoned.
+var uploadSessions = uploadSessionIndex{}
+
+// add registers a new session, and returns its randomly generated ID.
+func (i *uploadSessionIndex) add(session *uploadSession) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.sessions == nil {
+		i.sessions = make(map[string]*uploadSession)
+	}
+	i.sessions[id] = session
+	return id, nil
+}
+
+// get returns the session with the given ID, whose fields other than its parts and expiry may be read freely.
+func (i *uploadSessionIndex) get(id string) (*uploadSession, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	session, ok := i.sessions[id]
+	return session, ok
+}
+
+// recordPart records a part uploaded to the session with the given ID, and extends the session until the given time.
+// It tells whether the session still exists.
+func (i *uploadSessionIndex) recordPart(id string, part minio.ObjectPart, plaintextSize int64, expiresAt time.Time) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	session, ok := i.sessions[id]
+	if !ok {
+		return false
+	}
+	session.parts[part.PartNumber] = part
+	session.plaintextSizes[part.PartNumber] = plaintextSize
+	session.expiresAt = expiresAt
+	return true
+}
+
+// remove removes the session with the given ID from the index and returns it, so that no part is recorded any more.
+func (i *uploadSessionIndex) remove(id string) (*uploadSession, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	session, ok := i.sessions[id]
+	delete(i.sessions, id)
+	return session, ok
+}
+
+// restore registers again a session which was removed, but could not be completed.
+func (i *uploadSessionIndex) restore(id string, session *uploadSession) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sessions[id] = session
+}
+
+// expire removes the sessions which expired at the given time, and returns them.
+func (i *uploadSessionIndex) expire(now time.Time) []*uploadSession {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var expired []*uploadSession
+	for id, session := range i.sessions {
+		if !now.Before(session.expiresAt) {
+			delete(i.sessions, id)
+			expired = append(expired, session)
+		}
+	}
+	return expired
+}
+
+// abandonUploadSessions aborts the multipart uploads of the sessions which expired at the given time, and frees their
+// UIDs and filenames. It returns the number of abandoned sessions. A session whose upload cannot be aborted keeps its
+// UID, as its parts may still be completed into an object by MinIO.
+func abandonUploadSessions(ctx context.Context, store uploadStore, sessions *uploadSessionIndex, filenames *filenameIndex, now time.Time) int {
+	expired := sessions.expire(now)
+	for _, session := range expired {
+		if err := store.AbortMultipartUpload(ctx, session.objectName, session.uploadID); err != nil {
+			loggerFrom(ctx).Error("Unable to abort the upload of an abandoned session", "uid", session.objectName, "error", err)
+			continue
+		}
+		filenames.release(session.filename, session.objectName)
+		releaseObjectName(session.objectName)
+	}
+	return len(expired)
+}
+
+// startedSession describes a started upload session, as reported to the user.
+type startedSession struct {
+	Session   string    `json:"session"`
+	Uid       string    `json:"uid"`
+	PartSize  int64     `json:"partSize"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// startUploadHandler starts a resumable upload, whose file is then sent by parts of the returned size to /upload/part,
+// in any order, before being stored by /upload/complete. The file is named by the filename query parameter, and the
+// Uid, X-Namespace, Content-Type, TTL-Seconds and custom metadata headers are honored as for /upload.
+func startUploadHandler(store uploadStore, cipher cryptography.Cipher, cfg config, sessions *uploadSessionIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		filename := r.URL.Query().Get("filename")
+		if filename != "" {
+			if err := checkFilename(filename); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		ttl, err := parseTTL(r.Header.Get("TTL-Seconds"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		customMetadata, err := parseCustomMetadata(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		storageClass, err := parseStorageClass(r.Header.Get("X-Storage-Class"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		objectTags, err := parseTags(r.Header.Get("X-Tags"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The size of the file is unknown until the upload completes
+		if checkQuota(w, cfg, cipher, []int64{UNKNOWN_FILE_SIZE}) {
+			return
+		}
+
+		objectName, errOccurred := getUniqueObjectName(w, r)
+		if errOccurred {
+			return
+		}
+		setRequestUID(r.Context(), objectName)
+		if checkObjectNameFree(w, r.Context(), store, objectName) {
+			return
+		}
+		// The UID and filename are freed if the session cannot be started
+		started := false
+		defer func() {
+			if !started {
+				releaseObjectName(objectName)
+			}
+		}()
+
+		header, err := cipher.NewHeader()
+		if err != nil {
+			http.Error(w, "Encryption failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		session := &uploadSession{
+			objectName:     objectName,
+			header:         header,
+			partSize:       cfg.uploadPartSize,
+			filename:       objectUid(objectName),
+			parts:          make(map[int]minio.ObjectPart),
+			plaintextSizes: make(map[int]int64),
+			expiresAt:      time.Now().Add(UPLOAD_SESSION_DURATION),
+		}
+		if filename != "" {
+			session.filename = filename
+		}
+		if cfg.uniqueFilenames {
+			session.filename = fileNames.claim(session.filename, objectName)
+			defer func() {
+				if !started {
+					fileNames.release(session.filename, objectName)
+				}
+			}()
+		}
+
+		// The metadata is set when the upload starts, as MinIO gives it to the object once the upload is completed
+		metadata := map[string]string{"Filename": session.filename}
+		if ttl > 0 {
+			metadata[EXPIRY_METADATA] = time.Now().Add(ttl).UTC().Format(time.RFC3339)
+		}
+		for key, value := range customMetadata {
+			metadata[CUSTOM_METADATA_PREFIX+key] = value
+		}
+		if storageClass != "" {
+			metadata[STORAGE_CLASS_METADATA] = storageClass
+		}
+		session.contentType = partContentType(textproto.MIMEHeader(r.Header))
+		session.metadata = metadata
+		// The UID of the session is already known to the client, so the file is not deduplicated, but later uploads
+		// of the same file may be
+		session.indexChecksum = cfg.deduplicate && ttl == 0 && !uidChosen(r) && r.Header.Get("X-Namespace") == "" && len(objectTags) == 0
+		putOpts := minio.PutObjectOptions{
+			ContentType:  session.contentType,
+			UserMetadata: maps.Clone(metadata),
+			StorageClass: storageClass,
+			UserTags:     objectTags,
+		}
+		session.uploadID, err = store.NewMultipartUpload(r.Context(), objectName, putOpts)
+		if err != nil {
+			http.Error(w, "Unable to start the upload in MinIO", http.StatusInternalServerError)
+			return
+		}
+		id, err := sessions.add(session)
+		if err != nil {
+			if err := store.AbortMultipartUpload(context.WithoutCancel(r.Context()), objectName, session.uploadID); err != nil {
+				loggerFrom(r.Context()).Warn("Unable to abort the upload of a session which failed to start", "uid", objectName, "error", err)
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		started = true
+
+		w.Header().Set("Content-Type", "application/json")
+		response := startedSession{Session: id, Uid: objectUid(objectName), PartSize: session.partSize, ExpiresAt: session.expiresAt.UTC()}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the upload session", "error", err)
+		}
+	}
+}
+
+// getUploadSession returns true if an error occurred, meaning the program should return.
+// On the other hand, if it returns false, the returned session is the one named by the session query parameter.
+// The appropriate error and error code will be sent to the user in the function directly.
+func getUploadSession(w http.ResponseWriter, r *http.Request, sessions *uploadSessionIndex) (string, *uploadSession, bool) {
+	id := r.URL.Query().Get("session")
+	if id == "" {
+		http.Error(w, "The session query parameter should hold the ID returned by /upload/start", http.StatusBadRequest)
+		return "", nil, true
+	}
+	session, ok := sessions.get(id)
+	if !ok {
+		http.Error(w, "Upload session not found, it may have been completed or abandoned", http.StatusNotFound)
+		return "", nil, true
+	}
+	return id, session, false
+}
+
+// uploadPartHandler uploads the part of a session given by the part query parameter, numbered from 1. The body holds
+// the plaintext of the part, and its length must be declared. Every part but the last must be as long as the part size
+// of the session. A part which is uploaded again replaces the previous one.
+func uploadPartHandler(store uploadStore, cipher cryptography.Cipher, cfg config, sessions *uploadSessionIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPut) {
+			return
+		}
+		defer r.Body.Close()
+		id, session, errOccurred := getUploadSession(w, r, sessions)
+		if errOccurred {
+			return
+		}
+		setRequestUID(r.Context(), session.objectName)
+		partNumber, err := strconv.Atoi(r.URL.Query().Get("part"))
+		if err != nil || partNumber < 1 || partNumber > MAX_UPLOAD_PARTS {
+			http.Error(w, fmt.Sprintf("part should be a part number between 1 and %d", MAX_UPLOAD_PARTS), http.StatusBadRequest)
+			return
+		}
+		plaintextSize := r.ContentLength
+		if plaintextSize < 0 {
+			http.Error(w, "The length of the part must be declared", http.StatusLengthRequired)
+			return
+		}
+		if plaintextSize > session.partSize {
+			http.Error(w, fmt.Sprintf("Parts may not be longer than the part size of %d bytes", session.partSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		offset := int64(partNumber-1) * session.partSize
+		if offset+plaintextSize > cfg.maxUploadSize {
+			http.Error(w, fmt.Sprintf("The file would exceed the maximal upload size of %d bytes", cfg.maxUploadSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		// The body cannot be longer than declared, whatever reads it
+		limitIdleReads(w, r, cfg.uploadIdleTimeout)
+		r.Body = http.MaxBytesReader(w, r.Body, plaintextSize)
+
+		timeout := getMaxNbrRunSeconds(cipher.EncryptedSize(plaintextSize), cfg.uploadMinRate, cfg.uploadTimeoutMargin)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		// The part is encrypted at its offset in the file while it is uploaded to MinIO, the first part being preceded
+		// by the header of the encrypted stream
+		ciphertextReader, ciphertextWriter := io.Pipe()
+		var failure firstError
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partData := io.LimitReader(clientReader{reader: r.Body}, plaintextSize)
+			err := cipher.EncryptStreamAt(session.header, offset, partData, ciphertextWriter)
+			var clientError clientReadError
+			switch {
+			case isBodyTooLarge(err):
+				failure.set(http.StatusRequestEntityTooLarge, "The uploaded part is longer than its Content-Length")
+			case errors.Is(err, errUploadIdle):
+				failure.set(http.StatusRequestTimeout, "The upload stalled: "+err.Error())
+			case errors.As(err, &clientError):
+				failure.set(http.StatusBadRequest, "Unable to read the uploaded part: "+err.Error())
+			case err != nil:
+				failure.set(http.StatusInternalServerError, "Encryption failed: "+err.Error())
+			}
+			ciphertextWriter.CloseWithError(err)
+		}()
+
+		var ciphertext io.Reader = ciphertextReader
+		ciphertextSize := plaintextSize
+		if partNumber == 1 {
+			ciphertext = io.MultiReader(bytes.NewReader(session.header), ciphertextReader)
+			ciphertextSize += int64(len(session.header))
+		}
+		part, err := store.PutObjectPart(ctx, session.objectName, session.uploadID, partNumber, ciphertext, ciphertextSize)
+		if err != nil {
+			failure.set(http.StatusInternalServerError, "Upload to MinIO failed")
+			// Stop the encryption of a part which can no longer be uploaded
+			ciphertextReader.CloseWithError(err)
+		}
+		wg.Wait()
+		if err := failure.get(); err != nil {
+			if ctxError := contextError(ctx); ctxError != nil && err.status != http.StatusRequestTimeout {
+				err = ctxError
+			}
+			if err.status == http.StatusGatewayTimeout {
+				setTimeoutRetryAfter(w, timeout)
+			}
+			writeError(w, r.Context(), err)
+			return
+		}
+
+		part.PartNumber = partNumber
+		if !sessions.recordPart(id, part, plaintextSize, time.Now().Add(UPLOAD_SESSION_DURATION)) {
+			http.Error(w, "Upload session not found, it may have been completed or abandoned", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, "Part %d successfully uploaded and encrypted\n", partNumber)
+	}
+}
+
+// completeUploadHandler stores the file of a session, made of its parts numbered from 1 without gaps, under the UID
+// it was given when it started. A session which cannot be completed is kept, for the missing parts to be uploaded.
+// As the parts may be uploaded in any order, the SHA-256 checksum of the file is computed by reading back the completed
+// object, and stored in its metadata along with its size and upload time, as /upload does.
+func completeUploadHandler(store uploadStore, cipher cryptography.Cipher, sessions *uploadSessionIndex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPost) {
+			return
+		}
+		id := r.URL.Query().Get("session")
+		if id == "" {
+			http.Error(w, "The session query parameter should hold the ID returned by /upload/start", http.StatusBadRequest)
+			return
+		}
+		// The session is removed while it is completed, so that no part is uploaded to it in the meantime
+		session, ok := sessions.remove(id)
+		if !ok {
+			http.Error(w, "Upload session not found, it may have been completed or abandoned", http.StatusNotFound)
+			return
+		}
+		setRequestUID(r.Context(), session.objectName)
+		completed := false
+		defer func() {
+			if !completed {
+				sessions.restore(id, session)
+			}
+		}()
+
+		partNumbers := slices.Sorted(maps.Keys(session.parts))
+		if len(partNumbers) == 0 {
+			http.Error(w, "No part was uploaded", http.StatusBadRequest)
+			return
+		}
+		parts := make([]minio.CompletePart, len(partNumbers))
+		var fileSize, objectSize int64
+		for i, partNumber := range partNumbers {
+			if partNumber != i+1 {
+				http.Error(w, fmt.Sprintf("Part %d is missing", i+1), http.StatusBadRequest)
+				return
+			}
+			if i < len(partNumbers)-1 && session.plaintextSizes[partNumber] != session.partSize {
+				http.Error(w, fmt.Sprintf("Part %d is %d bytes long, but every part but the last must be %d bytes long", partNumber, session.plaintextSizes[partNumber], session.partSize), http.StatusBadRequest)
+				return
+			}
+			parts[i] = minio.CompletePart{PartNumber: partNumber, ETag: session.parts[partNumber].ETag}
+			fileSize += session.plaintextSizes[partNumber]
+			objectSize += session.parts[partNumber].Size
+		}
+		if err := store.CompleteMultipartUpload(r.Context(), session.objectName, session.uploadID, parts, minio.PutObjectOptions{}); err != nil {
+			http.Error(w, "Unable to complete the upload in MinIO", http.StatusInternalServerError)
+			return
+		}
+		// The parts are gone once the upload is completed, so the session cannot be resumed any more
+		completed = true
+
+		checksum, err := storeSessionChecksum(r.Context(), store, cipher, session, fileSize, objectSize)
+		if err != nil {
+			loggerFrom(r.Context()).Error("Unable to store the checksum of a completed upload", "uid", session.objectName, "error", err)
+			discardCompletedSession(context.WithoutCancel(r.Context()), store, session)
+			http.Error(w, "Failed to store the file checksum in MinIO", http.StatusInternalServerError)
+			return
+		}
+
+		uploadsTotal.Inc()
+		uploadedBytesTotal.Add(float64(fileSize))
+		storedBytes.add(objectSize)
+		fileUid := objectUid(session.objectName)
+		w.Header().Set("X-Upload-UID", fileUid)
+		w.Header().Set("X-Content-SHA256", checksum)
+		fmt.Fprintf(w, "File successfully uploaded and encrypted with UID %s \nSHA-256 checksum: %s \n", fileUid, checksum)
+	}
+}
+
+// storeSessionChecksum computes the checksum of the file of a completed session from its object, and adds it to the
+// metadata of the object with the size of the file and the time of the upload. It returns the hex-encoded checksum.
+func storeSessionChecksum(ctx context.Context, store uploadStore, cipher cryptography.Cipher, session *uploadSession, fileSize int64, objectSize int64) (string, error) {
+	checksum, err := computeStoredChecksum(ctx, store, cipher, minio.ObjectInfo{Key: session.objectName, Size: objectSize})
+	if err != nil {
+		return "", err
+	}
+	metadata := maps.Clone(session.metadata)
+	metadata[CHECKSUM_METADATA] = checksum
+	metadata[PLAINTEXT_SIZE_METADATA] = strconv.FormatInt(fileSize, 10)
+	metadata[UPLOADED_AT_METADATA] = time.Now().UTC().Format(time.RFC3339)
+	if err := store.ReplaceMetadata(ctx, session.objectName, session.contentType, metadata); err != nil {
+		return "", err
+	}
+	if session.indexChecksum {
+		fileChecksums.lookupOrAdd(checksum, session.objectName)
+	}
+	return checksum, nil
+}
+
+// discardCompletedSession deletes the object of a completed session whose checksum could not be stored, and frees its
+// UID and filename. The UID stays reserved if the object cannot be deleted.
+func discardCompletedSession(ctx context.Context, store uploadStore, session *uploadSession) {
+	ctx, cancel := context.WithTimeout(ctx, DISCARD_TIMEOUT)
+	defer cancel()
+	if err := store.RemoveObject(ctx, session.objectName); err != nil {
+		loggerFrom(ctx).Error("Unable to delete the object of a failed upload", "uid", session.objectName, "error", err)
+		return
+	}
+	fileNames.release(session.filename, session.objectName)
+	releaseObjectName(session.objectName)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startSession starts an upload session through the handler, and returns its description.
+func startSession(t *testing.T, store uploadStore, cfg config, sessions *uploadSessionIndex, query string) startedSession {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/upload/start?"+query, nil)
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	startUploadHandler(store, newTestCipher(), cfg, sessions)(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Starting the session failed with status %d: %s", w.Code, w.Body.String())
+	}
+	// The fields are named in camelCase, as in the other JSON responses
+	for _, key := range []string{`"session":`, `"uid":`, `"partSize":`, `"expiresAt":`} {
+		if !strings.Contains(w.Body.String(), key) {
+			t.Errorf("Response %q lacks the field %s", w.Body.String(), key)
+		}
+	}
+	var session startedSession
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatalf("Response %q is not an upload session: %v", w.Body.String(), err)
+	}
+	return session
+}
+
+// uploadPart uploads a part of a session through the handler.
+func uploadPart(store uploadStore, cfg config, sessions *uploadSessionIndex, session string, partNumber int, data []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPut, "/upload/part?session="+session+"&part="+strconv.Itoa(partNumber), bytes.NewReader(data))
+	w := httptest.NewRecorder()
+	uploadPartHandler(store, newTestCipher(), cfg, sessions)(w, r)
+	return w
+}
+
+// completeSession completes a session through the handler.
+func completeSession(store uploadStore, sessions *uploadSessionIndex, session string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	completeUploadHandler(store, newTestCipher(), sessions)(w, httptest.NewRequest(http.MethodPost, "/upload/complete?session="+session, nil))
+	return w
+}
+
+func TestResumableUploadOutOfOrder(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	sessions := &uploadSessionIndex{}
+	cfg := defaultConfig()
+	cfg.uploadPartSize = 100
+	content := bytes.Repeat([]byte("Henry took a train to Versailles. "), 10)
+
+	session := startSession(t, store, cfg, sessions, "filename=versailles.txt")
+	if session.PartSize != cfg.uploadPartSize {
+		t.Errorf("Part size %d, want %d", session.PartSize, cfg.uploadPartSize)
+	}
+	for _, partNumber := range []int{3, 1, 4, 2} {
+		start := int64(partNumber-1) * session.PartSize
+		end := min(start+session.PartSize, int64(len(content)))
+		if w := uploadPart(store, cfg, sessions, session.Session, partNumber, content[start:end]); w.Code != http.StatusOK {
+			t.Fatalf("Uploading part %d failed with status %d: %s", partNumber, w.Code, w.Body.String())
+		}
+	}
+	// A part uploaded again replaces the previous one
+	if w := uploadPart(store, cfg, sessions, session.Session, 2, content[100:200]); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 2 again failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	w := completeSession(store, sessions, session.Session)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Completing the session failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Upload-UID"); got != session.Uid {
+		t.Errorf("X-Upload-UID = %q, want %q", got, session.Uid)
+	}
+	// The file is described as if it had been sent to /upload
+	checksum := sha256.Sum256(content)
+	if got := w.Header().Get("X-Content-SHA256"); got != hex.EncodeToString(checksum[:]) {
+		t.Errorf("X-Content-SHA256 = %q, want the checksum of the file", got)
+	}
+	metadata := store.objects[session.Uid].info.UserMetadata
+	if metadata[CHECKSUM_METADATA] != hex.EncodeToString(checksum[:]) {
+		t.Errorf("Stored checksum %q, want the checksum of the file", metadata[CHECKSUM_METADATA])
+	}
+	if metadata[PLAINTEXT_SIZE_METADATA] != strconv.Itoa(len(content)) {
+		t.Errorf("Stored size %q, want %d", metadata[PLAINTEXT_SIZE_METADATA], len(content))
+	}
+	if _, err := time.Parse(time.RFC3339, metadata[UPLOADED_AT_METADATA]); err != nil {
+		t.Errorf("Stored upload time %q is not a time: %v", metadata[UPLOADED_AT_METADATA], err)
+	}
+	w = fetchFile(store, session.Uid, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetch failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetched %q, want %q", w.Body.Bytes(), content)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q, want %q", got, "text/plain")
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="versailles.txt"` {
+		t.Errorf("Content-Disposition = %q, want the filename of the session", got)
+	}
+
+	// The session is over once completed
+	if w := completeSession(store, sessions, session.Session); w.Code != http.StatusNotFound {
+		t.Errorf("Completing the session again gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestResumableUploadRejectsIncompleteSessions(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	sessions := &uploadSessionIndex{}
+	cfg := defaultConfig()
+	cfg.uploadPartSize = 10
+	session := startSession(t, store, cfg, sessions, "")
+
+	if w := completeSession(store, sessions, session.Session); w.Code != http.StatusBadRequest {
+		t.Errorf("Completing a session without parts gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := uploadPart(store, cfg, sessions, session.Session, 1, []byte("0123456789")); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 1 failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := uploadPart(store, cfg, sessions, session.Session, 3, []byte("end")); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 3 failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := completeSession(store, sessions, session.Session); w.Code != http.StatusBadRequest {
+		t.Errorf("Completing a session missing a part gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	// A short part is only accepted last
+	if w := uploadPart(store, cfg, sessions, session.Session, 2, []byte("short")); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 2 failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := completeSession(store, sessions, session.Session); w.Code != http.StatusBadRequest {
+		t.Errorf("Completing a session with a short part gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := uploadPart(store, cfg, sessions, session.Session, 2, []byte("0123456789abc")); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Uploading a part larger than the part size gave status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// The session is kept after a failed completion, for the missing parts to be uploaded
+	if w := uploadPart(store, cfg, sessions, session.Session, 2, []byte("abcdefghij")); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 2 again failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := completeSession(store, sessions, session.Session); w.Code != http.StatusOK {
+		t.Fatalf("Completing the session failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if w := fetchFile(store, session.Uid, nil); w.Body.String() != "0123456789abcdefghijend" {
+		t.Errorf("Fetched %q, want the parts in order", w.Body.String())
+	}
+}
+
+func TestResumableUploadUnknownSession(t *testing.T) {
+	store := newMemoryStore()
+	sessions := &uploadSessionIndex{}
+	cfg := defaultConfig()
+	if w := uploadPart(store, cfg, sessions, "unknown", 1, []byte("data")); w.Code != http.StatusNotFound {
+		t.Errorf("Uploading to an unknown session gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := uploadPart(store, cfg, sessions, "", 1, []byte("data")); w.Code != http.StatusBadRequest {
+		t.Errorf("Uploading without a session gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := completeSession(store, sessions, "unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("Completing an unknown session gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAbandonedUploadSessionIsCleanedUp(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	sessions := &uploadSessionIndex{}
+	cfg := defaultConfig()
+	cfg.uploadPartSize = 10
+
+	session := startSession(t, store, cfg, sessions, "filename=abandoned.txt")
+	if w := uploadPart(store, cfg, sessions, session.Session, 1, []byte("0123456789")); w.Code != http.StatusOK {
+		t.Fatalf("Uploading part 1 failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if uidTracker.Count() != 1 || len(store.uploads) != 1 {
+		t.Fatalf("%d UIDs tracked and %d uploads in progress, want 1 of each", uidTracker.Count(), len(store.uploads))
+	}
+
+	// The session is kept as long as it does not expire
+	if nbrAbandoned := abandonUploadSessions(context.Background(), store, sessions, &fileNames, time.Now()); nbrAbandoned != 0 {
+		t.Errorf("%d sessions abandoned before their expiry, want none", nbrAbandoned)
+	}
+	if nbrAbandoned := abandonUploadSessions(context.Background(), store, sessions, &fileNames, session.ExpiresAt.Add(time.Minute)); nbrAbandoned != 1 {
+		t.Errorf("%d sessions abandoned after their expiry, want 1", nbrAbandoned)
+	}
+	if uidTracker.Count() != 0 {
+		t.Errorf("%d UIDs tracked after the session was abandoned, want 0", uidTracker.Count())
+	}
+	if len(store.uploads) != 0 || len(store.objects) != 0 {
+		t.Errorf("%d uploads and %d objects left after the session was abandoned, want none", len(store.uploads), len(store.objects))
+	}
+	if w := uploadPart(store, cfg, sessions, session.Session, 2, []byte("late")); w.Code != http.StatusNotFound {
+		t.Errorf("Uploading to an abandoned session gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// retryPolicy describes how failed MinIO operations are retried.
+type retryPolicy struct {
+	// maxAttempts is the total number of attempts, including the first one.
+	maxAttempts int
+	// baseDelay is the delay before the first retry, which doubles after every failed attempt.
+	baseDelay time.Duration
+}
+
+// withRetry runs op until it succeeds, fails with a non-retryable error, or the policy's attempts are exhausted.
+// The delay between attempts grows exponentially, and no attempt is started if it would end after the context's deadline.
+func withRetry(ctx context.Context, policy retryPolicy, op func() error) error {
+	delay := policy.baseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= policy.maxAttempts || !isRetryable(err) {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		loggerFrom(ctx).Warn("MinIO operation failed, retrying", "attempt", attempt, "max_attempts", policy.maxAttempts, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isRetryable tells whether a failed MinIO operation may succeed if attempted again: MinIO reported a transient
+// failure, or the network failed to reach it. Other errors, such as a pipe closed by the upload pipeline, would fail
+// again.
+func isRetryable(err error) bool {
+	if errors.As(err, &permanentError{}) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var response minio.ErrorResponse
+	if errors.As(err, &response) {
+		switch response.Code {
+		case "RequestTimeout", "InternalError", "SlowDown", "ServiceUnavailable", "XMinioServerNotInitialized":
+			return true
+		default:
+			return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= http.StatusInternalServerError
+		}
+	}
+	var netError net.Error
+	return errors.As(err, &netError) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryStore wraps an uploadStore to retry the operations which fail with transient errors.
+type retryStore struct {
+	store  uploadStore
+	policy retryPolicy
+}
+
+// PutObject is only retried as long as no byte was consumed from the reader, since the uploaded stream cannot be replayed.
+func (s *retryStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	countingReader := &countingReader{reader: reader}
+	var uploadInfo minio.UploadInfo
+	err := withRetry(ctx, s.policy, func() error {
+		var err error
+		uploadInfo, err = s.store.PutObject(ctx, objectName, countingReader, objectSize, opts)
+		if err != nil && countingReader.count > 0 {
+			return permanentError{err}
+		}
+		return err
+	})
+	return uploadInfo, err
+}
+
+func (s *retryStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	var object io.ReadCloser
+	err := withRetry(ctx, s.policy, func() error {
+		var err error
+		object, err = s.store.GetObject(ctx, objectName, opts)
+		return err
+	})
+	return object, err
+}
+
+func (s *retryStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	var objectInfo minio.ObjectInfo
+	err := withRetry(ctx, s.policy, func() error {
+		var err error
+		objectInfo, err = s.store.StatObject(ctx, objectName)
+		return err
+	})
+	return objectInfo, err
+}
+
+// ListObjects retries the listing if it fails before yielding any object. Once objects were forwarded, a later error is
+// passed on to the caller since the listing cannot be resumed without duplicates.
+func (s *retryStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		var failure minio.ObjectInfo
+		err := withRetry(ctx, s.policy, func() error {
+			listing := s.store.ListObjects(ctx, startAfter)
+			first, ok := <-listing
+			if !ok {
+				return nil
+			}
+			if first.Err != nil {
+				failure = first
+				// Drain the failed listing so that its producer can terminate
+				for range listing {
+				}
+				return first.Err
+			}
+			sendObjectInfo(ctx, objects, first)
+			for object := range listing {
+				sendObjectInfo(ctx, objects, object)
+			}
+			return nil
+		})
+		if err != nil {
+			sendObjectInfo(ctx, objects, failure)
+		}
+	}()
+	return objects
+}
+
+// sendObjectInfo forwards an object on the listing channel, unless the listing was abandoned by cancelling the context.
+func sendObjectInfo(ctx context.Context, objects chan<- minio.ObjectInfo, object minio.ObjectInfo) {
+	select {
+	case objects <- object:
+	case <-ctx.Done():
+	}
+}
+
+func (s *retryStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.ReplaceMetadata(ctx, objectName, contentType, userMetadata)
+	})
+}
+
+func (s *retryStore) CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.CopyObject(ctx, srcObjectName, objectName, contentType, userMetadata)
+	})
+}
+
+func (s *retryStore) RemoveObject(ctx context.Context, objectName string) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.RemoveObject(ctx, objectName)
+	})
+}
+
+func (s *retryStore) AbortUpload(ctx context.Context, objectName string) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.AbortUpload(ctx, objectName)
+	})
+}
+
+func (s *retryStore) NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error) {
+	var uploadID string
+	err := withRetry(ctx, s.policy, func() error {
+		var err error
+		uploadID, err = s.store.NewMultipartUpload(ctx, objectName, opts)
+		return err
+	})
+	return uploadID, err
+}
+
+// PutObjectPart is only retried as long as no byte was consumed from the reader, like PutObject.
+func (s *retryStore) PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	countingReader := &countingReader{reader: reader}
+	var part minio.ObjectPart
+	err := withRetry(ctx, s.policy, func() error {
+		var err error
+		part, err = s.store.PutObjectPart(ctx, objectName, uploadID, partNumber, countingReader, size)
+		if err != nil && countingReader.count > 0 {
+			return permanentError{err}
+		}
+		return err
+	})
+	return part, err
+}
+
+func (s *retryStore) CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.CompleteMultipartUpload(ctx, objectName, uploadID, parts, opts)
+	})
+}
+
+func (s *retryStore) AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error {
+	return withRetry(ctx, s.policy, func() error {
+		return s.store.AbortMultipartUpload(ctx, objectName, uploadID)
+	})
+}
+
+// permanentError marks an error which must not be retried, whatever its cause.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
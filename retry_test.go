@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var errConnectionReset = &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+
+var testRetryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond}
+
+// flakyStore wraps a memoryStore and fails the given number of first calls of each operation with a transient error.
+type flakyStore struct {
+	*memoryStore
+	failures int
+	calls    map[string]int
+}
+
+func newFlakyStore(failures int) *flakyStore {
+	return &flakyStore{memoryStore: newMemoryStore(), failures: failures, calls: make(map[string]int)}
+}
+
+// fail records a call to the operation and tells whether it should fail.
+func (s *flakyStore) fail(operation string) bool {
+	s.calls[operation]++
+	return s.calls[operation] <= s.failures
+}
+
+func (s *flakyStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	if s.fail("PutObject") {
+		return minio.UploadInfo{}, errConnectionReset
+	}
+	return s.memoryStore.PutObject(ctx, objectName, reader, objectSize, opts)
+}
+
+func (s *flakyStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	if s.fail("GetObject") {
+		return nil, minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}
+	}
+	return s.memoryStore.GetObject(ctx, objectName, opts)
+}
+
+func (s *flakyStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	if s.fail("StatObject") {
+		return minio.ObjectInfo{}, errConnectionReset
+	}
+	return s.memoryStore.StatObject(ctx, objectName)
+}
+
+func (s *flakyStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	if s.fail("ListObjects") {
+		objects := make(chan minio.ObjectInfo, 1)
+		objects <- minio.ObjectInfo{Err: errConnectionReset}
+		close(objects)
+		return objects
+	}
+	return s.memoryStore.ListObjects(ctx, startAfter)
+}
+
+func TestRetryStoreSucceedsOnSecondAttempt(t *testing.T) {
+	flaky := newFlakyStore(1)
+	store := &retryStore{store: flaky, policy: testRetryPolicy}
+	ctx := context.Background()
+	content := []byte("retried content")
+
+	if _, err := store.PutObject(ctx, "1", bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{}); err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+	if _, err := store.StatObject(ctx, "1"); err != nil {
+		t.Fatalf("StatObject failed: %v", err)
+	}
+	object, err := store.GetObject(ctx, "1", minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("GetObject failed: %v", err)
+	}
+	if data, _ := io.ReadAll(object); !bytes.Equal(data, content) {
+		t.Errorf("GetObject returned %q, want %q", data, content)
+	}
+	var listed []string
+	for object := range store.ListObjects(ctx, "") {
+		if object.Err != nil {
+			t.Fatalf("ListObjects failed: %v", object.Err)
+		}
+		listed = append(listed, object.Key)
+	}
+	if len(listed) != 1 || listed[0] != "1" {
+		t.Errorf("ListObjects returned %v, want [1]", listed)
+	}
+
+	for _, operation := range []string{"PutObject", "StatObject", "GetObject", "ListObjects"} {
+		if flaky.calls[operation] != 2 {
+			t.Errorf("%s was called %d times, want 2", operation, flaky.calls[operation])
+		}
+	}
+}
+
+func TestRetryStoreGivesUpAfterMaxAttempts(t *testing.T) {
+	flaky := newFlakyStore(10)
+	store := &retryStore{store: flaky, policy: testRetryPolicy}
+
+	if _, err := store.StatObject(context.Background(), "1"); !errors.Is(err, errConnectionReset) {
+		t.Errorf("StatObject returned %v, want %v", err, errConnectionReset)
+	}
+	if flaky.calls["StatObject"] != testRetryPolicy.maxAttempts {
+		t.Errorf("StatObject was called %d times, want %d", flaky.calls["StatObject"], testRetryPolicy.maxAttempts)
+	}
+
+	var listErr error
+	for object := range store.ListObjects(context.Background(), "") {
+		listErr = object.Err
+	}
+	if !errors.Is(listErr, errConnectionReset) {
+		t.Errorf("ListObjects yielded %v, want %v", listErr, errConnectionReset)
+	}
+}
+
+func TestRetryStoreDoesNotRetryMissingObject(t *testing.T) {
+	flaky := newFlakyStore(0)
+	store := &retryStore{store: flaky, policy: testRetryPolicy}
+
+	if _, err := store.StatObject(context.Background(), "404"); err == nil {
+		t.Fatal("StatObject of a missing object succeeded")
+	}
+	if flaky.calls["StatObject"] != 1 {
+		t.Errorf("StatObject of a missing object was called %d times, want 1", flaky.calls["StatObject"])
+	}
+}
+
+// A stream which was partially uploaded cannot be replayed, so the upload must not be retried
+func TestRetryStoreDoesNotRetryConsumedUpload(t *testing.T) {
+	attempts := 0
+	op := &consumingStore{memoryStore: newMemoryStore(), attempts: &attempts}
+	store := &retryStore{store: op, policy: testRetryPolicy}
+
+	content := []byte("partially consumed")
+	if _, err := store.PutObject(context.Background(), "1", bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{}); err == nil {
+		t.Fatal("PutObject succeeded although the stream was cut")
+	}
+	if attempts != 1 {
+		t.Errorf("PutObject was attempted %d times, want 1", attempts)
+	}
+}
+
+// consumingStore fails uploads after reading part of the stream.
+type consumingStore struct {
+	*memoryStore
+	attempts *int
+}
+
+func (s *consumingStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	*s.attempts++
+	reader.Read(make([]byte, 4))
+	return minio.UploadInfo{}, errConnectionReset
+}
+
+func TestWithRetryRespectsDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	attempts := 0
+	start := time.Now()
+	err := withRetry(ctx, retryPolicy{maxAttempts: 5, baseDelay: time.Second}, func() error {
+		attempts++
+		return errConnectionReset
+	})
+	if !errors.Is(err, errConnectionReset) {
+		t.Errorf("withRetry returned %v, want %v", err, errConnectionReset)
+	}
+	if attempts != 1 {
+		t.Errorf("Attempted %d times, want 1 since the next attempt would exceed the deadline", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("withRetry waited %s past the context deadline", elapsed)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errConnectionReset, true},
+		{minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}, true},
+		{minio.ErrorResponse{Code: "InternalError", StatusCode: 500}, true},
+		{minio.ErrorResponse{StatusCode: 502}, true},
+		{errObjectNotFound, false},
+		{minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403}, false},
+		{minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: 404}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{permanentError{errConnectionReset}, false},
+		{&url.Error{Op: "Put", URL: "http://minio:9000/bucket/1", Err: errConnectionReset}, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{io.ErrClosedPipe, false},
+		{errors.New("encryption failed"), false},
+	}
+	for _, test := range tests {
+		if got := isRetryable(test.err); got != test.want {
+			t.Errorf("isRetryable(%v) = %t, want %t", test.err, got, test.want)
+		}
+	}
+}
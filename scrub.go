@@ -0,0 +1,87 @@
+package main
+
+import (
+	"api/cryptography"
+	"context"
+	"time"
+)
+
+// SCRUB_PAUSE is the pause between the verifications of two files by the scrubber, which keeps it from saturating
+// MinIO with downloads.
+const SCRUB_PAUSE = 100 * time.Millisecond
+
+// scrubReport counts the results of a verification of the stored files.
+type scrubReport struct {
+	verified  int
+	corrupted int
+	failed    int
+	// skipped counts the files which cannot be verified, because they expired or were stored without a checksum.
+	skipped int
+}
+
+// scrubObjects verifies every stored file, waiting pause between two of them, as /verify would. The failures are logged
+// and counted in the metrics, and only an error listing the objects stops the verification early, as does the context.
+func scrubObjects(ctx context.Context, store ObjectStore, cipher cryptography.Cipher, pause time.Duration) (scrubReport, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var report scrubReport
+	for listed := range store.ListObjects(listCtx, "") {
+		if listed.Err != nil {
+			return report, listed.Err
+		}
+		// The metadata of a listing is not named like that of an object, which computeStoredChecksum expects
+		objectInfo, err := store.StatObject(ctx, listed.Key)
+		if err != nil {
+			// The object may have been deleted since it was listed
+			report.skipped++
+			continue
+		}
+		storedChecksum, ok := objectInfo.UserMetadata[CHECKSUM_METADATA]
+		if !ok || isExpired(objectInfo.UserMetadata, time.Now()) {
+			report.skipped++
+			continue
+		}
+
+		checksum, err := computeStoredChecksum(ctx, store, cipher, objectInfo)
+		switch {
+		case ctx.Err() != nil:
+			return report, ctx.Err()
+		case err != nil:
+			report.failed++
+			scrubbedObjectsTotal.WithLabelValues("failed").Inc()
+			loggerFrom(ctx).Error("Scrubbing could not verify a file", "key", objectInfo.Key, "error", err)
+		case checksum != storedChecksum:
+			report.corrupted++
+			scrubbedObjectsTotal.WithLabelValues("corrupted").Inc()
+			loggerFrom(ctx).Error("Scrubbing found a corrupted file", "key", objectInfo.Key, "stored", storedChecksum, "computed", checksum)
+		default:
+			report.verified++
+			scrubbedObjectsTotal.WithLabelValues("ok").Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			return report, ctx.Err()
+		case <-time.After(pause):
+		}
+	}
+	return report, nil
+}
+
+// runScrubber verifies every stored file every interval, until the context is done.
+func runScrubber(ctx context.Context, store ObjectStore, cipher cryptography.Cipher, interval time.Duration, pause time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := scrubObjects(ctx, store, cipher, pause)
+			if err != nil && ctx.Err() == nil {
+				loggerFrom(ctx).Error("Failed to list the objects to scrub", "error", err)
+			}
+			loggerFrom(ctx).Info("Scrubbed the stored files", "verified", report.verified, "corrupted", report.corrupted, "failed", report.failed, "skipped", report.skipped)
+		}
+	}
+}
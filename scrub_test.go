@@ -0,0 +1,46 @@
+package main
+
+import (
+	"api/cryptography"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScrubObjectsDetectsCorruptedFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	uploadFile(t, store, "intact.txt", "text/plain", []byte("Steve's breakfast diary."))
+	corruptedName := uploadFile(t, store, "corrupted.txt", "text/plain", []byte("Steve's lunch diary."))
+	store.objects[corruptedName].data[cryptography.HEADER_SIZE] ^= 1
+	// A file without checksum cannot be verified
+	uncheckedName := uploadFile(t, store, "unchecked.txt", "text/plain", []byte("Steve's dinner diary."))
+	delete(store.objects[uncheckedName].info.UserMetadata, CHECKSUM_METADATA)
+
+	report, err := scrubObjects(context.Background(), store, newTestCipher(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (scrubReport{verified: 1, corrupted: 1, skipped: 1}); report != want {
+		t.Errorf("scrubObjects() = %+v, want %+v", report, want)
+	}
+}
+
+func TestScrubObjectsStopsWithContext(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	for _, filename := range []string{"first.txt", "second.txt", "third.txt"} {
+		uploadFile(t, store, filename, "text/plain", []byte("Steve's breakfast diary."))
+	}
+
+	// The context is cancelled while the scrubber pauses after the first file
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report, err := scrubObjects(ctx, store, newTestCipher(), time.Hour)
+	if err == nil {
+		t.Fatal("scrubObjects() did not stop once the context was done")
+	}
+	if report.verified != 1 {
+		t.Errorf("%d files were verified before the context was done, want 1", report.verified)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"api/cryptography"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// SELF_TEST_SIZE is the size in bytes of the random buffer encrypted by the self-test, spanning several AES blocks.
+const SELF_TEST_SIZE = 4096
+
+// selfTestHandler checks that the cipher decrypts what it encrypts, by encrypting a random buffer with the current key
+// and decrypting it. It answers 200 if the round-trip gives the buffer back, and 500 otherwise, e.g. if the key which
+// encrypts the files was registered under the ID of another key after a bad rotation.
+func selfTestHandler(cipher cryptography.Cipher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		if err := cryptoRoundTrip(r.Context(), cipher); err != nil {
+			loggerFrom(r.Context()).Error("Self-test failed", "error", err)
+			http.Error(w, "Self-test failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "Self-test passed: files are encrypted and decrypted with matching keys")
+	}
+}
+
+// cryptoRoundTrip encrypts and decrypts a random buffer with the cipher, and returns an error if it is not given back.
+func cryptoRoundTrip(ctx context.Context, cipher cryptography.Cipher) error {
+	plaintext := make([]byte, SELF_TEST_SIZE)
+	if _, err := rand.Read(plaintext); err != nil {
+		return err
+	}
+	var encrypted bytes.Buffer
+	if err := cipher.EncryptStreamCtx(ctx, bytes.NewReader(plaintext), &encrypted); err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+	if bytes.Contains(encrypted.Bytes(), plaintext) {
+		return errors.New("the encrypted buffer holds the plaintext")
+	}
+	var decrypted bytes.Buffer
+	if err := cipher.DecryptStreamCtx(ctx, &encrypted, &decrypted); err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plaintext) {
+		return errors.New("the decrypted buffer differs from the encrypted one")
+	}
+	return nil
+}
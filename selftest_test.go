@@ -0,0 +1,47 @@
+package main
+
+import (
+	"api/cryptography"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// divergentCipher decrypts the streams with a key other than the one which encrypted them, registered under the same
+// ID, like after a bad rotation.
+type divergentCipher struct {
+	*cryptography.StreamCipher
+	decryptor *cryptography.StreamCipher
+}
+
+func (c divergentCipher) DecryptStreamCtx(ctx context.Context, reader io.Reader, writer io.Writer) error {
+	return c.decryptor.DecryptStreamCtx(ctx, reader, writer)
+}
+
+func runSelfTest(cipher cryptography.Cipher) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	selfTestHandler(cipher)(w, httptest.NewRequest(http.MethodGet, "/selftest", nil))
+	return w
+}
+
+func TestSelfTestPasses(t *testing.T) {
+	if w := runSelfTest(newTestCipher()); w.Code != http.StatusOK {
+		t.Errorf("Self-test gave status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestSelfTestReportsBrokenCipher(t *testing.T) {
+	decryptor := &cryptography.StreamCipher{}
+	decryptor.Init("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	for name, cipher := range map[string]cryptography.Cipher{
+		"diverging keys":       divergentCipher{newTestCipher(), decryptor},
+		"failing decryption":   failingDecryptionCipher{newTestCipher()},
+		"uninitialized cipher": &cryptography.StreamCipher{},
+	} {
+		if w := runSelfTest(cipher); w.Code != http.StatusInternalServerError {
+			t.Errorf("Self-test with %s gave status %d, want %d", name, w.Code, http.StatusInternalServerError)
+		}
+	}
+}
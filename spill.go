@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// putSpilledObject uploads an object whose size is unknown by first writing it to a temporary file in dir, to learn its
+// size, then uploading that file in one go. Unlike uploading the object by parts, this keeps the memory used by the
+// upload bounded, whatever the part size. The temporary file is deleted once the upload is over, whether it succeeded
+// or not.
+func putSpilledObject(ctx context.Context, store uploadStore, dir string, objectName string, reader io.Reader, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	spill, err := os.CreateTemp(dir, "upload-"+objectUid(objectName)+"-*")
+	if err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("unable to create a spill file: %v", err)
+	}
+	defer os.Remove(spill.Name())
+	defer spill.Close()
+
+	objectSize, err := io.Copy(spill, reader)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if _, err := spill.Seek(0, io.SeekStart); err != nil {
+		return minio.UploadInfo{}, fmt.Errorf("unable to read the spill file: %v", err)
+	}
+	return store.PutObject(ctx, objectName, spill, objectSize, opts)
+}
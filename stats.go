@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Listing the whole bucket is costly, so the storage statistics are computed at most once per this duration.
+const STATS_CACHE_DURATION = 30 * time.Second
+
+// storageStats summarizes the usage of the storage.
+type storageStats struct {
+	// UidsInUse is the number of UIDs assigned to files in every namespace, including the files being uploaded
+	UidsInUse int `json:"uidsInUse"`
+	// Objects is the number of objects in the bucket
+	Objects int `json:"objects"`
+	// StoredBytes is the size of the objects, which includes the encryption headers
+	StoredBytes int64 `json:"storedBytes"`
+	// PlaintextBytes is the size of the stored files once decrypted, compressed files counting for their compressed size
+	PlaintextBytes int64 `json:"plaintextBytes"`
+	// AverageFileSize is the average number of plaintext bytes per object
+	AverageFileSize int64 `json:"averageFileSize"`
+	// ComputedAt is the time at which the object statistics were computed
+	ComputedAt time.Time `json:"computedAt"`
+}
+
+// statsHandler returns storage usage statistics as JSON. The statistics of the objects are cached for maxAge. The
+// bucket is listed without holding the cache, so that a slow listing does not block the requests served from it.
+func statsHandler(store ObjectStore, maxAge time.Duration) http.HandlerFunc {
+	var mu sync.Mutex
+	var cached storageStats
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet, http.MethodHead) {
+			return
+		}
+		mu.Lock()
+		stats := cached
+		mu.Unlock()
+		if stats.ComputedAt.IsZero() || time.Since(stats.ComputedAt) >= maxAge {
+			var err error
+			stats, err = computeStorageStats(r.Context(), store)
+			if err != nil {
+				loggerFrom(r.Context()).Error("Unable to compute the storage statistics", "error", err)
+				http.Error(w, "Unable to list the objects in MinIO", http.StatusInternalServerError)
+				return
+			}
+			mu.Lock()
+			// A listing which started earlier may finish later, and must not replace more recent statistics
+			if stats.ComputedAt.After(cached.ComputedAt) {
+				cached = stats
+			}
+			mu.Unlock()
+		}
+
+		// The trackers are in memory, so their count is always up to date
+		stats.UidsInUse = uidTracker.Count() + namespaceTrackers.count()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			loggerFrom(r.Context()).Error("Unable to write the storage statistics", "error", err)
+		}
+	}
+}
+
+// computeStorageStats lists the objects of the bucket to sum up their sizes.
+func computeStorageStats(ctx context.Context, store ObjectStore) (storageStats, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stats := storageStats{ComputedAt: time.Now()}
+	for obj := range store.ListObjects(listCtx, "") {
+		if obj.Err != nil {
+			return storageStats{}, obj.Err
+		}
+		stats.Objects++
+		stats.StoredBytes += obj.Size
+		// The objects whose header cannot be read count for none of the plaintext bytes
+		if plaintextSize, err := getPlaintextSize(listCtx, store, obj); err == nil {
+			stats.PlaintextBytes += max(plaintextSize, 0)
+		}
+	}
+	if stats.Objects > 0 {
+		stats.AverageFileSize = stats.PlaintextBytes / int64(stats.Objects)
+	}
+	return stats, nil
+}
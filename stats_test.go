@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api/cryptography"
+)
+
+// getStats runs a request through the stats handler and decodes its response.
+func getStats(t *testing.T, handler http.HandlerFunc) storageStats {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Stats failed with status %d: %s", w.Code, w.Body.String())
+	}
+	// The fields are named in camelCase, as in the other JSON responses
+	for _, key := range []string{`"uidsInUse":`, `"storedBytes":`, `"plaintextBytes":`, `"averageFileSize":`, `"computedAt":`} {
+		if !strings.Contains(w.Body.String(), key) {
+			t.Errorf("Response %q lacks the field %s", w.Body.String(), key)
+		}
+	}
+	var stats storageStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Response %q is not JSON: %v", w.Body.String(), err)
+	}
+	return stats
+}
+
+func TestStatsSummarizesStorage(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	uploadFile(t, store, "short.txt", "text/plain", []byte("Ten bytes!"))
+	uploadFile(t, store, "long.txt", "text/plain", []byte("Thirty bytes, give or take..."))
+
+	stats := getStats(t, statsHandler(store, 0))
+	want := storageStats{
+		UidsInUse:       2,
+		Objects:         2,
+		StoredBytes:     10 + 29 + 2*cryptography.HEADER_SIZE,
+		PlaintextBytes:  10 + 29,
+		AverageFileSize: (10 + 29) / 2,
+	}
+	stats.ComputedAt = time.Time{}
+	if stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestStatsAreCached(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	uploadFile(t, store, "first.txt", "text/plain", []byte("first"))
+	handler := statsHandler(store, time.Hour)
+	if stats := getStats(t, handler); stats.Objects != 1 {
+		t.Fatalf("Stats report %d objects, want 1", stats.Objects)
+	}
+
+	uploadFile(t, store, "second.txt", "text/plain", []byte("second"))
+	stats := getStats(t, handler)
+	if stats.Objects != 1 {
+		t.Errorf("Cached stats report %d objects, want the 1 object listed at first", stats.Objects)
+	}
+	if stats.UidsInUse != 2 {
+		t.Errorf("Stats report %d UIDs in use, want 2", stats.UidsInUse)
+	}
+}
+
+func TestStatsCountUidsOfNamespaces(t *testing.T) {
+	uidTracker.Init(nil)
+	namespaceTrackers = namespaceIndex{}
+	store := newMemoryStore()
+	uploadFile(t, store, "default.txt", "text/plain", []byte("In the default namespace"))
+	for _, namespace := range []string{"alice", "bob"} {
+		if w := uploadToNamespace(t, store, namespace, "42", []byte("In a namespace")); w.Code != http.StatusOK {
+			t.Fatalf("Upload to namespace %s failed with status %d: %s", namespace, w.Code, w.Body.String())
+		}
+	}
+
+	if stats := getStats(t, statsHandler(store, 0)); stats.UidsInUse != 3 || stats.Objects != 3 {
+		t.Errorf("Stats report %d UIDs in use and %d objects, want 3 of each", stats.UidsInUse, stats.Objects)
+	}
+}
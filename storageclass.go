@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// STORAGE_CLASS_METADATA is the metadata holding the storage class a file was stored with, if one was chosen through
+// the X-Storage-Class header. It keeps the class visible along with the other details of the file, and lets the
+// copies which replace the metadata of the object keep its class.
+const STORAGE_CLASS_METADATA = "Storage-Class"
+
+// The storage classes MinIO supports, which map to the parity configured for each of them. Reduced redundancy trades
+// durability for storage space.
+const STORAGE_CLASS_STANDARD = "STANDARD"
+const STORAGE_CLASS_REDUCED_REDUNDANCY = "REDUCED_REDUNDANCY"
+
+var storageClasses = []string{STORAGE_CLASS_STANDARD, STORAGE_CLASS_REDUCED_REDUNDANCY}
+
+// parseStorageClass parses the X-Storage-Class header of an upload. An empty header stores the files in the default
+// class of the deployment, and is returned as an empty class.
+func parseStorageClass(header string) (string, error) {
+	storageClass := strings.TrimSpace(header)
+	if storageClass == "" || slices.Contains(storageClasses, storageClass) {
+		return storageClass, nil
+	}
+	return "", fmt.Errorf("X-Storage-Class should be one of %s, got %q", strings.Join(storageClasses, ", "), header)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// optsRecordingStore is a memoryStore recording the options given to PutObject.
+type optsRecordingStore struct {
+	*memoryStore
+	putOpts []minio.PutObjectOptions
+}
+
+func (s *optsRecordingStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	s.putOpts = append(s.putOpts, opts)
+	return s.memoryStore.PutObject(ctx, objectName, reader, objectSize, opts)
+}
+
+func TestUploadForwardsStorageClass(t *testing.T) {
+	uidTracker.Init(nil)
+	store := &optsRecordingStore{memoryStore: newMemoryStore()}
+	r := newUploadRequest(t, "cheap.txt", "text/plain", []byte("Stored with less redundancy"))
+	r.Header.Set("X-Storage-Class", STORAGE_CLASS_REDUCED_REDUNDANCY)
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if len(store.putOpts) != 1 || store.putOpts[0].StorageClass != STORAGE_CLASS_REDUCED_REDUNDANCY {
+		t.Fatalf("PutObject was given the options %+v, want the storage class %s", store.putOpts, STORAGE_CLASS_REDUCED_REDUNDANCY)
+	}
+
+	// The class is kept in the metadata, and described by /info
+	objectName := uidFromResponse(w.Body.String())
+	w = httptest.NewRecorder()
+	infoHandler(store)(w, httptest.NewRequest(http.MethodGet, "/info?uid="+objectName, nil))
+	var info fileInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Invalid info %q: %v", w.Body.String(), err)
+	}
+	if info.StorageClass != STORAGE_CLASS_REDUCED_REDUNDANCY {
+		t.Errorf("/info gave the storage class %q, want %s", info.StorageClass, STORAGE_CLASS_REDUCED_REDUNDANCY)
+	}
+}
+
+func TestUploadRejectsUnknownStorageClass(t *testing.T) {
+	uidTracker.Init(nil)
+	store := &optsRecordingStore{memoryStore: newMemoryStore()}
+	r := newUploadRequest(t, "glacial.txt", "text/plain", []byte("Nowhere to store this"))
+	r.Header.Set("X-Storage-Class", "GLACIER")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with an unknown storage class gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(store.putOpts) != 0 {
+		t.Error("The file was stored despite its unknown storage class")
+	}
+}
+
+func TestParseStorageClass(t *testing.T) {
+	for header, want := range map[string]string{"": "", "STANDARD": STORAGE_CLASS_STANDARD, " REDUCED_REDUNDANCY ": STORAGE_CLASS_REDUCED_REDUNDANCY} {
+		if got, err := parseStorageClass(header); err != nil || got != want {
+			t.Errorf("parseStorageClass(%q) = %q, %v, want %q", header, got, err, want)
+		}
+	}
+	for _, header := range []string{"standard", "GLACIER", "STANDARD_IA"} {
+		if _, err := parseStorageClass(header); err == nil {
+			t.Errorf("parseStorageClass(%q) accepted an unknown class", header)
+		}
+	}
+}
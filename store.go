@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ObjectStore is the narrow set of object storage operations the handlers which read the stored files rely on.
+// It allows the HTTP logic to be exercised without a running MinIO deployment, against an in-memory store.
+type ObjectStore interface {
+	PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error)
+	RemoveObject(ctx context.Context, objectName string) error
+	ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo
+	StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error)
+}
+
+// uploadStore adds to ObjectStore the operations the uploads need to rewrite the metadata of the objects and to store
+// them by parts.
+type uploadStore interface {
+	ObjectStore
+	ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error
+	CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error
+	AbortUpload(ctx context.Context, objectName string) error
+	NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error)
+	PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error)
+	CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error
+	AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error
+}
+
+// minioStore implements uploadStore on top of a MinIO client, scoped to a single bucket.
+type minioStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func (s *minioStore) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	return s.client.PutObject(ctx, s.bucket, objectName, reader, objectSize, opts)
+}
+
+func (s *minioStore) GetObject(ctx context.Context, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, objectName, opts)
+}
+
+func (s *minioStore) StatObject(ctx context.Context, objectName string) (minio.ObjectInfo, error) {
+	return s.client.StatObject(ctx, s.bucket, objectName, minio.StatObjectOptions{})
+}
+
+// ListObjects lists the objects whose name comes after startAfter in lexicographic order, along with their user
+// metadata, which is a MinIO extension of the S3 API. An empty startAfter lists all the objects, those of the
+// namespaces included.
+func (s *minioStore) ListObjects(ctx context.Context, startAfter string) <-chan minio.ObjectInfo {
+	return s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{WithMetadata: true, StartAfter: startAfter, Recursive: true})
+}
+
+func (s *minioStore) RemoveObject(ctx context.Context, objectName string) error {
+	return s.client.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+// AbortUpload removes the parts uploaded by the incomplete multipart uploads of an object.
+func (s *minioStore) AbortUpload(ctx context.Context, objectName string) error {
+	return s.client.RemoveIncompleteUpload(ctx, s.bucket, objectName)
+}
+
+// NewMultipartUpload starts a multipart upload of an object, whose parts are then uploaded one by one with
+// PutObjectPart, and returns its ID. The object only exists once the upload is completed with CompleteMultipartUpload.
+func (s *minioStore) NewMultipartUpload(ctx context.Context, objectName string, opts minio.PutObjectOptions) (string, error) {
+	return minio.Core{Client: s.client}.NewMultipartUpload(ctx, s.bucket, objectName, opts)
+}
+
+func (s *minioStore) PutObjectPart(ctx context.Context, objectName string, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	return minio.Core{Client: s.client}.PutObjectPart(ctx, s.bucket, objectName, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+}
+
+// CompleteMultipartUpload assembles the given parts, in the order of their numbers, into the object.
+func (s *minioStore) CompleteMultipartUpload(ctx context.Context, objectName string, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) error {
+	_, err := minio.Core{Client: s.client}.CompleteMultipartUpload(ctx, s.bucket, objectName, uploadID, parts, opts)
+	return err
+}
+
+func (s *minioStore) AbortMultipartUpload(ctx context.Context, objectName string, uploadID string) error {
+	return minio.Core{Client: s.client}.AbortMultipartUpload(ctx, s.bucket, objectName, uploadID)
+}
+
+// ReplaceMetadata replaces the content type and user metadata of an object through a server-side copy onto itself.
+// The content of the object is left untouched.
+func (s *minioStore) ReplaceMetadata(ctx context.Context, objectName string, contentType string, userMetadata map[string]string) error {
+	return s.CopyObject(ctx, objectName, objectName, contentType, userMetadata)
+}
+
+// CopyObject copies the content of an object to another one through a server-side copy, with the given content type
+// and user metadata. A copy is stored in the default storage class unless another one is given, so the class recorded
+// in the metadata is passed on.
+func (s *minioStore) CopyObject(ctx context.Context, srcObjectName string, objectName string, contentType string, userMetadata map[string]string) error {
+	metadata := maps.Clone(userMetadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata["Content-Type"] = contentType
+	if storageClass, ok := userMetadata[STORAGE_CLASS_METADATA]; ok {
+		metadata["X-Amz-Storage-Class"] = storageClass
+	}
+	_, err := s.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: objectName, UserMetadata: metadata, ReplaceMetadata: true},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: srcObjectName},
+	)
+	return err
+}
+
+// metadataValue returns the value of the user metadata with the given name, if the object has it. Objects described by
+// StatObject hold their metadata under its name, while listings return it under its full header name, X-Amz-Meta-Name,
+// and the case of either depends on the server, hence the lookup of both in any case.
+func metadataValue(userMetadata map[string]string, name string) (string, bool) {
+	for key, value := range userMetadata {
+		if strings.EqualFold(key, name) || strings.EqualFold(key, "X-Amz-Meta-"+name) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// bucketMaker is the subset of the MinIO client used to prepare the bucket at startup.
+type bucketMaker interface {
+	BucketExists(ctx context.Context, bucketName string) (bool, error)
+	MakeBucket(ctx context.Context, bucketName string, opts minio.MakeBucketOptions) error
+}
+
+// ensureBucket creates the bucket in the given region if it does not exist yet, so that a fresh MinIO deployment
+// works out of the box.
+func ensureBucket(ctx context.Context, maker bucketMaker, bucketName string, region string) error {
+	exists, err := maker.BucketExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("unable to check whether bucket %s exists: %v", bucketName, err)
+	}
+	if exists {
+		return nil
+	}
+	log.Printf("Bucket %s does not exist, creating it in region %s", bucketName, region)
+	if err = maker.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: region}); err != nil {
+		return fmt.Errorf("unable to create bucket %s: %v", bucketName, err)
+	}
+	return nil
+}
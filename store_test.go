@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// fakeBucketMaker records the buckets created through it, starting with the given existing buckets.
+type fakeBucketMaker struct {
+	buckets map[string]string
+	err     error
+}
+
+func (m *fakeBucketMaker) BucketExists(ctx context.Context, bucketName string) (bool, error) {
+	if m.err != nil {
+		return false, m.err
+	}
+	_, ok := m.buckets[bucketName]
+	return ok, nil
+}
+
+func (m *fakeBucketMaker) MakeBucket(ctx context.Context, bucketName string, opts minio.MakeBucketOptions) error {
+	m.buckets[bucketName] = opts.Region
+	return nil
+}
+
+func TestEnsureBucketCreatesMissingBucket(t *testing.T) {
+	maker := &fakeBucketMaker{buckets: map[string]string{}}
+	if err := ensureBucket(context.Background(), maker, "challenge-taurus", "eu-west-1"); err != nil {
+		t.Fatalf("ensureBucket failed: %v", err)
+	}
+	region, ok := maker.buckets["challenge-taurus"]
+	if !ok {
+		t.Fatal("The missing bucket was not created")
+	}
+	if region != "eu-west-1" {
+		t.Errorf("The bucket was created in region %q, want %q", region, "eu-west-1")
+	}
+}
+
+func TestEnsureBucketKeepsExistingBucket(t *testing.T) {
+	maker := &fakeBucketMaker{buckets: map[string]string{"challenge-taurus": "us-east-1"}}
+	if err := ensureBucket(context.Background(), maker, "challenge-taurus", "eu-west-1"); err != nil {
+		t.Fatalf("ensureBucket failed: %v", err)
+	}
+	if region := maker.buckets["challenge-taurus"]; region != "us-east-1" {
+		t.Errorf("The existing bucket was recreated in region %q", region)
+	}
+}
+
+func TestEnsureBucketReportsErrors(t *testing.T) {
+	maker := &fakeBucketMaker{buckets: map[string]string{}, err: errors.New("connection refused")}
+	if err := ensureBucket(context.Background(), maker, "challenge-taurus", "us-east-1"); err == nil {
+		t.Error("ensureBucket did not report the failure to reach MinIO")
+	}
+	if len(maker.buckets) != 0 {
+		t.Error("A bucket was created although its existence could not be checked")
+	}
+}
+
+func TestMetadataValue(t *testing.T) {
+	tests := map[string]struct {
+		userMetadata map[string]string
+		value        string
+		ok           bool
+	}{
+		"stat":        {map[string]string{"Filename": "report.txt"}, "report.txt", true},
+		"listing":     {map[string]string{"X-Amz-Meta-Filename": "report.txt"}, "report.txt", true},
+		"lowercase":   {map[string]string{"x-amz-meta-filename": "report.txt"}, "report.txt", true},
+		"empty value": {map[string]string{"Filename": ""}, "", true},
+		"other name":  {map[string]string{"X-Amz-Meta-Filenames": "report.txt"}, "", false},
+		"no metadata": {nil, "", false},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, ok := metadataValue(test.userMetadata, "Filename")
+			if value != test.value || ok != test.ok {
+				t.Errorf("metadataValue = %q, %t, want %q, %t", value, ok, test.value, test.ok)
+			}
+		})
+	}
+}
+
+// readOnlyStore hides every operation of a store but those of ObjectStore.
+type readOnlyStore struct {
+	ObjectStore
+}
+
+func TestFetchThroughObjectStore(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Read through the narrow interface")
+	objectName := uploadFile(t, store, "narrow.txt", "text/plain", content)
+
+	w := fetchFile(readOnlyStore{store}, objectName, nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("Fetch gave status %d and %q, want the uploaded file", w.Code, w.Body.Bytes())
+	}
+	if page, status := listPage(t, readOnlyStore{store}, ""); status != http.StatusOK || len(page.Files) != 1 {
+		t.Errorf("Listing gave status %d and %+v, want the uploaded file", status, page.Files)
+	}
+}
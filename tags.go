@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// parseTags parses the X-Tags header of an upload, a comma-separated list of key=value pairs, into the MinIO object
+// tags of the uploaded files. The tags must follow the S3 rules: at most 10 tags per object, keys of up to 128
+// characters and values of up to 256 characters, made of letters, digits, spaces and the characters +-=._:/@.
+func parseTags(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+	tagMap := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, err := parseTag(pair)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tagMap[key]; ok {
+			return nil, fmt.Errorf("tag %q is set several times", key)
+		}
+		tagMap[key] = value
+	}
+	if err := checkTags(tagMap); err != nil {
+		return nil, err
+	}
+	return tagMap, nil
+}
+
+// checkTags returns an error if the tags do not follow the S3 rules described in parseTags.
+func checkTags(tagMap map[string]string) error {
+	if _, err := tags.NewTags(tagMap, true); err != nil {
+		return fmt.Errorf("invalid tags: %v", err)
+	}
+	return nil
+}
+
+// parseTag parses a tag written as key=value. The value may be empty, but not the key.
+func parseTag(pair string) (string, string, error) {
+	key, value, ok := strings.Cut(pair, "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("tag %q should be written as key=value", strings.TrimSpace(pair))
+	}
+	return key, value, nil
+}
+
+// hasTags tells whether the tags of an object hold every one of the wanted tags.
+func hasTags(objectTags map[string]string, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if objectValue, ok := objectTags[key]; !ok || objectValue != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	for header, want := range map[string]map[string]string{
+		"":                         nil,
+		"project=alpha":            {"project": "alpha"},
+		" project = alpha , team=": {"project": "alpha", "team": ""},
+		"path=a/b:c@d.e+f-g_h":     {"path": "a/b:c@d.e+f-g_h"},
+	} {
+		got, err := parseTags(header)
+		if err != nil || !maps.Equal(got, want) {
+			t.Errorf("parseTags(%q) = %v, %v, want %v", header, got, err, want)
+		}
+	}
+
+	var tooMany []string
+	for _, key := range strings.Split("a,b,c,d,e,f,g,h,i,j,k", ",") {
+		tooMany = append(tooMany, key+"=1")
+	}
+	for _, header := range []string{"project", "=alpha", "project=alpha,project=beta", "project=al,pha", "project=<alpha>", strings.Join(tooMany, ","), strings.Repeat("k", 129) + "=v"} {
+		if _, err := parseTags(header); err == nil {
+			t.Errorf("parseTags(%q) accepted invalid tags", header)
+		}
+	}
+}
+
+func TestUploadRejectsInvalidTags(t *testing.T) {
+	uidTracker.Init(nil)
+	r := newUploadRequest(t, "tagged.txt", "text/plain", []byte("Badly tagged"))
+	r.Header.Set("X-Tags", "project")
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Upload with invalid tags gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
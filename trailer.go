@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CHECKSUM_TRAILER is the trailer holding the SHA-256 checksum of a fetched file, computed while it was sent.
+const CHECKSUM_TRAILER = "X-Content-SHA256"
+
+// acceptsTrailers returns true if the client announced that it reads the trailers of the response, with `TE: trailers`.
+func acceptsTrailers(r *http.Request) bool {
+	for _, value := range r.Header.Values("TE") {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			if strings.EqualFold(strings.TrimSpace(coding), "trailers") {
+				return true
+			}
+		}
+	}
+	return false
+}
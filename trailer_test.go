@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptsTrailers(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "trailers": true, "gzip, Trailers": true, "deflate;q=0.5": false} {
+		r := httptest.NewRequest(http.MethodGet, "/fetch", nil)
+		if value != "" {
+			r.Header.Set("TE", value)
+		}
+		if got := acceptsTrailers(r); got != want {
+			t.Errorf("acceptsTrailers with TE %q = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestFetchSendsChecksumTrailer(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := bytes.Repeat([]byte("Streamed and hashed on the way. "), 1000)
+	objectName := uploadFile(t, store, "streamed.txt", "text/plain", content)
+	expectedChecksum := sha256.Sum256(content)
+
+	// Both the streamed and the buffered fetches send the trailer
+	for _, bufferedFetchMaxSize := range []int64{0, int64(len(content))} {
+		cfg := defaultConfig()
+		cfg.bufferedFetchMaxSize = bufferedFetchMaxSize
+		server := httptest.NewServer(fetchAndDecryptHandler(store, newTestCipher(), cfg))
+		r, err := http.NewRequest(http.MethodGet, server.URL+"/fetch?uid="+objectName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("TE", "trailers")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, content) {
+			t.Errorf("Fetched %d bytes with BUFFERED_FETCH_MAX_SIZE %d, want the %d of the file", len(body), bufferedFetchMaxSize, len(content))
+		}
+		// The trailer is only known once the body was read
+		if got := resp.Trailer.Get(CHECKSUM_TRAILER); got != hex.EncodeToString(expectedChecksum[:]) {
+			t.Errorf("Trailer %s with BUFFERED_FETCH_MAX_SIZE %d = %q, want %x", CHECKSUM_TRAILER, bufferedFetchMaxSize, got, expectedChecksum)
+		}
+	}
+}
+
+func TestFetchWithoutTrailersAnnouncesLength(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	content := []byte("Sent with its length")
+	objectName := uploadFile(t, store, "length.txt", "text/plain", content)
+
+	w := fetchFile(store, objectName, nil)
+	if w.Header().Get("Trailer") != "" {
+		t.Errorf("A trailer was declared to a client which does not read them: %q", w.Header().Get("Trailer"))
+	}
+	if got := w.Header().Get("Content-Length"); got != "20" {
+		t.Errorf("Content-Length = %q, want 20", got)
+	}
+}
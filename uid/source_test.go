@@ -0,0 +1,43 @@
+package uid_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"api/uid"
+)
+
+func TestNewUidTrackerDrawsFromSource(t *testing.T) {
+	drawn := []uint64{32, 32, 7}
+	tracker := uid.NewUidTracker(func() uint64 {
+		value := drawn[0]
+		drawn = drawn[1:]
+		return value
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	first, err := tracker.GenerateAndAdd(ctx)
+	if err != nil || first != 32 {
+		t.Fatalf("GenerateAndAdd() = %d, %v, want the first drawn UID 32", first, err)
+	}
+	// The UID drawn again collides, and the next one is used
+	second, attempts, err := tracker.GenerateAndAddWithAttempts(ctx)
+	if err != nil || second != 7 || attempts != 2 {
+		t.Errorf("GenerateAndAddWithAttempts() = %d, %d, %v, want 7 in 2 attempts", second, attempts, err)
+	}
+	if tracker.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", tracker.Count())
+	}
+}
+
+func TestNewUidTrackerWithoutSource(t *testing.T) {
+	tracker := uid.NewUidTracker(nil)
+	if _, err := tracker.GenerateAndAdd(context.Background()); err != nil {
+		t.Fatalf("Generation from crypto/rand failed: %v", err)
+	}
+	if tracker.Count() != 1 {
+		t.Errorf("Count() = %d, want 1", tracker.Count())
+	}
+}
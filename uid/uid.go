@@ -2,17 +2,66 @@ package uid
 
 import (
 	"context"
+	cryptorand "crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math/rand/v2"
 	"sync"
+	"time"
 )
 
+const (
+	// MAX_GENERATION_ATTEMPTS bounds the number of UIDs GenerateAndAdd draws before giving up, and AddUid draws to
+	// recommend one.
+	MAX_GENERATION_ATTEMPTS = 10
+	// GENERATION_JITTER is the maximum pause between two attempts of GenerateAndAdd, so that callers which collided do
+	// not retry in lockstep.
+	GENERATION_JITTER = 100 * time.Microsecond
+)
+
+// uidSource draws candidate UIDs, which may already be in use.
+type uidSource func() uint64
+
+// cryptoSource draws UIDs from crypto/rand, so that the UIDs of the stored files cannot be predicted from one another.
+func cryptoSource() uint64 {
+	var b [8]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		// The system's random number generator is not expected to fail, and no UID can be safely drawn without it
+		panic(fmt.Sprintf("unable to draw a UID: %v", err))
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
 // UidTracker is a concurrent thread-safe set which tracks the UIDs currently used in the system.
 // It ensures atomicity of Add and Contain results by holding a lock on the set when performing these operations.
+// The zero value is an empty tracker ready to use, Init only being needed to start with UIDs in use.
 type UidTracker struct {
 	uids map[uint64]bool
 	mu   sync.Mutex
+	// source draws the candidate UIDs, cryptoSource if nil. Tests set it through NewUidTracker to cause collisions.
+	source uidSource
+}
+
+// NewUidTracker returns an empty tracker drawing its candidate UIDs from source, or from crypto/rand if source is nil.
+// Tests give it a predictable source to cause collisions.
+func NewUidTracker(source func() uint64) *UidTracker {
+	return &UidTracker{uids: make(map[uint64]bool), source: source}
+}
+
+// lazyInit allocates the set of a tracker which was never initialized. The lock must be held.
+func (t *UidTracker) lazyInit() {
+	if t.uids == nil {
+		t.uids = make(map[uint64]bool)
+	}
+}
+
+// draw returns a candidate UID from the source of the tracker, which may already be in use.
+func (t *UidTracker) draw() uint64 {
+	if t.source != nil {
+		return t.source()
+	}
+	return cryptoSource()
 }
 
 // AddUid returns a nil error and the added uid if the given uid was successfully added to the UidTracker.
@@ -20,21 +69,36 @@ type UidTracker struct {
 func (t *UidTracker) AddUid(uid uint64) (uint64, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	t.lazyInit()
 	// The uid is already in use
 	if _, ok := t.uids[uid]; ok {
-		for {
-			recommended := rand.Uint64()
+		// Recommend an unused UID, unless MAX_GENERATION_ATTEMPTS of them in a row were in use too
+		for attempt := 1; attempt <= MAX_GENERATION_ATTEMPTS; attempt++ {
+			recommended := t.draw()
 			if _, ok = t.uids[recommended]; !ok {
-				// Recommend
 				return 0, fmt.Errorf("UID %d already used in the system, please retry with %d", uid, recommended)
 			}
 		}
+		return 0, fmt.Errorf("UID %d already used in the system", uid)
 	}
 	// If not used, add it and return
 	t.uids[uid] = true
 	return uid, nil
 }
 
+// AddOrKeep adds the uid to the UidTracker if it is not in use, and returns whether it already was. Unlike AddUid, a
+// uid in use is not a conflict, for callers which replace the file stored under it and keep using the uid.
+func (t *UidTracker) AddOrKeep(uid uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lazyInit()
+	if _, ok := t.uids[uid]; ok {
+		return true
+	}
+	t.uids[uid] = true
+	return false
+}
+
 // Init initializes a UidTracker with the elements in the provided array. Any duplicates in this array will only be added once.
 func (t *UidTracker) Init(initialElems []uint64) {
 	t.mu.Lock()
@@ -46,22 +110,76 @@ func (t *UidTracker) Init(initialElems []uint64) {
 }
 
 // GenerateAndAdd attempts to generate a non-used UID. If the context times-out or interrupts before a non-used UID is found,
-// an error is returned. If the error is nil, the value can be used as a valid UID.
+// or if MAX_GENERATION_ATTEMPTS UIDs in a row were already in use, an error is returned. If the error is nil, the value
+// can be used as a valid UID.
 func (t *UidTracker) GenerateAndAdd(ctx context.Context) (uint64, error) {
+	uid, _, err := t.GenerateAndAddWithAttempts(ctx)
+	return uid, err
+}
+
+// GenerateAndAddWithAttempts behaves like GenerateAndAdd, and also returns the number of UIDs which were drawn, so that
+// callers can tell how many attempts collisions caused.
+func (t *UidTracker) GenerateAndAddWithAttempts(ctx context.Context) (uint64, int, error) {
+	for attempt := 1; attempt <= MAX_GENERATION_ATTEMPTS; attempt++ {
+		if attempt > 1 {
+			// Wait a jittered pause without holding the lock before retrying
+			pause := time.NewTimer(rand.N(GENERATION_JITTER))
+			select {
+			case <-ctx.Done():
+				pause.Stop()
+				return 0, attempt - 1, errors.New("UID generation timed out.")
+			case <-pause.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return 0, attempt - 1, errors.New("UID generation timed out.")
+		default:
+		}
+		if uid, ok := t.tryAdd(); ok {
+			return uid, attempt, nil
+		}
+	}
+	return 0, MAX_GENERATION_ATTEMPTS, fmt.Errorf("no unused UID found after %d attempts", MAX_GENERATION_ATTEMPTS)
+}
+
+// tryAdd draws a single UID and adds it if it is not in use.
+func (t *UidTracker) tryAdd() (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lazyInit()
+	try := t.draw()
+	if _, ok := t.uids[try]; ok {
+		return 0, false
+	}
+	t.uids[try] = true
+	return try, true
+}
+
+// GenerateAndAddBatch generates n non-used UIDs and adds them all at once, so that no other caller can be given any of
+// them. If the context times-out or interrupts before they are all found, none is added and an error is returned.
+func (t *UidTracker) GenerateAndAddBatch(ctx context.Context, n int) ([]uint64, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	select {
-	case <-ctx.Done():
-		return 0, errors.New("UID generation timed out.")
-	default:
-		// Continue trying to generate a unique UID
-		try := rand.Uint64()
-		if _, ok := t.uids[try]; !ok {
-			t.uids[try] = true
-			return try, nil
+	t.lazyInit()
+	batch := make([]uint64, 0, n)
+	for len(batch) < n {
+		select {
+		case <-ctx.Done():
+			// Free the UIDs generated so far
+			for _, generated := range batch {
+				delete(t.uids, generated)
+			}
+			return nil, errors.New("UID generation timed out.")
+		default:
+			try := t.draw()
+			if _, ok := t.uids[try]; !ok {
+				t.uids[try] = true
+				batch = append(batch, try)
+			}
 		}
 	}
-	return 0, nil
+	return batch, nil
 }
 
 // Contains returns true if the uids map in the struct contains an entry for the elem uid.
@@ -71,3 +189,17 @@ func (t *UidTracker) Contains(elem uint64) bool {
 	_, ok := t.uids[elem]
 	return ok
 }
+
+// Remove frees the uid, which can then be used again. Removing a uid which is not in use has no effect.
+func (t *UidTracker) Remove(elem uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.uids, elem)
+}
+
+// Count returns the number of uids currently in use.
+func (t *UidTracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.uids)
+}
@@ -102,3 +102,230 @@ func TestGenerateAndAddTimeouts(t *testing.T) {
 		t.Fatal("The function should have timed out but didn't")
 	}
 }
+
+func TestRemove(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48})
+
+	tracker.Remove(32)
+	tracker.Remove(1000)
+	if tracker.Contains(32) {
+		t.Errorf("Removed value 32 is still contained")
+	}
+	if !tracker.Contains(48) {
+		t.Errorf("Removing value 32 also removed value 48")
+	}
+	if _, err := tracker.AddUid(32); err != nil {
+		t.Errorf("Removed value 32 cannot be added again: %v", err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48, 32})
+	if count := tracker.Count(); count != 2 {
+		t.Errorf("Count() = %d after initializing with 2 distinct values, want 2", count)
+	}
+
+	tracker.AddUid(7)
+	tracker.Remove(48)
+	if count := tracker.Count(); count != 2 {
+		t.Errorf("Count() = %d after adding and removing a value, want 2", count)
+	}
+}
+
+func TestGenerateAndAddBatchConcurrent(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48})
+
+	const nbrCallers = 8
+	const batchSize = 500
+	batches := make([][]uint64, nbrCallers)
+	errs := make([]error, nbrCallers)
+	wg := sync.WaitGroup{}
+	wg.Add(nbrCallers)
+	for i := range batches {
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			batches[i], errs[i] = tracker.GenerateAndAddBatch(ctx, batchSize)
+		}()
+	}
+	wg.Wait()
+
+	seen := map[uint64]bool{32: true, 48: true}
+	for i, batch := range batches {
+		if errs[i] != nil {
+			t.Fatalf("Caller %d failed to reserve a batch: %v", i, errs[i])
+		}
+		if len(batch) != batchSize {
+			t.Errorf("Caller %d was given %d UIDs, want %d", i, len(batch), batchSize)
+		}
+		for _, elem := range batch {
+			if seen[elem] {
+				t.Errorf("UID %d was given twice", elem)
+			}
+			seen[elem] = true
+			if !tracker.Contains(elem) {
+				t.Errorf("UID %d of caller %d was not added", elem, i)
+			}
+		}
+	}
+	if count := tracker.Count(); count != 2+nbrCallers*batchSize {
+		t.Errorf("Count() = %d, want %d", count, 2+nbrCallers*batchSize)
+	}
+}
+
+func TestGenerateAndAddBatchTimeout(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := tracker.GenerateAndAddBatch(ctx, 10); err == nil {
+		t.Error("A batch was generated with a cancelled context")
+	}
+	if count := tracker.Count(); count != 1 {
+		t.Errorf("Count() = %d after a failed batch, want 1", count)
+	}
+}
+
+// drawing returns a source which draws the given values in order, then keeps drawing the last one.
+func drawing(values ...uint64) uidSource {
+	next := 0
+	return func() uint64 {
+		value := values[next]
+		next = min(next+1, len(values)-1)
+		return value
+	}
+}
+
+func TestGenerateAndAddRetriesCollisions(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48, 326})
+	tracker.source = drawing(32, 48, 326, 7)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	added, attempts, err := tracker.GenerateAndAddWithAttempts(ctx)
+	if err != nil {
+		t.Fatalf("Generation failed after %d attempts: %v", attempts, err)
+	}
+	if added != 7 || attempts != 4 {
+		t.Errorf("Generated %d in %d attempts, want 7 in 4 attempts", added, attempts)
+	}
+	if !tracker.Contains(7) {
+		t.Error("The generated UID was not added")
+	}
+}
+
+func TestGenerateAndAddExhaustsAttempts(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32})
+	tracker.source = drawing(32)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, attempts, err := tracker.GenerateAndAddWithAttempts(ctx)
+	if err == nil {
+		t.Fatal("A UID was generated although every attempt collided")
+	}
+	if attempts != MAX_GENERATION_ATTEMPTS {
+		t.Errorf("Gave up after %d attempts, want %d", attempts, MAX_GENERATION_ATTEMPTS)
+	}
+	if count := tracker.Count(); count != 1 {
+		t.Errorf("Count() = %d after a failed generation, want 1", count)
+	}
+}
+
+func TestGenerateAndAddCancelledBeforeAttempting(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, attempts, err := tracker.GenerateAndAddWithAttempts(ctx); err == nil || attempts != 0 {
+		t.Errorf("Generating with a cancelled context gave %d attempts and error %v, want 0 attempts and an error", attempts, err)
+	}
+}
+
+func TestZeroValueTracker(t *testing.T) {
+	var tracker UidTracker
+	if added, err := tracker.AddUid(32); err != nil || added != 32 {
+		t.Fatalf("AddUid(32) on a tracker which was never initialized = %d, %v", added, err)
+	}
+	if !tracker.Contains(32) {
+		t.Error("The added value 32 is not contained")
+	}
+
+	var generating UidTracker
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if added, err := generating.GenerateAndAdd(ctx); err != nil || !generating.Contains(added) {
+		t.Errorf("GenerateAndAdd on a tracker which was never initialized gave %d, %v", added, err)
+	}
+	var batching UidTracker
+	if batch, err := batching.GenerateAndAddBatch(ctx, 3); err != nil || batching.Count() != len(batch) {
+		t.Errorf("GenerateAndAddBatch on a tracker which was never initialized gave %v, %v", batch, err)
+	}
+}
+
+func TestAddUidRecommendsUnusedUid(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48})
+	// The first recommendation drawn is in use, the second one is free
+	tracker.source = drawing(48, 7)
+
+	_, err := tracker.AddUid(32)
+	if err == nil {
+		t.Fatal("A UID in use was added again")
+	}
+	if want := "UID 32 already used in the system, please retry with 7"; err.Error() != want {
+		t.Errorf("AddUid(32) failed with %q, want %q", err, want)
+	}
+	if added, err := tracker.AddUid(7); err != nil || added != 7 {
+		t.Errorf("AddUid(7) = %d, %v, want the recommended UID to be free", added, err)
+	}
+}
+
+func TestAddUidGivesUpRecommending(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32, 48})
+	// Every UID drawn is in use
+	draws := 0
+	tracker.source = func() uint64 {
+		draws++
+		return 48
+	}
+
+	_, err := tracker.AddUid(32)
+	if want := "UID 32 already used in the system"; err == nil || err.Error() != want {
+		t.Errorf("AddUid(32) failed with %v, want %q without a recommendation", err, want)
+	}
+	if draws != MAX_GENERATION_ATTEMPTS {
+		t.Errorf("Drew %d UIDs to recommend, want %d", draws, MAX_GENERATION_ATTEMPTS)
+	}
+	// The tracker is not left locked
+	if added, err := tracker.AddUid(7); err != nil || added != 7 {
+		t.Errorf("AddUid(7) = %d, %v, want 7", added, err)
+	}
+}
+
+func TestAddOrKeep(t *testing.T) {
+	tracker := UidTracker{}
+	tracker.Init([]uint64{32})
+
+	if !tracker.AddOrKeep(32) {
+		t.Errorf("Uid 32 is in use, but AddOrKeep reported it was not")
+	}
+	if tracker.AddOrKeep(49) {
+		t.Errorf("Uid 49 is not in use, but AddOrKeep reported it was")
+	}
+	if !tracker.Contains(49) {
+		t.Errorf("AddOrKeep did not add uid 49")
+	}
+	if tracker.Count() != 2 {
+		t.Errorf("Expected 2 uids in use, got %d", tracker.Count())
+	}
+}
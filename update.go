@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// MAX_METADATA_UPDATE_SIZE bounds the size of the body of a metadata update, well above what valid updates need.
+const MAX_METADATA_UPDATE_SIZE = 16 * 1024
+
+// metadataUpdate holds the changes to the metadata of a stored file. The fields which are not set are left unchanged.
+type metadataUpdate struct {
+	Filename *string `json:"filename"`
+	// Metadata replaces the whole custom metadata of the file
+	Metadata map[string]string `json:"metadata"`
+}
+
+// updateMetadataHandler changes the filename and custom metadata of the file stored under the given UID, as given by
+// the JSON body of the request. The object is copied onto itself with its new metadata, so its content is untouched.
+// A file which may have been given to other uploaders by the deduplication cannot be renamed.
+func updateMetadataHandler(store uploadStore, cfg config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodPatch) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+
+		var update metadataUpdate
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_METADATA_UPDATE_SIZE))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&update); err != nil {
+			http.Error(w, "The body should be a JSON object with a filename and/or metadata: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if update.Filename != nil {
+			if err := checkFilename(*update.Filename); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		// The keys are stored as HTTP headers, like the ones of the metadata given on upload
+		var customMetadata map[string]string
+		if update.Metadata != nil {
+			customMetadata = make(map[string]string, len(update.Metadata))
+			for key, value := range update.Metadata {
+				customMetadata[http.CanonicalHeaderKey(key)] = strings.TrimSpace(value)
+			}
+			if err := checkCustomMetadata(customMetadata); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		objectInfo, err := store.StatObject(r.Context(), objectName)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get object metadata", http.StatusInternalServerError)
+			}
+			return
+		}
+		if isExpired(objectInfo.UserMetadata, time.Now()) {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
+		if isSharedObject(cfg, objectName, objectInfo.UserMetadata) {
+			http.Error(w, SHARED_OBJECT_MESSAGE, http.StatusConflict)
+			return
+		}
+
+		metadata := maps.Clone(objectInfo.UserMetadata)
+		oldFilename := metadata["Filename"]
+		if update.Filename != nil {
+			metadata["Filename"] = *update.Filename
+			// The new name is made unique like the names of the uploaded files
+			if cfg.uniqueFilenames && *update.Filename != oldFilename {
+				metadata["Filename"] = fileNames.claim(*update.Filename, objectName)
+			}
+		}
+		if customMetadata != nil {
+			maps.DeleteFunc(metadata, func(key, value string) bool {
+				return strings.HasPrefix(key, CUSTOM_METADATA_PREFIX)
+			})
+			for key, value := range customMetadata {
+				metadata[CUSTOM_METADATA_PREFIX+key] = value
+			}
+		}
+
+		if err := store.ReplaceMetadata(r.Context(), objectName, objectInfo.ContentType, metadata); err != nil {
+			if cfg.uniqueFilenames && metadata["Filename"] != oldFilename {
+				fileNames.release(metadata["Filename"], objectName)
+			}
+			loggerFrom(r.Context()).Error("Unable to update the metadata", "uid", objectName, "error", err)
+			http.Error(w, "Unable to update the metadata in MinIO", http.StatusInternalServerError)
+			return
+		}
+		if cfg.uniqueFilenames && metadata["Filename"] != oldFilename {
+			fileNames.release(oldFilename, objectName)
+		}
+		fmt.Fprintf(w, "Metadata of the file with UID %s successfully updated, it is named %s\n", objectUid(objectName), metadata["Filename"])
+	}
+}
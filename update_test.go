@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func updateMetadata(store uploadStore, cfg config, method string, objectName string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	updateMetadataHandler(store, cfg)(w, httptest.NewRequest(method, "/metadata?uid="+objectName, strings.NewReader(body)))
+	return w
+}
+
+func TestUpdateMetadataRenamesFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "wrong-name.txt", "text/plain", []byte("Steve's itinerary"))
+	r.Header.Set("X-Meta-Project", "alpha")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	objectName := uidFromResponse(w.Body.String())
+	ciphertext := store.objects[objectName].data
+
+	w = updateMetadata(store, defaultConfig(), http.MethodPatch, objectName, `{"filename": "itinerary.txt"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Update failed with status %d: %s", w.Code, w.Body.String())
+	}
+	fetched := fetchFile(store, objectName, nil)
+	if got := fetched.Header().Get("Content-Disposition"); got != `attachment; filename="itinerary.txt"` {
+		t.Errorf("Download disposition = %s, want the new filename", got)
+	}
+	if fetched.Body.String() != "Steve's itinerary" || string(store.objects[objectName].data) != string(ciphertext) {
+		t.Error("Renaming the file changed its content")
+	}
+	if got := customMetadata(store.objects[objectName].info.UserMetadata); !maps.Equal(got, map[string]string{"Project": "alpha"}) {
+		t.Errorf("Renaming the file changed its custom metadata to %v", got)
+	}
+
+	// The custom metadata is replaced as a whole
+	w = updateMetadata(store, defaultConfig(), http.MethodPatch, objectName, `{"metadata": {"owner": "Steve"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Update failed with status %d: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	infoHandler(store)(w, httptest.NewRequest(http.MethodGet, "/info?uid="+objectName, nil))
+	var info fileInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"Owner": "Steve"}; !maps.Equal(info.Metadata, want) || info.Filename != "itinerary.txt" {
+		t.Errorf("Info gives the filename %q and metadata %v, want itinerary.txt and %v", info.Filename, info.Metadata, want)
+	}
+	if info.Sha256 == "" {
+		t.Error("Updating the metadata dropped the checksum")
+	}
+}
+
+func TestUpdateMetadataRejectsInvalidUpdates(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "notes.txt", "text/plain", []byte("notes"))
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+	}{
+		{"path in filename", http.MethodPatch, `{"filename": "../notes.txt"}`, http.StatusBadRequest},
+		{"control character in filename", http.MethodPatch, `{"filename": "notes\u0007.txt"}`, http.StatusBadRequest},
+		{"empty filename", http.MethodPatch, `{"filename": ""}`, http.StatusBadRequest},
+		{"invalid metadata key", http.MethodPatch, `{"metadata": {"not valid": "x"}}`, http.StatusBadRequest},
+		{"unknown field", http.MethodPatch, `{"name": "other.txt"}`, http.StatusBadRequest},
+		{"not JSON", http.MethodPatch, `filename=other.txt`, http.StatusBadRequest},
+		{"wrong method", http.MethodPost, `{"filename": "other.txt"}`, http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := updateMetadata(store, defaultConfig(), test.method, objectName, test.body)
+			if w.Code != test.status {
+				t.Errorf("Status %d, want %d", w.Code, test.status)
+			}
+			if got := store.objects[objectName].info.UserMetadata["Filename"]; got != "notes.txt" {
+				t.Errorf("The rejected update renamed the file to %q", got)
+			}
+		})
+	}
+}
+
+func TestUpdateMetadataKeepsFilenamesUnique(t *testing.T) {
+	uidTracker.Init(nil)
+	fileNames.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.uniqueFilenames = true
+	uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.pdf", "application/pdf", []byte("Q1")))
+	other := uploadWithConfig(t, store, cfg, newUploadRequest(t, "draft.pdf", "application/pdf", []byte("Q2")))
+
+	if w := updateMetadata(store, cfg, http.MethodPatch, other, `{"filename": "report.pdf"}`); w.Code != http.StatusOK {
+		t.Fatalf("Update failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if got, want := store.objects[other].info.UserMetadata["Filename"], "report-"+other+".pdf"; got != want {
+		t.Errorf("File renamed to a used name is named %q, want %q", got, want)
+	}
+	// The previous name is free again
+	third := uploadWithConfig(t, store, cfg, newUploadRequest(t, "draft.pdf", "application/pdf", []byte("Q3")))
+	if got := store.objects[third].info.UserMetadata["Filename"]; got != "draft.pdf" {
+		t.Errorf("File uploaded under the previous name is named %q, want draft.pdf", got)
+	}
+}
+
+func TestUpdateMetadataOfDeduplicatedFileConflicts(t *testing.T) {
+	uidTracker.Init(nil)
+	fileChecksums.reset(nil)
+	store := newMemoryStore()
+	cfg := defaultConfig()
+	cfg.deduplicate = true
+	objectName := uploadWithConfig(t, store, cfg, newUploadRequest(t, "report.txt", "text/plain", []byte("A shared report")))
+
+	if w := updateMetadata(store, cfg, http.MethodPatch, objectName, `{"filename": "mine.txt"}`); w.Code != http.StatusConflict {
+		t.Errorf("Update of a deduplicated file gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if filename := store.objects[objectName].info.UserMetadata["Filename"]; filename != "report.txt" {
+		t.Errorf("Filename = %q after the rejected update, want report.txt", filename)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// isValidationRequest tells whether an upload request only asks whether the upload would be accepted, through its
+// validate parameter.
+func isValidationRequest(r *http.Request) (bool, error) {
+	validateStr := r.URL.Query().Get("validate")
+	if validateStr == "" {
+		return false, nil
+	}
+	validate, err := strconv.ParseBool(validateStr)
+	if err != nil {
+		return false, fmt.Errorf("validate should be true or false, got %q", validateStr)
+	}
+	return validate, nil
+}
+
+// validateUpload answers an upload request of nbrFiles files whose headers were checked, without reading its body.
+// The UIDs of the files are reserved like for the upload, which fails the same way if the chosen UID is taken, then
+// released. A chosen UID which was reserved through /reserve stays reserved. The UIDs are returned like those of
+// uploaded files, but generated UIDs are only given as examples, the upload being given other ones.
+func validateUpload(w http.ResponseWriter, r *http.Request, nbrFiles int) {
+	uids := make([]string, 0, nbrFiles)
+	var acquired []string
+	defer func() {
+		for _, objectName := range acquired {
+			releaseObjectName(objectName)
+		}
+	}()
+	for range nbrFiles {
+		chosenUid, chosen, err := requestedUid(r)
+		if err == nil && chosen && r.Header.Get("X-Namespace") == "" && uidReservations.reserved(chosenUid) {
+			uids = append(uids, strconv.FormatUint(chosenUid, 10))
+			continue
+		}
+		objectName, errOccurred := getUniqueObjectName(w, r)
+		if errOccurred {
+			return
+		}
+		uids = append(uids, objectUid(objectName))
+		acquired = append(acquired, objectName)
+	}
+	w.Header().Set("X-Upload-UID", strings.Join(uids, ","))
+	fmt.Fprintf(w, "The upload would be accepted, with the UID %s\n", strings.Join(uids, ","))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateUploadStoresNothing(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+
+	r := newMultiUploadRequest(t, []byte("first"), []byte("second"))
+	r.URL.RawQuery = "validate=true"
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Validation failed with status %d: %s", w.Code, w.Body.String())
+	}
+	if uids := strings.Split(w.Header().Get("X-Upload-UID"), ","); len(uids) != 2 {
+		t.Errorf("X-Upload-UID = %q, want the UIDs of 2 files", w.Header().Get("X-Upload-UID"))
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("Validation stored %d objects", len(store.objects))
+	}
+	if count := uidTracker.Count(); count != 0 {
+		t.Errorf("Validation left %d UIDs tracked", count)
+	}
+
+	// A chosen UID is returned, and stays free for the upload
+	r = newUploadRequest(t, "chosen.txt", "text/plain", []byte("Validated first"))
+	r.URL.RawQuery = "validate=true"
+	r.Header.Set("Uid", "0042")
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if got := w.Header().Get("X-Upload-UID"); w.Code != http.StatusOK || got != "42" {
+		t.Fatalf("Validation gave status %d with UID %q, want %d with UID 42", w.Code, got, http.StatusOK)
+	}
+	r = newUploadRequest(t, "chosen.txt", "text/plain", []byte("Validated first"))
+	r.Header.Set("Uid", "42")
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload after its validation failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	// Validating an upload to a taken UID fails like the upload
+	r = newUploadRequest(t, "taken.txt", "text/plain", []byte("Conflicting"))
+	r.URL.RawQuery = "validate=true"
+	r.Header.Set("Uid", "42")
+	w = httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Validation of an upload to a taken UID gave status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if !uidTracker.Contains(42) {
+		t.Error("The failed validation freed the UID of a stored file")
+	}
+}
+
+func TestValidateUploadKeepsReservations(t *testing.T) {
+	uidTracker.Init(nil)
+	uidReservations = reservationIndex{}
+	var reservation reservedUids
+	if err := json.Unmarshal(reserveUids(t, "count=1").Body.Bytes(), &reservation); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newUploadRequest(t, "planned.txt", "text/plain", []byte("Validated before its upload"))
+	r.URL.RawQuery = "validate=true"
+	r.Header.Set("Uid", reservation.Uids[0])
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), defaultConfig())(w, r)
+	if got := w.Header().Get("X-Upload-UID"); w.Code != http.StatusOK || got != reservation.Uids[0] {
+		t.Fatalf("Validation gave status %d with UID %q, want %d with the reserved UID", w.Code, got, http.StatusOK)
+	}
+	reservedUid, _ := strconv.ParseUint(reservation.Uids[0], 10, 64)
+	if !uidReservations.reserved(reservedUid) || !uidTracker.Contains(reservedUid) {
+		t.Error("The validation released the reserved UID")
+	}
+}
+
+func TestValidateUploadChecksHeaders(t *testing.T) {
+	uidTracker.Init(nil)
+	cfg := defaultConfig()
+	r := newUploadRequest(t, "large.txt", "text/plain", []byte("Declared too large"))
+	r.URL.RawQuery = "validate=true"
+	r.Header.Set("File-Size", strconv.FormatInt(cfg.maxUploadSize+1, 10))
+	w := httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Validation of a file over the limit gave status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	r = newUploadRequest(t, "file.txt", "text/plain", []byte("Invalid parameter"))
+	r.URL.RawQuery = "validate=maybe"
+	w = httptest.NewRecorder()
+	uploadHandler(newMemoryStore(), newTestCipher(), cfg)(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Validation with an invalid parameter gave status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if count := uidTracker.Count(); count != 0 {
+		t.Errorf("Rejected validations left %d UIDs tracked", count)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"api/cryptography"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// verifyHandler checks the integrity of a stored file without sending it: the object is decrypted server-side and the
+// checksum of the file is compared to the one stored at upload time. It answers 200 if they match, and 500 if the file
+// is corrupted or cannot be decrypted, so that operators can periodically scrub the bucket. Files stored without a
+// checksum cannot be verified, and are answered with a 422.
+func verifyHandler(store ObjectStore, cipher cryptography.Cipher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		objectName, errOccurred := getRequestedObjectName(w, r)
+		if errOccurred {
+			return
+		}
+
+		objectInfo, err := store.StatObject(r.Context(), objectName)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to get object metadata", http.StatusInternalServerError)
+			}
+			return
+		}
+		if isExpired(objectInfo.UserMetadata, time.Now()) {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UID", http.StatusNotFound)
+			return
+		}
+		storedChecksum, ok := objectInfo.UserMetadata[CHECKSUM_METADATA]
+		if !ok {
+			http.Error(w, "The file was stored without a checksum and cannot be verified", http.StatusUnprocessableEntity)
+			return
+		}
+
+		checksum, err := computeStoredChecksum(r.Context(), store, cipher, objectInfo)
+		if err != nil {
+			verificationsTotal.WithLabelValues("failed").Inc()
+			loggerFrom(r.Context()).Error("Verification failed", "uid", objectName, "error", err)
+			http.Error(w, "The stored file could not be verified: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if checksum != storedChecksum {
+			verificationsTotal.WithLabelValues("corrupted").Inc()
+			loggerFrom(r.Context()).Error("Checksum mismatch", "uid", objectName, "stored", storedChecksum, "computed", checksum)
+			http.Error(w, "The stored file is corrupted", http.StatusInternalServerError)
+			return
+		}
+		verificationsTotal.WithLabelValues("ok").Inc()
+		fmt.Fprintf(w, "File with UID %s verified \nSHA-256 checksum: %s \n", objectUid(objectName), checksum)
+	}
+}
+
+// computeStoredChecksum decrypts the given object, decompressing it if it was compressed, and returns the hex-encoded
+// SHA-256 checksum of the file it holds. The plaintext of a file which was not compressed must have the size the object
+// implies, or an error is returned.
+func computeStoredChecksum(ctx context.Context, store ObjectStore, cipher cryptography.Cipher, objectInfo minio.ObjectInfo) (string, error) {
+	object, err := store.GetObject(ctx, objectInfo.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch the object: %w", err)
+	}
+	defer object.Close()
+
+	plaintextHash := sha256.New()
+	plaintext := &countingWriter{writer: plaintextHash}
+	if objectInfo.UserMetadata["Compression"] == COMPRESSION_GZIP {
+		err = decryptAndDecompress(ctx, cipher, object, plaintext)
+	} else {
+		// The size of the header depends on the version of the stream
+		var header []byte
+		header, err = fetchStreamHeader(ctx, store, cipher, objectInfo.Key)
+		if err == nil {
+			err = cipher.DecryptStreamCtx(ctx, object, plaintext)
+		}
+		if wantSize := objectInfo.Size - int64(len(header)); err == nil && plaintext.count != wantSize {
+			err = fmt.Errorf("decrypted %d bytes, want %d", plaintext.count, wantSize)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(plaintextHash.Sum(nil)), nil
+}
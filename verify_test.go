@@ -0,0 +1,81 @@
+package main
+
+import (
+	"api/cryptography"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// verifyFile runs a verification of the given UID through the handler.
+func verifyFile(store uploadStore, objectName string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	verifyHandler(store, newTestCipher())(w, httptest.NewRequest(http.MethodGet, "/verify?uid="+objectName, nil))
+	return w
+}
+
+func TestVerifyHealthyFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "healthy.txt", "text/plain", []byte("Steve's breakfast diary."))
+
+	w := verifyFile(store, objectName)
+	if w.Code != http.StatusOK {
+		t.Errorf("Verifying a healthy file gave status %d: %s", w.Code, w.Body.String())
+	}
+	if w.Body.Len() > 200 {
+		t.Errorf("The verification sent %d bytes, which should not include the file", w.Body.Len())
+	}
+}
+
+func TestVerifyCompressedFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	r := newUploadRequest(t, "compressed.txt", "text/plain", []byte("Compressed before its encryption, compressed before its encryption."))
+	r.Header.Set("Compress", "gzip")
+	w := httptest.NewRecorder()
+	uploadHandler(store, newTestCipher(), defaultConfig())(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Upload failed with status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := verifyFile(store, uidFromResponse(w.Body.String())); w.Code != http.StatusOK {
+		t.Errorf("Verifying a compressed file gave status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestVerifyCorruptedFile(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "corrupted.txt", "text/plain", []byte("Steve's breakfast diary."))
+	store.objects[objectName].data[cryptography.HEADER_SIZE] ^= 1
+
+	if w := verifyFile(store, objectName); w.Code != http.StatusInternalServerError {
+		t.Errorf("Verifying a corrupted file gave status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	// A truncated object is reported as well
+	object := store.objects[objectName]
+	object.data = object.data[:len(object.data)-1]
+	store.objects[objectName] = object
+	if w := verifyFile(store, objectName); w.Code != http.StatusInternalServerError {
+		t.Errorf("Verifying a truncated file gave status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestVerifyWithoutChecksum(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "unchecked.txt", "text/plain", []byte("No checksum"))
+	if err := store.ReplaceMetadata(context.Background(), objectName, "text/plain", map[string]string{"Filename": "unchecked.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := verifyFile(store, objectName); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Verifying a file without checksum gave status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	if w := verifyFile(store, "404"); w.Code != http.StatusNotFound {
+		t.Errorf("Verifying an unknown UID gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
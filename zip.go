@@ -0,0 +1,142 @@
+package main
+
+import (
+	"api/cryptography"
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ZIP_ARCHIVE_NAME is the name under which the archives of /fetch-zip are downloaded.
+const ZIP_ARCHIVE_NAME = "files.zip"
+
+// fetchZipHandler streams the files stored under the given UIDs as a single zip archive, each file being decrypted while
+// it is written to the archive, under its stored filename. UIDs which do not match any file are skipped and listed in
+// the X-Missing-UIDs header. No file is held in memory.
+func fetchZipHandler(store ObjectStore, cipher cryptography.Cipher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet) {
+			return
+		}
+		uidStrs := r.URL.Query()["uid"]
+		if len(uidStrs) == 0 {
+			http.Error(w, "Missing UID", http.StatusBadRequest)
+			return
+		}
+		namespace, err := requestNamespace(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uids := make([]uint64, 0, len(uidStrs))
+		for _, uidStr := range uidStrs {
+			uid, err := strconv.ParseUint(uidStr, 10, 64)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(uids, uid) {
+				uids = append(uids, uid)
+			}
+		}
+
+		// The objects are all described before the archive starts, so that the missing ones can still be announced in
+		// a header. Like for single fetches, the fetches from MinIO are not cancelled along with the request.
+		ctx := context.WithoutCancel(r.Context())
+		objects := make([]minio.ObjectInfo, 0, len(uids))
+		var missing []string
+		for _, uid := range uids {
+			objectName := objectKey(namespace, uid)
+			if !namespaceTrackers.tracker(namespace).Contains(uid) {
+				missing = append(missing, objectUid(objectName))
+				continue
+			}
+			objectInfo, err := store.StatObject(ctx, objectName)
+			if err != nil || isExpired(objectInfo.UserMetadata, time.Now()) || objectInfo.UserMetadata["Filename"] == "" {
+				missing = append(missing, objectUid(objectName))
+				continue
+			}
+			objects = append(objects, objectInfo)
+		}
+		if len(objects) == 0 {
+			http.Error(w, "The MinIO bucket does not contain any object with the provided UIDs", http.StatusNotFound)
+			return
+		}
+		if len(missing) > 0 {
+			w.Header().Set("X-Missing-UIDs", strings.Join(missing, ","))
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", ZIP_ARCHIVE_NAME))
+
+		archive := zip.NewWriter(w)
+		entryNames := make(map[string]bool, len(objects))
+		for _, objectInfo := range objects {
+			if !writeZipEntry(r.Context(), ctx, archive, store, cipher, objectInfo, entryNames) {
+				// The status was already sent. The archive is left without its central directory, so that the client
+				// notices the failure instead of receiving an archive missing files.
+				return
+			}
+		}
+		if err := archive.Close(); err != nil {
+			loggerFrom(ctx).Error("Unable to finish the zip archive", "error", err)
+		}
+	}
+}
+
+// writeZipEntry decrypts the given object into a new entry of the archive. The entry is named after the stored filename,
+// prefixed by the UID of the file if another entry already has this name. It returns false if the file could not be
+// entirely written, in which case the archive cannot be used anymore.
+func writeZipEntry(requestCtx context.Context, ctx context.Context, archive *zip.Writer, store ObjectStore, cipher cryptography.Cipher, objectInfo minio.ObjectInfo, entryNames map[string]bool) bool {
+	objectName := objectInfo.Key
+	name := objectInfo.UserMetadata["Filename"]
+	if entryNames[name] {
+		name = objectUid(objectName) + "-" + name
+	}
+	entryNames[name] = true
+
+	object, err := store.GetObject(ctx, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		loggerFrom(ctx).Error("Unable to fetch file from MinIO", "uid", objectName, "error", err)
+		return false
+	}
+	defer object.Close()
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: objectInfo.LastModified,
+	})
+	if err != nil {
+		loggerFrom(ctx).Error("Unable to add a file to the zip archive", "uid", objectName, "error", err)
+		return false
+	}
+
+	plaintextHash := sha256.New()
+	sentData := &countingWriter{writer: entry}
+	if objectInfo.UserMetadata["Compression"] == COMPRESSION_GZIP {
+		err = decryptAndDecompress(requestCtx, cipher, object, io.MultiWriter(sentData, plaintextHash))
+	} else {
+		err = cipher.DecryptStreamCtx(requestCtx, object, io.MultiWriter(sentData, plaintextHash))
+	}
+	downloadedBytesTotal.Add(float64(sentData.count))
+	if err != nil {
+		loggerFrom(ctx).Error("Error during decryption", "uid", objectName, "error", err)
+		return false
+	}
+	downloadsTotal.Inc()
+	if storedChecksum, ok := objectInfo.UserMetadata[CHECKSUM_METADATA]; ok {
+		if checksum := hex.EncodeToString(plaintextHash.Sum(nil)); checksum != storedChecksum {
+			loggerFrom(ctx).Error("Checksum mismatch", "uid", objectName, "stored", storedChecksum, "computed", checksum)
+		}
+	}
+	return true
+}
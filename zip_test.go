@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchZipContainsDecryptedFiles(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	files := map[string][]byte{
+		"notes.txt":  []byte("Steve lost his map near the Eiffel Tower."),
+		"report.csv": bytes.Repeat([]byte("a,b,c\n"), 1000),
+	}
+	var query string
+	for filename, content := range files {
+		query += "&uid=" + uploadFile(t, store, filename, "text/plain", content)
+	}
+
+	w := httptest.NewRecorder()
+	fetchZipHandler(store, newTestCipher())(w, httptest.NewRequest(http.MethodGet, "/fetch-zip?"+query[1:], nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetching the archive gave status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("Archive sent with type %q, want application/zip", got)
+	}
+	if got := w.Header().Get("X-Missing-UIDs"); got != "" {
+		t.Errorf("X-Missing-UIDs = %q although every file exists", got)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("The archive cannot be read: %v", err)
+	}
+	if len(archive.File) != len(files) {
+		t.Fatalf("The archive holds %d files, want %d", len(archive.File), len(files))
+	}
+	for _, entry := range archive.File {
+		want, ok := files[entry.Name]
+		if !ok {
+			t.Errorf("Unexpected file %q in the archive", entry.Name)
+			continue
+		}
+		reader, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("Unable to read %q from the archive: %v", entry.Name, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%q holds %q, want %q", entry.Name, got, want)
+		}
+	}
+}
+
+func TestFetchZipSkipsMissingUids(t *testing.T) {
+	uidTracker.Init(nil)
+	store := newMemoryStore()
+	objectName := uploadFile(t, store, "kept.txt", "text/plain", []byte("Still here"))
+	missingUid := "1"
+	if objectName == missingUid {
+		missingUid = "2"
+	}
+
+	w := httptest.NewRecorder()
+	fetchZipHandler(store, newTestCipher())(w, httptest.NewRequest(http.MethodGet, "/fetch-zip?uid="+missingUid+"&uid="+objectName, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Fetching the archive gave status %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("X-Missing-UIDs"); got != missingUid {
+		t.Errorf("X-Missing-UIDs = %q, want %q", got, missingUid)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("The archive cannot be read: %v", err)
+	}
+	if len(archive.File) != 1 || archive.File[0].Name != "kept.txt" {
+		t.Errorf("The archive should only hold kept.txt, got %d files", len(archive.File))
+	}
+
+	// An archive without any file is not sent
+	w = httptest.NewRecorder()
+	fetchZipHandler(store, newTestCipher())(w, httptest.NewRequest(http.MethodGet, "/fetch-zip?uid="+missingUid, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Fetching only missing UIDs gave status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestFetchZipRejectsInvalidUids(t *testing.T) {
+	for _, query := range []string{"", "?uid=abc", "?uid=1&uid=-2"} {
+		w := httptest.NewRecorder()
+		fetchZipHandler(newMemoryStore(), newTestCipher())(w, httptest.NewRequest(http.MethodGet, "/fetch-zip"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("/fetch-zip%s gave status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}